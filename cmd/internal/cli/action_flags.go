@@ -172,8 +172,7 @@ func initBoolVars() {
 	actionFlags.SetAnnotation("boot", "envkey", []string{"BOOT"})
 
 	// -f|--fakeroot
-	actionFlags.BoolVarP(&IsFakeroot, "fakeroot", "f", false, "run container in new user namespace as uid 0 (experimental)")
-	actionFlags.Lookup("fakeroot").Hidden = true
+	actionFlags.BoolVarP(&IsFakeroot, "fakeroot", "f", false, "run container in new user namespace as uid 0, mapping your subordinate UIDs and GIDs")
 	actionFlags.SetAnnotation("fakeroot", "envkey", []string{"FAKEROOT"})
 
 	// -e|--cleanenv
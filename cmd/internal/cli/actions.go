@@ -124,7 +124,7 @@ func handleOCI(cmd *cobra.Command, u string) (string, error) {
 }
 
 func handleLibrary(u string) (string, error) {
-	libraryImage, err := library.GetImage("https://library.sylabs.io", authToken, u)
+	libraryImage, err := library.GetImage(currentRemote.Library, authToken, u)
 	if err != nil {
 		return "", err
 	}
@@ -136,15 +136,9 @@ func handleLibrary(u string) (string, error) {
 		return "", fmt.Errorf("unable to check if %v exists: %v", imagePath, err)
 	} else if !exists {
 		sylog.Infof("Downloading library image")
-		if err = library.DownloadImage(imagePath, u, "https://library.sylabs.io", true, authToken); err != nil {
+		if err = library.DownloadImage(imagePath, u, currentRemote.Library, true, authToken); err != nil {
 			return "", fmt.Errorf("unable to Download Image: %v", err)
 		}
-
-		if cacheFileHash, err := library.ImageHash(imagePath); err != nil {
-			return "", fmt.Errorf("Error getting ImageHash: %v", err)
-		} else if cacheFileHash != libraryImage.Hash {
-			return "", fmt.Errorf("Cached File Hash(%s) and Expected Hash(%s) does not match", cacheFileHash, libraryImage.Hash)
-		}
 	}
 
 	return imagePath, nil
@@ -20,6 +20,7 @@ import (
 
 	"github.com/spf13/cobra"
 	"github.com/sylabs/singularity/internal/pkg/buildcfg"
+	"github.com/sylabs/singularity/internal/pkg/fakeroot"
 	"github.com/sylabs/singularity/internal/pkg/instance"
 	"github.com/sylabs/singularity/internal/pkg/runtime/engines/config"
 	"github.com/sylabs/singularity/internal/pkg/runtime/engines/config/oci"
@@ -52,6 +53,24 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 		sylog.Fatalf("Unable to parse singularity.conf file: %s", err)
 	}
 
+	// root of a user namespace set up by a parent with --fakeroot
+	fakerootNS := IsFakeroot && uid == 0 && fakeroot.InUserNamespace()
+
+	if IsFakeroot && uid != 0 {
+		checkFakeroot(engineConfig.File)
+		pwd, err := user.GetPwUID(uid)
+		if err != nil {
+			sylog.Fatalf("failed to retrieve user information for UID %d: %s", uid, err)
+		}
+		// the command runs again as root of a user namespace mapping the
+		// subordinate ranges of the user, if any
+		if _, _, err := fakeroot.IDMappings(pwd); err != nil {
+			sylog.Warningf("%s, only your user is mapped to root in the container", err)
+		} else {
+			execFakeroot()
+		}
+	}
+
 	ociConfig := &oci.Config{}
 	generator := generate.Generator{Config: &ociConfig.Spec}
 
@@ -223,6 +242,13 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 	engineConfig.SetScratchDir(ScratchPath)
 	engineConfig.SetWorkdir(WorkdirPath)
 
+	// the home directory was resolved for root of the user namespace
+	if fakerootNS && !homeFlag.Changed {
+		if home := os.Getenv("HOME"); home != "" {
+			HomePath = home
+		}
+	}
+
 	homeSlice := strings.Split(HomePath, ":")
 
 	if len(homeSlice) > 2 || len(homeSlice) == 0 {
@@ -297,7 +323,18 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 		generator.AddOrReplaceLinuxNamespace("user", "")
 		starter = buildcfg.LIBEXECDIR + "/singularity/bin/starter"
 
-		if IsFakeroot {
+		if fakerootNS {
+			uids, gids, err := fakeroot.NamespaceMappings()
+			if err != nil {
+				sylog.Fatalf("While reading fakeroot user namespace mappings: %s", err)
+			}
+			for _, m := range uids {
+				generator.AddLinuxUIDMapping(m.HostID, m.ContainerID, m.Size)
+			}
+			for _, m := range gids {
+				generator.AddLinuxGIDMapping(m.HostID, m.ContainerID, m.Size)
+			}
+		} else if IsFakeroot {
 			generator.AddLinuxUIDMapping(uid, 0, 1)
 			generator.AddLinuxGIDMapping(gid, 0, 1)
 		} else {
@@ -9,20 +9,23 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 
 	ocitypes "github.com/containers/image/types"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/pkg/image"
 	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/internal/pkg/util/fs/squashfs"
 	"github.com/sylabs/singularity/pkg/build/types"
 	"github.com/sylabs/singularity/pkg/build/types/parser"
 	"github.com/sylabs/singularity/pkg/sypgp"
 )
 
 var (
-	remote         bool
+	remoteBuild    bool
 	builderURL     string
 	detached       bool
 	libraryURL     string
@@ -39,6 +42,14 @@ var (
 	dockerPassword string
 	dockerLogin    bool
 	noCleanUp      bool
+	buildFakeroot  bool
+	buildArgs      []string
+	buildArgsFile  string
+	reproducible   bool
+	compression    string
+	blockSize      string
+	processors     uint
+	excludes       []string
 )
 
 var buildflags = pflag.NewFlagSet("BuildFlags", pflag.ExitOnError)
@@ -64,7 +75,7 @@ func init() {
 	BuildCmd.Flags().BoolVarP(&noTest, "notest", "T", false, "build without running tests in %test section")
 	BuildCmd.Flags().SetAnnotation("notest", "envkey", []string{"NOTEST"})
 
-	BuildCmd.Flags().BoolVarP(&remote, "remote", "r", false, "build image remotely (does not require root)")
+	BuildCmd.Flags().BoolVarP(&remoteBuild, "remote", "r", false, "build image remotely (does not require root)")
 	BuildCmd.Flags().SetAnnotation("remote", "envkey", []string{"REMOTE"})
 
 	BuildCmd.Flags().BoolVarP(&detached, "detached", "d", false, "submit build job and print build ID (no real-time logs and requires --remote)")
@@ -72,9 +83,11 @@ func init() {
 
 	BuildCmd.Flags().StringVar(&builderURL, "builder", "https://build.sylabs.io", "remote Build Service URL")
 	BuildCmd.Flags().SetAnnotation("builder", "envkey", []string{"BUILDER"})
+	BuildCmd.Flags().SetAnnotation("builder", "remotekey", []string{"builder"})
 
 	BuildCmd.Flags().StringVar(&libraryURL, "library", "https://library.sylabs.io", "container Library URL")
 	BuildCmd.Flags().SetAnnotation("library", "envkey", []string{"LIBRARY"})
+	BuildCmd.Flags().SetAnnotation("library", "remotekey", []string{"library"})
 
 	BuildCmd.Flags().StringVar(&tmpDir, "tmpdir", "", "specify a temporary directory to use for build")
 	BuildCmd.Flags().SetAnnotation("tmpdir", "envkey", []string{"TMPDIR"})
@@ -85,6 +98,30 @@ func init() {
 	BuildCmd.Flags().BoolVar(&noCleanUp, "no-cleanup", false, "do NOT clean up bundle after failed build, can be helpul for debugging")
 	BuildCmd.Flags().SetAnnotation("no-cleanup", "envkey", []string{"NO_CLEANUP"})
 
+	BuildCmd.Flags().BoolVarP(&buildFakeroot, "fakeroot", "f", false, "build as an unprivileged user in a user namespace mapping your subordinate UIDs and GIDs")
+	BuildCmd.Flags().SetAnnotation("fakeroot", "envkey", []string{"FAKEROOT"})
+
+	BuildCmd.Flags().StringArrayVar(&buildArgs, "build-arg", []string{}, "set the value of a build argument of the definition, as KEY=VALUE")
+	BuildCmd.Flags().SetAnnotation("build-arg", "envkey", []string{"BUILD_ARG"})
+
+	BuildCmd.Flags().StringVar(&buildArgsFile, "build-arg-file", "", "read build argument values from a file holding one KEY=VALUE per line")
+	BuildCmd.Flags().SetAnnotation("build-arg-file", "envkey", []string{"BUILD_ARG_FILE"})
+
+	BuildCmd.Flags().BoolVar(&reproducible, "reproducible", false, "build a bit identical SIF image from identical inputs, recording the time set by SOURCE_DATE_EPOCH or the epoch")
+	BuildCmd.Flags().SetAnnotation("reproducible", "envkey", []string{"REPRODUCIBLE"})
+
+	BuildCmd.Flags().StringVar(&compression, "compression", "", "compression algorithm of the SIF image filesystem (gzip, lz4, xz, zstd or none), defaults to the singularity.conf setting")
+	BuildCmd.Flags().SetAnnotation("compression", "envkey", []string{"COMPRESSION"})
+
+	BuildCmd.Flags().StringVar(&blockSize, "block-size", "", "block size of the SIF image filesystem, a power of two between 4K and 1M")
+	BuildCmd.Flags().SetAnnotation("block-size", "envkey", []string{"BLOCK_SIZE"})
+
+	BuildCmd.Flags().UintVar(&processors, "processors", 0, "number of processors used to create the SIF image filesystem, defaults to the singularity.conf setting")
+	BuildCmd.Flags().SetAnnotation("processors", "envkey", []string{"PROCESSORS"})
+
+	BuildCmd.Flags().StringArrayVar(&excludes, "exclude", []string{}, "leave files matching a wildcard pattern, relative to the container root, out of the SIF image")
+	BuildCmd.Flags().SetAnnotation("exclude", "envkey", []string{"EXCLUDE"})
+
 	BuildCmd.Flags().AddFlag(actionFlags.Lookup("docker-username"))
 	BuildCmd.Flags().AddFlag(actionFlags.Lookup("docker-password"))
 	BuildCmd.Flags().AddFlag(actionFlags.Lookup("docker-login"))
@@ -109,8 +146,8 @@ var BuildCmd = &cobra.Command{
 // checkTargetCollision makes sure output target doesn't exist, or is ok to overwrite
 func checkBuildTarget(path string, update bool) bool {
 	if f, err := os.Stat(path); err == nil {
-		if update && !f.IsDir() {
-			sylog.Fatalf("Only sandbox updating is supported.")
+		if update && !f.IsDir() && !image.IsSIF(path) {
+			sylog.Fatalf("Only sandbox and SIF image updating is supported.")
 		}
 		if !update && !force {
 			reader := bufio.NewReader(os.Stdin)
@@ -151,6 +188,64 @@ func checkSections() error {
 	return nil
 }
 
+// checkSquashfsOptions returns an error if the SIF image filesystem can't be
+// created with the compression and block size set.
+func checkSquashfsOptions() error {
+	if compression != "" {
+		if err := squashfs.CheckCompression(compression); err != nil {
+			return err
+		}
+	}
+	if blockSize != "" {
+		if _, err := squashfs.ParseBlockSize(blockSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildArgsMap returns the build arguments read from --build-arg-file and
+// set with --build-arg, the latter taking precedence.
+func buildArgsMap() (map[string]string, error) {
+	args := make(map[string]string)
+
+	if buildArgsFile != "" {
+		f, err := os.Open(buildArgsFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open build arguments file: %v", err)
+		}
+		defer f.Close()
+
+		if args, err = parser.ParseArgumentsFile(f); err != nil {
+			return nil, fmt.Errorf("while parsing %s: %v", buildArgsFile, err)
+		}
+	}
+
+	for _, arg := range buildArgs {
+		name, value, err := parser.ParseArgument(arg)
+		if err != nil {
+			return nil, err
+		}
+		args[name] = value
+	}
+
+	return args, nil
+}
+
+// sourceDateEpoch returns the time set by the SOURCE_DATE_EPOCH environment
+// variable, builds are reproducible when it is set.
+func sourceDateEpoch() (epoch int64, set bool, err error) {
+	value, set := os.LookupEnv("SOURCE_DATE_EPOCH")
+	if !set {
+		return 0, false, nil
+	}
+	epoch, err = strconv.ParseInt(value, 10, 64)
+	if err != nil || epoch < 0 {
+		return 0, false, fmt.Errorf("invalid SOURCE_DATE_EPOCH %q, expected a number of seconds since the epoch", value)
+	}
+	return epoch, true, nil
+}
+
 func definitionFromSpec(spec string) (def types.Definition, err error) {
 
 	// Try spec as URI first
@@ -176,7 +271,23 @@ func definitionFromSpec(spec string) (def types.Definition, err error) {
 		}
 
 		defer defFile.Close()
-		def, err = parser.ParseDefinitionFile(defFile)
+
+		var args map[string]string
+		args, err = buildArgsMap()
+		if err != nil {
+			return
+		}
+
+		var defs []types.Definition
+		defs, err = parser.ParseDefinitionStagesPath(spec, defFile, args)
+		if err != nil {
+			return
+		}
+		if len(defs) > 1 {
+			err = fmt.Errorf("multi-stage definitions are not supported by the remote builder")
+			return
+		}
+		def = defs[0]
 
 		return
 	}
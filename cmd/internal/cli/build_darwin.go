@@ -27,7 +27,7 @@ func run(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 
-	if !remote {
+	if !remoteBuild {
 		sylog.Fatalf("Only remote builds are supported on this platform")
 	}
 
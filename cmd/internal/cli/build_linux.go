@@ -8,10 +8,14 @@ package cli
 import (
 	"context"
 	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
 	"github.com/sylabs/singularity/internal/pkg/build"
 	"github.com/sylabs/singularity/internal/pkg/build/remotebuilder"
+	"github.com/sylabs/singularity/internal/pkg/buildcfg"
+	"github.com/sylabs/singularity/internal/pkg/runtime/engines/config"
+	singularityConfig "github.com/sylabs/singularity/internal/pkg/runtime/engines/singularity/config"
 	"github.com/sylabs/singularity/internal/pkg/sylog"
 	"github.com/sylabs/singularity/pkg/build/types"
 )
@@ -21,6 +25,20 @@ func preRun(cmd *cobra.Command, args []string) {
 }
 
 func run(cmd *cobra.Command, args []string) {
+	if buildFakeroot && remoteBuild {
+		sylog.Fatalf("--fakeroot can't be used with --remote")
+	}
+
+	if buildFakeroot && os.Getuid() != 0 {
+		c := &singularityConfig.FileConfig{}
+		if err := config.Parser(buildcfg.SYSCONFDIR+"/singularity/singularity.conf", c); err != nil {
+			sylog.Fatalf("Unable to parse singularity.conf file: %s", err)
+		}
+		checkFakeroot(c)
+		// the whole build runs again as root of a user namespace
+		execFakeroot()
+	}
+
 	buildFormat := "sif"
 	if sandbox {
 		buildFormat = "sandbox"
@@ -30,11 +48,20 @@ func run(cmd *cobra.Command, args []string) {
 	spec := args[1]
 
 	// check if target collides with existing file
-	if ok := checkBuildTarget(dest, update); !ok {
+	target := dest
+	if format, archive := ociArchiveTarget(dest); format != "" {
+		if sandbox || update || remoteBuild {
+			sylog.Fatalf("%s targets can't be used with --sandbox, --update or --remote", format)
+		}
+		buildFormat = format
+		dest = strings.TrimPrefix(dest, format+":")
+		target = archive
+	}
+	if ok := checkBuildTarget(target, update); !ok {
 		os.Exit(1)
 	}
 
-	if remote {
+	if remoteBuild {
 		// Submiting a remote build requires a valid authToken
 		if authToken == "" {
 			sylog.Fatalf("Unable to submit build job: %v", authWarning)
@@ -60,11 +87,25 @@ func run(cmd *cobra.Command, args []string) {
 			sylog.Fatalf(err.Error())
 		}
 
+		if err := checkSquashfsOptions(); err != nil {
+			sylog.Fatalf("%s", err)
+		}
+
 		authConf, err := makeDockerCredentials(cmd)
 		if err != nil {
 			sylog.Fatalf("While creating Docker credentials: %v", err)
 		}
 
+		args, err := buildArgsMap()
+		if err != nil {
+			sylog.Fatalf("While reading build arguments: %v", err)
+		}
+
+		epoch, epochSet, err := sourceDateEpoch()
+		if err != nil {
+			sylog.Fatalf("%s", err)
+		}
+
 		b, err := build.NewBuild(
 			spec,
 			dest,
@@ -80,6 +121,13 @@ func run(cmd *cobra.Command, args []string) {
 				NoHTTPS:          noHTTPS,
 				NoCleanUp:        noCleanUp,
 				DockerAuthConfig: authConf,
+				BuildArgs:        args,
+				Reproducible:     reproducible || epochSet,
+				SourceDateEpoch:  epoch,
+				Compression:      compression,
+				BlockSize:        blockSize,
+				Processors:       processors,
+				Excludes:         excludes,
 			})
 		if err != nil {
 			sylog.Fatalf("Unable to create build: %v", err)
@@ -90,3 +138,16 @@ func run(cmd *cobra.Command, args []string) {
 		}
 	}
 }
+
+// ociArchiveTarget returns the OCI image format selected by the build target
+// dest of the form <format>:<path>[:<reference>] and the archive path, the
+// format is empty for other targets.
+func ociArchiveTarget(dest string) (format string, path string) {
+	for _, f := range []string{"oci-archive", "docker-archive"} {
+		if strings.HasPrefix(dest, f+":") {
+			path = strings.SplitN(strings.TrimPrefix(dest, f+":"), ":", 2)[0]
+			return f, path
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,41 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"os"
+
+	"github.com/sylabs/singularity/internal/pkg/client/cache"
+	"github.com/sylabs/singularity/internal/pkg/fakeroot"
+	singularityConfig "github.com/sylabs/singularity/internal/pkg/runtime/engines/singularity/config"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+)
+
+// checkFakeroot exits if the configuration c doesn't allow the calling user
+// to use --fakeroot.
+func checkFakeroot(c *singularityConfig.FileConfig) {
+	if !c.AllowFakeroot {
+		sylog.Fatalf("--fakeroot is disabled by configuration")
+	}
+	if err := fakeroot.CheckUID(uint32(os.Getuid()), c.FakerootAllowUsers, c.FakerootDenyUsers); err != nil {
+		sylog.Fatalf("%s", err)
+	}
+}
+
+// execFakeroot executes the command line again as root of a user namespace
+// mapping the subordinate UIDs and GIDs of the calling user, and exits with
+// its exit status.
+func execFakeroot() {
+	// root of the user namespace would use the cache of the host root user
+	if os.Getenv(cache.DirEnv) == "" {
+		os.Setenv(cache.DirEnv, cache.Root())
+	}
+	status, err := fakeroot.Run(os.Args[1:])
+	if err != nil {
+		sylog.Fatalf("While running in fakeroot user namespace: %s", err)
+	}
+	os.Exit(status)
+}
@@ -8,6 +8,7 @@ package cli
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
@@ -15,10 +16,13 @@ import (
 
 	"github.com/opencontainers/runtime-tools/generate"
 	"github.com/spf13/cobra"
+	"github.com/sylabs/sif/pkg/sif"
 	"github.com/sylabs/singularity/docs"
 	"github.com/sylabs/singularity/internal/pkg/buildcfg"
+	"github.com/sylabs/singularity/internal/pkg/image"
 	"github.com/sylabs/singularity/internal/pkg/sylog"
 	"github.com/sylabs/singularity/internal/pkg/util/exec"
+	"github.com/sylabs/singularity/internal/pkg/util/fs/squashfs"
 
 	"github.com/sylabs/singularity/internal/pkg/runtime/engines/config"
 	"github.com/sylabs/singularity/internal/pkg/runtime/engines/config/oci"
@@ -128,83 +132,27 @@ var InspectCmd = &cobra.Command{
 		}
 		name := filepath.Base(abspath)
 
-		attributes := make(map[string]string)
+		var attributes map[string]string
 
-		a := []string{"/bin/sh", "-c", ""}
-		prefix := "@@@start"
-		delimiter := "@@@end"
-
-		if helpfile {
-			sylog.Debugf("Inspection of helpfile selected.")
-
-			// append to a[2] to run commands in container
-			a[2] += fmt.Sprintf(" echo '%v\nhelpfile';", prefix)
-			a[2] += getHelpFile(AppName)
-			a[2] += fmt.Sprintf(" echo '%v';", delimiter)
-		}
-
-		if deffile {
-			sylog.Debugf("Inspection of deffile selected.")
-
-			// append to a[2] to run commands in container
-			a[2] += fmt.Sprintf(" echo '%v\ndeffile';", prefix)
-			a[2] += " cat .singularity.d/Singularity;" // apps share common definition file
-			a[2] += fmt.Sprintf(" echo '%v';", delimiter)
-		}
-
-		if runscript {
-			sylog.Debugf("Inspection of runscript selected.")
-
-			// append to a[2] to run commands in container
-			a[2] += fmt.Sprintf(" echo '%v\nrunscript';", prefix)
-			a[2] += getRunscriptFile(AppName)
-			a[2] += fmt.Sprintf(" echo '%v';", delimiter)
-		}
-
-		if testfile {
-			sylog.Debugf("Inspection of test selected.")
-
-			// append to a[2] to run commands in container
-			a[2] += fmt.Sprintf(" echo '%v\ntest';", prefix)
-			a[2] += getTestFile(AppName)
-			a[2] += fmt.Sprintf(" echo '%v';", delimiter)
-		}
-
-		if environment {
-			sylog.Debugf("Inspection of environment selected.")
-
-			// append to a[2] to run commands in container
-			a[2] += fmt.Sprintf(" echo '%v\nenvironment';", prefix)
-			a[2] += getEnvFile(AppName)
-			a[2] += fmt.Sprintf(" echo '%v';", delimiter)
-		}
-
-		// default to labels if nothing was appended
-		if labels || len(a[2]) == 0 {
-			sylog.Debugf("Inspection of labels as default.")
-
-			// append to a[2] to run commands in container
-			a[2] += fmt.Sprintf(" echo '%v\nlabels';", prefix)
-			a[2] += getLabelsFile(AppName)
-			a[2] += fmt.Sprintf(" echo '%v';", delimiter)
+		img, err := image.Init(abspath, false)
+		if err != nil {
+			sylog.Fatalf("While opening image %s: %v", abspath, err)
 		}
 
-		fileContents, err := getFileContent(abspath, name, a)
+		r, err := newImageReader(img)
 		if err != nil {
-			sylog.Fatalf("While getting helpfile: %v", err)
+			img.File.Close()
+			sylog.Fatalf("While reading image %s: %v", abspath, err)
 		}
 
-		contentSlice := strings.Split(fileContents, delimiter)
-		for _, s := range contentSlice {
-			s = strings.TrimSpace(s)
-			if strings.HasPrefix(s, prefix) {
-				split := strings.SplitN(s, "\n", 3)
-				if len(split) == 3 {
-					attributes[split[1]] = split[2]
-				} else if len(split) == 2 {
-					sylog.Warningf("%v metadata was not found.", split[1])
-				}
-			}
+		if r != nil {
+			attributes = inspectImage(r)
+			img.File.Close()
+		} else {
+			// image content can't be read directly, run the container
+			// to read metadata files
+			img.File.Close()
+			attributes = inspectContainer(abspath, name)
 		}
 
 		// format that data based on --json flag
@@ -237,6 +185,263 @@ var InspectCmd = &cobra.Command{
 	TraverseChildren: true,
 }
 
+// imageReader gives read access to the files of a container image
+// without starting the container
+type imageReader interface {
+	ReadFile(name string) ([]byte, error)
+	ReadDir(name string) ([]os.FileInfo, error)
+	// Deffile returns the definition file embedded in image metadata, if any
+	Deffile() []byte
+}
+
+// sandboxReader reads files from a sandbox directory
+type sandboxReader struct {
+	root string
+}
+
+func (s *sandboxReader) ReadFile(name string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(s.root, name))
+}
+
+func (s *sandboxReader) ReadDir(name string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(filepath.Join(s.root, name))
+}
+
+func (s *sandboxReader) Deffile() []byte {
+	return nil
+}
+
+// squashfsReader reads files from a squashfs image or the squashfs
+// partition of a SIF image
+type squashfsReader struct {
+	*squashfs.Reader
+	deffile []byte
+}
+
+func (s *squashfsReader) Deffile() []byte {
+	return s.deffile
+}
+
+// newImageReader returns an imageReader for the image img, or a nil
+// imageReader if the image format can't be read directly
+func newImageReader(img *image.Image) (imageReader, error) {
+	switch img.Type {
+	case image.SANDBOX:
+		return &sandboxReader{root: img.Path}, nil
+	case image.SQUASHFS:
+		r, err := img.SquashfsReader()
+		if err != nil {
+			return nil, err
+		}
+		return &squashfsReader{Reader: r}, nil
+	case image.SIF:
+		// use a separate handle on the image as unloading the SIF
+		// closes its file, img.File remains open for the squashfs reader
+		fimg, err := sif.LoadContainer(img.Path, true)
+		if err != nil {
+			return nil, err
+		}
+		defer fimg.UnloadContainer()
+
+		part, _, err := fimg.GetPartPrimSys()
+		if err != nil {
+			return nil, err
+		}
+		if fstype, err := part.GetFsType(); err != nil {
+			return nil, err
+		} else if fstype != sif.FsSquash {
+			return nil, nil
+		}
+		// files of layered images are read from within the container
+		if layers, err := image.SIFLayers(&fimg); err != nil || len(layers) > 0 {
+			return nil, err
+		}
+
+		r, err := img.SquashfsReader()
+		if err != nil {
+			return nil, err
+		}
+
+		sr := &squashfsReader{Reader: r}
+		if descr, _, err := fimg.GetFromDescr(sif.Descriptor{Datatype: sif.DataDeffile}); err == nil {
+			// copy the definition out of the mapping released on unload
+			sr.deffile = append([]byte(nil), descr[0].GetData(&fimg)...)
+		}
+		return sr, nil
+	}
+
+	return nil, nil
+}
+
+// getMetadataDir returns the directory holding metadata of the container or of an app
+func getMetadataDir(appName string) string {
+	if appName == "" {
+		return "/.singularity.d"
+	}
+
+	return fmt.Sprintf("/scif/apps/%s/scif", appName)
+}
+
+// inspectImage reads the requested metadata directly from the image
+func inspectImage(r imageReader) map[string]string {
+	attributes := make(map[string]string)
+	metadataDir := getMetadataDir(AppName)
+	selected := false
+
+	readFile := func(name string) string {
+		b, err := r.ReadFile(name)
+		if err != nil {
+			sylog.Debugf("While reading %s: %s", name, err)
+			return ""
+		}
+		return string(b)
+	}
+
+	add := func(attribute, content string) {
+		selected = true
+		if content = strings.TrimSpace(content); content == "" {
+			sylog.Warningf("%v metadata was not found.", attribute)
+			return
+		}
+		attributes[attribute] = content
+	}
+
+	if helpfile {
+		sylog.Debugf("Inspection of helpfile selected.")
+		add("helpfile", readFile(filepath.Join(metadataDir, "runscript.help")))
+	}
+
+	if deffile {
+		sylog.Debugf("Inspection of deffile selected.")
+		// apps share common definition file
+		content := string(r.Deffile())
+		if content == "" {
+			content = readFile("/.singularity.d/Singularity")
+		}
+		add("deffile", content)
+	}
+
+	if runscript {
+		sylog.Debugf("Inspection of runscript selected.")
+		add("runscript", readFile(filepath.Join(metadataDir, "runscript")))
+	}
+
+	if testfile {
+		sylog.Debugf("Inspection of test selected.")
+		add("test", readFile(filepath.Join(metadataDir, "test")))
+	}
+
+	if environment {
+		sylog.Debugf("Inspection of environment selected.")
+
+		content := ""
+		envDir := filepath.Join(metadataDir, "env")
+		files, err := r.ReadDir(envDir)
+		if err != nil {
+			sylog.Debugf("While reading %s: %s", envDir, err)
+		}
+		for _, f := range files {
+			if ok, _ := filepath.Match("9*-environment.sh", f.Name()); !ok || f.IsDir() {
+				continue
+			}
+			content += fmt.Sprintf("==%s==\n%s\n", f.Name(), readFile(filepath.Join(envDir, f.Name())))
+		}
+		add("environment", content)
+	}
+
+	// default to labels if nothing was selected
+	if labels || !selected {
+		sylog.Debugf("Inspection of labels as default.")
+		add("labels", readFile(filepath.Join(metadataDir, "labels.json")))
+	}
+
+	return attributes
+}
+
+// inspectContainer reads the requested metadata by running the container
+func inspectContainer(abspath, name string) map[string]string {
+	attributes := make(map[string]string)
+
+	a := []string{"/bin/sh", "-c", ""}
+	prefix := "@@@start"
+	delimiter := "@@@end"
+
+	if helpfile {
+		sylog.Debugf("Inspection of helpfile selected.")
+
+		// append to a[2] to run commands in container
+		a[2] += fmt.Sprintf(" echo '%v\nhelpfile';", prefix)
+		a[2] += getHelpFile(AppName)
+		a[2] += fmt.Sprintf(" echo '%v';", delimiter)
+	}
+
+	if deffile {
+		sylog.Debugf("Inspection of deffile selected.")
+
+		// append to a[2] to run commands in container
+		a[2] += fmt.Sprintf(" echo '%v\ndeffile';", prefix)
+		a[2] += " cat .singularity.d/Singularity;" // apps share common definition file
+		a[2] += fmt.Sprintf(" echo '%v';", delimiter)
+	}
+
+	if runscript {
+		sylog.Debugf("Inspection of runscript selected.")
+
+		// append to a[2] to run commands in container
+		a[2] += fmt.Sprintf(" echo '%v\nrunscript';", prefix)
+		a[2] += getRunscriptFile(AppName)
+		a[2] += fmt.Sprintf(" echo '%v';", delimiter)
+	}
+
+	if testfile {
+		sylog.Debugf("Inspection of test selected.")
+
+		// append to a[2] to run commands in container
+		a[2] += fmt.Sprintf(" echo '%v\ntest';", prefix)
+		a[2] += getTestFile(AppName)
+		a[2] += fmt.Sprintf(" echo '%v';", delimiter)
+	}
+
+	if environment {
+		sylog.Debugf("Inspection of environment selected.")
+
+		// append to a[2] to run commands in container
+		a[2] += fmt.Sprintf(" echo '%v\nenvironment';", prefix)
+		a[2] += getEnvFile(AppName)
+		a[2] += fmt.Sprintf(" echo '%v';", delimiter)
+	}
+
+	// default to labels if nothing was appended
+	if labels || len(a[2]) == 0 {
+		sylog.Debugf("Inspection of labels as default.")
+
+		// append to a[2] to run commands in container
+		a[2] += fmt.Sprintf(" echo '%v\nlabels';", prefix)
+		a[2] += getLabelsFile(AppName)
+		a[2] += fmt.Sprintf(" echo '%v';", delimiter)
+	}
+
+	fileContents, err := getFileContent(abspath, name, a)
+	if err != nil {
+		sylog.Fatalf("While getting helpfile: %v", err)
+	}
+
+	contentSlice := strings.Split(fileContents, delimiter)
+	for _, s := range contentSlice {
+		s = strings.TrimSpace(s)
+		if strings.HasPrefix(s, prefix) {
+			split := strings.SplitN(s, "\n", 3)
+			if len(split) == 3 {
+				attributes[split[1]] = split[2]
+			} else if len(split) == 2 {
+				sylog.Warningf("%v metadata was not found.", split[1])
+			}
+		}
+	}
+
+	return attributes
+}
+
 func getFileContent(abspath, name string, args []string) (string, error) {
 	starter := buildcfg.LIBEXECDIR + "/singularity/bin/starter-suid"
 	procname := "Singularity inspect"
@@ -29,6 +29,9 @@ func init() {
 	KeyCmd.AddCommand(KeySearchCmd)
 	KeyCmd.AddCommand(KeyPullCmd)
 	KeyCmd.AddCommand(KeyPushCmd)
+	KeyCmd.AddCommand(KeyImportCmd)
+	KeyCmd.AddCommand(KeyExportCmd)
+	KeyCmd.AddCommand(KeyRemoveCmd)
 
 	// keys commands
 	KeysCmd.AddCommand(KeyNewPairCmd)
@@ -36,6 +39,9 @@ func init() {
 	KeysCmd.AddCommand(KeySearchCmd)
 	KeysCmd.AddCommand(KeyPullCmd)
 	KeysCmd.AddCommand(KeyPushCmd)
+	KeysCmd.AddCommand(KeyImportCmd)
+	KeysCmd.AddCommand(KeyExportCmd)
+	KeysCmd.AddCommand(KeyRemoveCmd)
 }
 
 // KeysCmd is the 'keys' command that allows management of key stores
@@ -0,0 +1,71 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/pkg/sypgp"
+)
+
+var armor bool
+
+func init() {
+	KeyExportCmd.Flags().SetInterspersed(false)
+
+	KeyExportCmd.Flags().BoolVarP(&secret, "secret", "s", false, "export a private key instead of a public key")
+	KeyExportCmd.Flags().SetAnnotation("secret", "envkey", []string{"SECRET"})
+
+	KeyExportCmd.Flags().BoolVarP(&armor, "armor", "a", false, "export the key in ASCII armored format instead of binary format")
+	KeyExportCmd.Flags().SetAnnotation("armor", "envkey", []string{"ARMOR"})
+}
+
+// KeyExportCmd is `singularity key export' and exports a key from the local stores
+var KeyExportCmd = &cobra.Command{
+	Args:                  cobra.ExactArgs(2),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := doKeyExportCmd(args[0], args[1], secret, armor); err != nil {
+			sylog.Errorf("export failed: %s", err)
+			os.Exit(2)
+		}
+	},
+
+	Use:     docs.KeyExportUse,
+	Short:   docs.KeyExportShort,
+	Long:    docs.KeyExportLong,
+	Example: docs.KeyExportExample,
+}
+
+func doKeyExportCmd(fingerprint string, path string, secret bool, armor bool) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if secret {
+		err = sypgp.ExportPrivKey(f, fingerprint, armor)
+	} else {
+		err = sypgp.ExportPubKey(f, fingerprint, armor)
+	}
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	fmt.Printf("key with fingerprint %v exported successfully to `%v'\n", fingerprint, path)
+
+	return nil
+}
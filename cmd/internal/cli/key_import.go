@@ -0,0 +1,60 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/pkg/sypgp"
+)
+
+func init() {
+	KeyImportCmd.Flags().SetInterspersed(false)
+}
+
+// KeyImportCmd is `singularity key import' and imports keys into the local stores
+var KeyImportCmd = &cobra.Command{
+	Args:                  cobra.ExactArgs(1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := doKeyImportCmd(args[0]); err != nil {
+			sylog.Errorf("import failed: %s", err)
+			os.Exit(2)
+		}
+	},
+
+	Use:     docs.KeyImportUse,
+	Short:   docs.KeyImportShort,
+	Long:    docs.KeyImportLong,
+	Example: docs.KeyImportExample,
+}
+
+func doKeyImportCmd(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	el, err := sypgp.ImportKey(f)
+	if err != nil {
+		return err
+	}
+	if len(el) == 0 {
+		fmt.Printf("keys from `%v' are already in the local store and up to date\n", path)
+		return nil
+	}
+
+	for _, e := range el {
+		fmt.Printf("key with fingerprint %0X imported or updated successfully\n", e.PrimaryKey.Fingerprint)
+	}
+
+	return nil
+}
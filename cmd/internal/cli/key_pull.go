@@ -20,6 +20,7 @@ func init() {
 
 	KeyPullCmd.Flags().StringVarP(&keyServerURL, "url", "u", defaultKeyServer, "specify the key server URL")
 	KeyPullCmd.Flags().SetAnnotation("url", "envkey", []string{"URL"})
+	KeyPullCmd.Flags().SetAnnotation("url", "remotekey", []string{"keyserver"})
 }
 
 // KeyPullCmd is `singularity key pull' and fetches public keys from a key server
@@ -21,6 +21,7 @@ func init() {
 
 	KeyPushCmd.Flags().StringVarP(&keyServerURL, "url", "u", defaultKeyServer, "specify the key server URL")
 	KeyPushCmd.Flags().SetAnnotation("url", "envkey", []string{"URL"})
+	KeyPushCmd.Flags().SetAnnotation("url", "remotekey", []string{"keyserver"})
 }
 
 // KeyPushCmd is `singularity key list' and lists local store OpenPGP keys
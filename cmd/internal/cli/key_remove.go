@@ -0,0 +1,57 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/pkg/sypgp"
+)
+
+func init() {
+	KeyRemoveCmd.Flags().SetInterspersed(false)
+
+	KeyRemoveCmd.Flags().BoolVarP(&secret, "secret", "s", false, "remove a private key instead of a public key")
+	KeyRemoveCmd.Flags().SetAnnotation("secret", "envkey", []string{"SECRET"})
+}
+
+// KeyRemoveCmd is `singularity key remove' and removes a key from the local stores
+var KeyRemoveCmd = &cobra.Command{
+	Args:                  cobra.ExactArgs(1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := doKeyRemoveCmd(args[0], secret); err != nil {
+			sylog.Errorf("remove failed: %s", err)
+			os.Exit(2)
+		}
+	},
+
+	Use:     docs.KeyRemoveUse,
+	Short:   docs.KeyRemoveShort,
+	Long:    docs.KeyRemoveLong,
+	Example: docs.KeyRemoveExample,
+}
+
+func doKeyRemoveCmd(fingerprint string, secret bool) error {
+	if secret {
+		if err := sypgp.RemovePrivKey(fingerprint); err != nil {
+			return err
+		}
+		fmt.Printf("private key with fingerprint %v removed from `%v'\n", fingerprint, sypgp.SecretPath())
+		return nil
+	}
+
+	if err := sypgp.RemovePubKey(fingerprint); err != nil {
+		return err
+	}
+	fmt.Printf("public key with fingerprint %v removed from `%v'\n", fingerprint, sypgp.PublicPath())
+
+	return nil
+}
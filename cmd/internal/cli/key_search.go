@@ -20,6 +20,7 @@ func init() {
 
 	KeySearchCmd.Flags().StringVarP(&keyServerURL, "url", "u", defaultKeyServer, "specify the key server URL")
 	KeySearchCmd.Flags().SetAnnotation("url", "envkey", []string{"URL"})
+	KeySearchCmd.Flags().SetAnnotation("url", "remotekey", []string{"keyserver"})
 }
 
 // KeySearchCmd is `singularity key search' and look for public keys from a key server
@@ -0,0 +1,172 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	client "github.com/sylabs/singularity/pkg/client/library"
+)
+
+var (
+	// LibraryURI holds the base URI to the Sylabs library API instance
+	// managed by the library commands
+	LibraryURI string
+)
+
+func init() {
+	LibraryCmd.PersistentFlags().StringVar(&LibraryURI, "library", "https://library.sylabs.io", "the library to manage")
+	LibraryCmd.PersistentFlags().SetAnnotation("library", "envkey", []string{"LIBRARY"})
+	LibraryCmd.PersistentFlags().SetAnnotation("library", "remotekey", []string{"library"})
+
+	SingularityCmd.AddCommand(LibraryCmd)
+	LibraryCmd.AddCommand(LibraryTagsCmd)
+	LibraryCmd.AddCommand(LibraryImagesCmd)
+	LibraryCmd.AddCommand(LibraryTagCmd)
+	LibraryCmd.AddCommand(LibraryDeleteCmd)
+	LibraryCmd.AddCommand(LibraryCollectionCmd)
+}
+
+// LibraryCmd is the 'library' command that allows management of the images,
+// tags and collections of a library
+var LibraryCmd = &cobra.Command{
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.New("Invalid command")
+	},
+	DisableFlagsInUseLine: true,
+
+	Use:           docs.LibraryUse,
+	Short:         docs.LibraryShort,
+	Long:          docs.LibraryLong,
+	Example:       docs.LibraryExample,
+	SilenceErrors: true,
+}
+
+// libraryClient returns a client of the managed library. Commands changing
+// the library fail without a valid authToken.
+func libraryClient(write bool) *client.Client {
+	if write && authToken == "" {
+		sylog.Fatalf("Couldn't change library: %v", authWarning)
+	}
+	return client.NewClient(LibraryURI, authToken)
+}
+
+// LibraryTagsCmd is 'singularity library tags' and lists the tags of a
+// container
+var LibraryTagsCmd = &cobra.Command{
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.ExactArgs(1),
+	PreRun:                sylabsToken,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := listLibraryImages(args[0], true); err != nil {
+			sylog.Fatalf("Couldn't list tags: %v", err)
+		}
+	},
+
+	Use:     docs.LibraryTagsUse,
+	Short:   docs.LibraryTagsShort,
+	Long:    docs.LibraryTagsLong,
+	Example: docs.LibraryTagsExample,
+}
+
+// LibraryImagesCmd is 'singularity library images' and lists the images of
+// a container
+var LibraryImagesCmd = &cobra.Command{
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.ExactArgs(1),
+	PreRun:                sylabsToken,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := listLibraryImages(args[0], false); err != nil {
+			sylog.Fatalf("Couldn't list images: %v", err)
+		}
+	},
+
+	Use:     docs.LibraryImagesUse,
+	Short:   docs.LibraryImagesShort,
+	Long:    docs.LibraryImagesLong,
+	Example: docs.LibraryImagesExample,
+}
+
+// LibraryDeleteCmd is 'singularity library delete' and deletes an image
+var LibraryDeleteCmd = &cobra.Command{
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.ExactArgs(1),
+	PreRun:                sylabsToken,
+	Run: func(cmd *cobra.Command, args []string) {
+		c := libraryClient(true)
+		if err := c.DeleteLibraryImage(context.Background(), args[0]); err != nil {
+			sylog.Fatalf("Couldn't delete image: %v", err)
+		}
+		sylog.Infof("Image %s deleted", args[0])
+	},
+
+	Use:     docs.LibraryDeleteUse,
+	Short:   docs.LibraryDeleteShort,
+	Long:    docs.LibraryDeleteLong,
+	Example: docs.LibraryDeleteExample,
+}
+
+// listLibraryImages prints the tags of the container containerRef along with
+// the image they point to, or its images along with their tags if byTag is
+// false.
+func listLibraryImages(containerRef string, byTag bool) error {
+	ctx := context.Background()
+	c := libraryClient(false)
+
+	con, err := c.GetLibraryContainer(ctx, containerRef)
+	if err != nil {
+		return err
+	}
+	images, err := c.ListImages(ctx, con.ID.Hex())
+	if err != nil {
+		return err
+	}
+	tags, err := c.ListTags(ctx, con.ID.Hex())
+	if err != nil {
+		return err
+	}
+
+	hashes := make(map[string]string)
+	imageTags := make(map[string][]string)
+	for _, img := range images {
+		hashes[img.ID.Hex()] = img.Hash
+	}
+	var tagList []string
+	for tag, id := range tags {
+		tagList = append(tagList, tag)
+		imageTags[id.Hex()] = append(imageTags[id.Hex()], tag)
+	}
+	sort.Strings(tagList)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	if byTag {
+		fmt.Fprintln(w, "TAG\tIMAGE")
+		for _, tag := range tagList {
+			fmt.Fprintf(w, "%s\t%s\n", tag, hashes[tags[tag].Hex()])
+		}
+		return w.Flush()
+	}
+
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].CreatedAt.Before(images[j].CreatedAt)
+	})
+	fmt.Fprintln(w, "IMAGE\tSIZE\tCREATED\tTAGS")
+	for _, img := range images {
+		t := imageTags[img.ID.Hex()]
+		sort.Strings(t)
+		fmt.Fprintf(w, "%s\t%.1f MiB\t%s\t%s\n", img.Hash, float64(img.Size)/(1<<20), img.CreatedAt.Format("2006-01-02 15:04:05"), strings.Join(t, ","))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"context"
+	"errors"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+)
+
+// library collection create options
+var collectionPrivate bool
+
+func init() {
+	LibraryCollectionCreateCmd.Flags().SetInterspersed(false)
+
+	LibraryCollectionCreateCmd.Flags().BoolVar(&collectionPrivate, "private", false, "make the collection private")
+	LibraryCollectionCreateCmd.Flags().SetAnnotation("private", "envkey", []string{"PRIVATE"})
+
+	LibraryCollectionCmd.AddCommand(LibraryCollectionCreateCmd)
+	LibraryCollectionCmd.AddCommand(LibraryCollectionDeleteCmd)
+	LibraryCollectionCmd.AddCommand(LibraryCollectionVisibilityCmd)
+}
+
+// LibraryCollectionCmd is the 'library collection' command that allows
+// management of collections
+var LibraryCollectionCmd = &cobra.Command{
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.New("Invalid command")
+	},
+	DisableFlagsInUseLine: true,
+
+	Use:           docs.LibraryCollectionUse,
+	Short:         docs.LibraryCollectionShort,
+	Long:          docs.LibraryCollectionLong,
+	Example:       docs.LibraryCollectionExample,
+	SilenceErrors: true,
+}
+
+// LibraryCollectionCreateCmd is 'singularity library collection create' and
+// creates a collection
+var LibraryCollectionCreateCmd = &cobra.Command{
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.ExactArgs(1),
+	PreRun:                sylabsToken,
+	Run: func(cmd *cobra.Command, args []string) {
+		c := libraryClient(true)
+		if _, err := c.CreateLibraryCollection(context.Background(), args[0], collectionPrivate); err != nil {
+			sylog.Fatalf("Couldn't create collection: %v", err)
+		}
+		sylog.Infof("Collection %s created", args[0])
+	},
+
+	Use:     docs.LibraryCollectionCreateUse,
+	Short:   docs.LibraryCollectionCreateShort,
+	Long:    docs.LibraryCollectionCreateLong,
+	Example: docs.LibraryCollectionCreateExample,
+}
+
+// LibraryCollectionDeleteCmd is 'singularity library collection delete' and
+// deletes an empty collection
+var LibraryCollectionDeleteCmd = &cobra.Command{
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.ExactArgs(1),
+	PreRun:                sylabsToken,
+	Run: func(cmd *cobra.Command, args []string) {
+		c := libraryClient(true)
+		if err := c.DeleteLibraryCollection(context.Background(), args[0]); err != nil {
+			sylog.Fatalf("Couldn't delete collection: %v", err)
+		}
+		sylog.Infof("Collection %s deleted", args[0])
+	},
+
+	Use:     docs.LibraryCollectionDeleteUse,
+	Short:   docs.LibraryCollectionDeleteShort,
+	Long:    docs.LibraryCollectionDeleteLong,
+	Example: docs.LibraryCollectionDeleteExample,
+}
+
+// LibraryCollectionVisibilityCmd is 'singularity library collection
+// visibility' and makes a collection public or private
+var LibraryCollectionVisibilityCmd = &cobra.Command{
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.ExactArgs(2),
+	PreRun:                sylabsToken,
+	Run: func(cmd *cobra.Command, args []string) {
+		var private bool
+		switch args[1] {
+		case "private":
+			private = true
+		case "public":
+		default:
+			sylog.Fatalf("Unknown visibility %s, must be public or private", args[1])
+		}
+
+		c := libraryClient(true)
+		if err := c.SetLibraryVisibility(context.Background(), args[0], private); err != nil {
+			sylog.Fatalf("Couldn't change collection visibility: %v", err)
+		}
+		sylog.Infof("Collection %s is %s", args[0], args[1])
+	},
+
+	Use:     docs.LibraryCollectionVisibilityUse,
+	Short:   docs.LibraryCollectionVisibilityShort,
+	Long:    docs.LibraryCollectionVisibilityLong,
+	Example: docs.LibraryCollectionVisibilityExample,
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"context"
+	"errors"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+)
+
+func init() {
+	LibraryTagCmd.AddCommand(LibraryTagAddCmd)
+	LibraryTagCmd.AddCommand(LibraryTagMoveCmd)
+	LibraryTagCmd.AddCommand(LibraryTagRemoveCmd)
+}
+
+// LibraryTagCmd is the 'library tag' command that allows management of the
+// tags of a container
+var LibraryTagCmd = &cobra.Command{
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.New("Invalid command")
+	},
+	DisableFlagsInUseLine: true,
+
+	Use:           docs.LibraryTagUse,
+	Short:         docs.LibraryTagShort,
+	Long:          docs.LibraryTagLong,
+	Example:       docs.LibraryTagExample,
+	SilenceErrors: true,
+}
+
+// LibraryTagAddCmd is 'singularity library tag add' and adds new tags to an
+// image
+var LibraryTagAddCmd = &cobra.Command{
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.MinimumNArgs(2),
+	PreRun:                sylabsToken,
+	Run: func(cmd *cobra.Command, args []string) {
+		c := libraryClient(true)
+		if err := c.AddLibraryTags(context.Background(), args[0], args[1:], false); err != nil {
+			sylog.Fatalf("Couldn't add tags: %v", err)
+		}
+	},
+
+	Use:     docs.LibraryTagAddUse,
+	Short:   docs.LibraryTagAddShort,
+	Long:    docs.LibraryTagAddLong,
+	Example: docs.LibraryTagAddExample,
+}
+
+// LibraryTagMoveCmd is 'singularity library tag move' and points existing
+// tags to another image
+var LibraryTagMoveCmd = &cobra.Command{
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.MinimumNArgs(2),
+	PreRun:                sylabsToken,
+	Run: func(cmd *cobra.Command, args []string) {
+		c := libraryClient(true)
+		if err := c.AddLibraryTags(context.Background(), args[0], args[1:], true); err != nil {
+			sylog.Fatalf("Couldn't move tags: %v", err)
+		}
+	},
+
+	Use:     docs.LibraryTagMoveUse,
+	Short:   docs.LibraryTagMoveShort,
+	Long:    docs.LibraryTagMoveLong,
+	Example: docs.LibraryTagMoveExample,
+}
+
+// LibraryTagRemoveCmd is 'singularity library tag remove' and removes tags
+// from a container
+var LibraryTagRemoveCmd = &cobra.Command{
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.ExactArgs(1),
+	PreRun:                sylabsToken,
+	Run: func(cmd *cobra.Command, args []string) {
+		c := libraryClient(true)
+		if err := c.RemoveLibraryTags(context.Background(), args[0]); err != nil {
+			sylog.Fatalf("Couldn't remove tags: %v", err)
+		}
+	},
+
+	Use:     docs.LibraryTagRemoveUse,
+	Short:   docs.LibraryTagRemoveShort,
+	Long:    docs.LibraryTagRemoveLong,
+	Example: docs.LibraryTagRemoveExample,
+}
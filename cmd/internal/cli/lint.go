@@ -0,0 +1,81 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/pkg/build/types/parser"
+)
+
+var lintJSON bool
+
+func init() {
+	LintCmd.Flags().SetInterspersed(false)
+
+	LintCmd.Flags().BoolVarP(&lintJSON, "json", "j", false, "print diagnostics as structured json")
+	LintCmd.Flags().SetAnnotation("json", "envkey", []string{"JSON"})
+
+	SingularityCmd.AddCommand(LintCmd)
+}
+
+// LintCmd singularity lint
+var LintCmd = &cobra.Command{
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if !doLint(args[0]) {
+			os.Exit(1)
+		}
+	},
+
+	Use:     docs.LintUse,
+	Short:   docs.LintShort,
+	Long:    docs.LintLong,
+	Example: docs.LintExample,
+}
+
+// doLint prints the diagnostics of the definition file path and returns
+// false if an error was found.
+func doLint(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		sylog.Fatalf("Unable to open definition file: %v", err)
+	}
+	defer f.Close()
+
+	diagnostics, err := parser.Lint(path, f)
+	if err != nil {
+		sylog.Fatalf("While linting %s: %v", path, err)
+	}
+
+	if lintJSON {
+		if diagnostics == nil {
+			diagnostics = []parser.Diagnostic{}
+		}
+		b, err := json.MarshalIndent(diagnostics, "", "\t")
+		if err != nil {
+			sylog.Fatalf("While encoding diagnostics: %v", err)
+		}
+		fmt.Println(string(b))
+	} else {
+		for _, d := range diagnostics {
+			fmt.Println(d)
+		}
+	}
+
+	for _, d := range diagnostics {
+		if d.Severity == parser.SeverityError {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/pkg/overlay"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+)
+
+func init() {
+	OverlayAddCmd.Flags().SetInterspersed(false)
+
+	OverlayAddCmd.Flags().IntVarP(&overlaySize, "size", "s", 0, "size of the overlay partition in MiB")
+	OverlayAddCmd.Flags().SetAnnotation("size", "envkey", []string{"OVERLAY_SIZE"})
+}
+
+// OverlayAddCmd is 'singularity overlay add' and adds an overlay partition to a SIF image
+var OverlayAddCmd = &cobra.Command{
+	Args:                  cobra.ExactArgs(1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := doOverlayAddCmd(args[0]); err != nil {
+			sylog.Errorf("overlay add failed: %s", err)
+			os.Exit(2)
+		}
+	},
+
+	Use:     docs.OverlayAddUse,
+	Short:   docs.OverlayAddShort,
+	Long:    docs.OverlayAddLong,
+	Example: docs.OverlayAddExample,
+}
+
+func doOverlayAddCmd(path string) error {
+	size, err := overlaySizeBytes()
+	if err != nil {
+		return err
+	}
+	if err := overlay.AddSIFOverlay(path, size); err != nil {
+		return err
+	}
+	fmt.Printf("Overlay partition of %d MiB added to %s\n", overlaySize, path)
+	return nil
+}
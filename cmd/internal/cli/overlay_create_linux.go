@@ -0,0 +1,57 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/pkg/overlay"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+)
+
+var overlaySparse bool
+
+func init() {
+	OverlayCreateCmd.Flags().SetInterspersed(false)
+
+	OverlayCreateCmd.Flags().IntVarP(&overlaySize, "size", "s", 0, "size of the overlay image in MiB")
+	OverlayCreateCmd.Flags().SetAnnotation("size", "envkey", []string{"OVERLAY_SIZE"})
+
+	OverlayCreateCmd.Flags().BoolVar(&overlaySparse, "sparse", false, "create a sparse image, blocks are allocated on write")
+	OverlayCreateCmd.Flags().SetAnnotation("sparse", "envkey", []string{"OVERLAY_SPARSE"})
+}
+
+// OverlayCreateCmd is 'singularity overlay create' and creates an ext3 overlay image
+var OverlayCreateCmd = &cobra.Command{
+	Args:                  cobra.ExactArgs(1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := doOverlayCreateCmd(args[0]); err != nil {
+			sylog.Errorf("overlay create failed: %s", err)
+			os.Exit(2)
+		}
+	},
+
+	Use:     docs.OverlayCreateUse,
+	Short:   docs.OverlayCreateShort,
+	Long:    docs.OverlayCreateLong,
+	Example: docs.OverlayCreateExample,
+}
+
+func doOverlayCreateCmd(path string) error {
+	size, err := overlaySizeBytes()
+	if err != nil {
+		return err
+	}
+	if err := overlay.CreateExt3(path, size, overlaySparse); err != nil {
+		return err
+	}
+	fmt.Printf("Overlay image of %d MiB created at %s\n", overlaySize, path)
+	return nil
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/pkg/overlay"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+)
+
+// OverlayInspectCmd is 'singularity overlay inspect' and describes the overlay partition of a SIF image
+var OverlayInspectCmd = &cobra.Command{
+	Args:                  cobra.ExactArgs(1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := doOverlayInspectCmd(args[0]); err != nil {
+			sylog.Errorf("overlay inspect failed: %s", err)
+			os.Exit(2)
+		}
+	},
+
+	Use:     docs.OverlayInspectUse,
+	Short:   docs.OverlayInspectShort,
+	Long:    docs.OverlayInspectLong,
+	Example: docs.OverlayInspectExample,
+}
+
+func doOverlayInspectCmd(path string) error {
+	o, err := overlay.GetSIFOverlay(path)
+	if err != nil {
+		return err
+	}
+	info := o.Info
+
+	fmt.Printf("Overlay partition of %s:\n", path)
+	fmt.Printf("  %-12s %d\n", "ID:", o.ID)
+	fmt.Printf("  %-12s %d\n", "Group:", o.Groupid)
+	fmt.Printf("  %-12s %s\n", "Size:", formatSize(info.Size()))
+	fmt.Printf("  %-12s %s\n", "Used:", formatSize(info.Size()-info.Free()))
+	fmt.Printf("  %-12s %s\n", "Free:", formatSize(info.Free()))
+	fmt.Printf("  %-12s %d/%d\n", "Inodes:", info.Inodes-info.FreeInodes, info.Inodes)
+	fmt.Printf("  %-12s %d\n", "Block size:", info.BlockSize)
+	return nil
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+)
+
+// overlay create/add/resize options
+var overlaySize int
+
+func init() {
+	SingularityCmd.AddCommand(OverlayCmd)
+	OverlayCmd.AddCommand(OverlayCreateCmd)
+	OverlayCmd.AddCommand(OverlayAddCmd)
+	OverlayCmd.AddCommand(OverlayResizeCmd)
+	OverlayCmd.AddCommand(OverlayInspectCmd)
+	OverlayCmd.AddCommand(OverlayRemoveCmd)
+}
+
+// OverlayCmd is the 'overlay' command that allows management of overlay
+// images and of overlay partitions embedded in SIF images
+var OverlayCmd = &cobra.Command{
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.New("Invalid command")
+	},
+	DisableFlagsInUseLine: true,
+
+	Use:           docs.OverlayUse,
+	Short:         docs.OverlayShort,
+	Long:          docs.OverlayLong,
+	Example:       docs.OverlayExample,
+	SilenceErrors: true,
+}
+
+// overlaySizeBytes returns the size set with --size in bytes.
+func overlaySizeBytes() (int64, error) {
+	if overlaySize <= 0 {
+		return 0, fmt.Errorf("a positive size in MiB must be set with --size")
+	}
+	return int64(overlaySize) << 20, nil
+}
+
+// formatSize returns size in MiB with one decimal.
+func formatSize(size uint64) string {
+	return fmt.Sprintf("%.1f MiB", float64(size)/(1<<20))
+}
@@ -0,0 +1,34 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/pkg/overlay"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+)
+
+// OverlayRemoveCmd is 'singularity overlay remove' and removes the overlay partition of a SIF image
+var OverlayRemoveCmd = &cobra.Command{
+	Args:                  cobra.ExactArgs(1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := overlay.RemoveSIFOverlay(args[0]); err != nil {
+			sylog.Errorf("overlay remove failed: %s", err)
+			os.Exit(2)
+		}
+		fmt.Printf("Overlay partition removed from %s\n", args[0])
+	},
+
+	Use:     docs.OverlayRemoveUse,
+	Short:   docs.OverlayRemoveShort,
+	Long:    docs.OverlayRemoveLong,
+	Example: docs.OverlayRemoveExample,
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/pkg/overlay"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+)
+
+func init() {
+	OverlayResizeCmd.Flags().SetInterspersed(false)
+
+	OverlayResizeCmd.Flags().IntVarP(&overlaySize, "size", "s", 0, "new size of the overlay partition in MiB")
+	OverlayResizeCmd.Flags().SetAnnotation("size", "envkey", []string{"OVERLAY_SIZE"})
+}
+
+// OverlayResizeCmd is 'singularity overlay resize' and resizes the overlay partition of a SIF image
+var OverlayResizeCmd = &cobra.Command{
+	Args:                  cobra.ExactArgs(1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := doOverlayResizeCmd(args[0]); err != nil {
+			sylog.Errorf("overlay resize failed: %s", err)
+			os.Exit(2)
+		}
+	},
+
+	Use:     docs.OverlayResizeUse,
+	Short:   docs.OverlayResizeShort,
+	Long:    docs.OverlayResizeLong,
+	Example: docs.OverlayResizeExample,
+}
+
+func doOverlayResizeCmd(path string) error {
+	size, err := overlaySizeBytes()
+	if err != nil {
+		return err
+	}
+	if err := overlay.ResizeSIFOverlay(path, size); err != nil {
+		return err
+	}
+	fmt.Printf("Overlay partition of %s resized to %d MiB\n", path, overlaySize)
+	return nil
+}
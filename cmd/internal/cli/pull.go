@@ -39,6 +39,8 @@ var (
 	PullLibraryURI string
 	// PullImageName holds the name to be given to the pulled image
 	PullImageName string
+	// PullParallel holds the number of connections used to download library images
+	PullParallel int
 )
 
 func init() {
@@ -46,10 +48,14 @@ func init() {
 
 	PullCmd.Flags().StringVar(&PullLibraryURI, "library", "https://library.sylabs.io", "the library to pull from")
 	PullCmd.Flags().SetAnnotation("library", "envkey", []string{"LIBRARY"})
+	PullCmd.Flags().SetAnnotation("library", "remotekey", []string{"library"})
 
 	PullCmd.Flags().BoolVarP(&force, "force", "F", false, "overwrite an image file if it exists")
 	PullCmd.Flags().SetAnnotation("force", "envkey", []string{"FORCE"})
 
+	PullCmd.Flags().IntVar(&PullParallel, "parallel", 1, "number of parallel connections used to download a library image")
+	PullCmd.Flags().SetAnnotation("parallel", "envkey", []string{"PARALLEL"})
+
 	PullCmd.Flags().StringVar(&PullImageName, "name", "", "specify a custom image name")
 	PullCmd.Flags().Lookup("name").Hidden = true
 	PullCmd.Flags().SetAnnotation("name", "envkey", []string{"NAME"})
@@ -126,15 +132,9 @@ func pullRun(cmd *cobra.Command, args []string) {
 			sylog.Fatalf("unable to check if %v exists: %v", imagePath, err)
 		} else if !exists {
 			sylog.Infof("Downloading library image")
-			if err = client.DownloadImage(imagePath, args[i], PullLibraryURI, true, authToken); err != nil {
+			if err = client.DownloadImageParallel(imagePath, args[i], PullLibraryURI, true, authToken, PullParallel); err != nil {
 				sylog.Fatalf("unable to Download Image: %v", err)
 			}
-
-			if cacheFileHash, err := client.ImageHash(imagePath); err != nil {
-				sylog.Fatalf("Error getting ImageHash: %v", err)
-			} else if cacheFileHash != libraryImage.Hash {
-				sylog.Fatalf("Cached File Hash(%s) and Expected Hash(%s) does not match", cacheFileHash, libraryImage.Hash)
-			}
 		}
 
 		// Perms are 777 *prior* to umask
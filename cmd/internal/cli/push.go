@@ -1,4 +1,4 @@
-// Copyright (c) 2018, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2019, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -22,6 +22,7 @@ func init() {
 
 	PushCmd.Flags().StringVar(&PushLibraryURI, "library", "https://library.sylabs.io", "the library to push to")
 	PushCmd.Flags().SetAnnotation("library", "envkey", []string{"LIBRARY"})
+	PushCmd.Flags().SetAnnotation("library", "remotekey", []string{"library"})
 
 	SingularityCmd.AddCommand(PushCmd)
 }
@@ -0,0 +1,71 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"errors"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/pkg/remote"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+)
+
+func init() {
+	SingularityCmd.AddCommand(RemoteCmd)
+
+	RemoteCmd.AddCommand(RemoteAddCmd)
+	RemoteCmd.AddCommand(RemoteRemoveCmd)
+	RemoteCmd.AddCommand(RemoteListCmd)
+	RemoteCmd.AddCommand(RemoteUseCmd)
+	RemoteCmd.AddCommand(RemoteLoginCmd)
+	RemoteCmd.AddCommand(RemoteStatusCmd)
+}
+
+// RemoteCmd is the 'remote' command that allows management of the named
+// endpoints used by the client commands
+var RemoteCmd = &cobra.Command{
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.New("Invalid command")
+	},
+	DisableFlagsInUseLine: true,
+
+	Use:           docs.RemoteUse,
+	Short:         docs.RemoteShort,
+	Long:          docs.RemoteLong,
+	Example:       docs.RemoteExample,
+	SilenceErrors: true,
+}
+
+// loadRemotes returns the remote configuration of the user
+func loadRemotes() remote.Config {
+	c, err := remote.LoadConfig(remoteConfigFile)
+	if err != nil {
+		sylog.Fatalf("While loading remotes: %s", err)
+	}
+	return c
+}
+
+// saveRemotes writes the remote configuration of the user
+func saveRemotes(c remote.Config) {
+	if err := remote.PutConfig(c, remoteConfigFile); err != nil {
+		sylog.Fatalf("While saving remotes to %s: %s", remoteConfigFile, err)
+	}
+}
+
+// remoteArg returns the remote named by the optional argument of a remote
+// command, or the active remote
+func remoteArg(c *remote.Config, args []string) *remote.Remote {
+	name := c.Active
+	if len(args) > 0 {
+		name = args[0]
+	}
+	r, err := c.Get(name)
+	if err != nil {
+		sylog.Fatalf("%s", err)
+	}
+	return r
+}
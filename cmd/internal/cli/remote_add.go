@@ -0,0 +1,58 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/pkg/remote"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+)
+
+// remote add options
+var (
+	remoteLibrary   string
+	remoteKeyserver string
+	remoteBuilder   string
+	remoteUse       bool
+)
+
+func init() {
+	RemoteAddCmd.Flags().SetInterspersed(false)
+
+	RemoteAddCmd.Flags().StringVar(&remoteLibrary, "library", "", "URL of the library of the remote (required)")
+	RemoteAddCmd.Flags().StringVar(&remoteKeyserver, "keyserver", "", "URL of the key server of the remote")
+	RemoteAddCmd.Flags().StringVar(&remoteBuilder, "builder", "", "URL of the remote build service of the remote")
+	RemoteAddCmd.Flags().BoolVar(&remoteUse, "use", false, "make the new remote the active one")
+}
+
+// RemoteAddCmd is 'singularity remote add' and adds a named remote
+var RemoteAddCmd = &cobra.Command{
+	Args:                  cobra.ExactArgs(1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		c := loadRemotes()
+		r := remote.Remote{
+			Name:      args[0],
+			Library:   remoteLibrary,
+			Keyserver: remoteKeyserver,
+			Builder:   remoteBuilder,
+		}
+		if err := c.Add(r); err != nil {
+			sylog.Fatalf("Couldn't add remote: %s", err)
+		}
+		if remoteUse {
+			c.Use(r.Name)
+		}
+		saveRemotes(c)
+		sylog.Infof("Remote %s added", r.Name)
+	},
+
+	Use:     docs.RemoteAddUse,
+	Short:   docs.RemoteAddShort,
+	Long:    docs.RemoteAddLong,
+	Example: docs.RemoteAddExample,
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+)
+
+// RemoteListCmd is 'singularity remote list' and lists the remotes
+var RemoteListCmd = &cobra.Command{
+	Args:                  cobra.ExactArgs(0),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		c := loadRemotes()
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tACTIVE\tLOGGED IN\tLIBRARY\tKEYSERVER\tBUILDER")
+		for _, name := range c.Names() {
+			r, _ := c.Get(name)
+			active, login := "", "no"
+			if name == c.Active {
+				active = "*"
+			}
+			if r.Token != "" {
+				login = "yes"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", name, active, login, r.Library, r.Keyserver, r.Builder)
+		}
+		w.Flush()
+	},
+
+	Use:     docs.RemoteListUse,
+	Short:   docs.RemoteListShort,
+	Long:    docs.RemoteListLong,
+	Example: docs.RemoteListExample,
+}
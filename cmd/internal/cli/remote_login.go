@@ -0,0 +1,75 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/internal/pkg/util/auth"
+	"github.com/sylabs/singularity/pkg/sypgp"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// remote login options
+var remoteLogout bool
+
+func init() {
+	RemoteLoginCmd.Flags().SetInterspersed(false)
+
+	RemoteLoginCmd.Flags().BoolVar(&remoteLogout, "logout", false, "remove the token of the remote instead")
+}
+
+// RemoteLoginCmd is 'singularity remote login' and stores the token of a
+// remote
+var RemoteLoginCmd = &cobra.Command{
+	Args:                  cobra.RangeArgs(0, 1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		c := loadRemotes()
+		r := remoteArg(&c, args)
+
+		if remoteLogout {
+			r.Token = ""
+			saveRemotes(c)
+			sylog.Infof("Token of remote %s removed", r.Name)
+			return
+		}
+
+		token, err := readToken(r.Name)
+		if err != nil {
+			sylog.Fatalf("Couldn't read token: %s", err)
+		}
+		if warning := auth.CheckToken(token); warning != "" {
+			sylog.Fatalf("Couldn't login to remote %s: %s", r.Name, warning)
+		}
+		r.Token = token
+		saveRemotes(c)
+		sylog.Infof("Token of remote %s stored", r.Name)
+	},
+
+	Use:     docs.RemoteLoginUse,
+	Short:   docs.RemoteLoginShort,
+	Long:    docs.RemoteLoginLong,
+	Example: docs.RemoteLoginExample,
+}
+
+// readToken prompts for the token of the remote name, or reads it from the
+// standard input when it isn't a terminal
+func readToken(name string) (string, error) {
+	if terminal.IsTerminal(int(os.Stdin.Fd())) {
+		sylog.Infof("Generate an access token from the library of remote %s", name)
+		token, err := sypgp.AskQuestionNoEcho("Paste the token here (it will not be echoed): ")
+		return strings.TrimSpace(token), err
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Scan()
+	return strings.TrimSpace(scanner.Text()), scanner.Err()
+}
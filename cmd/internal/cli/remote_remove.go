@@ -0,0 +1,32 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+)
+
+// RemoteRemoveCmd is 'singularity remote remove' and removes a named remote
+// along with its token
+var RemoteRemoveCmd = &cobra.Command{
+	Args:                  cobra.ExactArgs(1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		c := loadRemotes()
+		if err := c.Remove(args[0]); err != nil {
+			sylog.Fatalf("Couldn't remove remote: %s", err)
+		}
+		saveRemotes(c)
+		sylog.Infof("Remote %s removed", args[0])
+	},
+
+	Use:     docs.RemoteRemoveUse,
+	Short:   docs.RemoteRemoveShort,
+	Long:    docs.RemoteRemoveLong,
+	Example: docs.RemoteRemoveExample,
+}
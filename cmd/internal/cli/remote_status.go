@@ -0,0 +1,56 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+)
+
+// RemoteStatusCmd is 'singularity remote status' and checks the services of
+// a remote
+var RemoteStatusCmd = &cobra.Command{
+	Args:                  cobra.RangeArgs(0, 1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		c := loadRemotes()
+		r := remoteArg(&c, args)
+
+		fmt.Printf("Checking status of remote %s\n\n", r.Name)
+
+		failed := false
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "SERVICE\tSTATUS\tVERSION\tURL")
+		for _, s := range r.Status(context.Background()) {
+			status := "OK"
+			if s.Err != nil {
+				status = fmt.Sprintf("N/A (%s)", s.Err)
+				failed = true
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.Service, status, s.Version, s.URL)
+		}
+		w.Flush()
+
+		if r.Token != "" {
+			fmt.Printf("\nLogged in to remote %s\n", r.Name)
+		} else {
+			fmt.Printf("\nNot logged in to remote %s\n", r.Name)
+		}
+		if failed {
+			os.Exit(2)
+		}
+	},
+
+	Use:     docs.RemoteStatusUse,
+	Short:   docs.RemoteStatusShort,
+	Long:    docs.RemoteStatusLong,
+	Example: docs.RemoteStatusExample,
+}
@@ -0,0 +1,31 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+)
+
+// RemoteUseCmd is 'singularity remote use' and makes a remote the active one
+var RemoteUseCmd = &cobra.Command{
+	Args:                  cobra.ExactArgs(1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		c := loadRemotes()
+		if err := c.Use(args[0]); err != nil {
+			sylog.Fatalf("Couldn't use remote: %s", err)
+		}
+		saveRemotes(c)
+		sylog.Infof("Remote %s is now active", args[0])
+	},
+
+	Use:     docs.RemoteUseUse,
+	Short:   docs.RemoteUseShort,
+	Long:    docs.RemoteUseLong,
+	Example: docs.RemoteUseExample,
+}
@@ -24,8 +24,10 @@ func init() {
 
 	SignCmd.Flags().StringVarP(&keyServerURL, "url", "u", defaultKeyServer, "key server URL")
 	SignCmd.Flags().SetAnnotation("url", "envkey", []string{"URL"})
+	SignCmd.Flags().SetAnnotation("url", "remotekey", []string{"keyserver"})
 	SignCmd.Flags().Uint32VarP(&sifGroupID, "groupid", "g", 0, "group ID to be signed")
 	SignCmd.Flags().Uint32VarP(&sifDescID, "id", "i", 0, "descriptor ID to be signed")
+	SignCmd.Flags().BoolVarP(&sifAll, "all", "a", false, "sign all data objects as one set, including the definition file, labels and environment")
 	SignCmd.Flags().IntVarP(&privKey, "keyidx", "k", -1, "private key to use (index from 'keys list')")
 
 	SingularityCmd.AddCommand(SignCmd)
@@ -57,6 +59,12 @@ func doSignCmd(cpath, url string) error {
 	if sifGroupID != 0 && sifDescID != 0 {
 		return fmt.Errorf("only one of -i or -g may be set")
 	}
+	if sifAll {
+		if sifGroupID != 0 || sifDescID != 0 {
+			return fmt.Errorf("-a can't be used with -i or -g")
+		}
+		return signing.SignAll(cpath, url, privKey, authToken)
+	}
 
 	var isGroup bool
 	var id uint32
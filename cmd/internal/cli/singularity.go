@@ -1,4 +1,4 @@
-// Copyright (c) 2018, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2019, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -18,6 +18,8 @@ import (
 	"github.com/spf13/pflag"
 	"github.com/sylabs/singularity/docs"
 	"github.com/sylabs/singularity/internal/pkg/buildcfg"
+	"github.com/sylabs/singularity/internal/pkg/fakeroot"
+	"github.com/sylabs/singularity/internal/pkg/remote"
 	"github.com/sylabs/singularity/internal/pkg/sylog"
 	"github.com/sylabs/singularity/internal/pkg/util/auth"
 )
@@ -35,6 +37,10 @@ var (
 	defaultTokenFile, tokenFile string
 	// authToken holds the sylabs auth token
 	authToken, authWarning string
+	// remoteConfigFile holds the path to the user remote configuration
+	remoteConfigFile string
+	// currentRemote holds the remote used by the client commands
+	currentRemote *remote.Remote
 )
 
 const (
@@ -58,6 +64,7 @@ func init() {
 		sylog.Fatalf("Couldn't determine user home directory: %v", err)
 	}
 	defaultTokenFile = path.Join(usr.HomeDir, ".singularity", "sylabs-token")
+	remoteConfigFile = path.Join(usr.HomeDir, ".singularity", "remote.toml")
 
 	SingularityCmd.Flags().BoolVarP(&debug, "debug", "d", false, "print debugging information (highest verbosity)")
 	SingularityCmd.Flags().BoolVarP(&silent, "silent", "s", false, "only print errors")
@@ -164,26 +171,71 @@ func handleEnv(flag *pflag.Flag) {
 
 func persistentPreRun(cmd *cobra.Command, args []string) {
 	setSylogMessageLevel(cmd, args)
+	// wait for the user namespace set up by a parent with --fakeroot
+	if err := fakeroot.Init(); err != nil {
+		sylog.Fatalf("While entering fakeroot user namespace: %s", err)
+	}
 	updateFlagsFromEnv(cmd)
 }
 
-// sylabsToken process the authentication Token
-// priority default_file < env < file_flag
+// sylabsToken process the authentication Token and the endpoints of the
+// active remote. Token priority default_file < remote < env < file_flag,
+// the default file is only read for the Sylabs cloud remote.
 func sylabsToken(cmd *cobra.Command, args []string) {
+	useRemote(cmd)
+
 	if val := os.Getenv("SYLABS_TOKEN"); val != "" {
 		authToken = val
 	}
 	if tokenFile != defaultTokenFile {
 		authToken, authWarning = auth.ReadToken(tokenFile)
 	}
+	if authToken == "" && currentRemote.Token != "" {
+		authToken = currentRemote.Token
+	}
 	if authToken == "" {
-		authToken, authWarning = auth.ReadToken(defaultTokenFile)
+		if currentRemote.Name == remote.DefaultName {
+			authToken, authWarning = auth.ReadToken(defaultTokenFile)
+		} else if authWarning == "" {
+			authWarning = fmt.Sprintf("Not logged in to remote %s", currentRemote.Name)
+		}
 	}
-	if authToken == "" && authWarning == auth.WarningTokenFileNotFound {
+	if authToken == "" && (authWarning == auth.WarningTokenFileNotFound || currentRemote.Name != remote.DefaultName) {
 		sylog.Warningf("%v : Only pulls of public images will succeed", authWarning)
 	}
 }
 
+// useRemote loads the active remote, and sets the flags of cmd with a
+// remotekey annotation that weren't set on the command line or in the
+// environment to the URL of the remote service
+func useRemote(cmd *cobra.Command) {
+	c, err := remote.LoadConfig(remoteConfigFile)
+	if err != nil {
+		sylog.Fatalf("While loading remotes: %s", err)
+	}
+	if currentRemote, err = c.Current(); err != nil {
+		sylog.Fatalf("While loading remotes: %s", err)
+	}
+	sylog.Debugf("Using remote %s", currentRemote.Name)
+
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		services, ok := flag.Annotations["remotekey"]
+		if !ok || flag.Changed {
+			return
+		}
+		// a service missing from the remote is left unset rather than
+		// defaulting to a Sylabs cloud service, which would receive the
+		// token of the remote
+		url := currentRemote.URL(services[0])
+		if url == "" {
+			sylog.Debugf("Remote %s has no %s, it must be set with --%s", currentRemote.Name, services[0], flag.Name)
+		}
+		if err := flag.Value.Set(url); err != nil {
+			sylog.Fatalf("Unable to set %s to %s: %s", flag.Name, url, err)
+		}
+	})
+}
+
 // envAppend combines command line and environment var into a single argument
 func envAppend(flag *pflag.Flag, envvar string) {
 	if err := flag.Value.Set(envvar); err != nil {
@@ -292,6 +344,19 @@ var flagEnvFuncs = map[string]envHandle{
 	"docker-username": envStringNSlice,
 	"docker-password": envStringNSlice,
 	"docker-login":    envBool,
+	"build-arg":       envStringNSlice,
+	"build-arg-file":  envStringNSlice,
+	"reproducible":    envBool,
+	"compression":     envStringNSlice,
+	"block-size":      envStringNSlice,
+	"processors":      envStringNSlice,
+	"exclude":         envStringNSlice,
+
+	// pull flags
+	"parallel": envStringNSlice,
+
+	// library flags
+	"private": envBool,
 
 	// capability flags (and others)
 	"user":  envStringNSlice,
@@ -11,13 +11,18 @@ import (
 
 	"github.com/spf13/cobra"
 	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/pkg/buildcfg"
+	"github.com/sylabs/singularity/internal/pkg/runtime/engines/config"
+	singularityConfig "github.com/sylabs/singularity/internal/pkg/runtime/engines/singularity/config"
 	"github.com/sylabs/singularity/internal/pkg/sylog"
 	"github.com/sylabs/singularity/pkg/signing"
 )
 
 var (
-	sifGroupID uint32 // -g groupid specification
-	sifDescID  uint32 // -i id specification
+	sifGroupID    uint32 // -g groupid specification
+	sifDescID     uint32 // -i id specification
+	sifAll        bool   // -a all data objects specification
+	verifyOffline bool   // --offline, never contact the key server
 )
 
 func init() {
@@ -25,8 +30,12 @@ func init() {
 
 	VerifyCmd.Flags().StringVarP(&keyServerURL, "url", "u", defaultKeyServer, "key server URL")
 	VerifyCmd.Flags().SetAnnotation("url", "envkey", []string{"URL"})
+	VerifyCmd.Flags().SetAnnotation("url", "remotekey", []string{"keyserver"})
 	VerifyCmd.Flags().Uint32VarP(&sifGroupID, "groupid", "g", 0, "group ID to be verified")
 	VerifyCmd.Flags().Uint32VarP(&sifDescID, "id", "i", 0, "descriptor ID to be verified")
+	VerifyCmd.Flags().BoolVarP(&sifAll, "all", "a", false, "verify the signatures covering all data objects and report the status of each object")
+	VerifyCmd.Flags().BoolVar(&verifyOffline, "offline", false, "only use the trusted and local keyrings, never contact the key server")
+	VerifyCmd.Flags().SetAnnotation("offline", "envkey", []string{"VERIFY_OFFLINE"})
 	SingularityCmd.AddCommand(VerifyCmd)
 }
 
@@ -55,6 +64,9 @@ func doVerifyCmd(cpath, url string) error {
 	if sifGroupID != 0 && sifDescID != 0 {
 		return fmt.Errorf("only one of -i or -g may be set")
 	}
+	if sifAll && (sifGroupID != 0 || sifDescID != 0) {
+		return fmt.Errorf("-a can't be used with -i or -g")
+	}
 
 	var isGroup bool
 	var id uint32
@@ -65,5 +77,19 @@ func doVerifyCmd(cpath, url string) error {
 		id = sifDescID
 	}
 
+	if verifyOffline {
+		c := &singularityConfig.FileConfig{}
+		if err := config.Parser(buildcfg.SYSCONFDIR+"/singularity/singularity.conf", c); err != nil {
+			return fmt.Errorf("unable to parse singularity.conf file: %s", err)
+		}
+		if sifAll {
+			return signing.VerifyAllOffline(cpath, c.TrustedKeyring)
+		}
+		return signing.VerifyOffline(cpath, id, isGroup, c.TrustedKeyring)
+	}
+
+	if sifAll {
+		return signing.VerifyAll(cpath, url, authToken, false)
+	}
 	return signing.Verify(cpath, url, id, isGroup, authToken, false)
 }
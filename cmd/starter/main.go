@@ -19,11 +19,13 @@ import (
 	"unsafe"
 
 	"github.com/sylabs/singularity/internal/app/starter"
+	"github.com/sylabs/singularity/internal/pkg/buildcfg"
 	"github.com/sylabs/singularity/internal/pkg/runtime/engines"
 	starterConfig "github.com/sylabs/singularity/internal/pkg/runtime/engines/config/starter"
 	"github.com/sylabs/singularity/internal/pkg/sylog"
 	"github.com/sylabs/singularity/internal/pkg/util/goversion"
 	"github.com/sylabs/singularity/internal/pkg/util/mainthread"
+	useragent "github.com/sylabs/singularity/pkg/util/user-agent"
 )
 
 func getEngine(jsonConfig []byte) *engines.Engine {
@@ -43,6 +45,10 @@ func startup() {
 		}
 	}
 
+	// the engines may query Sylabs services, e.g. the key server
+	// configured for the ECL
+	useragent.InitValue(buildcfg.PACKAGE_NAME, buildcfg.PACKAGE_VERSION)
+
 	cconf := unsafe.Pointer(C.config)
 	sconfig := starterConfig.NewConfig(starterConfig.CConfig(cconf))
 	jsonConfig := sconfig.GetJSONConfig()
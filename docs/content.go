@@ -41,10 +41,81 @@ Enterprise Performance Computing (EPC)`
       default:    The compressed Singularity read only image format (default)
       sandbox:    This is a read-write container within a directory structure
 
+  The container can also be exported to an OCI image usable with Docker or
+  Kubernetes by prefixing the image path with its format:
+
+      oci-archive:<path>[:<tag>]             An OCI image layout archive
+      docker-archive:<path>[:<name>[:<tag>]] An archive loadable with docker load
+
+  The runscript becomes the image entrypoint, variables assigned in the
+  environment scripts and %environment become the image environment and
+  labels are kept as image labels and annotations.
+
   note: It is a common workflow to use the "sandbox" mode for development of the
   container, and then build it as a default Singularity image for production 
   use. The default format is immutable.
 
+  FAKEROOT:
+
+  Building from a definition file requires root privileges, unless the
+  --fakeroot option is set. The build then runs as root of a user namespace
+  mapping your UID to root and the subordinate UID and GID ranges allocated
+  to you in /etc/subuid and /etc/subgid from ID 1, files are owned by the
+  right users in the image. Files of a base image owned by IDs above the
+  mapped ranges are owned by the nobody user and group (65534) instead, with
+  a warning. The newuidmap and newgidmap tools must be installed, device
+  nodes can't be created during such builds.
+
+  BUILD ARGUMENTS:
+
+  A definition file may reference build arguments with {{ NAME }} in its
+  headers and sections. Default values are declared as NAME=value lines in a
+  %arguments section of each build stage, and are overridden with
+  --build-arg NAME=value or with a file holding one NAME=value per line given
+  to --build-arg-file, --build-arg taking precedence. The values used are
+  recorded in the %arguments section of the definition file stored in the
+  image.
+
+  IMPORTS:
+
+  A definition file may import the sections of another definition file with
+  an %import <path> line, relative paths being resolved from the directory of
+  the importing file. Imported files can only hold sections, which are added
+  to the sections of the same name. The definition file stored in the image
+  holds the imported sections.
+
+  REPRODUCIBLE BUILDS:
+
+  With --reproducible, or when the SOURCE_DATE_EPOCH environment variable is
+  set, building the same definition file from the same sources gives a bit
+  identical SIF image. The time set by SOURCE_DATE_EPOCH, or the epoch, is
+  recorded as build date, file times of the root filesystem more recent than
+  it are clamped to it, the squashfs filesystem is created with a single
+  processor so files are written in sorted order, and the image ID is derived
+  from the image content.
+
+  FILESYSTEM OPTIONS:
+
+  The squashfs filesystem of SIF images is created with the compression
+  algorithm, block size and number of processors set by --compression,
+  --block-size and --processors, or by the mksquashfs directives of
+  singularity.conf. Files matching the wildcard patterns given with --exclude
+  are left out of the image. The compression algorithm is recorded in the
+  image, a warning is printed when running it on a host whose kernel can't
+  mount it. Filesystems created with --compression none are still recorded
+  as gzip compressed, the kernel needs zlib support to mount them.
+
+  UPDATING SIF IMAGES:
+
+  With --update, a definition file is built over the root filesystem of an
+  existing SIF image, header bootstrap is skipped. The changed files are
+  appended to the image as a new squashfs layer partition, along with the
+  definition file it was built from, removed files are recorded as overlay
+  whiteouts. Layers are stacked over the image with overlay at runtime, which
+  must be enabled and isn't available in user namespaces. Layers must be
+  signed for the image to pass 'singularity verify' and the execution control
+  list. Use --force to rebuild the image from scratch instead.
+
   BUILD SPEC:
 
   The build spec target is a definition (def) file, local image, or URI that can 
@@ -97,6 +168,14 @@ Enterprise Performance Computing (EPC)`
       Scratch:
           Bootstrap: scratch # Populate the container with a minimal rootfs in %setup
 
+      Multi-stage (each stage starts with a Bootstrap header):
+          Bootstrap: docker
+          From: golang:1.11
+          Stage: devel # Name the stage so later stages can copy files from it
+
+          Bootstrap: library
+          From: alpine:3.9
+
   DEFFILE SECTIONS:
 
       %pre
@@ -134,6 +213,9 @@ Enterprise Performance Computing (EPC)`
           /path/on/host/file.txt /path/on/container/file.txt
           relative_file.txt /path/on/container/relative_file.txt
 
+      %files from devel
+          /path/in/devel/stage/file.txt /path/on/container/file.txt
+
       %environment
           LUKE=goodguy
           VADER=badguy
@@ -154,7 +236,16 @@ Enterprise Performance Computing (EPC)`
       Build a base sandbox from DockerHub, make changes to it, then build sif
           $ singularity build --sandbox /tmp/debian docker://debian:latest
           $ singularity exec --writable /tmp/debian apt-get install python
-          $ singularity build /tmp/debian2.sif /tmp/debian`
+          $ singularity build /tmp/debian2.sif /tmp/debian
+
+      Build a sif file from a Singularity recipe file without root privileges:
+          $ singularity build --fakeroot /tmp/debian3.sif /path/to/debian.def
+
+      Build a sif file setting a build argument of the recipe file:
+          $ singularity build --build-arg VERSION=stretch /tmp/debian4.sif /path/to/debian.def
+
+      Export a Singularity image to an archive loadable with docker load:
+          $ singularity build docker-archive:/tmp/debian.tar:debian:mine /tmp/debian2.sif`
 
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// Cache
@@ -212,8 +303,9 @@ Enterprise Performance Computing (EPC)`
 	KeyLong  string = `
   The 'key' command allows you to manage local OpenPGP key stores by creating
   a new store and new key pairs. You can also list available keys from the
-  default store. Finally, the key command offers subcommands to communicate
-  with an HKP key server to fetch and upload public keys.`
+  default store, import keys into it, export keys from it and remove keys
+  from it. Finally, the key command offers subcommands to communicate with an
+  HKP key server to fetch and upload public keys.`
 	KeyExample string = `
   All group commands have their own help output:
 
@@ -277,6 +369,134 @@ Enterprise Performance Computing (EPC)`
 	KeyPushExample string = `
   $ singularity key push D87FE3AF5C1F063FCBCC9B02F812842B5EEE5934`
 
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// key import
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	KeyImportUse   string = `import <input-key>`
+	KeyImportShort string = `Import a local key into the local key store`
+	KeyImportLong  string = `
+  The 'key import' command allows you to add ASCII armored or binary keys
+  from a file to the local key store (e.g., $HOME/.singularity/sypgp).
+  Private keys are added to the private key store and their public part to
+  the public key store. Keys already present are updated with their new
+  signatures, such as revocations, and subkeys.`
+	KeyImportExample string = `
+  $ singularity key import ./my-key.asc`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// key export
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	KeyExportUse   string = `export [export options...] <fingerprint> <output-file>`
+	KeyExportShort string = `Export a public or private key into a specific file`
+	KeyExportLong  string = `
+  The 'key export' command allows you to write a public key, or a private
+  key with the --secret option, from the local key store to a new file in
+  binary format, or ASCII armored format with the --armor option. Private
+  keys are exported as stored, encrypted keys remain encrypted.`
+	KeyExportExample string = `
+  $ singularity key export D87FE3AF5C1F063FCBCC9B02F812842B5EEE5934 ./public.key
+
+  $ singularity key export --secret --armor D87FE3AF5C1F063FCBCC9B02F812842B5EEE5934 ./private.asc`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// key remove
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	KeyRemoveUse   string = `remove [remove options...] <fingerprint>`
+	KeyRemoveShort string = `Remove a local public or private key from the local key store`
+	KeyRemoveLong  string = `
+  The 'key remove' command allows you to remove the public key, or the
+  private key with the --secret option, matching the given fingerprint from
+  the local key store.`
+	KeyRemoveExample string = `
+  $ singularity key remove D87FE3AF5C1F063FCBCC9B02F812842B5EEE5934
+
+  $ singularity key remove --secret D87FE3AF5C1F063FCBCC9B02F812842B5EEE5934`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// overlay
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	OverlayUse   string = `overlay <subcommand>`
+	OverlayShort string = `Manage persistent overlay images and partitions`
+	OverlayLong  string = `
+  The 'overlay' command allows you to create ext3 overlay images and to
+  manage an ext3 overlay partition embedded in a SIF image. When a SIF image holds an overlay partition, the
+  changes made in the container with the --writable option are stored into
+  it and persist across runs, the image can be moved and shared as a single
+  file along with its changes.`
+	OverlayExample string = `
+  All group commands have their own help output:
+
+  $ singularity help overlay add
+  $ singularity overlay inspect --help`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// overlay create
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	OverlayCreateUse   string = `create [create options...] --size <MiB> <image path>`
+	OverlayCreateShort string = `Create an ext3 overlay image`
+	OverlayCreateLong  string = `
+  The 'overlay create' command allows you to create an ext3 overlay image of
+  the given size in MiB, usable with the --overlay option. The image holds
+  the upper and work directories expected by the runtime and is owned by the
+  calling user, no root privileges are required. The image blocks are
+  allocated unless the --sparse option is set. e2fsprogs must be installed.`
+	OverlayCreateExample string = `
+  $ singularity overlay create --size 1024 overlay.img
+  $ singularity shell --overlay overlay.img container.sif
+
+  $ singularity overlay create --sparse --size 4096 overlay.img`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// overlay add
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	OverlayAddUse   string = `add --size <MiB> <sif image>`
+	OverlayAddShort string = `Add an overlay partition to a SIF image`
+	OverlayAddLong  string = `
+  The 'overlay add' command allows you to create an ext3 overlay partition
+  of the given size in MiB and to add it to a SIF image. The partition is
+  attached to the container file system so that changes made with the
+  --writable option are stored into it. e2fsprogs must be installed.`
+	OverlayAddExample string = `
+  $ singularity overlay add --size 512 container.sif
+  $ singularity shell --writable container.sif`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// overlay resize
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	OverlayResizeUse   string = `resize --size <MiB> <sif image>`
+	OverlayResizeShort string = `Resize the overlay partition of a SIF image`
+	OverlayResizeLong  string = `
+  The 'overlay resize' command allows you to grow or shrink the overlay
+  partition of a SIF image to the given size in MiB, its content is kept.
+  Shrinking fails if the content doesn't fit in the new size.`
+	OverlayResizeExample string = `
+  $ singularity overlay resize --size 1024 container.sif`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// overlay inspect
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	OverlayInspectUse   string = `inspect <sif image>`
+	OverlayInspectShort string = `Show the overlay partition of a SIF image`
+	OverlayInspectLong  string = `
+  The 'overlay inspect' command allows you to display the size, the used
+  and free space and the inode usage of the overlay partition of a SIF
+  image.`
+	OverlayInspectExample string = `
+  $ singularity overlay inspect container.sif`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// overlay remove
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	OverlayRemoveUse   string = `remove <sif image>`
+	OverlayRemoveShort string = `Remove the overlay partition of a SIF image`
+	OverlayRemoveLong  string = `
+  The 'overlay remove' command allows you to remove the overlay partition
+  of a SIF image, all the changes stored into it are lost. The space used
+  by the partition is reclaimed when it's the last data object of the
+  image.`
+	OverlayRemoveExample string = `
+  $ singularity overlay remove container.sif`
+
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// capability
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
@@ -452,6 +672,7 @@ Enterprise Performance Computing (EPC)`
   $ singularity exec /tmp/debian.sif python ./hello_world.py
   $ cat hello_world.py | singularity exec /tmp/debian.sif python
   $ sudo singularity exec --writable /tmp/debian.sif apt-get update
+  $ singularity exec --fakeroot --writable /tmp/debian apt-get update
   $ singularity exec instance://my_instance ps -ef
   $ singularity exec library://centos cat /etc/os-release`
 
@@ -541,6 +762,26 @@ Enterprise Performance Computing (EPC)`
   $ singularity instance stop -s TERM mysql1
   $ singularity instance stop -s 15 mysql1`
 
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// lint
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	LintUse   string = `lint [lint options...] <definition file>`
+	LintShort string = `Check a definition file for problems`
+	LintLong  string = `
+  The 'lint' command reports all the problems found in a definition file and
+  in the files it imports at once, as file:line:column, severity, message and
+  a suggestion when one is available. It checks for unknown headers and
+  sections, empty Bootstrap and From headers, duplicate app sections, %files
+  sources missing from the current directory, references to previous build
+  stages and build arguments without default value.
+
+  The command exits with a non zero status if an error is found, warnings
+  don't prevent to build. JSON output for editors is selected with --json.`
+	LintExample string = `
+  $ singularity lint /path/to/debian.def
+
+  $ singularity lint --json /path/to/debian.def`
+
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// pull
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
@@ -557,7 +798,11 @@ Enterprise Performance Computing (EPC)`
       docker://user/image:tag
     
   shub: Pull an image from Singularity Hub to CWD
-      shub://user/image:tag`
+      shub://user/image:tag
+
+  Library images are downloaded to a temporary file which is resumed by the
+  next pull after an interruption, with --parallel connections, and only
+  kept once its hash matches the hash of the library image.`
 	PullExample string = `
   From Sylabs cloud library
   $ singularity pull alpine.sif library://alpine:latest
@@ -575,7 +820,9 @@ Enterprise Performance Computing (EPC)`
 	PushShort string = `Push a container to a Library URI`
 	PushLong  string = `
   The Singularity push command allows you to upload your sif image to a library
-  of your choosing`
+  of your choosing. The image is uploaded in parts which are retried after a
+  network or server error, pushing the same image again after an interruption
+  resumes the upload after the parts already received by the library.`
 	PushExample string = `
   $ singularity push /home/user/my.sif library://user/collection/my.sif:latest`
 
@@ -591,6 +838,253 @@ Enterprise Performance Computing (EPC)`
 	SearchExample string = `
   $ singularity search lolcow`
 
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// library
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	LibraryUse   string = `library <subcommand>`
+	LibraryShort string = `Manage the images, tags and collections of a library`
+	LibraryLong  string = `
+  The 'library' command allows you to list the images and tags of your
+  containers in a library, to add, move and remove tags, to delete images,
+  and to create, delete and change the visibility of collections. The
+  library defaults to https://library.sylabs.io when no --library option is
+  given. Commands changing the library require a valid authentication
+  token.`
+	LibraryExample string = `
+  All group commands have their own help output:
+
+  $ singularity help library tag add
+  $ singularity library images --help`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// library tags
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	LibraryTagsUse   string = `tags <library://entity/collection/container>`
+	LibraryTagsShort string = `List the tags of a library container`
+	LibraryTagsLong  string = `
+  The 'library tags' command allows you to list the tags of a container
+  along with the hash of the image each tag points to.`
+	LibraryTagsExample string = `
+  $ singularity library tags library://user/collection/container`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// library images
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	LibraryImagesUse   string = `images <library://entity/collection/container>`
+	LibraryImagesShort string = `List the images of a library container`
+	LibraryImagesLong  string = `
+  The 'library images' command allows you to list the images of a container
+  from the oldest to the newest, along with their size, creation date and
+  tags. Images without tags can only be pulled by hash.`
+	LibraryImagesExample string = `
+  $ singularity library images library://user/collection/container`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// library tag
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	LibraryTagUse   string = `tag <subcommand>`
+	LibraryTagShort string = `Manage the tags of a library container`
+	LibraryTagLong  string = `
+  The 'library tag' command allows you to add new tags to an image, to move
+  existing tags to another image and to remove tags from a container. Images
+  are given by tag or by hash, e.g. container:latest or
+  container:sha256.<hash>.`
+	LibraryTagExample string = `
+  All group commands have their own help output:
+
+  $ singularity help library tag move
+  $ singularity library tag remove --help`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// library tag add
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	LibraryTagAddUse   string = `add <library://entity/collection/container:tag> <new tag>...`
+	LibraryTagAddShort string = `Add new tags to a library image`
+	LibraryTagAddLong  string = `
+  The 'library tag add' command allows you to add tags to an image of a
+  container. The tags must not exist yet, existing tags are moved with the
+  'library tag move' command.`
+	LibraryTagAddExample string = `
+  $ singularity library tag add library://user/collection/container:latest v1.0 stable`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// library tag move
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	LibraryTagMoveUse   string = `move <library://entity/collection/container:tag> <tag>...`
+	LibraryTagMoveShort string = `Move existing tags to a library image`
+	LibraryTagMoveLong  string = `
+  The 'library tag move' command allows you to point existing tags of a
+  container to another of its images.`
+	LibraryTagMoveExample string = `
+  $ singularity library tag move library://user/collection/container:sha256.f4a5b6e7 latest`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// library tag remove
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	LibraryTagRemoveUse   string = `remove <library://entity/collection/container:tag[,tag...]>`
+	LibraryTagRemoveShort string = `Remove tags from a library container`
+	LibraryTagRemoveLong  string = `
+  The 'library tag remove' command allows you to remove tags from a
+  container, the images they point to are kept.`
+	LibraryTagRemoveExample string = `
+  $ singularity library tag remove library://user/collection/container:testing,old`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// library delete
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	LibraryDeleteUse   string = `delete <library://entity/collection/container:tag>`
+	LibraryDeleteShort string = `Delete an image from a library`
+	LibraryDeleteLong  string = `
+  The 'library delete' command allows you to delete an image given by tag
+  or by hash from a container, along with all the tags pointing to it.`
+	LibraryDeleteExample string = `
+  $ singularity library delete library://user/collection/container:sha256.f4a5b6e7`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// library collection
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	LibraryCollectionUse   string = `collection <subcommand>`
+	LibraryCollectionShort string = `Manage library collections`
+	LibraryCollectionLong  string = `
+  The 'library collection' command allows you to create and delete
+  collections of containers, and to make them public or private. Private
+  collections and their containers are only visible to their owner.`
+	LibraryCollectionExample string = `
+  All group commands have their own help output:
+
+  $ singularity help library collection create
+  $ singularity library collection visibility --help`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// library collection create
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	LibraryCollectionCreateUse   string = `create [create options...] <library://entity/collection>`
+	LibraryCollectionCreateShort string = `Create a library collection`
+	LibraryCollectionCreateLong  string = `
+  The 'library collection create' command allows you to create an empty
+  collection in an existing entity, public unless the --private option is
+  set.`
+	LibraryCollectionCreateExample string = `
+  $ singularity library collection create --private library://user/collection`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// library collection delete
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	LibraryCollectionDeleteUse   string = `delete <library://entity/collection>`
+	LibraryCollectionDeleteShort string = `Delete an empty library collection`
+	LibraryCollectionDeleteLong  string = `
+  The 'library collection delete' command allows you to delete a collection
+  which doesn't hold any container.`
+	LibraryCollectionDeleteExample string = `
+  $ singularity library collection delete library://user/collection`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// library collection visibility
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	LibraryCollectionVisibilityUse   string = `visibility <library://entity/collection> <public|private>`
+	LibraryCollectionVisibilityShort string = `Make a library collection public or private`
+	LibraryCollectionVisibilityLong  string = `
+  The 'library collection visibility' command allows you to make a
+  collection and its containers public, or private to their owner.`
+	LibraryCollectionVisibilityExample string = `
+  $ singularity library collection visibility library://user/collection public`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// remote
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	RemoteUse   string = `remote <subcommand>`
+	RemoteShort string = `Manage the remote endpoints of the client commands`
+	RemoteLong  string = `
+  The 'remote' command allows you to manage named remotes, each holding the
+  URLs of a library, a key server and a remote build service along with your
+  authentication token for these services. The client commands (pull, push,
+  search, library, build, key, sign and verify) use the services of the
+  active remote unless their URL option is set, and send its token unless
+  another one is set with SYLABS_TOKEN or --tokenfile. The remotes are
+  stored in ~/.singularity/remote.toml, the SylabsCloud remote is used when
+  this file doesn't exist.`
+	RemoteExample string = `
+  All group commands have their own help output:
+
+  $ singularity help remote add
+  $ singularity remote login --help`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// remote add
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	RemoteAddUse   string = `add [add options...] --library <URL> <remote name>`
+	RemoteAddShort string = `Add a named remote`
+	RemoteAddLong  string = `
+  The 'remote add' command allows you to add a remote with the URLs of its
+  services. A library is required, the key server and the remote build
+  service are optional. Commands using a service the active remote doesn't
+  provide must be given its URL with their own option.`
+	RemoteAddExample string = `
+  $ singularity remote add --use --library https://library.example.com \
+      --keyserver https://keys.example.com OnPrem`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// remote remove
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	RemoteRemoveUse   string = `remove <remote name>`
+	RemoteRemoveShort string = `Remove a named remote`
+	RemoteRemoveLong  string = `
+  The 'remote remove' command allows you to remove a remote along with its
+  token. The active remote can't be removed.`
+	RemoteRemoveExample string = `
+  $ singularity remote remove OnPrem`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// remote list
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	RemoteListUse   string = `list`
+	RemoteListShort string = `List the remotes`
+	RemoteListLong  string = `
+  The 'remote list' command allows you to list the remotes along with the
+  URLs of their services, the active remote is marked with a star.`
+	RemoteListExample string = `
+  $ singularity remote list`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// remote use
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	RemoteUseUse   string = `use <remote name>`
+	RemoteUseShort string = `Make a remote the active one`
+	RemoteUseLong  string = `
+  The 'remote use' command allows you to select the remote used by the
+  client commands.`
+	RemoteUseExample string = `
+  $ singularity remote use SylabsCloud`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// remote login
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	RemoteLoginUse   string = `login [login options...] [remote name]`
+	RemoteLoginShort string = `Store the authentication token of a remote`
+	RemoteLoginLong  string = `
+  The 'remote login' command allows you to store your authentication token
+  for the services of a remote, the active remote when no name is given. The
+  token is prompted for, or read from the standard input when it isn't a
+  terminal. The --logout option removes the stored token.`
+	RemoteLoginExample string = `
+  $ singularity remote login OnPrem
+
+  $ singularity remote login < token-file
+
+  $ singularity remote login --logout OnPrem`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// remote status
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	RemoteStatusUse   string = `status [remote name]`
+	RemoteStatusShort string = `Check the services of a remote`
+	RemoteStatusLong  string = `
+  The 'remote status' command allows you to check that the services of a
+  remote, the active remote when no name is given, are reachable, and shows
+  their version and whether you are logged in to the remote.`
+	RemoteStatusExample string = `
+  $ singularity remote status`
+
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// run
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
@@ -660,9 +1154,18 @@ Enterprise Performance Computing (EPC)`
   The sign command allows a user to create a cryptographic signature on either a 
   single data object or a list of data objects within the same SIF group. By 
   default without parameters, the command searches for the primary partition and 
-  creates a verification block that is then added to the SIF container file.`
+  creates a verification block that is then added to the SIF container file.
+
+  With --all a single verification block covers all the data objects of the
+  container except signatures and the writable overlay partition, so the
+  definition file, labels and environment are protected along with the
+  partitions. Each object is hashed separately so 'singularity verify --all'
+  reports which objects were tampered with.`
 	SignExample string = `
-  $ singularity sign container.sif`
+  $ singularity sign container.sif
+
+  Sign all the data objects of a container:
+  $ singularity sign --all container.sif`
 
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// verify
@@ -675,9 +1178,35 @@ Enterprise Performance Computing (EPC)`
   multiple data objects signed. By default the command searches for the primary 
   partition signature. If found, a list of all verification blocks applied on 
   the primary partition is gathered so that data integrity (hashing) and 
-  signature verification is done for all those blocks.`
+  signature verification is done for all those blocks. A signature is rejected
+  if the signing key was expired or revoked when the signature was made, the
+  revocation status of keys from the local store is also checked on the key
+  server. Keys superseded or retired after the signature was made are still
+  accepted. The layer partitions appended by 'singularity build --update' must
+  be signed too, each with its own verification blocks or by the verification
+  blocks created by 'singularity sign --all', an unsigned layer fails the
+  verification.
+
+  With --offline the key server is never contacted: keys are only looked up
+  in the trusted keyring configured by the administrator with the 'trusted
+  keyring' directive of singularity.conf and in the local public keyring. The
+  revocation status of keys is then only checked against those keyrings.
+
+  With --all the verification blocks created by 'singularity sign --all' are
+  verified and the status of each data object is reported: verified, tampered
+  with, missing, or not covered by signature when the object was added after
+  the signature was made or is an overlay partition, whose content changes with
+  --writable and is never signed. Verification fails if a signed object was
+  tampered with or removed, a warning is displayed for each object not
+  covered.`
 	VerifyExample string = `
-  $ singularity verify container.sif`
+  $ singularity verify container.sif
+
+  Verify a container on a host without network access:
+  $ singularity verify --offline container.sif
+
+  Verify all the data objects of a container signed with 'sign --all':
+  $ singularity verify --all container.sif`
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// Run-help
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
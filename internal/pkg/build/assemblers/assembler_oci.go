@@ -0,0 +1,321 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package assemblers
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/containers/image/copy"
+	dockerarchive "github.com/containers/image/docker/archive"
+	ociarchive "github.com/containers/image/oci/archive"
+	oci "github.com/containers/image/oci/layout"
+	"github.com/containers/image/signature"
+	imagetypes "github.com/containers/image/types"
+	digest "github.com/opencontainers/go-digest"
+	imgspecs "github.com/opencontainers/image-spec/specs-go"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/pkg/build/types"
+)
+
+// layoutTag is the reference name of the image in the temporary OCI layout
+const layoutTag = "latest"
+
+// OCIAssembler assembles an OCI image holding the bundle rootfs in a single
+// layer, the image config is generated from the container metadata.
+type OCIAssembler struct {
+	// Format is the output format, either "oci-archive" for an OCI image
+	// layout archive or "docker-archive" for an archive loadable with
+	// docker load
+	Format string
+}
+
+// Assemble creates an OCI image from a Bundle and writes it to path, for
+// an oci-archive path may be followed by :<tag>, for a docker-archive by
+// :<name>[:<tag>].
+func (a *OCIAssembler) Assemble(b *types.Bundle, path string) (err error) {
+	sylog.Infof("Creating %s image...", a.Format)
+
+	var destRef imagetypes.ImageReference
+	switch a.Format {
+	case "oci-archive":
+		destRef, err = ociarchive.ParseReference(path)
+	case "docker-archive":
+		destRef, err = dockerarchive.ParseReference(path)
+	default:
+		return fmt.Errorf("unsupported OCI image format %s", a.Format)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s destination %s: %v", a.Format, path, err)
+	}
+
+	layout, err := ioutil.TempDir(b.Path, "oci-layout-")
+	if err != nil {
+		return fmt.Errorf("While creating OCI layout directory: %v", err)
+	}
+	defer os.RemoveAll(layout)
+
+	if err := writeLayout(b, layout); err != nil {
+		return fmt.Errorf("While creating OCI layout: %v", err)
+	}
+
+	srcRef, err := oci.NewReference(layout, layoutTag)
+	if err != nil {
+		return err
+	}
+	policy := &signature.Policy{Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()}}
+	policyCtx, err := signature.NewPolicyContext(policy)
+	if err != nil {
+		return err
+	}
+	defer policyCtx.Destroy()
+
+	// remove anything that may exist at the build destination at last moment
+	file := archiveFile(path)
+	os.RemoveAll(file)
+
+	err = copy.Image(context.Background(), policyCtx, destRef, srcRef, &copy.Options{
+		ReportWriter: ioutil.Discard,
+	})
+	if err != nil {
+		return fmt.Errorf("While writing %s image: %v", a.Format, err)
+	}
+
+	// chown the archive to the calling user
+	if uid, gid, ok := changeOwner(); ok {
+		if err := os.Chown(file, uid, gid); err != nil {
+			return fmt.Errorf("while changing image ownership: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// archiveFile returns the archive file of a destination of the form
+// <path>[:<reference>].
+func archiveFile(path string) string {
+	if i := strings.IndexByte(path, ':'); i >= 0 {
+		return path[:i]
+	}
+	return path
+}
+
+// writeBlob writes data as a blob of the OCI layout dir and returns its
+// descriptor.
+func writeBlob(dir string, mediaType string, data []byte) (imgspecv1.Descriptor, error) {
+	d := digest.FromBytes(data)
+	path := filepath.Join(dir, "blobs", d.Algorithm().String(), d.Hex())
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+	return imgspecv1.Descriptor{
+		MediaType: mediaType,
+		Digest:    d,
+		Size:      int64(len(data)),
+	}, nil
+}
+
+// writeJSONBlob writes the JSON encoding of v as a blob of the OCI layout
+// dir and returns its descriptor.
+func writeJSONBlob(dir string, mediaType string, v interface{}) (imgspecv1.Descriptor, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+	return writeBlob(dir, mediaType, data)
+}
+
+// writeLayer writes the gzip compressed layer holding the bundle rootfs to
+// the OCI layout dir, it returns the layer descriptor along with the digest
+// of the uncompressed layer used as diff ID in the image config.
+func writeLayer(b *types.Bundle, dir string) (imgspecv1.Descriptor, digest.Digest, error) {
+	f, err := ioutil.TempFile(dir, "layer-")
+	if err != nil {
+		return imgspecv1.Descriptor{}, "", err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	compressed := digest.Canonical.Digester()
+	uncompressed := digest.Canonical.Digester()
+
+	gz := gzip.NewWriter(io.MultiWriter(f, compressed.Hash()))
+	// files are owned by root in the image when building as a user, like
+	// with mksquashfs -all-root
+	if err := tarRootfs(b.Rootfs(), io.MultiWriter(gz, uncompressed.Hash()), syscall.Getuid() != 0); err != nil {
+		return imgspecv1.Descriptor{}, "", err
+	}
+	if err := gz.Close(); err != nil {
+		return imgspecv1.Descriptor{}, "", err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return imgspecv1.Descriptor{}, "", err
+	}
+	d := compressed.Digest()
+	if err := os.Rename(f.Name(), filepath.Join(dir, "blobs", d.Algorithm().String(), d.Hex())); err != nil {
+		return imgspecv1.Descriptor{}, "", err
+	}
+
+	desc := imgspecv1.Descriptor{
+		MediaType: imgspecv1.MediaTypeImageLayerGzip,
+		Digest:    d,
+		Size:      fi.Size(),
+	}
+	return desc, uncompressed.Digest(), nil
+}
+
+// tarRootfs writes a tar archive of the rootfs directory to w, ownership
+// is reset to root if allRoot is true.
+func tarRootfs(rootfs string, w io.Writer, allRoot bool) error {
+	tw := tar.NewWriter(w)
+	// hard links are recorded as links to the first archived path
+	inodes := make(map[uint64]string)
+
+	err := filepath.Walk(rootfs, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(rootfs, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." || fi.Mode()&os.ModeSocket != 0 {
+			return nil
+		}
+
+		link := ""
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return fmt.Errorf("while archiving %s: %v", path, err)
+		}
+		hdr.Name = rel
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		hdr.Uname = ""
+		hdr.Gname = ""
+		if allRoot {
+			hdr.Uid = 0
+			hdr.Gid = 0
+		}
+
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok && fi.Mode().IsRegular() && st.Nlink > 1 {
+			if target, ok := inodes[st.Ino]; ok {
+				hdr.Typeflag = tar.TypeLink
+				hdr.Linkname = target
+				hdr.Size = 0
+			} else {
+				inodes[st.Ino] = rel
+			}
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// writeLayout writes an OCI image layout of the bundle to dir, the image is
+// referenced by layoutTag.
+func writeLayout(b *types.Bundle, dir string) error {
+	if err := os.MkdirAll(filepath.Join(dir, "blobs", digest.Canonical.String()), 0755); err != nil {
+		return err
+	}
+
+	layer, diffID, err := writeLayer(b, dir)
+	if err != nil {
+		return fmt.Errorf("while creating layer: %v", err)
+	}
+
+	imgConfig, err := imageConfig(b.Rootfs())
+	if err != nil {
+		return fmt.Errorf("while creating image config: %v", err)
+	}
+	created := b.Opts.BuildTime().UTC()
+	img := imgspecv1.Image{
+		Created:      &created,
+		Architecture: runtime.GOARCH,
+		OS:           "linux",
+		Config:       imgConfig,
+		RootFS: imgspecv1.RootFS{
+			Type:    "layers",
+			DiffIDs: []digest.Digest{diffID},
+		},
+		History: []imgspecv1.History{
+			{Created: &created, CreatedBy: "singularity build"},
+		},
+	}
+	config, err := writeJSONBlob(dir, imgspecv1.MediaTypeImageConfig, img)
+	if err != nil {
+		return err
+	}
+
+	annotations := map[string]string{imgspecv1.AnnotationCreated: created.Format(time.RFC3339)}
+	for k, v := range imgConfig.Labels {
+		annotations[k] = v
+	}
+	manifest, err := writeJSONBlob(dir, imgspecv1.MediaTypeImageManifest, imgspecv1.Manifest{
+		Versioned:   imgspecs.Versioned{SchemaVersion: 2},
+		Config:      config,
+		Layers:      []imgspecv1.Descriptor{layer},
+		Annotations: annotations,
+	})
+	if err != nil {
+		return err
+	}
+	manifest.Annotations = map[string]string{imgspecv1.AnnotationRefName: layoutTag}
+
+	index, err := json.Marshal(imgspecv1.Index{
+		Versioned: imgspecs.Versioned{SchemaVersion: 2},
+		Manifests: []imgspecv1.Descriptor{manifest},
+	})
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "index.json"), index, 0644); err != nil {
+		return err
+	}
+
+	layout, err := json.Marshal(imgspecv1.ImageLayout{Version: imgspecv1.ImageLayoutVersion})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, imgspecv1.ImageLayoutFile), layout, 0644)
+}
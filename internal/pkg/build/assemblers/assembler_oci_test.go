@@ -0,0 +1,116 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package assemblers_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	dockerarchive "github.com/containers/image/docker/archive"
+	ociarchive "github.com/containers/image/oci/archive"
+	imagetypes "github.com/containers/image/types"
+	"github.com/sylabs/singularity/internal/pkg/build/assemblers"
+	"github.com/sylabs/singularity/pkg/build/types"
+)
+
+// createOCITestBundle creates a bundle holding a minimal rootfs with a
+// runscript, an environment script and labels.
+func createOCITestBundle(t *testing.T) *types.Bundle {
+	b, err := types.NewBundle("", "sbuild-OCIAssembler")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		".singularity.d/runscript":              "#!/bin/sh\n\necho hello\n",
+		".singularity.d/env/90-environment.sh":  "#!/bin/sh\n\nexport FOO=bar\n",
+		".singularity.d/labels.json":            `{"org.example.label": "value"}`,
+		"etc/hostname":                          "container\n",
+		"usr/share/doc/test/hard-link-target":   "data\n",
+		"usr/share/doc/test/subdir/placeholder": "",
+	}
+	for name, content := range files {
+		path := filepath.Join(b.Rootfs(), name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	doc := filepath.Join(b.Rootfs(), "usr/share/doc/test")
+	if err := os.Link(filepath.Join(doc, "hard-link-target"), filepath.Join(doc, "hard-link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("hard-link-target", filepath.Join(doc, "symlink")); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestOCIAssembler(t *testing.T) {
+	b := createOCITestBundle(t)
+	defer os.RemoveAll(b.Path)
+
+	dir, err := ioutil.TempDir("", "oci-assembler-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		format string
+		dest   string
+		parse  func(string) (imagetypes.ImageReference, error)
+	}{
+		{"oci-archive", filepath.Join(dir, "image-oci.tar") + ":test", ociarchive.ParseReference},
+		{"docker-archive", filepath.Join(dir, "image-docker.tar") + ":test/image:v1", dockerarchive.ParseReference},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			a := &assemblers.OCIAssembler{Format: tt.format}
+			if err := a.Assemble(b, tt.dest); err != nil {
+				t.Fatalf("failed to assemble %s image: %v", tt.format, err)
+			}
+
+			ref, err := tt.parse(tt.dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			img, err := ref.NewImage(context.Background(), &imagetypes.SystemContext{})
+			if err != nil {
+				t.Fatalf("failed to open %s image: %v", tt.format, err)
+			}
+			defer img.Close()
+
+			config, err := img.OCIConfig(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(config.Config.Entrypoint, []string{"/.singularity.d/runscript"}) {
+				t.Errorf("unexpected entrypoint %v", config.Config.Entrypoint)
+			}
+			found := false
+			for _, env := range config.Config.Env {
+				found = found || env == "FOO=bar"
+			}
+			if !found {
+				t.Errorf("FOO=bar not found in environment %v", config.Config.Env)
+			}
+			if config.Config.Labels["org.example.label"] != "value" {
+				t.Errorf("unexpected labels %v", config.Config.Labels)
+			}
+			if len(img.LayerInfos()) != 1 {
+				t.Errorf("unexpected number of layers %d", len(img.LayerInfos()))
+			}
+		})
+	}
+}
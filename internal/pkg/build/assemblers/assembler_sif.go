@@ -10,17 +10,17 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"os/exec"
-	"path/filepath"
 	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	uuid "github.com/satori/go.uuid"
 	"github.com/sylabs/sif/pkg/sif"
 	"github.com/sylabs/singularity/internal/pkg/buildcfg"
+	"github.com/sylabs/singularity/internal/pkg/image"
 	"github.com/sylabs/singularity/internal/pkg/runtime/engines/config"
 	singularityConfig "github.com/sylabs/singularity/internal/pkg/runtime/engines/singularity/config"
 	"github.com/sylabs/singularity/internal/pkg/sylog"
@@ -31,7 +31,11 @@ import (
 type SIFAssembler struct {
 }
 
-func createSIF(path string, definition []byte, squashfile string) (err error) {
+// createSIF creates the SIF image path holding the definition and the
+// squashfs system partition, the compression of the partition is recorded in
+// its descriptor. The image is reproducible if epoch isn't nil: its ID is
+// derived from its content and the times it records are epoch.
+func createSIF(path string, definition []byte, squashfile, compression string, epoch *time.Time) (err error) {
 	// general info for the new SIF file creation
 	cinfo := sif.CreateInfo{
 		Pathname:   path,
@@ -39,6 +43,11 @@ func createSIF(path string, definition []byte, squashfile string) (err error) {
 		Sifversion: sif.HdrVersion,
 		ID:         uuid.NewV4(),
 	}
+	if epoch != nil {
+		if cinfo.ID, err = contentID(definition, squashfile); err != nil {
+			return fmt.Errorf("while computing image ID: %s", err)
+		}
+	}
 
 	// data we need to create a definition file descriptor
 	definput := sif.DescriptorInput{
@@ -69,11 +78,18 @@ func createSIF(path string, definition []byte, squashfile string) (err error) {
 		return fmt.Errorf("while calling start on partition file: %s", err)
 	}
 	parinput.Size = fi.Size()
+	if epoch != nil {
+		// the descriptor name is the file name
+		parinput.Fname = reproducibleSquashfsName
+	}
 
 	err = parinput.SetPartExtra(sif.FsSquash, sif.PartPrimSys, sif.GetSIFArch(runtime.GOARCH))
 	if err != nil {
 		return
 	}
+	if err := image.SetPartCompression(&parinput, compression); err != nil {
+		return fmt.Errorf("while recording partition compression: %s", err)
+	}
 
 	// add this descriptor input element to the list
 	cinfo.InputDescr = append(cinfo.InputDescr, parinput)
@@ -86,6 +102,12 @@ func createSIF(path string, definition []byte, squashfile string) (err error) {
 		return fmt.Errorf("while creating container: %s", err)
 	}
 
+	if epoch != nil {
+		if err := clampSIF(path, *epoch); err != nil {
+			return fmt.Errorf("while setting image times: %s", err)
+		}
+	}
+
 	// chown the sif file to the calling user
 	if uid, gid, ok := changeOwner(); ok {
 		if err := os.Chown(path, uid, gid); err != nil {
@@ -96,32 +118,24 @@ func createSIF(path string, definition []byte, squashfile string) (err error) {
 	return nil
 }
 
-func getMksquashfsPath() (string, error) {
+// Assemble creates a SIF image from a Bundle
+func (a *SIFAssembler) Assemble(b *types.Bundle, path string) (err error) {
+	sylog.Infof("Creating SIF file...")
+
 	// Parse singularity configuration file
 	c := &singularityConfig.FileConfig{}
 	if err := config.Parser(buildcfg.SYSCONFDIR+"/singularity/singularity.conf", c); err != nil {
-		return "", fmt.Errorf("Unable to parse singularity.conf file: %s", err)
+		return fmt.Errorf("Unable to parse singularity.conf file: %s", err)
 	}
 
-	// p is either "" or the string value in the conf file
-	p := c.MksquashfsPath
-
-	// If the path contains the binary name use it as is, otherwise add mksquashfs via filepath.Join
-	if !strings.HasSuffix(c.MksquashfsPath, "mksquashfs") {
-		p = filepath.Join(c.MksquashfsPath, "mksquashfs")
+	mksquashfs, err := getMksquashfsPath(c)
+	if err != nil {
+		return fmt.Errorf("While searching for mksquashfs: %v", err)
 	}
 
-	// exec.LookPath functions on absolute paths (ignoring $PATH) as well
-	return exec.LookPath(p)
-}
-
-// Assemble creates a SIF image from a Bundle
-func (a *SIFAssembler) Assemble(b *types.Bundle, path string) (err error) {
-	sylog.Infof("Creating SIF file...")
-
-	mksquashfs, err := getMksquashfsPath()
+	options, compression, err := mksquashfsArgs(b.Opts, c)
 	if err != nil {
-		return fmt.Errorf("While searching for mksquashfs: %v", err)
+		return fmt.Errorf("While setting mksquashfs options: %v", err)
 	}
 
 	f, err := ioutil.TempFile(b.Path, "squashfs-")
@@ -138,26 +152,30 @@ func (a *SIFAssembler) Assemble(b *types.Bundle, path string) (err error) {
 		args = append(args, "-all-root")
 	}
 
-	mksquashfsCmd := exec.Command(mksquashfs, args...)
-	stderr, err := mksquashfsCmd.StderrPipe()
-	if err != nil {
-		return fmt.Errorf("While setting up stderr pipe: %v", err)
+	var epoch *time.Time
+	if b.Opts.Reproducible {
+		t := b.Opts.BuildTime()
+		epoch = &t
+		sylog.Debugf("Building reproducible image with source date epoch %d", b.Opts.SourceDateEpoch)
+		if err := clampTimes(b.Rootfs(), t); err != nil {
+			return fmt.Errorf("While setting rootfs times: %v", err)
+		}
 	}
+	args = append(args, options...)
 
-	if err := mksquashfsCmd.Start(); err != nil {
-		return fmt.Errorf("While starting mksquashfs: %v", err)
-	}
+	sylog.Debugf("Creating squashfs filesystem with %s compression: %s", compression, strings.Join(args, " "))
 
-	errOut, err := ioutil.ReadAll(stderr)
-	if err != nil {
-		return fmt.Errorf("While reading mksquashfs stderr: %v", err)
+	if err := runMksquashfs(mksquashfs, args); err != nil {
+		return err
 	}
 
-	if err := mksquashfsCmd.Wait(); err != nil {
-		return fmt.Errorf("While running mksquashfs: %v: %s", err, strings.Replace(string(errOut), "\n", " ", -1))
+	if epoch != nil {
+		if err := setSquashfsTime(squashfsPath, *epoch); err != nil {
+			return fmt.Errorf("While setting squashfs time: %v", err)
+		}
 	}
 
-	err = createSIF(path, b.Recipe.Raw, squashfsPath)
+	err = createSIF(path, b.Recipe.Raw, squashfsPath, compression, epoch)
 	if err != nil {
 		return fmt.Errorf("While creating SIF: %v", err)
 	}
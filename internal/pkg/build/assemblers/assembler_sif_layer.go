@@ -0,0 +1,342 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package assemblers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/internal/pkg/buildcfg"
+	"github.com/sylabs/singularity/internal/pkg/image"
+	"github.com/sylabs/singularity/internal/pkg/runtime/engines/config"
+	singularityConfig "github.com/sylabs/singularity/internal/pkg/runtime/engines/singularity/config"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/pkg/build/types"
+	"golang.org/x/sys/unix"
+)
+
+// SIFLayerAssembler appends the changes made to the root filesystem
+// extracted from an existing SIF image to this image, as a squashfs layer
+// partition stacked over its partitions by the runtime.
+type SIFLayerAssembler struct {
+	// snapshot holds the state of the files of the extracted root
+	// filesystem, by path relative to the root filesystem
+	snapshot map[string]fileState
+}
+
+// fileState identifies a file and its last change
+type fileState struct {
+	ino   uint64
+	ctime syscall.Timespec
+}
+
+// Snapshot records the state of the root filesystem extracted from the
+// image, the layer holds the changes made to it afterwards.
+func (a *SIFLayerAssembler) Snapshot(rootfs string) error {
+	a.snapshot = make(map[string]fileState)
+	return filepath.Walk(rootfs, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(rootfs, path)
+		if err != nil {
+			return err
+		}
+		st := fi.Sys().(*syscall.Stat_t)
+		a.snapshot[rel] = fileState{ino: st.Ino, ctime: st.Ctim}
+		return nil
+	})
+}
+
+// Assemble adds a layer holding the changes made to the root filesystem of
+// the bundle to the SIF image path
+func (a *SIFLayerAssembler) Assemble(b *types.Bundle, path string) error {
+	sylog.Infof("Adding layer to SIF file...")
+
+	if a.snapshot == nil {
+		return fmt.Errorf("no snapshot of the image root filesystem was taken")
+	}
+	if b.Opts.Reproducible {
+		sylog.Warningf("Layers are not reproducible, ignoring SOURCE_DATE_EPOCH")
+	}
+
+	// Parse singularity configuration file
+	c := &singularityConfig.FileConfig{}
+	if err := config.Parser(buildcfg.SYSCONFDIR+"/singularity/singularity.conf", c); err != nil {
+		return fmt.Errorf("Unable to parse singularity.conf file: %s", err)
+	}
+
+	mksquashfs, err := getMksquashfsPath(c)
+	if err != nil {
+		return fmt.Errorf("While searching for mksquashfs: %v", err)
+	}
+
+	options, compression, err := mksquashfsArgs(b.Opts, c)
+	if err != nil {
+		return fmt.Errorf("While setting mksquashfs options: %v", err)
+	}
+
+	layerDir := filepath.Join(b.Path, "layer")
+	whiteouts, changed, err := a.diff(b.Rootfs(), layerDir)
+	if err != nil {
+		return fmt.Errorf("While collecting changes: %v", err)
+	}
+	if changed == 0 && len(whiteouts) == 0 {
+		sylog.Warningf("Root filesystem is unchanged, no layer added to %s", path)
+		return nil
+	}
+	sylog.Debugf("Layer holds %d changed and %d removed files", changed, len(whiteouts))
+
+	pseudoPath := filepath.Join(b.Path, "whiteouts")
+	if err := writeWhiteouts(pseudoPath, whiteouts); err != nil {
+		return fmt.Errorf("While writing whiteouts: %v", err)
+	}
+
+	squashfsPath := filepath.Join(b.Path, "layer.squashfs")
+	defer os.Remove(squashfsPath)
+
+	args := []string{layerDir, squashfsPath, "-noappend", "-pf", pseudoPath}
+
+	// build squashfs with all-root flag when building as a user
+	if syscall.Getuid() != 0 {
+		args = append(args, "-all-root")
+	}
+	args = append(args, options...)
+
+	sylog.Debugf("Creating squashfs layer with %s compression: %s", compression, strings.Join(args, " "))
+
+	if err := runMksquashfs(mksquashfs, args); err != nil {
+		return err
+	}
+
+	if err := appendLayer(path, b.Recipe.Raw, squashfsPath, compression); err != nil {
+		return fmt.Errorf("While adding layer to SIF: %v", err)
+	}
+
+	return nil
+}
+
+// diff creates in dir the files of rootfs changed since the snapshot, along
+// with their parent directories, and returns the paths of the removed files
+// relative to rootfs and the number of changed files.
+func (a *SIFLayerAssembler) diff(rootfs, dir string) (removed []string, changed int, err error) {
+	created := map[string]bool{".": true}
+	var dirs []string
+
+	// mkdirAll creates the layer directory rel and its missing parents,
+	// their attributes are set once their content is created
+	mkdirAll := func(rel string) error {
+		if created[rel] {
+			return nil
+		}
+		if err := os.MkdirAll(filepath.Join(dir, rel), 0755); err != nil {
+			return err
+		}
+		for p := rel; !created[p]; p = filepath.Dir(p) {
+			created[p] = true
+			dirs = append(dirs, p)
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, 0, err
+	}
+	dirs = append(dirs, ".")
+
+	err = filepath.Walk(rootfs, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(rootfs, path)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		st := fi.Sys().(*syscall.Stat_t)
+		if old, ok := a.snapshot[rel]; ok && old.ino == st.Ino && old.ctime == st.Ctim {
+			return nil
+		}
+		changed++
+
+		if fi.IsDir() {
+			return mkdirAll(rel)
+		}
+		if err := mkdirAll(filepath.Dir(rel)); err != nil {
+			return err
+		}
+		// the layer shares the content of the root filesystem files
+		if err := os.Link(path, filepath.Join(dir, rel)); err != nil {
+			sylog.Debugf("Copying %s: %s", rel, err)
+			if out, err := exec.Command("/bin/cp", "-a", path, filepath.Join(dir, rel)).CombinedOutput(); err != nil {
+				return fmt.Errorf("while copying %s: %v: %s", rel, err, out)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// only the topmost removed file of a removed tree is whited out, files
+	// replaced by non-directories are hidden by their replacement
+	for rel := range a.snapshot {
+		if _, err := os.Lstat(filepath.Join(rootfs, rel)); !os.IsNotExist(err) {
+			continue
+		}
+		parent := filepath.Dir(rel)
+		if fi, err := os.Lstat(filepath.Join(rootfs, parent)); err != nil || !fi.IsDir() {
+			continue
+		}
+		if err := mkdirAll(parent); err != nil {
+			return nil, 0, err
+		}
+		removed = append(removed, rel)
+	}
+	sort.Strings(removed)
+
+	// children are set before their parents, whose modification time
+	// would be changed otherwise
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, rel := range dirs {
+		if err := copyAttributes(filepath.Join(rootfs, rel), filepath.Join(dir, rel)); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return removed, changed, nil
+}
+
+// copyAttributes sets the ownership, permissions and times of the
+// directory dst to the ones of src.
+func copyAttributes(src, dst string) error {
+	var st syscall.Stat_t
+	if err := syscall.Lstat(src, &st); err != nil {
+		return err
+	}
+	if os.Geteuid() == 0 {
+		if err := os.Lchown(dst, int(st.Uid), int(st.Gid)); err != nil {
+			return err
+		}
+	}
+	if err := unix.Chmod(dst, st.Mode&07777); err != nil {
+		return err
+	}
+	ts := []unix.Timespec{
+		unix.NsecToTimespec(syscall.TimespecToNsec(st.Atim)),
+		unix.NsecToTimespec(syscall.TimespecToNsec(st.Mtim)),
+	}
+	return unix.UtimesNanoAt(unix.AT_FDCWD, dst, ts, unix.AT_SYMLINK_NOFOLLOW)
+}
+
+// writeWhiteouts writes the mksquashfs pseudo file creating the overlay
+// whiteouts, 0/0 character devices, of the removed paths.
+func writeWhiteouts(path string, removed []string) error {
+	var buf strings.Builder
+	for _, rel := range removed {
+		if strings.ContainsAny(rel, "\n") {
+			return fmt.Errorf("can't remove %q: new lines are not supported in file names", rel)
+		}
+		fmt.Fprintf(&buf, "%s c 0 0 0 0 0\n", escapePseudo(rel))
+	}
+	return ioutil.WriteFile(path, []byte(buf.String()), 0644)
+}
+
+// escapePseudo escapes the characters of a path interpreted by mksquashfs
+// pseudo file definitions.
+func escapePseudo(path string) string {
+	var buf strings.Builder
+	for _, r := range path {
+		switch r {
+		case '\\', ' ', '\t', '"':
+			buf.WriteRune('\\')
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
+
+// appendLayer adds the squashfs layer squashfile to the SIF image path,
+// stacked over its topmost partition, along with the definition it was
+// built from.
+func appendLayer(path string, definition []byte, squashfile, compression string) error {
+	fimg, err := sif.LoadContainer(path, false)
+	if err != nil {
+		return fmt.Errorf("while loading SIF image: %s", err)
+	}
+	defer fimg.UnloadContainer()
+
+	prim, _, err := fimg.GetPartPrimSys()
+	if err != nil {
+		return fmt.Errorf("no primary partition found")
+	}
+	if fstype, err := prim.GetFsType(); err != nil || fstype != sif.FsSquash {
+		return fmt.Errorf("layers can only be added over a squashfs primary partition")
+	}
+	arch, err := prim.GetArch()
+	if err != nil {
+		return err
+	}
+	layers, err := image.SIFLayers(&fimg)
+	if err != nil {
+		return err
+	}
+	top := prim.ID
+	if len(layers) > 0 {
+		top = layers[len(layers)-1].ID
+	}
+
+	f, err := os.Open(squashfile)
+	if err != nil {
+		return fmt.Errorf("while opening layer file: %s", err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	parinput := sif.DescriptorInput{
+		Datatype: sif.DataPartition,
+		Groupid:  prim.Groupid,
+		Link:     top,
+		Fname:    fmt.Sprintf("layer-%d.squashfs", len(layers)+1),
+		Fp:       f,
+		Size:     fi.Size(),
+	}
+	if err := parinput.SetPartExtra(sif.FsSquash, sif.PartSystem, string(arch[:sif.HdrArchLen-1])); err != nil {
+		return err
+	}
+	if err := image.SetPartCompression(&parinput, compression); err != nil {
+		return fmt.Errorf("while recording partition compression: %s", err)
+	}
+	if err := fimg.AddObject(parinput); err != nil {
+		return fmt.Errorf("while adding layer partition: %s", err)
+	}
+
+	if layers, err = image.SIFLayers(&fimg); err != nil {
+		return err
+	}
+	definput := sif.DescriptorInput{
+		Datatype: sif.DataDeffile,
+		Groupid:  sif.DescrDefaultGroup,
+		Link:     layers[len(layers)-1].ID,
+		Data:     definition,
+		Size:     int64(len(definition)),
+	}
+	if err := fimg.AddObject(definput); err != nil {
+		return fmt.Errorf("while adding layer definition: %s", err)
+	}
+
+	return nil
+}
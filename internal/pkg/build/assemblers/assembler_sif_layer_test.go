@@ -0,0 +1,181 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package assemblers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/internal/pkg/image"
+)
+
+func TestSIFLayerDiff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sif-layer-diff-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rootfs := filepath.Join(dir, "rootfs")
+	for _, d := range []string{"etc", "opt/app", "var/cache"} {
+		if err := os.MkdirAll(filepath.Join(rootfs, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []string{"etc/hosts", "etc/passwd", "opt/app/bin", "var/cache/a", "var/cache/b", "removed file"} {
+		if err := ioutil.WriteFile(filepath.Join(rootfs, f), []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a := &SIFLayerAssembler{}
+	if err := a.Snapshot(rootfs); err != nil {
+		t.Fatalf("failed to take snapshot: %v", err)
+	}
+
+	// changes are detected from the change time
+	time.Sleep(20 * time.Millisecond)
+
+	if err := ioutil.WriteFile(filepath.Join(rootfs, "etc/hosts"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(rootfs, "etc/new"), []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(rootfs, "opt/app"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{"var/cache", "removed file"} {
+		if err := os.RemoveAll(filepath.Join(rootfs, f)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Remove(filepath.Join(rootfs, "etc/passwd")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(rootfs, "etc/passwd"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	layer := filepath.Join(dir, "layer")
+	removed, changed, err := a.diff(rootfs, layer)
+	if err != nil {
+		t.Fatalf("failed to compute changes: %v", err)
+	}
+
+	if expected := []string{"removed file", "var/cache"}; !reflect.DeepEqual(removed, expected) {
+		t.Errorf("unexpected removed files %v", removed)
+	}
+	// etc and var changed along with their content, opt is only created
+	// as the parent of opt/app
+	if changed != 6 {
+		t.Errorf("unexpected number of changed files %d", changed)
+	}
+
+	var files []string
+	filepath.Walk(layer, func(path string, fi os.FileInfo, err error) error {
+		if err == nil && path != layer {
+			rel, _ := filepath.Rel(layer, path)
+			files = append(files, rel)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	expected := []string{"etc", "etc/hosts", "etc/new", "etc/passwd", "opt", "opt/app", "var"}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("unexpected layer content %v", files)
+	}
+
+	fi, err := os.Stat(filepath.Join(layer, "opt/app"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0700 {
+		t.Errorf("unexpected permissions %s of opt/app", fi.Mode())
+	}
+
+	pseudo := filepath.Join(dir, "whiteouts")
+	if err := writeWhiteouts(pseudo, removed); err != nil {
+		t.Fatalf("failed to write whiteouts: %v", err)
+	}
+	b, err := ioutil.ReadFile(pseudo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "removed\\ file c 0 0 0 0 0\nvar/cache c 0 0 0 0 0\n" {
+		t.Errorf("unexpected whiteouts:\n%s", b)
+	}
+}
+
+func TestAppendLayer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sif-layer-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	squashfs := filepath.Join(dir, "squashfs.img")
+	if err := ioutil.WriteFile(squashfs, []byte("rootfs"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "image.sif")
+	if err := createSIF(path, []byte("Bootstrap: scratch\n"), squashfs, "gzip", nil); err != nil {
+		t.Fatalf("failed to create SIF image: %v", err)
+	}
+
+	for i, c := range []string{"xz", "zstd"} {
+		layer := filepath.Join(dir, "layer.img")
+		if err := ioutil.WriteFile(layer, []byte(c+" layer"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := appendLayer(path, []byte("Bootstrap: localimage\n"), layer, c); err != nil {
+			t.Fatalf("failed to add layer %d: %v", i, err)
+		}
+	}
+
+	fimg, err := sif.LoadContainer(path, true)
+	if err != nil {
+		t.Fatalf("failed to load SIF image: %v", err)
+	}
+	defer fimg.UnloadContainer()
+
+	prim, _, err := fimg.GetPartPrimSys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	layers, err := image.SIFLayers(&fimg)
+	if err != nil {
+		t.Fatalf("failed to get layers: %v", err)
+	}
+	if len(layers) != 2 {
+		t.Fatalf("unexpected number of layers %d", len(layers))
+	}
+	if layers[0].Link != prim.ID || layers[1].Link != layers[0].ID {
+		t.Errorf("layers are not stacked over the primary partition")
+	}
+	for i, c := range []string{"xz", "zstd"} {
+		if data := string(layers[i].GetData(&fimg)); data != c+" layer" {
+			t.Errorf("unexpected content %q of layer %d", data, i)
+		}
+		if compression := image.PartCompression(layers[i]); compression != c {
+			t.Errorf("unexpected compression %s of layer %d", compression, i)
+		}
+	}
+
+	defs, _, err := fimg.GetFromDescr(sif.Descriptor{Datatype: sif.DataDeffile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(defs) != 3 || defs[2].Link != layers[1].ID {
+		t.Errorf("unexpected layer definitions")
+	}
+}
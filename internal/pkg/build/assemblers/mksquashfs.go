@@ -0,0 +1,117 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package assemblers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	singularityConfig "github.com/sylabs/singularity/internal/pkg/runtime/engines/singularity/config"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/internal/pkg/util/fs/squashfs"
+	"github.com/sylabs/singularity/pkg/build/types"
+)
+
+func getMksquashfsPath(c *singularityConfig.FileConfig) (string, error) {
+	// p is either "" or the string value in the conf file
+	p := c.MksquashfsPath
+
+	// If the path contains the binary name use it as is, otherwise add mksquashfs via filepath.Join
+	if !strings.HasSuffix(c.MksquashfsPath, "mksquashfs") {
+		p = filepath.Join(c.MksquashfsPath, "mksquashfs")
+	}
+
+	// exec.LookPath functions on absolute paths (ignoring $PATH) as well
+	return exec.LookPath(p)
+}
+
+// mksquashfsArgs returns the mksquashfs options creating the filesystem of a
+// SIF image along with its compression, the build options take precedence
+// over the singularity.conf ones.
+func mksquashfsArgs(opts types.Options, c *singularityConfig.FileConfig) ([]string, string, error) {
+	var args []string
+
+	compression := c.MksquashfsCompression
+	if opts.Compression != "" {
+		compression = opts.Compression
+	}
+	if compression == "" {
+		compression = "gzip"
+	}
+	if err := squashfs.CheckCompression(compression); err != nil {
+		return nil, "", err
+	}
+	if compression == squashfs.CompressionNone {
+		args = append(args, "-noI", "-noD", "-noF", "-noX")
+	} else {
+		args = append(args, "-comp", compression)
+	}
+
+	blockSize := c.MksquashfsBlockSize
+	if opts.BlockSize != "" {
+		blockSize = opts.BlockSize
+	}
+	if blockSize != "" {
+		size, err := squashfs.ParseBlockSize(blockSize)
+		if err != nil {
+			return nil, "", err
+		}
+		args = append(args, "-b", strconv.Itoa(size))
+	}
+
+	processors := c.MksquashfsProcs
+	if opts.Processors != 0 {
+		processors = opts.Processors
+	}
+	if opts.Reproducible {
+		if processors > 1 {
+			sylog.Warningf("Reproducible images are created with a single processor, ignoring %d processors", processors)
+		}
+		// mksquashfs reads directories in sorted order, a single
+		// processor writes their content in that order
+		processors = 1
+	}
+	if processors != 0 {
+		args = append(args, "-processors", strconv.FormatUint(uint64(processors), 10))
+	}
+
+	// exclude patterns must come last
+	excludes := append(append([]string{}, c.MksquashfsExclude...), opts.Excludes...)
+	if len(excludes) > 0 {
+		args = append(args, "-wildcards", "-e")
+		args = append(args, excludes...)
+	}
+
+	return args, compression, nil
+}
+
+// runMksquashfs runs mksquashfs with args, its error output is reported on
+// failure.
+func runMksquashfs(mksquashfs string, args []string) error {
+	mksquashfsCmd := exec.Command(mksquashfs, args...)
+	stderr, err := mksquashfsCmd.StderrPipe()
+	if err != nil {
+		return fmt.Errorf("While setting up stderr pipe: %v", err)
+	}
+
+	if err := mksquashfsCmd.Start(); err != nil {
+		return fmt.Errorf("While starting mksquashfs: %v", err)
+	}
+
+	errOut, err := ioutil.ReadAll(stderr)
+	if err != nil {
+		return fmt.Errorf("While reading mksquashfs stderr: %v", err)
+	}
+
+	if err := mksquashfsCmd.Wait(); err != nil {
+		return fmt.Errorf("While running mksquashfs: %v: %s", err, strings.Replace(string(errOut), "\n", " ", -1))
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package assemblers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/internal/pkg/image"
+	singularityConfig "github.com/sylabs/singularity/internal/pkg/runtime/engines/singularity/config"
+	"github.com/sylabs/singularity/internal/pkg/util/fs/squashfs"
+	"github.com/sylabs/singularity/pkg/build/types"
+)
+
+func TestMksquashfsArgs(t *testing.T) {
+	conf := singularityConfig.FileConfig{
+		MksquashfsCompression: "gzip",
+		MksquashfsProcs:       4,
+		MksquashfsExclude:     []string{"var/cache/*"},
+	}
+
+	tests := []struct {
+		name        string
+		opts        types.Options
+		conf        singularityConfig.FileConfig
+		args        []string
+		compression string
+		valid       bool
+	}{
+		{
+			name:        "Defaults",
+			args:        []string{"-comp", "gzip"},
+			compression: "gzip",
+			valid:       true,
+		},
+		{
+			name:        "Config",
+			conf:        conf,
+			args:        []string{"-comp", "gzip", "-processors", "4", "-wildcards", "-e", "var/cache/*"},
+			compression: "gzip",
+			valid:       true,
+		},
+		{
+			name: "Options",
+			opts: types.Options{
+				Compression: "zstd",
+				BlockSize:   "1M",
+				Processors:  2,
+				Excludes:    []string{"tmp/*"},
+			},
+			conf:        conf,
+			args:        []string{"-comp", "zstd", "-b", "1048576", "-processors", "2", "-wildcards", "-e", "var/cache/*", "tmp/*"},
+			compression: "zstd",
+			valid:       true,
+		},
+		{
+			name:        "NoCompression",
+			opts:        types.Options{Compression: "none"},
+			args:        []string{"-noI", "-noD", "-noF", "-noX"},
+			compression: "none",
+			valid:       true,
+		},
+		{
+			name:        "Reproducible",
+			opts:        types.Options{Reproducible: true},
+			conf:        conf,
+			args:        []string{"-comp", "gzip", "-processors", "1", "-wildcards", "-e", "var/cache/*"},
+			compression: "gzip",
+			valid:       true,
+		},
+		{
+			name:  "BadCompression",
+			opts:  types.Options{Compression: "lzma"},
+			valid: false,
+		},
+		{
+			name:  "BadBlockSize",
+			opts:  types.Options{BlockSize: "3K"},
+			valid: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, compression, err := mksquashfsArgs(tt.opts, &tt.conf)
+			if !tt.valid {
+				if err == nil {
+					t.Fatalf("unexpected success")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("unexpected arguments %v", args)
+			}
+			if compression != tt.compression {
+				t.Errorf("unexpected compression %s", compression)
+			}
+		})
+	}
+}
+
+func TestCreateSIFCompression(t *testing.T) {
+	dir, err := ioutil.TempDir("", "compression-sif-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	squashfs := filepath.Join(dir, "squashfs.img")
+	if err := ioutil.WriteFile(squashfs, []byte("rootfs"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "image.sif")
+	if err := createSIF(path, []byte("Bootstrap: scratch\n"), squashfs, "zstd", nil); err != nil {
+		t.Fatalf("failed to create SIF image: %v", err)
+	}
+
+	fimg, err := sif.LoadContainer(path, true)
+	if err != nil {
+		t.Fatalf("failed to load SIF image: %v", err)
+	}
+	defer fimg.UnloadContainer()
+
+	part, _, err := fimg.GetPartPrimSys()
+	if err != nil {
+		t.Fatalf("failed to get primary system partition: %v", err)
+	}
+	if fstype, err := part.GetFsType(); err != nil || fstype != sif.FsSquash {
+		t.Errorf("unexpected filesystem type %v: %v", fstype, err)
+	}
+	if c := image.PartCompression(part); c != "zstd" {
+		t.Errorf("unexpected compression %q", c)
+	}
+}
+
+// TestCompressionRoundTrip checks that filesystems created with each of the
+// compressions accepted by build can be read back, e.g. to inspect or update
+// images.
+func TestCompressionRoundTrip(t *testing.T) {
+	mksquashfs, err := getMksquashfsPath(&singularityConfig.FileConfig{})
+	if err != nil {
+		t.Skipf("mksquashfs not found: %s", err)
+	}
+
+	dir, err := ioutil.TempDir("", "compression-round-trip-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rootfs := filepath.Join(dir, "rootfs")
+	if err := os.Mkdir(rootfs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	content := strings.Repeat("singularity\n", 10000)
+	if err := ioutil.WriteFile(filepath.Join(rootfs, "file"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := map[string]int{
+		"gzip":                   squashfs.CompGzip,
+		"lz4":                    squashfs.CompLz4,
+		"xz":                     squashfs.CompXz,
+		"zstd":                   squashfs.CompZstd,
+		squashfs.CompressionNone: squashfs.CompGzip,
+	}
+
+	for _, c := range squashfs.Compressions {
+		t.Run(c, func(t *testing.T) {
+			id, ok := ids[c]
+			if !ok {
+				t.Fatalf("unexpected compression %s", c)
+			}
+
+			options, _, err := mksquashfsArgs(types.Options{Compression: c}, &singularityConfig.FileConfig{})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			path := filepath.Join(dir, c+".sqfs")
+			args := append([]string{rootfs, path, "-noappend", "-all-root"}, options...)
+			if err := runMksquashfs(mksquashfs, args); err != nil {
+				if strings.Contains(err.Error(), "not supported") {
+					t.Skipf("mksquashfs doesn't support %s compression", c)
+				}
+				t.Fatalf("failed to create squashfs filesystem: %s", err)
+			}
+
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			r, err := squashfs.NewReader(f)
+			if err != nil {
+				t.Fatalf("failed to read squashfs filesystem: %s", err)
+			}
+			if r.Compression() != id {
+				t.Errorf("unexpected compression %s", squashfs.CompressionName(r.Compression()))
+			}
+			b, err := r.ReadFile("/file")
+			if err != nil {
+				t.Fatalf("failed to read file: %s", err)
+			}
+			if string(b) != content {
+				t.Errorf("unexpected file content")
+			}
+		})
+	}
+}
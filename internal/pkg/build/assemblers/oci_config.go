@@ -0,0 +1,243 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package assemblers
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+)
+
+// defaultPath is the PATH set by the runtime before sourcing environment
+// scripts
+const defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// envScripts lists the environment scripts converted into the image config
+// environment: variables from a Docker image, the %environment section and
+// the variables added to $SINGULARITY_ENVIRONMENT during %post.
+var envScripts = []string{
+	"10-docker2singularity.sh",
+	"90-environment.sh",
+	"91-environment.sh",
+}
+
+// envAssignment matches a variable assignment, optionally exported
+var envAssignment = regexp.MustCompile(`^(?:export\s+)?([A-Za-z_][A-Za-z0-9_]*)=(.*)$`)
+
+// imageEnv holds the environment of an image in assignment order.
+type imageEnv struct {
+	names  []string
+	values map[string]string
+}
+
+func newImageEnv() *imageEnv {
+	e := &imageEnv{values: make(map[string]string)}
+	e.set("PATH", defaultPath)
+	return e
+}
+
+func (e *imageEnv) set(name, value string) {
+	if _, ok := e.values[name]; !ok {
+		e.names = append(e.names, name)
+	}
+	e.values[name] = value
+}
+
+func (e *imageEnv) lookup(name string) (string, bool) {
+	v, ok := e.values[name]
+	return v, ok
+}
+
+// list returns the environment in the NAME=value form of the image config.
+func (e *imageEnv) list() []string {
+	env := make([]string, 0, len(e.names))
+	for _, name := range e.names {
+		env = append(env, name+"="+e.values[name])
+	}
+	return env
+}
+
+// parseScript applies the variable assignments of an environment script to
+// e. Values are expanded with the variables already set, lines using other
+// shell features can't be represented in an image config and are ignored.
+func (e *imageEnv) parseScript(content string) {
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		m := envAssignment.FindStringSubmatch(line)
+		if m == nil {
+			if line != "" && !strings.HasPrefix(line, "#") {
+				sylog.Debugf("Ignoring environment line %q", line)
+			}
+			continue
+		}
+		p := &wordParser{s: m[2], lookup: e.lookup}
+		value, ok := p.word(false, 0)
+		if !ok {
+			sylog.Warningf("Environment variable %s can't be converted: %s", m[1], line)
+			continue
+		}
+		e.set(m[1], value)
+	}
+}
+
+// wordParser expands a shell word supporting quoting and the $NAME,
+// ${NAME}, ${NAME:-default} and ${NAME-default} parameter expansions.
+type wordParser struct {
+	s      string
+	i      int
+	lookup func(string) (string, bool)
+}
+
+// word expands the word up to the end character, or to the end of the
+// string, an unquoted blank or semicolon when end is 0. It returns false
+// if the word uses unsupported shell features.
+func (p *wordParser) word(quoted bool, end byte) (string, bool) {
+	var b strings.Builder
+	for p.i < len(p.s) {
+		c := p.s[p.i]
+		switch {
+		case end != 0 && c == end:
+			p.i++
+			return b.String(), true
+		case end == 0 && !quoted && (c == ' ' || c == '\t' || c == ';'):
+			// only a comment or another command may follow an assignment
+			rest := strings.TrimSpace(p.s[p.i:])
+			return b.String(), rest == "" || rest[0] == ';' || rest[0] == '#'
+		case c == '`':
+			return "", false
+		case c == '\\':
+			p.i++
+			if p.i == len(p.s) {
+				return "", false
+			}
+			n := p.s[p.i]
+			if quoted && !strings.ContainsRune("$`\"\\", rune(n)) {
+				b.WriteByte(c)
+			}
+			b.WriteByte(n)
+			p.i++
+		case c == '\'' && !quoted:
+			j := strings.IndexByte(p.s[p.i+1:], '\'')
+			if j < 0 {
+				return "", false
+			}
+			b.WriteString(p.s[p.i+1 : p.i+1+j])
+			p.i += j + 2
+		case c == '"' && !quoted:
+			p.i++
+			v, ok := p.word(true, '"')
+			if !ok {
+				return "", false
+			}
+			b.WriteString(v)
+		case c == '$':
+			v, ok := p.param(quoted)
+			if !ok {
+				return "", false
+			}
+			b.WriteString(v)
+		default:
+			b.WriteByte(c)
+			p.i++
+		}
+	}
+	return b.String(), end == 0
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// param expands the parameter expansion starting at the current $.
+func (p *wordParser) param(quoted bool) (string, bool) {
+	p.i++
+	braces := p.i < len(p.s) && p.s[p.i] == '{'
+	if braces {
+		p.i++
+	}
+	start := p.i
+	for p.i < len(p.s) && isNameChar(p.s[p.i]) {
+		p.i++
+	}
+	name := p.s[start:p.i]
+	if name == "" {
+		if braces || (p.i < len(p.s) && p.s[p.i] == '(') {
+			return "", false
+		}
+		return "$", true
+	}
+	value, set := p.lookup(name)
+	if !braces {
+		return value, true
+	}
+
+	if p.i < len(p.s) && p.s[p.i] == '}' {
+		p.i++
+		return value, true
+	}
+	colon := strings.HasPrefix(p.s[p.i:], ":-")
+	if !colon && !strings.HasPrefix(p.s[p.i:], "-") {
+		return "", false
+	}
+	if colon {
+		p.i += 2
+	} else {
+		p.i++
+	}
+	def, ok := p.word(quoted, '}')
+	if !ok {
+		return "", false
+	}
+	if !set || (colon && value == "") {
+		return def, true
+	}
+	return value, true
+}
+
+// imageConfig returns the image config of the container in rootfs: the
+// runscript is the entrypoint, the environment scripts give the environment
+// and the labels are kept.
+func imageConfig(rootfs string) (imgspecv1.ImageConfig, error) {
+	config := imgspecv1.ImageConfig{}
+
+	runscript := filepath.Join(rootfs, ".singularity.d", "runscript")
+	if fi, err := os.Stat(runscript); err == nil && fi.Mode().IsRegular() {
+		config.Entrypoint = []string{"/.singularity.d/runscript"}
+	} else {
+		config.Cmd = []string{"/bin/sh"}
+	}
+
+	env := newImageEnv()
+	for _, script := range envScripts {
+		content, err := ioutil.ReadFile(filepath.Join(rootfs, ".singularity.d", "env", script))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return config, err
+		}
+		env.parseScript(string(content))
+	}
+	config.Env = env.list()
+
+	labels, err := ioutil.ReadFile(filepath.Join(rootfs, ".singularity.d", "labels.json"))
+	if err == nil {
+		if err := json.Unmarshal(labels, &config.Labels); err != nil {
+			return config, err
+		}
+	} else if !os.IsNotExist(err) {
+		return config, err
+	}
+
+	return config, nil
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package assemblers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseScript(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		expected []string
+	}{
+		{
+			name:     "simple",
+			script:   "FOO=bar\nexport BAZ=qux\n",
+			expected: []string{"PATH=" + defaultPath, "FOO=bar", "BAZ=qux"},
+		},
+		{
+			name:     "quoted",
+			script:   "    export FOO=\"a b\" # comment\n    BAR='$FOO'\n    BAZ=\"\\$FOO \\\"x\\\"\"\n",
+			expected: []string{"PATH=" + defaultPath, "FOO=a b", "BAR=$FOO", "BAZ=$FOO \"x\""},
+		},
+		{
+			name:     "expansion",
+			script:   "export PATH=/opt/bin:$PATH\nFOO=${PATH}\nBAR=${UNSET}x\n",
+			expected: []string{"PATH=/opt/bin:" + defaultPath, "FOO=/opt/bin:" + defaultPath, "BAR=x"},
+		},
+		{
+			name:     "default values",
+			script:   "export FOO=${FOO:-\"a\\$b\"}\nexport FOO=${FOO:-c}\nEMPTY=\nA=${EMPTY-d}\nB=${EMPTY:-e}\n",
+			expected: []string{"PATH=" + defaultPath, "FOO=a$b", "EMPTY=", "A=", "B=e"},
+		},
+		{
+			name:     "unsupported",
+			script:   "FOO=$(hostname)\nBAR=`hostname`\nBAZ=a b\nQUX=${FOO:=x}\nif [ -z \"$A\" ]; then\nexport A B\nOK=1; export OK\n",
+			expected: []string{"PATH=" + defaultPath, "OK=1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newImageEnv()
+			e.parseScript(tt.script)
+			if env := e.list(); !reflect.DeepEqual(env, tt.expected) {
+				t.Errorf("unexpected environment %q, expected %q", env, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package assemblers
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/sylabs/sif/pkg/sif"
+	"golang.org/x/sys/unix"
+)
+
+// squashfsTimeOffset is the offset of the mkfs_time field of the squashfs
+// superblock, following the magic and inode count
+const squashfsTimeOffset = 8
+
+// reproducibleSquashfsName is the name recorded in the descriptor of the
+// system partition of reproducible images instead of the temporary file name
+const reproducibleSquashfsName = "rootfs.squashfs"
+
+// clampTimes sets the modification and access times of the files of rootfs
+// more recent than epoch to epoch, symbolic links are not followed.
+func clampTimes(rootfs string, epoch time.Time) error {
+	ts := []unix.Timespec{
+		unix.NsecToTimespec(epoch.UnixNano()),
+		unix.NsecToTimespec(epoch.UnixNano()),
+	}
+
+	return filepath.Walk(rootfs, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.ModTime().After(epoch) {
+			return nil
+		}
+		if err := unix.UtimesNanoAt(unix.AT_FDCWD, path, ts, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+			return fmt.Errorf("while setting times of %s: %v", path, err)
+		}
+		return nil
+	})
+}
+
+// setSquashfsTime sets the creation time recorded in the superblock of the
+// squashfs image at path.
+func setSquashfsTime(path string, epoch time.Time) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, uint32(epoch.Unix()))
+	if _, err := f.WriteAt(b, squashfsTimeOffset); err != nil {
+		return fmt.Errorf("while writing squashfs creation time: %v", err)
+	}
+	return nil
+}
+
+// contentID returns a SIF ID derived from the definition and the system
+// partition of an image, identical content gives the same ID.
+func contentID(definition []byte, squashfile string) (uuid.UUID, error) {
+	f, err := os.Open(squashfile)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	h.Write(definition)
+	if _, err := io.Copy(h, f); err != nil {
+		return uuid.Nil, err
+	}
+	return uuid.NewV5(uuid.NamespaceOID, fmt.Sprintf("sha256:%x", h.Sum(nil))), nil
+}
+
+// clampSIF sets the times recorded in the header and the descriptors of the
+// SIF image at path to epoch, descriptors are owned by root.
+func clampSIF(path string, epoch time.Time) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var h sif.Header
+	if err := binary.Read(f, binary.LittleEndian, &h); err != nil {
+		return fmt.Errorf("while reading SIF header: %v", err)
+	}
+	h.Ctime = epoch.Unix()
+	h.Mtime = epoch.Unix()
+
+	descrs := make([]sif.Descriptor, h.Dtotal)
+	if _, err := f.Seek(h.Descroff, io.SeekStart); err != nil {
+		return err
+	}
+	if err := binary.Read(f, binary.LittleEndian, descrs); err != nil {
+		return fmt.Errorf("while reading SIF descriptors: %v", err)
+	}
+	for i := range descrs {
+		if !descrs[i].Used {
+			continue
+		}
+		descrs[i].Ctime = epoch.Unix()
+		descrs[i].Mtime = epoch.Unix()
+		descrs[i].UID = 0
+		descrs[i].Gid = 0
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := binary.Write(f, binary.LittleEndian, h); err != nil {
+		return fmt.Errorf("while writing SIF header: %v", err)
+	}
+	if _, err := f.Seek(h.Descroff, io.SeekStart); err != nil {
+		return err
+	}
+	if err := binary.Write(f, binary.LittleEndian, descrs); err != nil {
+		return fmt.Errorf("while writing SIF descriptors: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package assemblers
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sylabs/sif/pkg/sif"
+)
+
+func TestClampTimes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clamp-times-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	epoch := time.Unix(1500000000, 0)
+	old := time.Unix(1000000000, 0)
+
+	recent := filepath.Join(dir, "recent")
+	older := filepath.Join(dir, "older")
+	link := filepath.Join(dir, "link")
+	for _, f := range []string{recent, older} {
+		if err := ioutil.WriteFile(f, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chtimes(older, old, old); err != nil {
+		t.Fatal(err)
+	}
+	// links are not followed
+	if err := os.Symlink("older", link); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := clampTimes(dir, epoch); err != nil {
+		t.Fatalf("failed to clamp times: %v", err)
+	}
+
+	for path, expected := range map[string]time.Time{dir: epoch, recent: epoch, link: epoch, older: old} {
+		fi, err := os.Lstat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fi.ModTime().Equal(expected) {
+			t.Errorf("unexpected time of %s: got %v, expected %v", path, fi.ModTime(), expected)
+		}
+	}
+}
+
+func TestCreateSIFReproducible(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reproducible-sif-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	epoch := time.Unix(1500000000, 0)
+	definition := []byte("Bootstrap: docker\nFrom: alpine\n")
+
+	var images [][]byte
+	for i, name := range []string{"squashfs-1.img", "squashfs-2.img"} {
+		squashfs := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(squashfs, bytes.Repeat([]byte("rootfs"), 1000), 0644); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, name+".sif")
+		if err := createSIF(path, definition, squashfs, "gzip", &epoch); err != nil {
+			t.Fatalf("failed to create SIF image: %v", err)
+		}
+
+		fimg, err := sif.LoadContainer(path, true)
+		if err != nil {
+			t.Fatalf("failed to load SIF image: %v", err)
+		}
+		if fimg.Header.Ctime != epoch.Unix() || fimg.Header.Mtime != epoch.Unix() {
+			t.Errorf("image %d: unexpected header times %d %d", i, fimg.Header.Ctime, fimg.Header.Mtime)
+		}
+		for _, d := range fimg.DescrArr {
+			if d.Used && (d.Ctime != epoch.Unix() || d.Mtime != epoch.Unix() || d.UID != 0 || d.Gid != 0) {
+				t.Errorf("image %d: unexpected descriptor %d times or owner", i, d.ID)
+			}
+		}
+		fimg.UnloadContainer()
+
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		images = append(images, b)
+
+		// make sure the second image is created at another time
+		if i == 0 {
+			time.Sleep(1100 * time.Millisecond)
+		}
+	}
+
+	if !bytes.Equal(images[0], images[1]) {
+		t.Fatal("images built from identical inputs differ")
+	}
+
+	// the image ID depends on the content
+	squashfs := filepath.Join(dir, "squashfs-3.img")
+	if err := ioutil.WriteFile(squashfs, []byte("other rootfs"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	id1, err := contentID(definition, filepath.Join(dir, "squashfs-1.img"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id3, err := contentID(definition, squashfs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id1 == id3 {
+		t.Fatal("images with different content have the same ID")
+	}
+}
+
+func TestSetSquashfsTime(t *testing.T) {
+	f, err := ioutil.TempFile("", "squashfs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Write(make([]byte, 96))
+	f.Close()
+
+	if err := setSquashfsTime(f.Name(), time.Unix(0x01020304, 0)); err != nil {
+		t.Fatalf("failed to set squashfs time: %v", err)
+	}
+	b, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b[8:12], []byte{4, 3, 2, 1}) || len(b) != 96 {
+		t.Fatalf("unexpected superblock: %v", b[:16])
+	}
+}
@@ -16,7 +16,6 @@ import (
 	"path/filepath"
 	"strconv"
 	"syscall"
-	"time"
 
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/sylabs/singularity/internal/pkg/build/apps"
@@ -40,27 +39,38 @@ import (
 // 		Call Bundle() to obtain all data needed to execute the specified build locally on the machine
 // 		Execute all of a definition using AllSections()
 // 		And finally call Assemble() to create our container image
+//
+// A definition may declare several build stages, each stage is built in
+// order into its own Bundle and only the final stage is assembled.
 type Build struct {
 	// dest is the location for container after build is complete
 	dest string
 	// format is the format of built container, e.g., SIF, sandbox
 	format string
-	// c Gets and Packs data needed to build a container into a Bundle from various sources
-	c ConveyorPacker
+	// stages are the build stages of the definition, the last one being the final image
+	stages []*stage
 	// a Assembles a container from the information stored in a Bundle into various formats
 	a Assembler
+}
+
+// stage holds everything needed to build a single stage of a definition
+type stage struct {
+	// name is the name given to the stage by the Stage header, if any
+	name string
+	// c Gets and Packs data needed to build a container into a Bundle from various sources
+	c ConveyorPacker
 	// b is an intermediate structure that encapsulates all information for the container, e.g., metadata, filesystems
 	b *types.Bundle
 }
 
 // NewBuild creates a new Build struct from a spec (URI, definition file, etc...)
 func NewBuild(spec, dest, format string, libraryURL, authToken string, opts types.Options) (*Build, error) {
-	def, err := makeDef(spec, false)
+	defs, err := makeDef(spec, false, opts.BuildArgs)
 	if err != nil {
 		return nil, fmt.Errorf("unable to parse spec %v: %v", spec, err)
 	}
 
-	return newBuild(def, dest, format, libraryURL, authToken, opts)
+	return newBuild(defs, dest, format, libraryURL, authToken, opts)
 }
 
 // NewBuildJSON creates a new build struct from a JSON byte slice
@@ -70,16 +80,19 @@ func NewBuildJSON(r io.Reader, dest, format string, libraryURL, authToken string
 		return nil, fmt.Errorf("unable to parse JSON: %v", err)
 	}
 
-	return newBuild(def, dest, format, libraryURL, authToken, opts)
+	return newBuild([]types.Definition{def}, dest, format, libraryURL, authToken, opts)
 }
 
-func newBuild(d types.Definition, dest, format string, libraryURL, authToken string, opts types.Options) (*Build, error) {
-	var err error
-
+func newBuild(defs []types.Definition, dest, format string, libraryURL, authToken string, opts types.Options) (*Build, error) {
 	syscall.Umask(0002)
 
-	// always build a sandbox if updating an existing sandbox
-	if opts.Update {
+	// updating a SIF image adds a layer holding the changes to it, other
+	// images are updated as sandboxes
+	layer := false
+	if opts.Update && !opts.Force && image.IsSIF(dest) {
+		format = "sif"
+		layer = true
+	} else if opts.Update {
 		format = "sandbox"
 	}
 
@@ -88,20 +101,42 @@ func newBuild(d types.Definition, dest, format string, libraryURL, authToken str
 		dest:   dest,
 	}
 
-	b.b, err = types.NewBundle(opts.TmpDir, "sbuild")
-	if err != nil {
-		return nil, err
-	}
+	for i, d := range defs {
+		final := i == len(defs)-1
 
-	b.b.Recipe = d
-	b.b.Opts = opts
+		// the final image records the whole definition, including the
+		// stages it was built from
+		if final && len(defs) > 1 {
+			var raw []byte
+			for _, sd := range defs {
+				raw = append(raw, sd.Raw...)
+			}
+			d.Raw = raw
+		}
 
-	// dont need to get cp if we're skipping bootstrap
-	if !opts.Update || opts.Force {
-		if c, err := getcp(b.b.Recipe, libraryURL, authToken); err == nil {
-			b.c = c
-		} else {
-			return nil, fmt.Errorf("unable to get conveyorpacker: %s", err)
+		bundle, err := types.NewBundle(opts.TmpDir, "sbuild")
+		if err != nil {
+			b.cleanUp()
+			return nil, err
+		}
+
+		s := &stage{
+			name: d.Header["stage"],
+			b:    bundle,
+		}
+		s.b.Recipe = d
+		s.b.Opts = opts
+		b.stages = append(b.stages, s)
+
+		// dont need to get cp if we're skipping bootstrap, previous
+		// stages are always built from scratch
+		if !final || !opts.Update || opts.Force {
+			if c, err := getcp(s.b.Recipe, libraryURL, authToken); err == nil {
+				s.c = c
+			} else {
+				b.cleanUp()
+				return nil, fmt.Errorf("unable to get conveyorpacker: %s", err)
+			}
 		}
 	}
 
@@ -110,21 +145,34 @@ func newBuild(d types.Definition, dest, format string, libraryURL, authToken str
 		b.a = &assemblers.SandboxAssembler{}
 	case "sif":
 		b.a = &assemblers.SIFAssembler{}
+	case "oci-archive", "docker-archive":
+		b.a = &assemblers.OCIAssembler{Format: format}
 	default:
+		b.cleanUp()
 		return nil, fmt.Errorf("unrecognized output format %s", format)
 	}
+	if layer {
+		b.a = &assemblers.SIFLayerAssembler{}
+	}
 
 	return b, nil
 }
 
+// final returns the stage which is assembled into the container
+func (b *Build) final() *stage {
+	return b.stages[len(b.stages)-1]
+}
+
 // cleanUp removes remnants of build from file system unless NoCleanUp is specified
 func (b Build) cleanUp() {
-	if b.b.Opts.NoCleanUp {
-		sylog.Infof("Build performed with no clean up option, build bundle located at: %v", b.b.Path)
-		return
+	for _, s := range b.stages {
+		if s.b.Opts.NoCleanUp {
+			sylog.Infof("Build performed with no clean up option, build bundle located at: %v", s.b.Path)
+			continue
+		}
+		sylog.Debugf("Build bundle cleanup: %v", s.b.Path)
+		os.RemoveAll(s.b.Path)
 	}
-	sylog.Debugf("Build bundle cleanup: %v", b.b.Path)
-	os.RemoveAll(b.b.Path)
 }
 
 // Full runs a standard build from start to finish
@@ -142,14 +190,38 @@ func (b *Build) Full() error {
 	// clean up build normally
 	defer b.cleanUp()
 
-	if err := b.runPreScript(); err != nil {
+	for i, s := range b.stages {
+		if len(b.stages) > 1 && s.name != "" {
+			sylog.Infof("Building stage %d/%d: %s", i+1, len(b.stages), s.name)
+		} else if len(b.stages) > 1 {
+			sylog.Infof("Building stage %d/%d", i+1, len(b.stages))
+		}
+
+		if err := b.buildStage(s, s == b.final()); err != nil {
+			return err
+		}
+	}
+
+	sylog.Debugf("Calling assembler")
+	if err := b.Assemble(b.dest); err != nil {
 		return err
 	}
 
-	if b.b.Opts.Update && !b.b.Opts.Force {
+	sylog.Infof("Build complete: %s", b.dest)
+	return nil
+}
+
+// buildStage builds a single stage into its bundle, metadata is only
+// inserted for the final stage
+func (b *Build) buildStage(s *stage, final bool) error {
+	if err := s.runPreScript(); err != nil {
+		return err
+	}
+
+	if final && s.b.Opts.Update && !s.b.Opts.Force {
 		//if updating, extract dest container to bundle
 		sylog.Infof("Building into existing container: %s", b.dest)
-		p, err := sources.GetLocalPacker(b.dest, b.b)
+		p, err := sources.GetLocalPacker(b.dest, s.b)
 		if err != nil {
 			return err
 		}
@@ -158,116 +230,154 @@ func (b *Build) Full() error {
 		if err != nil {
 			return err
 		}
+
+		// changes are computed from the extracted root filesystem
+		if a, ok := b.a.(*assemblers.SIFLayerAssembler); ok {
+			if err := a.Snapshot(s.b.Rootfs()); err != nil {
+				return fmt.Errorf("while taking root filesystem snapshot: %v", err)
+			}
+		}
 	} else {
 		//if force, start build from scratch
-		if err := b.c.Get(b.b); err != nil {
+		if err := s.c.Get(s.b); err != nil {
 			return fmt.Errorf("conveyor failed to get: %v", err)
 		}
 
-		_, err := b.c.Pack()
+		_, err := s.c.Pack()
 		if err != nil {
 			return fmt.Errorf("packer failed to pack: %v", err)
 		}
 	}
 
+	if s.b.RunSection("files") {
+		if err := b.copyStageFiles(s); err != nil {
+			return fmt.Errorf("unable to copy files from previous stages: %v", err)
+		}
+	}
+
 	// create apps in bundle
 	a := apps.New()
-	for k, v := range b.b.Recipe.CustomData {
+	for k, v := range s.b.Recipe.CustomData {
 		a.HandleSection(k, v)
 	}
 
-	a.HandleBundle(b.b)
-	b.b.Recipe.BuildData.Post += a.HandlePost()
+	a.HandleBundle(s.b)
+	s.b.Recipe.BuildData.Post += a.HandlePost()
 
-	if engineRequired(b.b.Recipe) {
-		if err := b.runBuildEngine(); err != nil {
+	if engineRequired(s.b.Recipe) {
+		if err := s.runBuildEngine(); err != nil {
 			return fmt.Errorf("while running engine: %v", err)
 		}
 	}
 
-	sylog.Debugf("Inserting Metadata")
-	if err := b.insertMetadata(); err != nil {
-		return fmt.Errorf("While inserting metadata to bundle: %v", err)
+	if !final {
+		return nil
 	}
 
-	sylog.Debugf("Calling assembler")
-	if err := b.Assemble(b.dest); err != nil {
-		return err
+	sylog.Debugf("Inserting Metadata")
+	if err := s.insertMetadata(); err != nil {
+		return fmt.Errorf("While inserting metadata to bundle: %v", err)
 	}
 
-	sylog.Infof("Build complete: %s", b.dest)
 	return nil
 }
 
-// engineRequired returns true if build definition is requesting to run scripts or copy files
-func engineRequired(def types.Definition) bool {
-	return def.BuildData.Post != "" || def.BuildData.Setup != "" || def.BuildData.Test != "" || len(def.BuildData.Files) != 0
-}
-
-func (b *Build) copyFiles() error {
-
-	// iterate through files transfers
-	for _, transfer := range b.b.Recipe.BuildData.Files {
+// copyStageFiles copies files listed in "%files from <stage>" sections
+// out of the rootfs of previously built stages into the stage rootfs
+func (b *Build) copyStageFiles(s *stage) error {
+	for _, transfer := range s.b.Recipe.BuildData.Files {
+		if transfer.Stage == "" {
+			continue
+		}
 		// sanity
 		if transfer.Src == "" {
 			sylog.Warningf("Attempt to copy file with no name...")
 			continue
 		}
+
+		var from *stage
+		for _, prev := range b.stages {
+			if prev == s {
+				break
+			}
+			if prev.name == transfer.Stage {
+				from = prev
+			}
+		}
+		if from == nil {
+			return fmt.Errorf("no previous stage named %s", transfer.Stage)
+		}
+
 		// dest = source if not specified
 		if transfer.Dst == "" {
 			transfer.Dst = transfer.Src
 		}
-		sylog.Infof("Copying %v to %v", transfer.Src, transfer.Dst)
-		// copy each file into bundle rootfs
-		transfer.Dst = filepath.Join(b.b.Rootfs(), transfer.Dst)
-		copy := exec.Command("/bin/cp", "-fLr", transfer.Src, transfer.Dst)
-		if err := copy.Run(); err != nil {
-			return fmt.Errorf("While copying %v to %v: %v", transfer.Src, transfer.Dst, err)
+		sylog.Infof("Copying %v from stage %v to %v", transfer.Src, transfer.Stage, transfer.Dst)
+		// paths are resolved from the root of each stage rootfs
+		src := filepath.Join(from.b.Rootfs(), filepath.Join("/", transfer.Src))
+		dst := filepath.Join(s.b.Rootfs(), filepath.Join("/", transfer.Dst))
+		copy := exec.Command("/bin/cp", "-fa", src, dst)
+		if out, err := copy.CombinedOutput(); err != nil {
+			return fmt.Errorf("While copying %v to %v: %v: %s", src, dst, err, out)
 		}
 	}
 
 	return nil
 }
 
-func (b *Build) insertMetadata() (err error) {
+// engineRequired returns true if build definition is requesting to run scripts or copy files
+// from the host, files copied from previous stages don't need the engine
+func engineRequired(def types.Definition) bool {
+	if def.BuildData.Post != "" || def.BuildData.Setup != "" || def.BuildData.Test != "" {
+		return true
+	}
+	for _, f := range def.BuildData.Files {
+		if f.Stage == "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *stage) insertMetadata() (err error) {
 	// insert help
-	err = insertHelpScript(b.b)
+	err = insertHelpScript(s.b)
 	if err != nil {
 		return fmt.Errorf("While inserting help script: %v", err)
 	}
 
 	// insert labels
-	err = insertLabelsJSON(b.b)
+	err = insertLabelsJSON(s.b)
 	if err != nil {
 		return fmt.Errorf("While inserting labels JSON: %v", err)
 	}
 
 	// insert definition
-	err = insertDefinition(b.b)
+	err = insertDefinition(s.b)
 	if err != nil {
 		return fmt.Errorf("While inserting definition: %v", err)
 	}
 
 	// insert environment
-	err = insertEnvScript(b.b)
+	err = insertEnvScript(s.b)
 	if err != nil {
 		return fmt.Errorf("While inserting environment script: %v", err)
 	}
 
 	// insert startscript
-	err = insertStartScript(b.b)
+	err = insertStartScript(s.b)
 	if err != nil {
 		return fmt.Errorf("While inserting startscript: %v", err)
 	}
 
 	// insert runscript
-	err = insertRunScript(b.b)
+	err = insertRunScript(s.b)
 	if err != nil {
 		return fmt.Errorf("While inserting runscript: %v", err)
 	}
 
 	// insert test script
-	err = insertTestScript(b.b)
+	err = insertTestScript(s.b)
 	if err != nil {
 		return fmt.Errorf("While inserting test script: %v", err)
 	}
@@ -275,14 +385,14 @@ func (b *Build) insertMetadata() (err error) {
 	return
 }
 
-func (b *Build) runPreScript() error {
-	if b.runPre() && b.b.Recipe.BuildData.Pre != "" {
+func (s *stage) runPreScript() error {
+	if s.runPre() && s.b.Recipe.BuildData.Pre != "" {
 		if syscall.Getuid() != 0 {
 			return fmt.Errorf("Attempted to build with scripts as non-root user")
 		}
 
 		// Run %pre script here
-		pre := exec.Command("/bin/sh", "-cex", b.b.Recipe.BuildData.Pre)
+		pre := exec.Command("/bin/sh", "-cex", s.b.Recipe.BuildData.Pre)
 		pre.Stdout = os.Stdout
 		pre.Stderr = os.Stderr
 
@@ -298,7 +408,7 @@ func (b *Build) runPreScript() error {
 }
 
 // runBuildEngine creates an imgbuild engine and creates a container out of our bundle in order to execute %post %setup scripts in the bundle
-func (b *Build) runBuildEngine() error {
+func (s *stage) runBuildEngine() error {
 	if syscall.Getuid() != 0 {
 		return fmt.Errorf("Attempted to build with scripts as non-root user")
 	}
@@ -310,12 +420,12 @@ func (b *Build) runBuildEngine() error {
 	ociConfig := &oci.Config{}
 
 	engineConfig := &imgbuildConfig.EngineConfig{
-		Bundle:    *b.b,
+		Bundle:    *s.b,
 		OciConfig: ociConfig,
 	}
 
 	// surface build specific environment variables for scripts
-	sRootfs := "SINGULARITY_ROOTFS=" + b.b.Rootfs()
+	sRootfs := "SINGULARITY_ROOTFS=" + s.b.Rootfs()
 	sEnvironment := "SINGULARITY_ENVIRONMENT=" + "/.singularity.d/env/91-environment.sh"
 
 	ociConfig.Process = &specs.Process{}
@@ -375,22 +485,25 @@ func getcp(def types.Definition, libraryURL, authToken string) (ConveyorPacker,
 	}
 }
 
-// makeDef gets a definition object from a spec
-func makeDef(spec string, remote bool) (types.Definition, error) {
+// makeDef gets the definition objects of each build stage from a spec, args
+// holds the build arguments substituted in a definition file
+func makeDef(spec string, remote bool, args map[string]string) ([]types.Definition, error) {
 	if ok, err := uri.IsValid(spec); ok && err == nil {
 		// URI passed as spec
-		return types.NewDefinitionFromURI(spec)
+		d, err := types.NewDefinitionFromURI(spec)
+		return []types.Definition{d}, err
 	}
 
 	// Check if spec is an image/sandbox
 	if _, err := image.Init(spec, false); err == nil {
-		return types.NewDefinitionFromURI("localimage" + "://" + spec)
+		d, err := types.NewDefinitionFromURI("localimage" + "://" + spec)
+		return []types.Definition{d}, err
 	}
 
 	// default to reading file as definition
 	defFile, err := os.Open(spec)
 	if err != nil {
-		return types.Definition{}, fmt.Errorf("unable to open file %s: %v", spec, err)
+		return nil, fmt.Errorf("unable to open file %s: %v", spec, err)
 	}
 	defer defFile.Close()
 
@@ -399,17 +512,17 @@ func makeDef(spec string, remote bool) (types.Definition, error) {
 		sylog.Fatalf("You must be the root user to build from a Singularity recipe file")
 	}
 
-	d, err := parser.ParseDefinitionFile(defFile)
+	d, err := parser.ParseDefinitionStagesPath(spec, defFile, args)
 	if err != nil {
-		return types.Definition{}, fmt.Errorf("While parsing definition: %s: %v", spec, err)
+		return nil, fmt.Errorf("While parsing definition: %s: %v", spec, err)
 	}
 
 	return d, nil
 }
 
 // runPre determines if %pre section was specified to be run from the CLI
-func (b Build) runPre() bool {
-	for _, section := range b.b.Opts.Sections {
+func (s stage) runPre() bool {
+	for _, section := range s.b.Opts.Sections {
 		if section == "none" {
 			return false
 		}
@@ -420,14 +533,14 @@ func (b Build) runPre() bool {
 	return false
 }
 
-// MakeDef gets a definition object from a spec
-func MakeDef(spec string, remote bool) (types.Definition, error) {
-	return makeDef(spec, remote)
+// MakeDef gets the definition objects of each build stage from a spec
+func MakeDef(spec string, remote bool) ([]types.Definition, error) {
+	return makeDef(spec, remote, nil)
 }
 
 // Assemble assembles the bundle to the specified path
 func (b *Build) Assemble(path string) error {
-	return b.a.Assemble(b.b, path)
+	return b.a.Assemble(b.final().b, path)
 }
 
 func insertEnvScript(b *types.Bundle) error {
@@ -614,7 +727,7 @@ func addBuildLabels(labels map[string]string, b *types.Bundle) error {
 	labels["org.label-schema.schema-version"] = "1.0"
 
 	// build date and time, lots of time formatting
-	currentTime := time.Now()
+	currentTime := b.Opts.BuildTime()
 	year, month, day := currentTime.Date()
 	date := strconv.Itoa(day) + `_` + month.String() + `_` + strconv.Itoa(year)
 	hour, min, sec := currentTime.Clock()
@@ -1,4 +1,4 @@
-// Copyright (c) 2018, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2019, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -7,6 +7,8 @@ package remotebuilder
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -103,6 +105,20 @@ func (m *mockService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		} else {
 			jsonresp.WriteError(w, "", m.statusResponseCode)
 		}
+	} else if r.Method == http.MethodGet && strings.HasPrefix(r.RequestURI, imagePath+"s/") {
+		// Mock get image metadata endpoint, checked before the download
+		if m.imageResponseCode == http.StatusOK {
+			sum := sha256.Sum256([]byte(imageContents))
+			image := map[string]interface{}{
+				"hash": "sha256." + hex.EncodeToString(sum[:]),
+				"size": len(imageContents),
+			}
+			if err := jsonresp.WriteResponse(w, image, http.StatusOK); err != nil {
+				m.t.Fatalf("failed to write image metadata")
+			}
+		} else {
+			jsonresp.WriteError(w, "", m.imageResponseCode)
+		}
 	} else if r.Method == http.MethodGet && strings.HasPrefix(r.RequestURI, imagePath) {
 		// Mock get image endpoint
 		if m.imageResponseCode == http.StatusOK {
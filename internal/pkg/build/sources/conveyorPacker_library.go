@@ -57,12 +57,6 @@ func (cp *LibraryConveyorPacker) Get(b *types.Bundle) (err error) {
 		if err = client.DownloadImage(imagePath, libURI, cp.LibraryURL, true, cp.AuthToken); err != nil {
 			return fmt.Errorf("unable to Download Image: %v", err)
 		}
-
-		if cacheFileHash, err := client.ImageHash(imagePath); err != nil {
-			return fmt.Errorf("Error getting ImageHash: %v", err)
-		} else if cacheFileHash != libraryImage.Hash {
-			return fmt.Errorf("Cached File Hash(%s) and Expected Hash(%s) does not match", cacheFileHash, libraryImage.Hash)
-		}
 	}
 
 	cp.LocalPacker, err = GetLocalPacker(imagePath, cp.b)
@@ -8,13 +8,16 @@ package sources
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"syscall"
 
 	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/internal/pkg/image"
 	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/internal/pkg/util/fs/squashfs"
 	"github.com/sylabs/singularity/pkg/build/types"
 	"github.com/sylabs/singularity/pkg/util/loop"
 )
@@ -49,30 +52,53 @@ func (p *SIFPacker) unpackSIF(b *types.Bundle, rootfs string) (err error) {
 		return err
 	}
 
-	// record the fs type
-	mountType := ""
 	fstype, err := part.GetFsType()
 	if err != nil {
 		return err
 	}
-	if fstype == sif.FsSquash {
-		mountType = "squashfs"
-	} else if fstype == sif.FsExt3 {
-		mountType = "ext3"
-	} else {
-		return fmt.Errorf("unknown file system type: %v", fstype)
-	}
-
-	info := &loop.Info64{
-		Offset:    uint64(part.Fileoff),
-		SizeLimit: uint64(part.Filelen),
-		Flags:     loop.FlagsAutoClear,
-	}
 
-	//copy partition contents to bundle rootfs
-	err = unpackImagePartion(fimg.Fp.Name(), b.Rootfs(), mountType, info)
-	if err != nil {
-		return fmt.Errorf("While copying partition data to bundle: %v", err)
+	switch fstype {
+	case sif.FsSquash:
+		r, err := squashfs.NewReader(io.NewSectionReader(fimg.Fp, part.Fileoff, part.Filelen))
+		if err != nil {
+			return fmt.Errorf("While reading squashfs partition: %v", err)
+		}
+
+		// copy partition contents to bundle rootfs
+		sylog.Debugf("Extracting squashfs partition to %s\n", b.Rootfs())
+		if err := r.Extract("/", b.Rootfs()); err != nil {
+			return fmt.Errorf("While copying partition data to bundle: %v", err)
+		}
+
+		// apply the layers stacked over the primary partition
+		layers, err := image.SIFLayers(&fimg)
+		if err != nil {
+			return fmt.Errorf("While reading image layers: %v", err)
+		}
+		for _, l := range layers {
+			r, err := squashfs.NewReader(io.NewSectionReader(fimg.Fp, l.Fileoff, l.Filelen))
+			if err != nil {
+				return fmt.Errorf("While reading layer partition %d: %v", l.ID, err)
+			}
+			sylog.Debugf("Extracting layer partition %d to %s\n", l.ID, b.Rootfs())
+			if err := r.ExtractLayer("/", b.Rootfs()); err != nil {
+				return fmt.Errorf("While copying layer data to bundle: %v", err)
+			}
+		}
+	case sif.FsExt3:
+		info := &loop.Info64{
+			Offset:    uint64(part.Fileoff),
+			SizeLimit: uint64(part.Filelen),
+			Flags:     loop.FlagsAutoClear,
+		}
+
+		// copy partition contents to bundle rootfs
+		err = unpackImagePartion(fimg.Fp.Name(), b.Rootfs(), "ext3", info)
+		if err != nil {
+			return fmt.Errorf("While copying partition data to bundle: %v", err)
+		}
+	default:
+		return fmt.Errorf("unknown file system type: %v", fstype)
 	}
 
 	return nil
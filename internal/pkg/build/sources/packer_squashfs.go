@@ -6,13 +6,12 @@
 package sources
 
 import (
-	"bytes"
 	"fmt"
-	"io/ioutil"
-	"os/exec"
-	"strconv"
+	"io"
+	"os"
 
 	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/internal/pkg/util/fs/squashfs"
 	"github.com/sylabs/singularity/pkg/build/types"
 	"github.com/sylabs/singularity/pkg/util/loop"
 )
@@ -37,31 +36,24 @@ func (p *SquashfsPacker) Pack() (*types.Bundle, error) {
 	return p.b, nil
 }
 
-// unpackSquashfs removes the image header with dd and then unpackes image into bundle directories with unsquashfs
+// unpackSquashfs extracts the squashfs filesystem found at the image offset into the bundle rootfs
 func (p *SquashfsPacker) unpackSquashfs(b *types.Bundle, info *loop.Info64, rootfs string) (err error) {
-	var stderr bytes.Buffer
-
-	trimfile, err := ioutil.TempFile(p.b.Path, "trim.squashfs")
+	f, err := os.Open(rootfs)
 	if err != nil {
-		return fmt.Errorf("While making tmp file: %v", err)
+		return fmt.Errorf("While opening %s: %v", rootfs, err)
 	}
+	defer f.Close()
 
-	// trim header
-	sylog.Debugf("Creating copy of %s without header at %s\n", rootfs, trimfile.Name())
-	cmd := exec.Command("dd", "bs="+strconv.Itoa(int(info.Offset)), "skip=1", "if="+rootfs, "of="+trimfile.Name())
-	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("Trimming header Failed: %v: %v", err, stderr.String())
+	r, err := squashfs.NewReader(io.NewSectionReader(f, int64(info.Offset), int64(info.SizeLimit)))
+	if err != nil {
+		return fmt.Errorf("While reading squashfs image %s: %v", rootfs, err)
 	}
 
 	// copy filesystem into bundle rootfs
-	sylog.Debugf("Unsquashing %s to %s in Bundle\n", trimfile.Name(), b.Rootfs())
-	stderr.Reset()
-	cmd = exec.Command("unsquashfs", "-f", "-d", b.Rootfs(), trimfile.Name())
-	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("unsquashfs Failed: %v: %v", err, stderr.String())
+	sylog.Debugf("Extracting %s to %s in Bundle\n", rootfs, b.Rootfs())
+	if err := r.Extract("/", b.Rootfs()); err != nil {
+		return fmt.Errorf("While extracting %s: %v", rootfs, err)
 	}
 
-	return err
+	return nil
 }
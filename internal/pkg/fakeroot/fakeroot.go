@@ -0,0 +1,133 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package fakeroot
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/internal/pkg/util/user"
+)
+
+const (
+	// SubUIDFile is the file listing the subordinate UID ranges of users
+	SubUIDFile = "/etc/subuid"
+	// SubGIDFile is the file listing the subordinate GID ranges of users
+	SubGIDFile = "/etc/subgid"
+)
+
+// RangeSize is the maximum number of subordinate IDs mapped in a fake
+// root user namespace, in addition to root.
+const RangeSize = 65536
+
+// GetIDRange returns the subordinate ID range allocated to the user u in
+// the file at path, using the subuid(5) format where each line holds the
+// user name or UID, the first subordinate ID and the number of IDs. The
+// returned mapping starts at ID 1 in the user namespace and holds at most
+// RangeSize IDs.
+func GetIDRange(path string, u *user.User) (*specs.LinuxIDMapping, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %s", path, err)
+	}
+	defer f.Close()
+
+	uid := strconv.FormatUint(uint64(u.UID), 10)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) != 3 || (fields[0] != u.Name && fields[0] != uid) {
+			continue
+		}
+		start, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subordinate ID range %q in %s", line, path)
+		}
+		count, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subordinate ID range %q in %s", line, path)
+		}
+		if count == 0 {
+			continue
+		}
+		if count > RangeSize {
+			count = RangeSize
+		}
+		return &specs.LinuxIDMapping{
+			ContainerID: 1,
+			HostID:      uint32(start),
+			Size:        uint32(count),
+		}, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %s", path, err)
+	}
+	return nil, fmt.Errorf("no subordinate ID range found for user %s in %s", u.Name, path)
+}
+
+// IDMappings returns the UID and GID mappings of a fake root user namespace
+// for the user u: the user and its group are mapped to root and the
+// subordinate ranges allocated to the user are mapped from ID 1.
+func IDMappings(u *user.User) (uids []specs.LinuxIDMapping, gids []specs.LinuxIDMapping, err error) {
+	uidRange, err := GetIDRange(SubUIDFile, u)
+	if err != nil {
+		return nil, nil, err
+	}
+	gidRange, err := GetIDRange(SubGIDFile, u)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	uids = []specs.LinuxIDMapping{{ContainerID: 0, HostID: u.UID, Size: 1}, *uidRange}
+	gids = []specs.LinuxIDMapping{{ContainerID: 0, HostID: u.GID, Size: 1}, *gidRange}
+	return uids, gids, nil
+}
+
+// CheckUser returns an error if the user u is not allowed to use fakeroot
+// by the allow and deny lists, each entry is a user name or a UID. Denied
+// users are always refused, and when the allow list is not empty only the
+// users it holds are allowed.
+func CheckUser(u *user.User, allow []string, deny []string) error {
+	uid := strconv.FormatUint(uint64(u.UID), 10)
+	match := func(list []string) bool {
+		for _, entry := range list {
+			if entry == u.Name || entry == uid {
+				return true
+			}
+		}
+		return false
+	}
+
+	if match(deny) || (len(allow) > 0 && !match(allow)) {
+		return fmt.Errorf("user %s is not allowed to use fakeroot", u.Name)
+	}
+	return nil
+}
+
+// CheckUID is like CheckUser for the user with the UID uid, it does nothing
+// if both lists are empty. The UID must be resolved in the user namespace it
+// comes from, if it can't be only the UID entries of the lists can match.
+func CheckUID(uid uint32, allow []string, deny []string) error {
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil
+	}
+	u, err := user.GetPwUID(uid)
+	if err != nil {
+		sylog.Debugf("Could not resolve UID %d, only checking fakeroot users by UID: %s", uid, err)
+		u = &user.User{Name: strconv.FormatUint(uint64(uid), 10), UID: uid}
+	}
+	return CheckUser(u, allow, deny)
+}
@@ -0,0 +1,343 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package fakeroot
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/internal/pkg/util/user"
+)
+
+// syncEnv is set in the environment of a process started by Run, it holds
+// the file descriptor closed once the user namespace mappings are written.
+const syncEnv = "SINGULARITY_FAKEROOT_SYNC"
+
+// syncFd is the file descriptor of the synchronization pipe in the child.
+const syncFd = 3
+
+// mappingArgs returns the newuidmap/newgidmap arguments for the mappings m.
+func mappingArgs(m []specs.LinuxIDMapping) []string {
+	var args []string
+	for _, id := range m {
+		args = append(args,
+			strconv.FormatUint(uint64(id.ContainerID), 10),
+			strconv.FormatUint(uint64(id.HostID), 10),
+			strconv.FormatUint(uint64(id.Size), 10),
+		)
+	}
+	return args
+}
+
+// writeMapping writes the ID mappings m of process pid to the proc file
+// name, uid_map or gid_map. As root the file is written directly, otherwise
+// the setuid helper tool is used, it checks the mappings against the
+// subordinate ranges allocated to the user.
+func writeMapping(pid int, name, tool string, m []specs.LinuxIDMapping) error {
+	if os.Geteuid() == 0 {
+		var b bytes.Buffer
+		for _, id := range m {
+			fmt.Fprintf(&b, "%d %d %d\n", id.ContainerID, id.HostID, id.Size)
+		}
+		path := fmt.Sprintf("/proc/%d/%s", pid, name)
+		if err := ioutil.WriteFile(path, b.Bytes(), 0); err != nil {
+			return fmt.Errorf("failed to write %s: %s", path, err)
+		}
+		return nil
+	}
+
+	path, err := exec.LookPath(tool)
+	if err != nil {
+		return fmt.Errorf("%s not found, the uidmap package must be installed", tool)
+	}
+	args := append([]string{strconv.Itoa(pid)}, mappingArgs(m)...)
+	out, err := exec.Command(path, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s failed: %s: %s", tool, err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// Run executes the current program with args in a new user namespace where
+// the calling user is root and its subordinate UIDs and GIDs are mapped, so
+// files can be owned by any user of the namespace. The program must call
+// Init first, before relying on its identity. Run returns the exit status
+// of the program.
+func Run(args []string) (int, error) {
+	u, err := user.GetPwUID(uint32(os.Getuid()))
+	if err != nil {
+		return 0, fmt.Errorf("failed to retrieve user information: %s", err)
+	}
+	uids, gids, err := IDMappings(u)
+	if err != nil {
+		return 0, err
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, fmt.Errorf("failed to create synchronization pipe: %s", err)
+	}
+	defer w.Close()
+
+	cmd := exec.Command("/proc/self/exe", args...)
+	cmd.Args[0] = os.Args[0]
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{r}
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%d", syncEnv, syncFd))
+	cmd.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWUSER}
+
+	sylog.Debugf("Starting %s in a fake root user namespace", os.Args[0])
+	err = cmd.Start()
+	r.Close()
+	if err != nil {
+		return 0, fmt.Errorf("failed to start process in user namespace: %s", err)
+	}
+
+	// interrupted processes clean up after themselves
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		for s := range signals {
+			cmd.Process.Signal(s)
+		}
+	}()
+
+	pid := cmd.Process.Pid
+	err = writeMapping(pid, "gid_map", "newgidmap", gids)
+	if err == nil {
+		err = writeMapping(pid, "uid_map", "newuidmap", uids)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return 0, err
+	}
+	if _, err := w.Write([]byte{0}); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return 0, fmt.Errorf("failed to synchronize with process: %s", err)
+	}
+	w.Close()
+
+	err = cmd.Wait()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.Sys().(syscall.WaitStatus).ExitStatus(), nil
+	}
+	return 0, err
+}
+
+// Init waits for the user namespace mappings to be written when the
+// program was started by Run, it does nothing otherwise. The program was
+// executed before being mapped to root and lost its capabilities in the
+// user namespace, so once mapped it's executed again with the same
+// arguments and Init doesn't return.
+func Init() error {
+	fd := os.Getenv(syncEnv)
+	if fd == "" {
+		return nil
+	}
+	os.Unsetenv(syncEnv)
+
+	n, err := strconv.Atoi(fd)
+	if err != nil {
+		return fmt.Errorf("invalid synchronization file descriptor %q", fd)
+	}
+	f := os.NewFile(uintptr(n), "sync")
+	b := make([]byte, 1)
+	_, err = f.Read(b)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("failed to set up user namespace mappings")
+	}
+	if os.Getuid() != 0 {
+		return fmt.Errorf("not mapped to root in the user namespace")
+	}
+
+	if err := syscall.Exec("/proc/self/exe", os.Args, os.Environ()); err != nil {
+		return fmt.Errorf("failed to execute %s in user namespace: %s", os.Args[0], err)
+	}
+	return nil
+}
+
+// parseMapping parses the ID mappings of the current process from the proc
+// file name, uid_map or gid_map, host IDs are the IDs of the parent user
+// namespace.
+func parseMapping(name string) ([]specs.LinuxIDMapping, error) {
+	path := "/proc/self/" + name
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %s", path, err)
+	}
+
+	var m []specs.LinuxIDMapping
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid mapping %q in %s", line, path)
+		}
+		var ids [3]uint64
+		for i, f := range fields {
+			if ids[i], err = strconv.ParseUint(f, 10, 32); err != nil {
+				return nil, fmt.Errorf("invalid mapping %q in %s", line, path)
+			}
+		}
+		m = append(m, specs.LinuxIDMapping{
+			ContainerID: uint32(ids[0]),
+			HostID:      uint32(ids[1]),
+			Size:        uint32(ids[2]),
+		})
+	}
+	return m, nil
+}
+
+// readMapping is like parseMapping but mapped IDs keep their value so a
+// user namespace created with the returned mappings maps the same IDs.
+func readMapping(name string) ([]specs.LinuxIDMapping, error) {
+	m, err := parseMapping(name)
+	if err != nil {
+		return nil, err
+	}
+	for i := range m {
+		m[i].HostID = m[i].ContainerID
+	}
+	return m, nil
+}
+
+// runOwner returns the UID of the parent user namespace mapped to root by
+// the UID mappings m if they have the layout set up by Run, where root is
+// mapped alone to the calling user, and false otherwise. User namespaces
+// mapping root along with other IDs, like the ones of system containers,
+// weren't set up by Run.
+func runOwner(m []specs.LinuxIDMapping) (uint32, bool) {
+	for _, r := range m {
+		if r.ContainerID == 0 {
+			return r.HostID, r.Size == 1 && r.HostID != 0
+		}
+	}
+	return 0, false
+}
+
+// NamespaceOwner returns the UID of the user who set up the current fake
+// root user namespace with Run, as known before entering the namespace, and
+// false if the current process doesn't run in such a namespace.
+func NamespaceOwner() (uint32, bool, error) {
+	if !InUserNamespace() {
+		return 0, false, nil
+	}
+	m, err := parseMapping("uid_map")
+	if err != nil {
+		return 0, false, err
+	}
+	uid, ok := runOwner(m)
+	return uid, ok, nil
+}
+
+// NamespaceMappings returns the UID and GID mappings of a user namespace
+// nested in the fake root user namespace set up by Run, all the IDs mapped
+// in the current namespace are mapped to themselves. Root is mapped first.
+func NamespaceMappings() (uids []specs.LinuxIDMapping, gids []specs.LinuxIDMapping, err error) {
+	if uids, err = readMapping("uid_map"); err != nil {
+		return nil, nil, err
+	}
+	if gids, err = readMapping("gid_map"); err != nil {
+		return nil, nil, err
+	}
+	return uids, gids, nil
+}
+
+// InUserNamespace returns true if the current process runs in a user
+// namespace other than the initial one, where all IDs are mapped.
+func InUserNamespace() bool {
+	b, err := ioutil.ReadFile("/proc/self/uid_map")
+	if err != nil {
+		return false
+	}
+	return strings.Join(strings.Fields(string(b)), " ") != "0 0 4294967295"
+}
+
+// overflowID is the owner given to files owned by IDs not mapped in the
+// user namespace, the nobody user and group on most distributions.
+const overflowID = 65534
+
+// idMapper replaces the IDs not mapped in the current user namespace.
+type idMapper struct {
+	once   sync.Once
+	userns bool
+	err    error
+	uids   []specs.LinuxIDMapping
+	gids   []specs.LinuxIDMapping
+	mu     sync.Mutex
+	warned map[string]bool
+}
+
+var mapper idMapper
+
+// mapped returns true if id is mapped by m.
+func mapped(m []specs.LinuxIDMapping, id int) bool {
+	for _, r := range m {
+		if id >= int(r.ContainerID) && id < int(r.ContainerID)+int(r.Size) {
+			return true
+		}
+	}
+	return false
+}
+
+// mapID returns id if it's mapped by m, or the overflow ID, or root if the
+// overflow ID isn't mapped either. A warning is displayed once per ID.
+func (im *idMapper) mapID(m []specs.LinuxIDMapping, kind string, id int) int {
+	if mapped(m, id) {
+		return id
+	}
+	newID := overflowID
+	if !mapped(m, newID) {
+		newID = 0
+	}
+
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	key := fmt.Sprintf("%s%d", kind, id)
+	if !im.warned[key] {
+		if im.warned == nil {
+			im.warned = make(map[string]bool)
+		}
+		im.warned[key] = true
+		sylog.Warningf("%s %d is not mapped in the user namespace, files owned by it are owned by %s %d instead", kind, id, kind, newID)
+	}
+	return newID
+}
+
+// Lchown changes the ownership of path like os.Lchown. In a user namespace
+// only the mapped IDs can own files, the IDs outside of the mapped ranges,
+// e.g. above the 65536 subordinate IDs of a fake root user namespace, are
+// replaced by the overflow ID 65534, or by root if it isn't mapped either.
+func Lchown(path string, uid, gid int) error {
+	mapper.once.Do(func() {
+		if mapper.userns = InUserNamespace(); mapper.userns {
+			mapper.uids, mapper.gids, mapper.err = NamespaceMappings()
+		}
+	})
+	if !mapper.userns {
+		return os.Lchown(path, uid, gid)
+	} else if mapper.err != nil {
+		return mapper.err
+	}
+	uid = mapper.mapID(mapper.uids, "UID", uid)
+	gid = mapper.mapID(mapper.gids, "GID", gid)
+	return os.Lchown(path, uid, gid)
+}
@@ -0,0 +1,254 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package fakeroot
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sylabs/singularity/internal/pkg/util/user"
+)
+
+func TestGetIDRange(t *testing.T) {
+	u := &user.User{Name: "alice", UID: 1000, GID: 1000}
+
+	tests := []struct {
+		name    string
+		content string
+		mapping *specs.LinuxIDMapping
+	}{
+		{
+			name:    "by name",
+			content: "bob:100000:65536\nalice:165536:65536\n",
+			mapping: &specs.LinuxIDMapping{ContainerID: 1, HostID: 165536, Size: 65536},
+		},
+		{
+			name:    "by UID",
+			content: "# comment\n\n1000:200000:65536\n",
+			mapping: &specs.LinuxIDMapping{ContainerID: 1, HostID: 200000, Size: 65536},
+		},
+		{
+			name:    "large range",
+			content: "alice:100000:1000000\n",
+			mapping: &specs.LinuxIDMapping{ContainerID: 1, HostID: 100000, Size: RangeSize},
+		},
+		{
+			name:    "small range",
+			content: "alice:100000:1000\n",
+			mapping: &specs.LinuxIDMapping{ContainerID: 1, HostID: 100000, Size: 1000},
+		},
+		{
+			name:    "empty range skipped",
+			content: "alice:100000:0\nalice:300000:65536\n",
+			mapping: &specs.LinuxIDMapping{ContainerID: 1, HostID: 300000, Size: 65536},
+		},
+		{
+			name:    "no range",
+			content: "bob:100000:65536\nalicia:165536:65536\n",
+		},
+		{
+			name:    "invalid range",
+			content: "alice:foo:65536\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ioutil.TempFile("", "subid-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(f.Name())
+			if _, err := f.WriteString(tt.content); err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+
+			m, err := GetIDRange(f.Name(), u)
+			if tt.mapping == nil {
+				if err == nil {
+					t.Errorf("unexpected success")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if *m != *tt.mapping {
+				t.Errorf("unexpected mapping %+v, expected %+v", *m, *tt.mapping)
+			}
+		})
+	}
+
+	if _, err := GetIDRange("/non/existent/subuid", u); err == nil {
+		t.Errorf("unexpected success with a missing file")
+	}
+}
+
+func TestCheckUser(t *testing.T) {
+	u := &user.User{Name: "alice", UID: 1000, GID: 1000}
+
+	tests := []struct {
+		name       string
+		allow      []string
+		deny       []string
+		shouldPass bool
+	}{
+		{"no lists", nil, nil, true},
+		{"allowed by name", []string{"bob", "alice"}, nil, true},
+		{"allowed by UID", []string{"1000"}, nil, true},
+		{"not allowed", []string{"bob"}, nil, false},
+		{"denied by name", nil, []string{"alice"}, false},
+		{"denied by UID", nil, []string{"1000"}, false},
+		{"allowed and denied", []string{"alice"}, []string{"alice"}, false},
+		{"other user denied", nil, []string{"bob"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckUser(u, tt.allow, tt.deny)
+			if tt.shouldPass && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			} else if !tt.shouldPass && err == nil {
+				t.Errorf("unexpected success")
+			}
+		})
+	}
+}
+
+func TestMappingArgs(t *testing.T) {
+	m := []specs.LinuxIDMapping{
+		{ContainerID: 0, HostID: 1000, Size: 1},
+		{ContainerID: 1, HostID: 100000, Size: 65536},
+	}
+	expected := "0 1000 1 1 100000 65536"
+	if s := strings.Join(mappingArgs(m), " "); s != expected {
+		t.Errorf("unexpected arguments %q, expected %q", s, expected)
+	}
+}
+
+func TestMapID(t *testing.T) {
+	m := []specs.LinuxIDMapping{
+		{ContainerID: 0, HostID: 0, Size: 1},
+		{ContainerID: 1, HostID: 1, Size: RangeSize},
+	}
+	small := []specs.LinuxIDMapping{
+		{ContainerID: 0, HostID: 0, Size: 1},
+		{ContainerID: 1, HostID: 1, Size: 1000},
+	}
+
+	tests := []struct {
+		name     string
+		mappings []specs.LinuxIDMapping
+		id       int
+		expected int
+	}{
+		{"root", m, 0, 0},
+		{"mapped", m, 1000, 1000},
+		{"last mapped", m, RangeSize, RangeSize},
+		{"above range", m, RangeSize + 1, overflowID},
+		{"large", m, 1000000, overflowID},
+		{"overflow not mapped", small, 5000, 0},
+	}
+
+	var im idMapper
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if id := im.mapID(tt.mappings, "UID", tt.id); id != tt.expected {
+				t.Errorf("unexpected ID %d for %d, expected %d", id, tt.id, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRunOwner(t *testing.T) {
+	tests := []struct {
+		name     string
+		mappings []specs.LinuxIDMapping
+		uid      uint32
+		ok       bool
+	}{
+		{
+			name: "fake root",
+			mappings: []specs.LinuxIDMapping{
+				{ContainerID: 0, HostID: 1000, Size: 1},
+				{ContainerID: 1, HostID: 100000, Size: RangeSize},
+			},
+			uid: 1000,
+			ok:  true,
+		},
+		{
+			name:     "fake root without subordinate range",
+			mappings: []specs.LinuxIDMapping{{ContainerID: 0, HostID: 1000, Size: 1}},
+			uid:      1000,
+			ok:       true,
+		},
+		{
+			name:     "system container",
+			mappings: []specs.LinuxIDMapping{{ContainerID: 0, HostID: 100000, Size: RangeSize}},
+			uid:      100000,
+		},
+		{
+			name:     "initial namespace",
+			mappings: []specs.LinuxIDMapping{{ContainerID: 0, HostID: 0, Size: 4294967295}},
+		},
+		{
+			name:     "root mapped to itself",
+			mappings: []specs.LinuxIDMapping{{ContainerID: 0, HostID: 0, Size: 1}},
+		},
+		{
+			name:     "root not mapped",
+			mappings: []specs.LinuxIDMapping{{ContainerID: 1000, HostID: 1000, Size: 1}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uid, ok := runOwner(tt.mappings)
+			if ok != tt.ok {
+				t.Errorf("unexpected result %v, expected %v", ok, tt.ok)
+			}
+			if uid != tt.uid {
+				t.Errorf("unexpected UID %d, expected %d", uid, tt.uid)
+			}
+		})
+	}
+}
+
+func TestCheckUID(t *testing.T) {
+	// UID without passwd entry, e.g. root of a system container on the host
+	const unknown = 4000000000
+
+	tests := []struct {
+		name       string
+		uid        uint32
+		allow      []string
+		deny       []string
+		shouldPass bool
+	}{
+		{"unknown user without lists", unknown, nil, nil, true},
+		{"unknown user allowed by UID", unknown, []string{"4000000000"}, nil, true},
+		{"unknown user not allowed", unknown, []string{"root"}, nil, false},
+		{"unknown user denied by UID", unknown, nil, []string{"4000000000"}, false},
+		{"unknown user other user denied", unknown, nil, []string{"root"}, true},
+		{"known user denied by name", 0, nil, []string{"root"}, false},
+		{"known user allowed by name", 0, []string{"root"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckUID(tt.uid, tt.allow, tt.deny)
+			if tt.shouldPass && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			} else if !tt.shouldPass && err == nil {
+				t.Errorf("unexpected success")
+			}
+		})
+	}
+}
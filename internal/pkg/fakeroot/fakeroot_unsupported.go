@@ -0,0 +1,13 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// +build !linux
+
+package fakeroot
+
+// Init does nothing, user namespaces are only supported on Linux.
+func Init() error {
+	return nil
+}
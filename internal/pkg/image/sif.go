@@ -1,4 +1,4 @@
-// Copyright (c) 2018, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2019, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -7,8 +7,11 @@ package image
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"os"
+
+	"github.com/sylabs/sif/pkg/sif"
 )
 
 // SIF defines constant for sif format
@@ -16,8 +19,16 @@ const SIF = 4
 
 const (
 	sifMagic = "\x53\x49\x46\x5f\x4d\x41\x47\x49\x43"
+
+	// sifCompressionLen is the size reserved for the compression algorithm
+	// name in the extra data of partition descriptors
+	sifCompressionLen = 16
 )
 
+// sifCompressionOffset is the offset of the compression algorithm name in the
+// extra data of partition descriptors, right after the partition data
+var sifCompressionOffset = binary.Size(sif.Partition{})
+
 type sifFormat struct{}
 
 func (f *sifFormat) initializer(img *Image, fileinfo os.FileInfo) error {
@@ -41,3 +52,94 @@ func (f *sifFormat) openMode(writable bool) int {
 	}
 	return os.O_RDONLY
 }
+
+// IsSIF returns whether path is an existing SIF image.
+func IsSIF(path string) bool {
+	img, err := Init(path, false)
+	if err != nil {
+		return false
+	}
+	img.File.Close()
+	return img.Type == SIF
+}
+
+// SetPartCompression records the compression algorithm of a squashfs
+// partition in the extra data of its descriptor input, it must be called
+// after SetPartExtra. The SIF format only defines the sif.Partition data at
+// the start of Descriptor.Extra, the algorithm name is stored right after it
+// by singularity as sifCompressionLen bytes padded with NUL bytes:
+//
+//	Extra[0:sifCompressionOffset]                  sif.Partition
+//	Extra[sifCompressionOffset:+sifCompressionLen] compression name
+//
+// Other tools may not preserve these bytes, so an empty or unknown name
+// read back by PartCompression only means the compression isn't known.
+func SetPartCompression(di *sif.DescriptorInput, compression string) error {
+	if di.Extra.Len() != sifCompressionOffset {
+		return fmt.Errorf("partition extra data must be set before compression")
+	}
+	if len(compression) >= sifCompressionLen {
+		return fmt.Errorf("compression name %q is too long", compression)
+	}
+	b := make([]byte, sifCompressionLen)
+	copy(b, compression)
+	_, err := di.Extra.Write(b)
+	return err
+}
+
+// PartCompression returns the compression algorithm recorded in the
+// descriptor of a squashfs partition, images built without it recorded
+// return an empty string.
+func PartCompression(d *sif.Descriptor) string {
+	b := d.Extra[sifCompressionOffset : sifCompressionOffset+sifCompressionLen]
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// SIFLayers returns the squashfs layer partitions stacked over the primary
+// partition of fimg, from the lowest to the topmost one. Each layer is a
+// system partition of the primary partition group linked to the partition
+// right below it.
+func SIFLayers(fimg *sif.FileImage) ([]*sif.Descriptor, error) {
+	prim, _, err := fimg.GetPartPrimSys()
+	if err != nil {
+		return nil, err
+	}
+	descrs, _, err := fimg.GetPartFromGroup(prim.Groupid)
+	if err != nil {
+		return nil, err
+	}
+
+	var layers []*sif.Descriptor
+	seen := map[uint32]bool{prim.ID: true}
+	below := prim.ID
+	for {
+		var next *sif.Descriptor
+		for _, d := range descrs {
+			if d.Link != below {
+				continue
+			}
+			if ptype, err := d.GetPartType(); err != nil || ptype != sif.PartSystem {
+				continue
+			}
+			if fstype, err := d.GetFsType(); err != nil || fstype != sif.FsSquash {
+				continue
+			}
+			if next != nil {
+				return nil, fmt.Errorf("partitions %d and %d are both stacked over partition %d", next.ID, d.ID, below)
+			}
+			next = d
+		}
+		if next == nil {
+			return layers, nil
+		}
+		if seen[next.ID] {
+			return nil, fmt.Errorf("partition %d is stacked over itself", next.ID)
+		}
+		seen[next.ID] = true
+		layers = append(layers, next)
+		below = next.ID
+	}
+}
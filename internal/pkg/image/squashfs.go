@@ -9,10 +9,13 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"os"
 	"unsafe"
 
+	"github.com/sylabs/sif/pkg/sif"
 	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/internal/pkg/util/fs/squashfs"
 )
 
 // SQUASHFS defines constant for squashfs format
@@ -106,3 +109,40 @@ func (f *squashfsFormat) initializer(img *Image, fileinfo os.FileInfo) error {
 func (f *squashfsFormat) openMode(writable bool) int {
 	return os.O_RDONLY
 }
+
+// SquashfsReader returns a reader giving access to the files of a SQUASHFS
+// image or of the squashfs primary system partition of a SIF image without
+// mounting it
+func (i *Image) SquashfsReader() (*squashfs.Reader, error) {
+	var offset, size int64
+
+	switch i.Type {
+	case SQUASHFS:
+		offset = int64(i.Offset)
+		size = int64(i.Size)
+	case SIF:
+		fimg, err := sif.LoadContainer(i.Path, true)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load SIF image %s: %s", i.Path, err)
+		}
+		defer fimg.UnloadContainer()
+
+		part, _, err := fimg.GetPartPrimSys()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get primary system partition: %s", err)
+		}
+		fstype, err := part.GetFsType()
+		if err != nil {
+			return nil, err
+		}
+		if fstype != sif.FsSquash {
+			return nil, fmt.Errorf("primary system partition of %s is not a squashfs filesystem", i.Path)
+		}
+		offset = part.Fileoff
+		size = part.Filelen
+	default:
+		return nil, fmt.Errorf("%s is not a squashfs or SIF image", i.Path)
+	}
+
+	return squashfs.NewReader(io.NewSectionReader(i.File, offset, size))
+}
@@ -0,0 +1,226 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package overlay
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"github.com/sylabs/singularity/internal/pkg/image"
+)
+
+// superblockOffset is the offset of the ext3 superblock
+const superblockOffset = 1024
+
+// headerSize is the size of the image header read to validate an ext3
+// image, it must hold the superblock
+const headerSize = 2048
+
+// sbinPaths lists the directories searched for e2fsprogs tools not found
+// in PATH, they usually live in sbin directories which are not in the PATH
+// of unprivileged users.
+var sbinPaths = []string{"/sbin", "/usr/sbin", "/usr/local/sbin"}
+
+// superblock holds the first fields of an ext3 superblock.
+type superblock struct {
+	InodesCount     uint32
+	BlocksCount     uint32
+	RBlocksCount    uint32
+	FreeBlocksCount uint32
+	FreeInodesCount uint32
+	FirstDataBlock  uint32
+	LogBlockSize    uint32
+}
+
+// Info describes an ext3 overlay image.
+type Info struct {
+	// BlockSize is the file system block size in bytes
+	BlockSize uint64
+	// Blocks is the number of blocks of the file system
+	Blocks uint64
+	// FreeBlocks is the number of unused blocks
+	FreeBlocks uint64
+	// Inodes is the number of inodes of the file system
+	Inodes uint64
+	// FreeInodes is the number of unused inodes
+	FreeInodes uint64
+}
+
+// Size returns the file system size in bytes.
+func (i *Info) Size() uint64 {
+	return i.Blocks * i.BlockSize
+}
+
+// Free returns the free space of the file system in bytes.
+func (i *Info) Free() uint64 {
+	return i.FreeBlocks * i.BlockSize
+}
+
+// ReadInfo reads the superblock of the ext3 file system starting at offset
+// in r, an error is returned if it's not a valid ext3 file system.
+func ReadInfo(r io.ReaderAt, offset int64) (*Info, error) {
+	b := make([]byte, headerSize)
+	if _, err := r.ReadAt(b, offset); err != nil {
+		return nil, fmt.Errorf("failed to read ext3 header: %s", err)
+	}
+	if o, err := image.CheckExt3Header(b); err != nil {
+		return nil, err
+	} else if o != 0 {
+		return nil, fmt.Errorf("unexpected ext3 header at offset %d", o)
+	}
+
+	sb := &superblock{}
+	if err := binary.Read(bytes.NewReader(b[superblockOffset:]), binary.LittleEndian, sb); err != nil {
+		return nil, fmt.Errorf("failed to read ext3 superblock: %s", err)
+	}
+	if sb.LogBlockSize > 6 {
+		return nil, fmt.Errorf("invalid ext3 block size")
+	}
+
+	return &Info{
+		BlockSize:  1024 << sb.LogBlockSize,
+		Blocks:     uint64(sb.BlocksCount),
+		FreeBlocks: uint64(sb.FreeBlocksCount),
+		Inodes:     uint64(sb.InodesCount),
+		FreeInodes: uint64(sb.FreeInodesCount),
+	}, nil
+}
+
+// lookPath searches for the e2fsprogs tool name in PATH and in the sbin
+// directories.
+func lookPath(name string) (string, error) {
+	if path, err := exec.LookPath(name); err == nil {
+		return path, nil
+	}
+	for _, dir := range sbinPaths {
+		if path, err := exec.LookPath(filepath.Join(dir, name)); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("%s not found, e2fsprogs must be installed", name)
+}
+
+// run executes the e2fsprogs tool name with args, okStatus lists the
+// exit codes reporting a success other than 0.
+func run(name string, okStatus []int, args ...string) error {
+	path, err := lookPath(name)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(path, args...)
+	out, err := cmd.CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		status := exitErr.Sys().(syscall.WaitStatus).ExitStatus()
+		for _, s := range okStatus {
+			if status == s {
+				return nil
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%s failed: %s: %s", name, err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// CreateExt3 creates an ext3 overlay image of size bytes at path with the
+// upper and work directories used by the overlay layer. The file system and
+// the directories are owned by the calling user. Unless sparse is true, the
+// image blocks are allocated so writes in the overlay can't fail later when
+// the underlying file system is full.
+func CreateExt3(path string, size int64, sparse bool) error {
+	dir, err := ioutil.TempDir("", "overlay-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, d := range []string{"upper", "work"} {
+		if err := os.Mkdir(filepath.Join(dir, d), 0755); err != nil {
+			return fmt.Errorf("failed to create %s directory: %s", d, err)
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %s", path, err)
+	}
+	if sparse {
+		err = f.Truncate(size)
+	} else {
+		err = syscall.Fallocate(int(f.Fd()), 0, 0, size)
+	}
+	f.Close()
+	if err != nil {
+		os.Remove(path)
+		return fmt.Errorf("failed to allocate %d bytes for %s: %s", size, path, err)
+	}
+
+	// mke2fs discards blocks by punching holes in image files
+	extended := fmt.Sprintf("root_owner=%d:%d", os.Getuid(), os.Getgid())
+	if !sparse {
+		extended += ",nodiscard"
+	}
+	if err := run("mkfs.ext3", nil, "-q", "-F", "-E", extended, "-d", dir, path); err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	if err := checkExt3(path); err != nil {
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
+
+// checkExt3 returns an error if the image at path is not an ext3 file
+// system usable by the runtime, mkfs.ext3 may enable unsupported features
+// depending on the mke2fs configuration.
+func checkExt3(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := ReadInfo(f, 0); err != nil {
+		return fmt.Errorf("mkfs.ext3 created an unsupported image: %s", err)
+	}
+	return nil
+}
+
+// ResizeExt3 grows or shrinks the ext3 image at path to size bytes, the
+// file system is checked first as required by resize2fs.
+func ResizeExt3(path string, size int64) error {
+	// e2fsck exit code 1 means errors were corrected
+	if err := run("e2fsck", []int{1}, "-f", "-y", path); err != nil {
+		return err
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if size > fi.Size() {
+		if err := os.Truncate(path, size); err != nil {
+			return fmt.Errorf("failed to grow %s: %s", path, err)
+		}
+	}
+	if err := run("resize2fs", nil, path, strconv.FormatInt(size/1024, 10)+"K"); err != nil {
+		if size > fi.Size() {
+			os.Truncate(path, fi.Size())
+		}
+		return err
+	}
+	return os.Truncate(path, size)
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package overlay
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestCreateExt3(t *testing.T) {
+	if _, err := lookPath("mkfs.ext3"); err != nil {
+		t.Skip(err)
+	}
+
+	dir, err := ioutil.TempDir("", "overlay-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name   string
+		size   int64
+		sparse bool
+	}{
+		{"allocated", 16 << 20, false},
+		{"sparse", 64 << 20, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".img")
+			if err := CreateExt3(path, tt.size, tt.sparse); err != nil {
+				t.Fatalf("failed to create image: %s", err)
+			}
+			if err := CreateExt3(path, tt.size, tt.sparse); err == nil {
+				t.Errorf("unexpected success overwriting an existing image")
+			}
+
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			info, err := ReadInfo(f, 0)
+			if err != nil {
+				t.Fatalf("invalid ext3 image: %s", err)
+			}
+			if info.Size() != uint64(tt.size) {
+				t.Errorf("unexpected file system size %d", info.Size())
+			}
+
+			fi, err := f.Stat()
+			if err != nil {
+				t.Fatal(err)
+			}
+			st := fi.Sys().(*syscall.Stat_t)
+			if int(st.Uid) != os.Getuid() || int(st.Gid) != os.Getgid() {
+				t.Errorf("unexpected image owner %d:%d", st.Uid, st.Gid)
+			}
+			allocated := st.Blocks * 512
+			if tt.sparse && allocated >= tt.size {
+				t.Errorf("sparse image has %d bytes allocated", allocated)
+			} else if !tt.sparse && allocated < tt.size {
+				t.Errorf("image has only %d bytes allocated", allocated)
+			}
+		})
+	}
+
+	if err := CreateExt3(filepath.Join(dir, "missing", "overlay.img"), 16<<20, false); err == nil {
+		t.Errorf("unexpected success in a missing directory")
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package overlay
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+)
+
+// sifOverlayName is the name of the overlay partition data object
+const sifOverlayName = "overlay.img"
+
+// SIFOverlay describes the overlay partition of a SIF image.
+type SIFOverlay struct {
+	// ID is the data object ID of the overlay partition
+	ID uint32
+	// Groupid is the group of the overlay partition
+	Groupid uint32
+	// Offset is the offset of the overlay partition in the SIF file
+	Offset int64
+	// Size is the size of the overlay partition in the SIF file
+	Size int64
+	// Info describes the ext3 file system of the overlay partition
+	Info *Info
+}
+
+// findSIFOverlay returns the ext3 overlay partition found in the group of
+// the primary partition, the one used by the runtime with --writable.
+func findSIFOverlay(fimg *sif.FileImage) (*sif.Descriptor, error) {
+	prim, _, err := fimg.GetPartPrimSys()
+	if err != nil {
+		return nil, fmt.Errorf("no primary partition found")
+	}
+	descr, _, err := fimg.GetPartFromGroup(prim.Groupid)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range descr {
+		ptype, err := d.GetPartType()
+		if err != nil || ptype != sif.PartOverlay {
+			continue
+		}
+		if fstype, err := d.GetFsType(); err == nil && fstype == sif.FsExt3 {
+			return d, nil
+		}
+	}
+	return nil, fmt.Errorf("no overlay partition found")
+}
+
+// addSIFOverlay adds the ext3 image at path as the overlay partition of the
+// primary partition group.
+func addSIFOverlay(fimg *sif.FileImage, path string) error {
+	prim, _, err := fimg.GetPartPrimSys()
+	if err != nil {
+		return fmt.Errorf("no primary partition found")
+	}
+	arch, err := prim.GetArch()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	input := sif.DescriptorInput{
+		Datatype: sif.DataPartition,
+		Groupid:  prim.Groupid,
+		Link:     sif.DescrUnusedLink,
+		Fname:    sifOverlayName,
+		Fp:       f,
+		Size:     fi.Size(),
+	}
+	if err := input.SetPartExtra(sif.FsExt3, sif.PartOverlay, string(arch[:sif.HdrArchLen-1])); err != nil {
+		return err
+	}
+	if err := fimg.AddObject(input); err != nil {
+		return fmt.Errorf("failed to add overlay partition: %s", err)
+	}
+	return nil
+}
+
+// deleteSIFObject removes the data object d, its space is reclaimed when
+// it's the last object of the file, otherwise its data are zeroed.
+func deleteSIFObject(fimg *sif.FileImage, d *sif.Descriptor) error {
+	flags := sif.DelZero
+	if fimg.Filesize == d.Fileoff+d.Filelen {
+		flags = sif.DelCompact
+	} else {
+		sylog.Warningf("Overlay partition is not the last data object, its space can't be reclaimed")
+	}
+	if err := fimg.DeleteObject(d.ID, flags); err != nil {
+		return err
+	}
+	// DeleteObject only resets the descriptor on disk, it would be written
+	// back by a later AddObject
+	*d = sif.Descriptor{}
+	return nil
+}
+
+// tempImage returns the path of a new temporary file in the directory of
+// the SIF file at path, as overlay images can be too big for /tmp.
+func tempImage(path string) (string, error) {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+"-overlay-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file: %s", err)
+	}
+	f.Close()
+	return f.Name(), os.Remove(f.Name())
+}
+
+// AddSIFOverlay creates an ext3 overlay partition of size bytes in the SIF
+// image at path, the partition is added to the group of the primary
+// partition so changes made with --writable are stored into it.
+func AddSIFOverlay(path string, size int64) error {
+	fimg, err := sif.LoadContainer(path, false)
+	if err != nil {
+		return fmt.Errorf("failed to load SIF image %s: %s", path, err)
+	}
+	defer fimg.UnloadContainer()
+
+	if _, err := findSIFOverlay(&fimg); err == nil {
+		return fmt.Errorf("%s already has an overlay partition", path)
+	} else if _, _, err := fimg.GetPartPrimSys(); err != nil {
+		return fmt.Errorf("no primary partition found in %s", path)
+	}
+
+	tmp, err := tempImage(path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	// the image is copied into the SIF file, blocks are allocated there
+	if err := CreateExt3(tmp, size, true); err != nil {
+		return err
+	}
+	return addSIFOverlay(&fimg, tmp)
+}
+
+// ResizeSIFOverlay resizes the overlay partition of the SIF image at path
+// to size bytes. The partition content is copied to a temporary image which
+// is resized and added back in place of the partition.
+func ResizeSIFOverlay(path string, size int64) error {
+	fimg, err := sif.LoadContainer(path, false)
+	if err != nil {
+		return fmt.Errorf("failed to load SIF image %s: %s", path, err)
+	}
+	defer fimg.UnloadContainer()
+
+	d, err := findSIFOverlay(&fimg)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := tempImage(path)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %s", tmp, err)
+	}
+	_, err = io.Copy(f, io.NewSectionReader(fimg.Fp, d.Fileoff, d.Filelen))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to copy overlay partition: %s", err)
+	}
+
+	if err := ResizeExt3(tmp, size); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := deleteSIFObject(&fimg, d); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to remove overlay partition: %s", err)
+	}
+	if err := addSIFOverlay(&fimg, tmp); err != nil {
+		// the overlay content is only left in the temporary image
+		return fmt.Errorf("%s, overlay content saved in %s", err, tmp)
+	}
+	return os.Remove(tmp)
+}
+
+// GetSIFOverlay returns a description of the overlay partition of the SIF
+// image at path.
+func GetSIFOverlay(path string) (*SIFOverlay, error) {
+	fimg, err := sif.LoadContainer(path, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load SIF image %s: %s", path, err)
+	}
+	defer fimg.UnloadContainer()
+
+	d, err := findSIFOverlay(&fimg)
+	if err != nil {
+		return nil, err
+	}
+	info, err := ReadInfo(fimg.Fp, d.Fileoff)
+	if err != nil {
+		return nil, fmt.Errorf("invalid overlay partition: %s", err)
+	}
+
+	return &SIFOverlay{
+		ID:      d.ID,
+		Groupid: d.Groupid &^ sif.DescrGroupMask,
+		Offset:  d.Fileoff,
+		Size:    d.Filelen,
+		Info:    info,
+	}, nil
+}
+
+// RemoveSIFOverlay removes the overlay partition of the SIF image at path,
+// along with all the changes stored into it.
+func RemoveSIFOverlay(path string) error {
+	fimg, err := sif.LoadContainer(path, false)
+	if err != nil {
+		return fmt.Errorf("failed to load SIF image %s: %s", path, err)
+	}
+	defer fimg.UnloadContainer()
+
+	d, err := findSIFOverlay(&fimg)
+	if err != nil {
+		return err
+	}
+	if err := deleteSIFObject(&fimg, d); err != nil {
+		return fmt.Errorf("failed to remove overlay partition: %s", err)
+	}
+	return nil
+}
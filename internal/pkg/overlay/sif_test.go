@@ -0,0 +1,112 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package overlay
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/sylabs/sif/pkg/sif"
+)
+
+// createTestSIF creates a SIF file at path holding a primary partition.
+func createTestSIF(t *testing.T, path string) {
+	input := sif.DescriptorInput{
+		Datatype: sif.DataPartition,
+		Groupid:  sif.DescrDefaultGroup,
+		Link:     sif.DescrUnusedLink,
+		Data:     []byte("squashfs"),
+	}
+	input.Size = int64(binary.Size(input.Data))
+	if err := input.SetPartExtra(sif.FsSquash, sif.PartPrimSys, sif.GetSIFArch(runtime.GOARCH)); err != nil {
+		t.Fatal(err)
+	}
+
+	cinfo := sif.CreateInfo{
+		Pathname:   path,
+		Launchstr:  sif.HdrLaunch,
+		Sifversion: sif.HdrVersion,
+		ID:         uuid.NewV4(),
+		InputDescr: []sif.DescriptorInput{input},
+	}
+	if _, err := sif.CreateContainer(cinfo); err != nil {
+		t.Fatalf("failed to create SIF file: %s", err)
+	}
+}
+
+func TestSIFOverlay(t *testing.T) {
+	for _, tool := range []string{"mkfs.ext3", "e2fsck", "resize2fs"} {
+		if _, err := lookPath(tool); err != nil {
+			t.Skip(err)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "overlay-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test.sif")
+	createTestSIF(t, path)
+
+	if _, err := GetSIFOverlay(path); err == nil {
+		t.Fatalf("unexpected overlay partition in new image")
+	}
+	if err := RemoveSIFOverlay(path); err == nil {
+		t.Errorf("unexpected success removing a missing overlay partition")
+	}
+
+	if err := AddSIFOverlay(path, 16<<20); err != nil {
+		t.Fatalf("failed to add overlay partition: %s", err)
+	}
+	if err := AddSIFOverlay(path, 16<<20); err == nil {
+		t.Errorf("unexpected success adding a second overlay partition")
+	}
+
+	o, err := GetSIFOverlay(path)
+	if err != nil {
+		t.Fatalf("failed to get overlay partition: %s", err)
+	}
+	if o.Size != 16<<20 || o.Info.Size() != 16<<20 {
+		t.Errorf("unexpected overlay size %d, file system size %d", o.Size, o.Info.Size())
+	}
+	if o.Info.Free() == 0 || o.Info.Free() >= o.Info.Size() {
+		t.Errorf("unexpected free space %d", o.Info.Free())
+	}
+
+	for _, size := range []int64{32 << 20, 8 << 20} {
+		if err := ResizeSIFOverlay(path, size); err != nil {
+			t.Fatalf("failed to resize overlay partition: %s", err)
+		}
+		o, err = GetSIFOverlay(path)
+		if err != nil {
+			t.Fatalf("failed to get overlay partition: %s", err)
+		}
+		if o.Size != size || o.Info.Size() != uint64(size) {
+			t.Errorf("unexpected overlay size %d, file system size %d, expected %d", o.Size, o.Info.Size(), size)
+		}
+	}
+
+	if err := RemoveSIFOverlay(path); err != nil {
+		t.Fatalf("failed to remove overlay partition: %s", err)
+	}
+	if _, err := GetSIFOverlay(path); err == nil {
+		t.Errorf("unexpected overlay partition after removal")
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() >= 8<<20 {
+		t.Errorf("overlay partition space not reclaimed, image size %d", fi.Size())
+	}
+}
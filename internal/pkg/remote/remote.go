@@ -0,0 +1,303 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package remote implements the management of the named remote endpoints
+// used by the client commands. A remote holds the URLs of a library, a key
+// server and a remote build service along with the authentication token of
+// the user for these services. The remotes are stored in a per user TOML
+// configuration file.
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	toml "github.com/pelletier/go-toml"
+	useragent "github.com/sylabs/singularity/pkg/util/user-agent"
+)
+
+// DefaultName is the name of the Sylabs cloud remote, active when no
+// remote is configured
+const DefaultName = "SylabsCloud"
+
+// Services of a remote, also used as the remotekey annotation of the
+// command flags setting their URL
+const (
+	LibraryService   = "library"
+	KeyserverService = "keyserver"
+	BuilderService   = "builder"
+)
+
+// Remote describes a named set of endpoints:
+//	Name: identifier of the remote
+//	Library: URL of the container library
+//	Keyserver: URL of the key server, optional
+//	Builder: URL of the remote build service, optional
+//	Token: authentication token of the user for the remote services
+type Remote struct {
+	Name      string `toml:"name"`
+	Library   string `toml:"library"`
+	Keyserver string `toml:"keyserver"`
+	Builder   string `toml:"builder"`
+	Token     string `toml:"token"`
+}
+
+// Config describes the remote configuration file of a user
+type Config struct {
+	Active  string   `toml:"active"` // name of the remote used by the client commands
+	Remotes []Remote `toml:"remote"` // Slice of all remotes
+}
+
+// DefaultConfig returns the configuration used when the user has no remote
+// configuration file, holding the Sylabs cloud remote.
+func DefaultConfig() Config {
+	return Config{
+		Active: DefaultName,
+		Remotes: []Remote{
+			{
+				Name:      DefaultName,
+				Library:   "https://library.sylabs.io",
+				Keyserver: "https://keys.sylabs.io",
+				Builder:   "https://build.sylabs.io",
+			},
+		},
+	}
+}
+
+// LoadConfig opens the remote configuration file confPath and unmarshals it
+// into a Config struct, the default configuration is returned if it doesn't
+// exist
+func LoadConfig(confPath string) (c Config, err error) {
+	b, err := ioutil.ReadFile(confPath)
+	if os.IsNotExist(err) {
+		return DefaultConfig(), nil
+	} else if err != nil {
+		return
+	}
+
+	if err = toml.Unmarshal(b, &c); err != nil {
+		return c, fmt.Errorf("while parsing %s: %s", confPath, err)
+	}
+	if err = c.ValidateConfig(); err != nil {
+		return c, fmt.Errorf("invalid remote configuration %s: %s", confPath, err)
+	}
+	return c, nil
+}
+
+// PutConfig takes the content of a Config struct and Marshals it to file,
+// readable only by the user as it holds authentication tokens
+func PutConfig(c Config, confPath string) (err error) {
+	data, err := toml.Marshal(c)
+	if err != nil {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(confPath), 0700); err != nil {
+		return
+	}
+
+	// write a new file replacing the old one, the configuration is never
+	// left truncated
+	f, err := ioutil.TempFile(filepath.Dir(confPath), filepath.Base(confPath)+".")
+	if err != nil {
+		return
+	}
+	defer os.Remove(f.Name())
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return
+	}
+	if err = f.Close(); err != nil {
+		return
+	}
+	return os.Rename(f.Name(), confPath)
+}
+
+// ValidateConfig makes sure that remote names are unique, that their URLs
+// are valid and that the active remote exists
+func (c *Config) ValidateConfig() error {
+	names := map[string]bool{}
+	for _, r := range c.Remotes {
+		if err := r.validate(); err != nil {
+			return err
+		}
+		if names[r.Name] {
+			return fmt.Errorf("remote %s is defined more than once", r.Name)
+		}
+		names[r.Name] = true
+	}
+	if !names[c.Active] {
+		return fmt.Errorf("active remote %s doesn't exist", c.Active)
+	}
+	return nil
+}
+
+// validate checks that the remote has a name, a library and valid URLs
+func (r *Remote) validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("a remote must have a name")
+	}
+	if r.Library == "" {
+		return fmt.Errorf("remote %s has no library URL", r.Name)
+	}
+	for _, u := range []string{r.Library, r.Keyserver, r.Builder} {
+		if u == "" {
+			continue
+		}
+		p, err := url.Parse(u)
+		if err != nil {
+			return fmt.Errorf("remote %s has an invalid URL %s: %s", r.Name, u, err)
+		}
+		if (p.Scheme != "http" && p.Scheme != "https") || p.Host == "" {
+			return fmt.Errorf("remote %s has an invalid URL %s: must be http:// or https://", r.Name, u)
+		}
+	}
+	return nil
+}
+
+// Names returns the sorted names of the remotes
+func (c *Config) Names() []string {
+	var names []string
+	for _, r := range c.Remotes {
+		names = append(names, r.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get returns the remote name
+func (c *Config) Get(name string) (*Remote, error) {
+	for i := range c.Remotes {
+		if c.Remotes[i].Name == name {
+			return &c.Remotes[i], nil
+		}
+	}
+	return nil, fmt.Errorf("remote %s doesn't exist", name)
+}
+
+// Current returns the active remote
+func (c *Config) Current() (*Remote, error) {
+	return c.Get(c.Active)
+}
+
+// Add adds the remote r, its name must not be used yet
+func (c *Config) Add(r Remote) error {
+	if _, err := c.Get(r.Name); err == nil {
+		return fmt.Errorf("remote %s already exists", r.Name)
+	}
+	if err := r.validate(); err != nil {
+		return err
+	}
+	c.Remotes = append(c.Remotes, r)
+	return nil
+}
+
+// Remove removes the remote name, which must not be the active one
+func (c *Config) Remove(name string) error {
+	if name == c.Active {
+		return fmt.Errorf("remote %s is active, another remote must be used first", name)
+	}
+	for i := range c.Remotes {
+		if c.Remotes[i].Name == name {
+			c.Remotes = append(c.Remotes[:i], c.Remotes[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("remote %s doesn't exist", name)
+}
+
+// Use makes the remote name the active one
+func (c *Config) Use(name string) error {
+	if _, err := c.Get(name); err != nil {
+		return err
+	}
+	c.Active = name
+	return nil
+}
+
+// URL returns the URL of the service of the remote, empty if the remote
+// doesn't provide it
+func (r *Remote) URL(service string) string {
+	switch service {
+	case LibraryService:
+		return r.Library
+	case KeyserverService:
+		return r.Keyserver
+	case BuilderService:
+		return r.Builder
+	}
+	return ""
+}
+
+// ServiceStatus describes the state of a service of a remote:
+//	Service: library, keyserver or builder
+//	URL: URL of the service
+//	Version: version reported by the service, if any
+//	Err: error reaching the service
+type ServiceStatus struct {
+	Service string
+	URL     string
+	Version string
+	Err     error
+}
+
+// Status queries the version endpoint of each service of the remote
+func (r *Remote) Status(ctx context.Context) []ServiceStatus {
+	var status []ServiceStatus
+	for _, service := range []string{LibraryService, KeyserverService, BuilderService} {
+		u := r.URL(service)
+		if u == "" {
+			continue
+		}
+		s := ServiceStatus{Service: service, URL: u}
+		s.Version, s.Err = serviceVersion(ctx, u)
+		status = append(status, s)
+	}
+	return status
+}
+
+// serviceVersion returns the version reported by the service at baseURL
+func serviceVersion(ctx context.Context, baseURL string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, baseURL+"/version", nil)
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", useragent.Value())
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("service returned %s", res.Status)
+	}
+
+	// services answer with their version either at the top level or
+	// in the data of an API response
+	var v struct {
+		Version string `json:"version"`
+		Data    struct {
+			Version string `json:"version"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&v); err != nil {
+		return "", nil
+	}
+	if v.Version != "" {
+		return v.Version, nil
+	}
+	return v.Data.Version, nil
+}
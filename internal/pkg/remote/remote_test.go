@@ -0,0 +1,161 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package remote
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	useragent "github.com/sylabs/singularity/pkg/util/user-agent"
+)
+
+func TestMain(m *testing.M) {
+	useragent.InitValue("singularity", "3.0.0-alpha.1-303-gaed8d30-dirty")
+
+	os.Exit(m.Run())
+}
+
+func TestConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remote-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	confPath := filepath.Join(dir, "config", "remote.toml")
+
+	c, err := LoadConfig(confPath)
+	if err != nil {
+		t.Fatalf("failed to load missing configuration: %v", err)
+	}
+	if !reflect.DeepEqual(c, DefaultConfig()) {
+		t.Errorf("unexpected default configuration %v", c)
+	}
+
+	onprem := Remote{Name: "onprem", Library: "https://library.example.com", Keyserver: "http://keys.example.com:8080"}
+	if err := c.Add(onprem); err != nil {
+		t.Fatalf("failed to add remote: %v", err)
+	}
+	if err := c.Add(onprem); err == nil {
+		t.Errorf("unexpected success adding an existing remote")
+	}
+	for _, r := range []Remote{
+		{Name: "nolibrary", Keyserver: "https://keys.example.com"},
+		{Name: "badscheme", Library: "ftp://library.example.com"},
+		{Name: "nohost", Library: "https://"},
+		{Library: "https://library.example.com"},
+	} {
+		if err := c.Add(r); err == nil {
+			t.Errorf("unexpected success adding invalid remote %v", r)
+		}
+	}
+
+	if err := c.Use("missing"); err == nil {
+		t.Errorf("unexpected success using a missing remote")
+	}
+	if err := c.Use("onprem"); err != nil {
+		t.Fatalf("failed to use remote: %v", err)
+	}
+	r, err := c.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Token = "token"
+	if err := c.Remove("onprem"); err == nil {
+		t.Errorf("unexpected success removing the active remote")
+	}
+	if err := c.Remove("missing"); err == nil {
+		t.Errorf("unexpected success removing a missing remote")
+	}
+
+	if err := PutConfig(c, confPath); err != nil {
+		t.Fatalf("failed to save configuration: %v", err)
+	}
+	fi, err := os.Stat(confPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("unexpected permissions %s", fi.Mode())
+	}
+
+	loaded, err := LoadConfig(confPath)
+	if err != nil {
+		t.Fatalf("failed to load configuration: %v", err)
+	}
+	if !reflect.DeepEqual(loaded, c) {
+		t.Errorf("unexpected configuration %v, expected %v", loaded, c)
+	}
+	if names := loaded.Names(); !reflect.DeepEqual(names, []string{"SylabsCloud", "onprem"}) {
+		t.Errorf("unexpected names %v", names)
+	}
+	if r, _ := loaded.Current(); r.Token != "token" || r.URL(KeyserverService) != onprem.Keyserver || r.URL(BuilderService) != "" {
+		t.Errorf("unexpected active remote %v", r)
+	}
+
+	if err := loaded.Use(DefaultName); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Remove("onprem"); err != nil {
+		t.Errorf("failed to remove remote: %v", err)
+	}
+	if names := loaded.Names(); !reflect.DeepEqual(names, []string{"SylabsCloud"}) {
+		t.Errorf("unexpected names %v", names)
+	}
+
+	for _, content := range []string{
+		"active = \"missing\"\n",
+		"active = \"a\"\n[[remote]]\nname = \"a\"\nlibrary = \"https://a\"\n[[remote]]\nname = \"a\"\nlibrary = \"https://b\"\n",
+		"active = \n",
+	} {
+		if err := ioutil.WriteFile(confPath, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadConfig(confPath); err == nil {
+			t.Errorf("unexpected success loading invalid configuration:\n%s", content)
+		}
+	}
+}
+
+func TestStatus(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/library/version":
+			w.Write([]byte(`{"data":{"version":"1.2.3"}}`))
+		case "/keys/version":
+			w.Write([]byte(`{"version":"0.4"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer s.Close()
+
+	r := Remote{Name: "test", Library: s.URL + "/library", Keyserver: s.URL + "/keys", Builder: s.URL + "/builder"}
+	status := r.Status(context.Background())
+	if len(status) != 3 {
+		t.Fatalf("unexpected status %v", status)
+	}
+	if status[0].Service != LibraryService || status[0].Version != "1.2.3" || status[0].Err != nil {
+		t.Errorf("unexpected library status %v", status[0])
+	}
+	if status[1].Service != KeyserverService || status[1].Version != "0.4" || status[1].Err != nil {
+		t.Errorf("unexpected keyserver status %v", status[1])
+	}
+	if status[2].Service != BuilderService || status[2].Err == nil {
+		t.Errorf("unexpected builder status %v", status[2])
+	}
+
+	r.Builder = ""
+	if status := r.Status(context.Background()); len(status) != 2 {
+		t.Errorf("unexpected status %v", status)
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"syscall"
 
 	"github.com/sylabs/singularity/internal/pkg/buildcfg"
+	"github.com/sylabs/singularity/internal/pkg/fakeroot"
 	imgbuildConfig "github.com/sylabs/singularity/internal/pkg/runtime/engines/imgbuild/config"
 	"github.com/sylabs/singularity/internal/pkg/runtime/engines/singularity/rpc/client"
 	"github.com/sylabs/singularity/internal/pkg/sylog"
@@ -63,7 +64,12 @@ func (engine *EngineOperations) CreateContainer(pid int, rpcConn net.Conn) error
 	}
 
 	sylog.Debugf("Mounting sysfs at %s\n", filepath.Join(sessionPath, "sys"))
-	_, err = rpcOps.Mount("sysfs", filepath.Join(sessionPath, "sys"), "sysfs", syscall.MS_NOSUID, "")
+	if fakeroot.InUserNamespace() {
+		// sysfs can't be mounted without a network namespace owned by the user namespace
+		_, err = rpcOps.Mount("/sys", filepath.Join(sessionPath, "sys"), "", syscall.MS_BIND|syscall.MS_NOSUID|syscall.MS_REC, "")
+	} else {
+		_, err = rpcOps.Mount("sysfs", filepath.Join(sessionPath, "sys"), "sysfs", syscall.MS_NOSUID, "")
+	}
 	if err != nil {
 		return fmt.Errorf("mount sys failed: %s", err)
 	}
@@ -152,6 +158,10 @@ func (engine *EngineOperations) CreateContainer(pid int, rpcConn net.Conn) error
 func (engine *EngineOperations) copyFiles() error {
 	// iterate through filetransfers
 	for _, transfer := range engine.EngineConfig.Recipe.BuildData.Files {
+		// files from previous build stages are copied by the builder
+		if transfer.Stage != "" {
+			continue
+		}
 		// sanity
 		if transfer.Src == "" {
 			sylog.Warningf("Attempt to copy file with no name...")
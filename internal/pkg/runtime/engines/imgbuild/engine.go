@@ -11,9 +11,12 @@ import (
 
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 
+	"github.com/sylabs/singularity/internal/pkg/buildcfg"
+	"github.com/sylabs/singularity/internal/pkg/fakeroot"
 	"github.com/sylabs/singularity/internal/pkg/runtime/engines/config"
 	"github.com/sylabs/singularity/internal/pkg/runtime/engines/config/starter"
 	imgbuildConfig "github.com/sylabs/singularity/internal/pkg/runtime/engines/imgbuild/config"
+	singularityConfig "github.com/sylabs/singularity/internal/pkg/runtime/engines/singularity/config"
 	"github.com/sylabs/singularity/pkg/util/capabilities"
 )
 
@@ -34,6 +37,29 @@ func (e *EngineOperations) Config() config.EngineConfig {
 	return e.EngineConfig
 }
 
+// checkFakeroot returns an error if the build runs as root of a fake root
+// user namespace set up by the command line and the configuration doesn't
+// allow the user to use fakeroot. The check is done here as the starter may
+// be executed without the command line checks.
+func checkFakeroot() error {
+	uid, fake, err := fakeroot.NamespaceOwner()
+	if err != nil {
+		return err
+	}
+	if !fake {
+		return nil
+	}
+
+	c := &singularityConfig.FileConfig{}
+	if err := config.Parser(buildcfg.SYSCONFDIR+"/singularity/singularity.conf", c); err != nil {
+		return fmt.Errorf("unable to parse singularity.conf file: %s", err)
+	}
+	if !c.AllowFakeroot {
+		return fmt.Errorf("fakeroot is disabled by configuration")
+	}
+	return fakeroot.CheckUID(uid, c.FakerootAllowUsers, c.FakerootDenyUsers)
+}
+
 // PrepareConfig validates/prepares EngineConfig setup
 func (e *EngineOperations) PrepareConfig(starterConfig *starter.Config) error {
 	e.EngineConfig.OciConfig.SetProcessNoNewPrivileges(true)
@@ -47,6 +73,10 @@ func (e *EngineOperations) PrepareConfig(starterConfig *starter.Config) error {
 		return fmt.Errorf("%s don't allow SUID workflow", e.CommonConfig.EngineName)
 	}
 
+	if err := checkFakeroot(); err != nil {
+		return err
+	}
+
 	e.EngineConfig.OciConfig.SetupPrivileged(true)
 
 	e.EngineConfig.OciConfig.AddOrReplaceLinuxNamespace(specs.MountNamespace, "")
@@ -47,7 +47,15 @@ type FileConfig struct {
 	CniConfPath             string   `directive:"cni configuration path"`
 	CniPluginPath           string   `directive:"cni plugin path"`
 	MksquashfsPath          string   `directive:"mksquashfs path"`
+	MksquashfsCompression   string   `default:"gzip" authorized:"gzip,lz4,xz,zstd,none" directive:"mksquashfs compression"`
+	MksquashfsBlockSize     string   `directive:"mksquashfs block size"`
+	MksquashfsProcs         uint     `default:"0" directive:"mksquashfs procs"`
+	MksquashfsExclude       []string `directive:"mksquashfs exclude"`
 	SharedLoopDevices       bool     `default:"no" authorized:"yes,no" directive:"shared loop devices"`
+	TrustedKeyring          string   `directive:"trusted keyring"`
+	AllowFakeroot           bool     `default:"yes" authorized:"yes,no" directive:"allow fakeroot"`
+	FakerootAllowUsers      []string `directive:"fakeroot allow users"`
+	FakerootDenyUsers       []string `directive:"fakeroot deny users"`
 }
 
 // JSONConfig stores engine specific confguration that is allowed to be set by the user
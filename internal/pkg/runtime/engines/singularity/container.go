@@ -27,6 +27,7 @@ import (
 	"github.com/sylabs/singularity/internal/pkg/util/fs/layout/layer/overlay"
 	"github.com/sylabs/singularity/internal/pkg/util/fs/layout/layer/underlay"
 	"github.com/sylabs/singularity/internal/pkg/util/fs/mount"
+	"github.com/sylabs/singularity/internal/pkg/util/fs/squashfs"
 	"github.com/sylabs/singularity/internal/pkg/util/user"
 	"github.com/sylabs/singularity/pkg/network"
 	"github.com/sylabs/singularity/pkg/util/fs/proc"
@@ -679,6 +680,16 @@ func (c *container) addRootfsMount(system *mount.System) error {
 		}
 		if fstype == sif.FsSquash {
 			mountType = "squashfs"
+			checkSquashfsCompression(image.PartCompression(part))
+
+			// layers are stacked by addSIFLayersMount
+			layers, err := image.SIFLayers(&fimg)
+			if err != nil {
+				return err
+			}
+			if len(layers) > 0 && c.sessionLayerType != "overlay" {
+				return fmt.Errorf("image has %d layers which can't be stacked without overlay support", len(layers))
+			}
 		} else if fstype == sif.FsExt3 {
 			mountType = "ext3"
 		} else {
@@ -723,6 +734,22 @@ func (c *container) addRootfsMount(system *mount.System) error {
 	return nil
 }
 
+// checkSquashfsCompression warns if the kernel may not be able to mount a
+// squashfs filesystem compressed with compression.
+func checkSquashfsCompression(compression string) {
+	if compression == "" {
+		return
+	}
+	supported, err := squashfs.KernelSupportsCompression(compression)
+	if err != nil {
+		sylog.Debugf("Unable to check kernel support of %s squashfs compression: %s", compression, err)
+		return
+	}
+	if !supported {
+		sylog.Warningf("Image filesystem is compressed with %s, the kernel squashfs driver may not support it", compression)
+	}
+}
+
 func (c *container) overlayUpperWork(system *mount.System) error {
 	ov := c.session.Layer.(*overlay.Overlay)
 
@@ -753,11 +780,67 @@ func (c *container) overlayUpperWork(system *mount.System) error {
 	return nil
 }
 
+// addSIFLayersMount mounts the squashfs layers stacked over the primary
+// partition of a SIF image as overlay lower directories.
+func (c *container) addSIFLayersMount(system *mount.System) error {
+	ov := c.session.Layer.(*overlay.Overlay)
+
+	imageObject, err := c.loadImage(c.engine.EngineConfig.GetImage(), true)
+	if err != nil {
+		return err
+	}
+	if imageObject.Type != image.SIF {
+		return nil
+	}
+
+	// use a separate handle on the opened image as unloading the SIF closes
+	// its file, imageObject.File remains open for the mounts
+	fimg, err := sif.LoadContainer(imageObject.Source, true)
+	if err != nil {
+		return err
+	}
+	defer fimg.UnloadContainer()
+
+	layers, err := image.SIFLayers(&fimg)
+	if err != nil {
+		return err
+	}
+
+	for i, l := range layers {
+		sessionDest := fmt.Sprintf("/rootfs-layers/%d", i)
+		if err := c.session.AddDir(sessionDest); err != nil {
+			return fmt.Errorf("failed to create session directory for layer: %s", err)
+		}
+		dst, _ := c.session.GetPath(sessionDest)
+
+		checkSquashfsCompression(image.PartCompression(l))
+
+		sylog.Debugf("Stacking layer partition %d of %s", l.ID, imageObject.Path)
+		flags := uintptr(c.suidFlag | syscall.MS_NODEV | syscall.MS_RDONLY)
+		err = system.Points.AddImage(mount.PreLayerTag, imageObject.Source, dst, "squashfs", flags, uint64(l.Fileoff), uint64(l.Filelen))
+		if err != nil {
+			return err
+		}
+		if err := system.Points.AddPropagation(mount.DevTag, dst, syscall.MS_UNBINDABLE); err != nil {
+			return err
+		}
+		// each layer is added over the previous ones
+		ov.AddLowerDir(dst)
+	}
+
+	return nil
+}
+
 func (c *container) addOverlayMount(system *mount.System) error {
 	nb := 0
 	ov := c.session.Layer.(*overlay.Overlay)
 	hasUpper := false
 
+	// overlay images are stacked over the image layers
+	if err := c.addSIFLayersMount(system); err != nil {
+		return fmt.Errorf("failed to stack image layers: %s", err)
+	}
+
 	if c.engine.EngineConfig.GetWritableTmpfs() {
 		sylog.Debugf("Setup writable tmpfs overlay")
 
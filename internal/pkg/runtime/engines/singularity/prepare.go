@@ -14,6 +14,7 @@ import (
 
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/sylabs/singularity/internal/pkg/buildcfg"
+	"github.com/sylabs/singularity/internal/pkg/fakeroot"
 	"github.com/sylabs/singularity/internal/pkg/image"
 	"github.com/sylabs/singularity/internal/pkg/instance"
 	"github.com/sylabs/singularity/internal/pkg/runtime/engines/config"
@@ -438,6 +439,37 @@ func (e *EngineOperations) prepareInstanceJoinConfig(starterConfig *starter.Conf
 	return nil
 }
 
+// checkFakeroot returns an error if the container runs with fake root
+// privileges, as root of a fake root user namespace set up by the command
+// line or with a user namespace of the engine config mapping root to the
+// calling user, and the configuration doesn't allow the user to use
+// fakeroot. The check is done here as the starter may be executed without
+// the command line checks.
+func (e *EngineOperations) checkFakeroot() error {
+	uid, fake, err := fakeroot.NamespaceOwner()
+	if err != nil {
+		return err
+	}
+	// the container user namespace isn't created yet, the calling user
+	// is resolved in the current namespace
+	if !fake && os.Getuid() != 0 && e.EngineConfig.OciConfig.Linux != nil {
+		for _, m := range e.EngineConfig.OciConfig.Linux.UIDMappings {
+			if m.ContainerID == 0 {
+				uid, fake = uint32(os.Getuid()), true
+				break
+			}
+		}
+	}
+	if !fake {
+		return nil
+	}
+
+	if !e.EngineConfig.File.AllowFakeroot {
+		return fmt.Errorf("fakeroot is disabled by configuration")
+	}
+	return fakeroot.CheckUID(uid, e.EngineConfig.File.FakerootAllowUsers, e.EngineConfig.File.FakerootDenyUsers)
+}
+
 // PrepareConfig checks and prepares the runtime engine config
 func (e *EngineOperations) PrepareConfig(starterConfig *starter.Config) error {
 	if e.CommonConfig.EngineName != singularityConfig.Name {
@@ -466,6 +498,14 @@ func (e *EngineOperations) PrepareConfig(starterConfig *starter.Config) error {
 		if !fs.IsOwner(buildcfg.ECL_FILE, 0) {
 			return fmt.Errorf("%s must be owned by root", buildcfg.ECL_FILE)
 		}
+		// check for ownership of the ECL keyring, if any
+		if fs.IsFile(buildcfg.ECL_KEYRING) && !fs.IsOwner(buildcfg.ECL_KEYRING, 0) {
+			return fmt.Errorf("%s must be owned by root", buildcfg.ECL_KEYRING)
+		}
+	}
+
+	if err := e.checkFakeroot(); err != nil {
+		return err
 	}
 
 	// Save the current working directory to restore it in stage 2
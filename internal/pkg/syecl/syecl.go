@@ -1,4 +1,4 @@
-// Copyright (c) 2018, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2019, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -13,32 +13,123 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 
 	toml "github.com/pelletier/go-toml"
+	"github.com/sylabs/singularity/internal/pkg/buildcfg"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
 	"github.com/sylabs/singularity/pkg/signing"
+	"github.com/sylabs/singularity/pkg/sypgp"
+	"golang.org/x/crypto/openpgp"
 )
 
 // EclConfig describes the structure of an execution control list configuration file
 type EclConfig struct {
 	Activated  bool        `toml:"activated"` // toggle the activation of the ECL rules
+	Keyserver  string      `toml:"keyserver"` // key server checked for missing keys and revocations, disabled if empty
 	ExecGroups []execgroup `toml:"execgroup"` // Slice of all execution groups
 }
 
 // execgroup describes an execution group, the main unit of configuration:
 //	TagName: a descriptive identifier
-//	ListMode: whether the execgroup follows a whitelist, whitestrict or blacklist model
+//	ListMode: whether the execgroup follows a whitelist, whitestrict, blacklist or audit model
 //		whitelist: one or more KeyFP's present and verified,
 //		whitestrict: all KeyFP's present and verified,
-//		blacklist: none of the KeyFP should be present
-//	DirPath: containers must be stored in this directory path
+//		blacklist: none of the KeyFP should be present,
+//		audit: the AuditMode decision is logged but containers are always allowed to run
+//	DirPath: containers must be stored in this directory path, may be a glob pattern
 //	KeyFPs: list of Key Fingerprints of entities to verify
+//	Recursive: containers may also be stored in any subdirectory of DirPath
+//	AuditMode: the whitelist, whitestrict or blacklist model evaluated by an audit execgroup
 type execgroup struct {
-	TagName  string   `toml:"tagname"`
-	ListMode string   `toml:"mode"`
-	DirPath  string   `toml:"dirpath"`
-	KeyFPs   []string `toml:"keyfp"`
+	TagName   string   `toml:"tagname"`
+	ListMode  string   `toml:"mode"`
+	DirPath   string   `toml:"dirpath"`
+	KeyFPs    []string `toml:"keyfp"`
+	Recursive bool     `toml:"recursive"`
+	AuditMode string   `toml:"auditmode"`
+}
+
+// globChars are the metacharacters of the dirpath glob patterns
+const globChars = "*?[\\"
+
+// isGlob returns true if path contains glob pattern metacharacters.
+func isGlob(path string) bool {
+	return strings.ContainsAny(path, globChars)
+}
+
+// depth returns the number of components of the absolute path.
+func depth(path string) int {
+	if path == "/" {
+		return 0
+	}
+	return strings.Count(path, "/")
+}
+
+// match returns true if the execgroup dirpath matches directory dir or,
+// for a recursive execgroup, one of its parent directories.
+func (e *execgroup) match(dir string) bool {
+	for {
+		if ok, _ := filepath.Match(e.DirPath, dir); ok {
+			return true
+		}
+		parent := filepath.Dir(dir)
+		if !e.Recursive || parent == dir {
+			return false
+		}
+		dir = parent
+	}
+}
+
+// rank returns the precedence of a matching execgroup for a container stored
+// in directory dir: the deepest dirpath wins, then a dirpath matching dir
+// itself wins over a dirpath matching one of its parents and finally a plain
+// dirpath wins over a glob pattern.
+func (e *execgroup) rank(dir string) int {
+	r := depth(e.DirPath) * 4
+	if ok, _ := filepath.Match(e.DirPath, dir); ok {
+		r += 2
+	}
+	if !isGlob(e.DirPath) {
+		r++
+	}
+	return r
+}
+
+// mayOverlap returns true if the dirpaths a and b, of the same depth, may
+// match a common directory. Two glob components are considered overlapping
+// unless their leading literal parts differ.
+func mayOverlap(a, b string) bool {
+	ca, cb := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := range ca {
+		ga, gb := isGlob(ca[i]), isGlob(cb[i])
+		switch {
+		case ga && gb:
+			pa := ca[i][:strings.IndexAny(ca[i], globChars)]
+			pb := cb[i][:strings.IndexAny(cb[i], globChars)]
+			if !strings.HasPrefix(pa, pb) && !strings.HasPrefix(pb, pa) {
+				return false
+			}
+		case ga:
+			if ok, _ := filepath.Match(ca[i], cb[i]); !ok {
+				return false
+			}
+		case gb:
+			if ok, _ := filepath.Match(cb[i], ca[i]); !ok {
+				return false
+			}
+		case ca[i] != cb[i]:
+			return false
+		}
+	}
+	return true
+}
+
+func validListMode(mode string) bool {
+	return mode == "whitelist" || mode == "whitestrict" || mode == "blacklist"
 }
 
 // LoadConfig opens an ECL config file and unmarshals it into structures
@@ -65,18 +156,33 @@ func PutConfig(ecl EclConfig, confPath string) (err error) {
 }
 
 // ValidateConfig makes sure paths from configs are fully resolved and that
-// values from an execgroup are logically correct.
+// values from an execgroup are logically correct. Execgroups which could both
+// be selected with the same precedence for a container are rejected.
 func (ecl *EclConfig) ValidateConfig() (err error) {
+	if ecl.Keyserver != "" {
+		u, err := url.Parse(ecl.Keyserver)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("the keyserver field must be an http:// or https:// URL")
+		}
+	}
+
 	m := map[string]bool{}
 
-	for _, v := range ecl.ExecGroups {
+	for i, v := range ecl.ExecGroups {
 		if m[v.DirPath] {
 			return fmt.Errorf("a specific dirpath can only appear in one execgroup: %s", v.DirPath)
 		}
 		m[v.DirPath] = true
 
 		// if we allow containers everywhere, don't test dirpath constraint
-		if v.DirPath != "" {
+		if v.DirPath != "" && isGlob(v.DirPath) {
+			if _, err := filepath.Match(v.DirPath, ""); err != nil {
+				return fmt.Errorf("invalid dirpath pattern %s: %s", v.DirPath, err)
+			}
+			if !filepath.IsAbs(v.DirPath) || filepath.Clean(v.DirPath) != v.DirPath {
+				return fmt.Errorf("all execgroup dirpath`s should be absolute and fully cleaned")
+			}
+		} else if v.DirPath != "" {
 			path, err := filepath.EvalSymlinks(v.DirPath)
 			if err != nil {
 				return err
@@ -89,8 +195,14 @@ func (ecl *EclConfig) ValidateConfig() (err error) {
 				return fmt.Errorf("all execgroup dirpath`s should be fully cleaned with symlinks resolved")
 			}
 		}
-		if v.ListMode != "whitelist" && v.ListMode != "whitestrict" && v.ListMode != "blacklist" {
-			return fmt.Errorf("the mode field can only be either: whitelist, whitestrict, blacklist")
+		if v.ListMode == "audit" {
+			if !validListMode(v.AuditMode) {
+				return fmt.Errorf("the auditmode field of an audit execgroup can only be either: whitelist, whitestrict, blacklist")
+			}
+		} else if !validListMode(v.ListMode) {
+			return fmt.Errorf("the mode field can only be either: whitelist, whitestrict, blacklist, audit")
+		} else if v.AuditMode != "" {
+			return fmt.Errorf("the auditmode field is only allowed in an audit execgroup")
 		}
 		for _, k := range v.KeyFPs {
 			decoded, err := hex.DecodeString(k)
@@ -98,60 +210,101 @@ func (ecl *EclConfig) ValidateConfig() (err error) {
 				return fmt.Errorf("expecting a 40 chars hex fingerprint string")
 			}
 		}
+
+		// execgroups of the same depth and the same kind of dirpath have
+		// the same precedence, they must not match a common directory
+		for _, u := range ecl.ExecGroups[:i] {
+			if v.DirPath == "" || u.DirPath == "" {
+				continue
+			}
+			if depth(v.DirPath) != depth(u.DirPath) || isGlob(v.DirPath) != isGlob(u.DirPath) {
+				continue
+			}
+			if mayOverlap(v.DirPath, u.DirPath) {
+				return fmt.Errorf("execgroups %s and %s overlap ambiguously: dirpath %s and %s may match the same directory", u.TagName, v.TagName, u.DirPath, v.DirPath)
+			}
+		}
 	}
 	return
 }
 
+// keyringPath is the public keyring used to verify container signatures, it
+// is owned by root so that users can't remove keys or revocations from it
+var keyringPath = buildcfg.ECL_KEYRING
+
+// loadKeyring returns the public keys used to verify container signatures,
+// a missing keyring holds no keys
+func loadKeyring() (openpgp.EntityList, error) {
+	el, err := sypgp.LoadKeyring(keyringPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return el, err
+}
+
+// signEntities returns the signing entities of the primary partition and of
+// the layers stacked over it along with the reason their signatures are not
+// valid, nil when an entity validly signed the primary partition and each
+// layer
+func signEntities(ecl *EclConfig, fp *os.File) (map[string]error, error) {
+	el, err := loadKeyring()
+	if err != nil {
+		return nil, fmt.Errorf("could not load public keyring %s: %s", keyringPath, err)
+	}
+	return signing.VerifySignEntitiesFp(fp, el, ecl.Keyserver)
+}
+
+// notSignedError reports the rejected signatures of the required entities
+func notSignedError(fp *os.File, egroup *execgroup, status map[string]error) error {
+	var reasons []string
+	for _, v := range egroup.KeyFPs {
+		if err, ok := status[v]; ok && err != nil {
+			reasons = append(reasons, fmt.Sprintf("signature by %s rejected: %s", v, err))
+		}
+	}
+	if len(reasons) == 0 {
+		return fmt.Errorf("%s is not signed by required entities", fp.Name())
+	}
+	return fmt.Errorf("%s is not signed by required entities: %s", fp.Name(), strings.Join(reasons, "; "))
+}
+
 // checkWhiteList evaluates authorization by requiring at least 1 entity
-func checkWhiteList(fp *os.File, egroup *execgroup) (ok bool, err error) {
+func checkWhiteList(ecl *EclConfig, fp *os.File, egroup *execgroup) (ok bool, err error) {
 	// get all signing entities fingerprints on the primary partition
-	keyfps, err := signing.GetSignEntitiesFp(fp)
+	status, err := signEntities(ecl, fp)
 	if err != nil {
 		return
 	}
-	// was the primary partition signed by an authorized entity?
+	// was the primary partition validly signed by an authorized entity?
 	for _, v := range egroup.KeyFPs {
-		for _, u := range keyfps {
-			if v == u {
-				ok = true
-			}
+		if err, signed := status[v]; signed && err == nil {
+			return true, nil
 		}
 	}
-	if !ok {
-		return false, fmt.Errorf("%s is not signed by required entities", fp.Name())
-	}
 
-	return true, nil
+	return false, notSignedError(fp, egroup, status)
 }
 
 // checkWhiteStrict evaluates authorization by requiring all entities
-func checkWhiteStrict(fp *os.File, egroup *execgroup) (ok bool, err error) {
+func checkWhiteStrict(ecl *EclConfig, fp *os.File, egroup *execgroup) (ok bool, err error) {
 	// get all signing entities fingerprints on the primary partition
-	keyfps, err := signing.GetSignEntitiesFp(fp)
+	status, err := signEntities(ecl, fp)
 	if err != nil {
 		return
 	}
 
-	// was the primary partition signed by all authorized entity?
-	m := map[string]bool{}
+	// was the primary partition validly signed by all authorized entity?
 	for _, v := range egroup.KeyFPs {
-		m[v] = false
-		for _, u := range keyfps {
-			if v == u {
-				m[v] = true
-			}
-		}
-	}
-	for _, v := range m {
-		if v != true {
-			return false, fmt.Errorf("%s is not signed by required entities", fp.Name())
+		if err, signed := status[v]; !signed || err != nil {
+			return false, notSignedError(fp, egroup, status)
 		}
 	}
 
 	return true, nil
 }
 
-// checkBlackList evaluates authorization by requiring all entities to be absent
+// checkBlackList evaluates authorization by requiring all entities to be absent,
+// signatures of forbidden entities are rejected even if they are not valid
 func checkBlackList(fp *os.File, egroup *execgroup) (ok bool, err error) {
 	// get all signing entities fingerprints on the primary partition
 	keyfps, err := signing.GetSignEntitiesFp(fp)
@@ -173,18 +326,32 @@ func checkBlackList(fp *os.File, egroup *execgroup) (ok bool, err error) {
 func shouldRun(ecl *EclConfig, fp *os.File) (ok bool, err error) {
 	var egroup *execgroup
 
-	// look what execgroup a container is part of
-	for _, v := range ecl.ExecGroups {
-		if filepath.Dir(fp.Name()) == v.DirPath {
-			egroup = &v
-			break
+	path, err := filepath.Abs(fp.Name())
+	if err != nil {
+		return false, err
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	dir := filepath.Dir(path)
+
+	// look what execgroup a container is part of, the one with the
+	// highest precedence is selected when several execgroups match
+	rank := -1
+	for i := range ecl.ExecGroups {
+		v := &ecl.ExecGroups[i]
+		if v.DirPath == "" || !v.match(dir) {
+			continue
+		}
+		if r := v.rank(dir); r > rank {
+			egroup, rank = v, r
 		}
 	}
 	// go back at it and this time look for an empty dirpath execgroup to fallback into
 	if egroup == nil {
-		for _, v := range ecl.ExecGroups {
-			if v.DirPath == "" {
-				egroup = &v
+		for i := range ecl.ExecGroups {
+			if ecl.ExecGroups[i].DirPath == "" {
+				egroup = &ecl.ExecGroups[i]
 				break
 			}
 		}
@@ -194,11 +361,26 @@ func shouldRun(ecl *EclConfig, fp *os.File) (ok bool, err error) {
 		return false, fmt.Errorf("%s not part of any execgroup", fp.Name())
 	}
 
-	switch egroup.ListMode {
+	if egroup.ListMode == "audit" {
+		ok, err = checkListMode(ecl, fp, egroup, egroup.AuditMode)
+		if err != nil {
+			sylog.Warningf("ECL audit: %s would not be allowed to run by execgroup %s: %s", fp.Name(), egroup.TagName, err)
+		} else {
+			sylog.Infof("ECL audit: %s would be allowed to run by execgroup %s", fp.Name(), egroup.TagName)
+		}
+		return true, nil
+	}
+
+	return checkListMode(ecl, fp, egroup, egroup.ListMode)
+}
+
+// checkListMode evaluates authorization of a container according to mode
+func checkListMode(ecl *EclConfig, fp *os.File, egroup *execgroup, mode string) (ok bool, err error) {
+	switch mode {
 	case "whitelist":
-		return checkWhiteList(fp, egroup)
+		return checkWhiteList(ecl, fp, egroup)
 	case "whitestrict":
-		return checkWhiteStrict(fp, egroup)
+		return checkWhiteStrict(ecl, fp, egroup)
 	case "blacklist":
 		return checkBlackList(fp, egroup)
 	}
@@ -1,4 +1,4 @@
-// Copyright (c) 2018, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2019, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -6,22 +6,36 @@
 package syecl
 
 import (
+	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+
+	useragent "github.com/sylabs/singularity/pkg/util/user-agent"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
 )
 
 const (
-	KeyFP1 = "5994BE54C31CF1B5E1994F987C52CF6D055F072B"
-	KeyFP2 = "7064B1D6EFF01B1262FED3F03581D99FE87EAFD1"
+	KeyFP1 = "7AA6BD2273A709C129DC3437C9B1AAEA760254F9"
+	KeyFP2 = "3D7FC1D8EB7658B1D90BF29E124C67CE1E694697"
+	// KeyFP3 signs the test containers but isn't in the test keyrings
+	KeyFP3 = "C9DC6FA6F6C1F7FF06D169BCE5DCB7DA6D4C8C11"
 )
 
 var (
 	srcContainer1 = filepath.Join("testdata", "container1.sif")
 	srcContainer2 = filepath.Join("testdata", "container2.sif")
 	srcContainer3 = filepath.Join("testdata", "container3.sif")
+	// keyring holding KeyFP1 and KeyFP2 public keys
+	testKeyring = filepath.Join("testdata", "pgp-public")
+	// keyring holding KeyFP1 revoked before the containers were signed and KeyFP2
+	testRevokedKeyring = filepath.Join("testdata", "pgp-public-revoked")
 )
 
 var (
@@ -39,17 +53,17 @@ var (
 var testEclConfig = EclConfig{
 	Activated: true,
 	ExecGroups: []execgroup{
-		{"group1", "whitelist", "", []string{KeyFP1, KeyFP2}},
-		{"group2", "whitestrict", "", []string{KeyFP1, KeyFP2}},
-		{"group3", "blacklist", "", []string{KeyFP1}},
+		{"group1", "whitelist", "", []string{KeyFP1, KeyFP2}, false, ""},
+		{"group2", "whitestrict", "", []string{KeyFP1, KeyFP2}, false, ""},
+		{"group3", "blacklist", "", []string{KeyFP1}, false, ""},
 	},
 }
 
 var testEclConfig2 = EclConfig{
 	Activated: true,
 	ExecGroups: []execgroup{
-		{"pathdup", "whitelist", "/tmp", nil},
-		{"pathdup", "whitelist", "/tmp", nil},
+		{"pathdup", "whitelist", "/tmp", nil, false, ""},
+		{"pathdup", "whitelist", "/tmp", nil, false, ""},
 	},
 }
 
@@ -156,6 +170,362 @@ func TestShouldRun(t *testing.T) {
 	}
 }
 
+func TestValidateConfigGroups(t *testing.T) {
+	tests := []struct {
+		name       string
+		groups     []execgroup
+		shouldPass bool
+	}{
+		{
+			name: "nested directory trees",
+			groups: []execgroup{
+				{"tree", "whitelist", filepath.Dir(testEclDirPath1), nil, true, ""},
+				{"nested", "blacklist", testEclDirPath1, nil, true, ""},
+			},
+			shouldPass: true,
+		},
+		{
+			name: "glob and plain dirpath",
+			groups: []execgroup{
+				{"glob", "whitelist", "/tmp/ecldir*", nil, false, ""},
+				{"plain", "blacklist", testEclDirPath1, nil, false, ""},
+			},
+			shouldPass: true,
+		},
+		{
+			name: "disjoint globs",
+			groups: []execgroup{
+				{"glob1", "whitelist", "/tmp/a*", nil, false, ""},
+				{"glob2", "blacklist", "/tmp/b*", nil, true, ""},
+			},
+			shouldPass: true,
+		},
+		{
+			name: "overlapping globs",
+			groups: []execgroup{
+				{"glob1", "whitelist", "/tmp/a*", nil, false, ""},
+				{"glob2", "blacklist", "/tmp/*b", nil, true, ""},
+			},
+			shouldPass: false,
+		},
+		{
+			name: "overlapping glob components",
+			groups: []execgroup{
+				{"glob1", "whitelist", "/tmp/*/sub", nil, false, ""},
+				{"glob2", "blacklist", "/tmp/ecl*/s?b", nil, false, ""},
+			},
+			shouldPass: false,
+		},
+		{
+			name: "invalid pattern",
+			groups: []execgroup{
+				{"glob", "whitelist", "/tmp/[", nil, false, ""},
+			},
+			shouldPass: false,
+		},
+		{
+			name: "relative pattern",
+			groups: []execgroup{
+				{"glob", "whitelist", "tmp/*", nil, false, ""},
+			},
+			shouldPass: false,
+		},
+		{
+			name: "audit",
+			groups: []execgroup{
+				{"audit", "audit", testEclDirPath1, []string{KeyFP1}, true, "whitestrict"},
+			},
+			shouldPass: true,
+		},
+		{
+			name: "audit without auditmode",
+			groups: []execgroup{
+				{"audit", "audit", testEclDirPath1, []string{KeyFP1}, true, ""},
+			},
+			shouldPass: false,
+		},
+		{
+			name: "auditmode without audit",
+			groups: []execgroup{
+				{"audit", "whitelist", testEclDirPath1, []string{KeyFP1}, true, "blacklist"},
+			},
+			shouldPass: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ecl := EclConfig{Activated: true, ExecGroups: tt.groups}
+			err := ecl.ValidateConfig()
+			if tt.shouldPass && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			} else if !tt.shouldPass && err == nil {
+				t.Errorf("unexpected success")
+			}
+		})
+	}
+}
+
+func TestShouldRunTree(t *testing.T) {
+	// container3 is only signed by KeyFP1
+	subDir := filepath.Join(testEclDirPath1, "sub")
+	deepDir := filepath.Join(subDir, "deep")
+	if err := os.MkdirAll(deepDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	subContainer := filepath.Join(subDir, filepath.Base(srcContainer3))
+	if err := copyFile(subContainer, srcContainer3); err != nil {
+		t.Fatal(err)
+	}
+	deepContainer := filepath.Join(deepDir, filepath.Base(srcContainer3))
+	if err := copyFile(deepContainer, srcContainer3); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		groups     []execgroup
+		container  string
+		shouldPass bool
+	}{
+		{
+			name: "parent directory",
+			groups: []execgroup{
+				{"parent", "whitelist", testEclDirPath1, []string{KeyFP1}, false, ""},
+			},
+			container:  deepContainer,
+			shouldPass: false,
+		},
+		{
+			name: "directory tree",
+			groups: []execgroup{
+				{"tree", "whitelist", testEclDirPath1, []string{KeyFP1}, true, ""},
+			},
+			container:  deepContainer,
+			shouldPass: true,
+		},
+		{
+			name: "deepest directory tree",
+			groups: []execgroup{
+				{"tree", "whitelist", testEclDirPath1, []string{KeyFP1}, true, ""},
+				{"subtree", "blacklist", subDir, []string{KeyFP1}, true, ""},
+			},
+			container:  deepContainer,
+			shouldPass: false,
+		},
+		{
+			name: "glob",
+			groups: []execgroup{
+				{"tree", "whitelist", testEclDirPath1, []string{KeyFP1}, true, ""},
+				{"glob", "blacklist", testEclDirPath1 + "/s*", []string{KeyFP1}, false, ""},
+			},
+			container:  subContainer,
+			shouldPass: false,
+		},
+		{
+			name: "glob not recursive",
+			groups: []execgroup{
+				{"tree", "whitelist", testEclDirPath1, []string{KeyFP1}, true, ""},
+				{"glob", "blacklist", testEclDirPath1 + "/s*", []string{KeyFP1}, false, ""},
+			},
+			container:  deepContainer,
+			shouldPass: true,
+		},
+		{
+			name: "glob directory tree",
+			groups: []execgroup{
+				{"glob", "blacklist", filepath.Dir(testEclDirPath1) + "/ecldir1-*", []string{KeyFP1}, true, ""},
+			},
+			container:  deepContainer,
+			shouldPass: false,
+		},
+		{
+			name: "audit",
+			groups: []execgroup{
+				{"audit", "audit", testEclDirPath1, []string{KeyFP1}, true, "blacklist"},
+			},
+			container:  deepContainer,
+			shouldPass: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ecl := EclConfig{Activated: true, ExecGroups: tt.groups}
+			if err := ecl.ValidateConfig(); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			run, err := ecl.ShouldRun(tt.container)
+			if tt.shouldPass && (err != nil || !run) {
+				t.Errorf("%s should be allowed to run: %v", tt.container, err)
+			} else if !tt.shouldPass && (err == nil || run) {
+				t.Errorf("%s should NOT be allowed to run", tt.container)
+			}
+		})
+	}
+}
+
+// useKeyring makes the ECL verify signatures with the keys of path
+func useKeyring(path string) {
+	keyringPath = path
+}
+
+func TestShouldRunKeyValidity(t *testing.T) {
+	defer useKeyring(testKeyring)
+
+	ecl := EclConfig{
+		Activated: true,
+		ExecGroups: []execgroup{
+			{"group1", "whitelist", testEclDirPath1, []string{KeyFP1}, false, ""},
+			{"group2", "whitestrict", testEclDirPath2, []string{KeyFP1, KeyFP2}, false, ""},
+			{"group3", "audit", testEclDirPath3, []string{KeyFP1}, false, "whitelist"},
+		},
+	}
+	if err := ecl.ValidateConfig(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tests := []struct {
+		name       string
+		keyring    string
+		container  string
+		shouldPass bool
+		reason     string
+	}{
+		{"whitelist", testKeyring, testContainer1, true, ""},
+		{"whitelist revoked", testRevokedKeyring, testContainer1, false, "key " + KeyFP1 + " was revoked on 2019-02-01T00:00:00Z"},
+		{"whitestrict revoked", testRevokedKeyring, testContainer2, false, "key " + KeyFP1 + " was revoked"},
+		{"audit revoked", testRevokedKeyring, testContainer4, true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useKeyring(tt.keyring)
+			run, err := ecl.ShouldRun(tt.container)
+			if tt.shouldPass && (err != nil || !run) {
+				t.Errorf("%s should be allowed to run: %v", tt.container, err)
+			} else if !tt.shouldPass && (err == nil || run) {
+				t.Errorf("%s should NOT be allowed to run", tt.container)
+			} else if err != nil && !strings.Contains(err.Error(), tt.reason) {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+
+	// a signature is only accepted if the public key is available
+	ecl.ExecGroups[0].KeyFPs = []string{KeyFP3}
+	run, err := ecl.ShouldRun(testContainer1)
+	if err == nil || run {
+		t.Errorf("%s should NOT be allowed to run without the public key", testContainer1)
+	} else if !strings.Contains(err.Error(), "public key missing") {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+// keyserver serves the public keys of the keyring path, or none if empty
+func keyserver(t *testing.T, path *string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if *path == "" || r.URL.Path != "/pks/lookup" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		f, err := os.Open(*path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		el, err := openpgp.ReadKeyRing(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		search := strings.TrimPrefix(r.URL.Query().Get("search"), "0x")
+		for _, e := range el {
+			if fmt.Sprintf("%X", e.PrimaryKey.Fingerprint) != search {
+				continue
+			}
+			// Entity.Serialize doesn't write revocations
+			aw, err := armor.Encode(w, openpgp.PublicKeyType, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e.PrimaryKey.Serialize(aw)
+			for _, sig := range e.Revocations {
+				sig.Serialize(aw)
+			}
+			for _, ident := range e.Identities {
+				ident.UserId.Serialize(aw)
+				ident.SelfSignature.Serialize(aw)
+			}
+			for _, subkey := range e.Subkeys {
+				subkey.PublicKey.Serialize(aw)
+				subkey.Sig.Serialize(aw)
+			}
+			aw.Close()
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+}
+
+func TestShouldRunKeyserver(t *testing.T) {
+	defer useKeyring(testKeyring)
+
+	var served string
+	s := keyserver(t, &served)
+	defer s.Close()
+
+	ecl := EclConfig{
+		Activated: true,
+		Keyserver: s.URL,
+		ExecGroups: []execgroup{
+			{"group1", "whitelist", testEclDirPath1, []string{KeyFP1}, false, ""},
+		},
+	}
+	if err := ecl.ValidateConfig(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	missing := filepath.Join(testEclDirPath3, "missing")
+
+	tests := []struct {
+		name       string
+		keyring    string
+		served     string
+		keyserver  string
+		shouldPass bool
+		reason     string
+	}{
+		{"local key", testKeyring, "", s.URL, true, ""},
+		{"revoked on key server", testKeyring, testRevokedKeyring, s.URL, false, "key " + KeyFP1 + " was revoked"},
+		{"fetched key", missing, testKeyring, s.URL, true, ""},
+		{"fetched revoked key", missing, testRevokedKeyring, s.URL, false, "key " + KeyFP1 + " was revoked"},
+		{"missing key", missing, "", s.URL, false, "public key missing"},
+		{"no key server", missing, testKeyring, "", false, "public key missing"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useKeyring(tt.keyring)
+			served = tt.served
+			ecl.Keyserver = tt.keyserver
+			run, err := ecl.ShouldRun(testContainer1)
+			if tt.shouldPass && (err != nil || !run) {
+				t.Errorf("%s should be allowed to run: %v", testContainer1, err)
+			} else if !tt.shouldPass && (err == nil || run) {
+				t.Errorf("%s should NOT be allowed to run", testContainer1)
+			} else if err != nil && !strings.Contains(err.Error(), tt.reason) {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+
+	ecl.Keyserver = "ftp://keys.example.com"
+	if err := ecl.ValidateConfig(); err == nil {
+		t.Errorf("unexpected success with an invalid keyserver URL")
+	}
+}
+
 func copyFile(dst, src string) error {
 	s, err := os.Open(src)
 	if err != nil {
@@ -239,6 +609,9 @@ func shutdown() {
 }
 
 func TestMain(m *testing.M) {
+	useragent.InitValue("singularity", "3.0.0-alpha.1-303-gaed8d30-dirty")
+	useKeyring(testKeyring)
+
 	if err := setup(); err != nil {
 		shutdown()
 		os.Exit(2)
@@ -1,4 +1,4 @@
-// Copyright (c) 2018, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2019, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -6,6 +6,7 @@
 package auth
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/sylabs/singularity/internal/pkg/test"
@@ -36,3 +37,15 @@ func Test_ReadToken(t *testing.T) {
 		t.Errorf("readToken from valid file must match expected result")
 	}
 }
+
+func Test_CheckToken(t *testing.T) {
+	if w := CheckToken(testToken); w != "" {
+		t.Errorf("valid token must not give a warning: %s", w)
+	}
+	if w := CheckToken("too short"); w != WarningTokenTooShort {
+		t.Errorf("short token must give %q, got %q", WarningTokenTooShort, w)
+	}
+	if w := CheckToken(strings.Repeat("a", 4097)); w != WarningTokenToolong {
+		t.Errorf("long token must give %q, got %q", WarningTokenToolong, w)
+	}
+}
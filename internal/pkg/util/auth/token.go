@@ -1,5 +1,5 @@
 /*
-  Copyright (c) 2018, Sylabs, Inc. All rights reserved.
+  Copyright (c) 2018-2019, Sylabs, Inc. All rights reserved.
 
   This software is licensed under a 3-clause BSD license.  Please
   consult LICENSE.md file distributed with the sources of this project regarding
@@ -45,17 +45,26 @@ func ReadToken(tokenPath string) (token, warning string) {
 		return "", WarningEmptyToken
 	}
 
-	// A valid RSA signed token is at least 200 chars with no extra payload
 	token = lines[0]
+	if warning = CheckToken(token); warning != "" {
+		return "", warning
+	}
+
+	return
+}
+
+// CheckToken returns a warning if token isn't a valid sylabs JWT auth token
+func CheckToken(token string) (warning string) {
+	// A valid RSA signed token is at least 200 chars with no extra payload
 	if len(token) < 200 {
-		return "", WarningTokenTooShort
+		return WarningTokenTooShort
 	}
 
 	// A token should never be bigger than 4Kb - if it is we will have problems
 	// with header buffers
 	if len(token) > 4096 {
-		return "", WarningTokenToolong
+		return WarningTokenToolong
 	}
 
-	return
+	return ""
 }
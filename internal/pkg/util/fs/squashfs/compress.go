@@ -0,0 +1,117 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package squashfs
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// decompressor returns the uncompressed content of src, size is the maximum
+// expected size of uncompressed data.
+type decompressor func(src []byte, size int) ([]byte, error)
+
+func getDecompressor(compression uint16) (decompressor, error) {
+	switch compression {
+	case CompGzip:
+		return gzipDecompress, nil
+	case CompLzma:
+		return lzmaDecompress, nil
+	case CompXz:
+		return xzDecompress, nil
+	case CompLz4:
+		return lz4Decompress, nil
+	case CompZstd:
+		return zstdDecompress, nil
+	}
+	return nil, fmt.Errorf("squashfs compression %s is not supported", CompressionName(int(compression)))
+}
+
+// CompressionName returns the name of the compression algorithm identified by c.
+func CompressionName(c int) string {
+	switch c {
+	case CompGzip:
+		return "gzip"
+	case CompLzma:
+		return "lzma"
+	case CompLzo:
+		return "lzo"
+	case CompXz:
+		return "xz"
+	case CompLz4:
+		return "lz4"
+	case CompZstd:
+		return "zstd"
+	}
+	return fmt.Sprintf("unknown (%d)", c)
+}
+
+func gzipDecompress(src []byte, size int) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return ioutil.ReadAll(io.LimitReader(zr, int64(size)))
+}
+
+func lzmaDecompress(src []byte, size int) ([]byte, error) {
+	lr, err := lzma.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(io.LimitReader(lr, int64(size)))
+}
+
+func xzDecompress(src []byte, size int) ([]byte, error) {
+	xr, err := xz.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(io.LimitReader(xr, int64(size)))
+}
+
+func lz4Decompress(src []byte, size int) ([]byte, error) {
+	dst := make([]byte, size)
+	n, err := lz4.UncompressBlock(src, dst)
+	if err != nil {
+		return nil, err
+	}
+	return dst[:n], nil
+}
+
+var zstdDecoder struct {
+	sync.Once
+	d   *zstd.Decoder
+	err error
+}
+
+func zstdDecompress(src []byte, size int) ([]byte, error) {
+	// the decoder is safe for concurrent use and expensive to create
+	zstdDecoder.Do(func() {
+		zstdDecoder.d, zstdDecoder.err = zstd.NewReader(nil)
+	})
+	if zstdDecoder.err != nil {
+		return nil, zstdDecoder.err
+	}
+	data, err := zstdDecoder.d.DecodeAll(src, make([]byte, 0, size))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > size {
+		return nil, fmt.Errorf("uncompressed data exceeds %d bytes", size)
+	}
+	return data, nil
+}
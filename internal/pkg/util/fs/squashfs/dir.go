@@ -0,0 +1,77 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package squashfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+type dirHeader struct {
+	Count  uint32
+	Start  uint32
+	Number uint32
+}
+
+type dirEntryHeader struct {
+	Offset      uint16
+	NumberDelta int16
+	Type        uint16
+	NameSize    uint16
+}
+
+type dirEntry struct {
+	name   string
+	ref    uint64
+	number uint32
+}
+
+// readDir returns the entries of directory in, the listing size stored in
+// the inode accounts for the implicit "." and ".." entries.
+func (r *Reader) readDir(in *inode) ([]dirEntry, error) {
+	if in.size <= 3 {
+		return nil, nil
+	}
+
+	m, err := r.newMetadataReader(int64(r.sb.DirectoryTableStart)+int64(in.dirBlock), int(in.dirOffset))
+	if err != nil {
+		return nil, err
+	}
+	lr := io.LimitReader(m, int64(in.size)-3)
+
+	var entries []dirEntry
+
+	for {
+		var hdr dirHeader
+		if err := binary.Read(lr, binary.LittleEndian, &hdr); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read directory header: %s", err)
+		}
+		if hdr.Count >= 256 {
+			return nil, fmt.Errorf("invalid directory header count %d", hdr.Count)
+		}
+
+		for i := uint32(0); i <= hdr.Count; i++ {
+			var e dirEntryHeader
+			if err := binary.Read(lr, binary.LittleEndian, &e); err != nil {
+				return nil, fmt.Errorf("failed to read directory entry: %s", err)
+			}
+			name := make([]byte, int(e.NameSize)+1)
+			if _, err := io.ReadFull(lr, name); err != nil {
+				return nil, fmt.Errorf("failed to read directory entry name: %s", err)
+			}
+			entries = append(entries, dirEntry{
+				name:   string(name),
+				ref:    uint64(hdr.Start)<<16 | uint64(e.Offset),
+				number: uint32(int64(hdr.Number) + int64(e.NumberDelta)),
+			})
+		}
+	}
+
+	return entries, nil
+}
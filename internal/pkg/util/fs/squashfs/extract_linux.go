@@ -0,0 +1,262 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package squashfs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/sylabs/singularity/internal/pkg/fakeroot"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"golang.org/x/sys/unix"
+)
+
+// extractor holds the state of an extraction.
+type extractor struct {
+	r *Reader
+	// privileged is true when files ownership can be restored
+	privileged bool
+	// userns is true when running in a user namespace, where device
+	// nodes can't be created even as root
+	userns bool
+	// paths of already extracted inodes with multiple links
+	links map[uint32]string
+	// layer is true when extracting an overlay layer, whose whiteouts
+	// remove the files they hide
+	layer bool
+}
+
+// overlayOpaque is the extended attribute marking the directories of an
+// overlay layer hiding the content of the lower layers
+const overlayOpaque = "trusted.overlay.opaque"
+
+// Extract extracts the content of the directory src found in the image
+// into the directory dst which is created if it doesn't exist, existing
+// files are overwritten. File ownership is restored only when running as
+// root, device nodes which can't be created by an unprivileged user or in a
+// user namespace and extended attributes not supported by the destination
+// are skipped with a warning.
+func (r *Reader) Extract(src, dst string) error {
+	return r.extract(src, dst, false)
+}
+
+// ExtractLayer extracts the content of the directory src found in the image
+// over the directory dst as an overlay layer: whiteouts, character devices
+// with 0/0 device numbers, remove the files they hide from dst and the
+// content of opaque directories replaces the existing one.
+func (r *Reader) ExtractLayer(src, dst string) error {
+	return r.extract(src, dst, true)
+}
+
+func (r *Reader) extract(src, dst string, layer bool) error {
+	in, err := r.lookup(src, true)
+	if err != nil {
+		return &os.PathError{Op: "extract", Path: src, Err: err}
+	}
+	if !in.isDir() {
+		return &os.PathError{Op: "extract", Path: src, Err: syscall.ENOTDIR}
+	}
+
+	e := &extractor{
+		r:          r,
+		privileged: os.Geteuid() == 0,
+		userns:     fakeroot.InUserNamespace(),
+		links:      make(map[uint32]string),
+		layer:      layer,
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %s", filepath.Dir(dst), err)
+	}
+	if err := e.mkdir(dst); err != nil {
+		return err
+	}
+	if err := e.extractDir(in, dst); err != nil {
+		return err
+	}
+	return e.setAttributes(in, dst)
+}
+
+// extractDir extracts the entries of directory in into dst.
+func (e *extractor) extractDir(in *inode, dst string) error {
+	entries, err := e.r.readDir(in)
+	if err != nil {
+		return fmt.Errorf("failed to read directory %s: %s", dst, err)
+	}
+
+	for _, entry := range entries {
+		if entry.name == "" || entry.name == "." || entry.name == ".." || strings.Contains(entry.name, "/") {
+			return fmt.Errorf("invalid file name %q in directory %s", entry.name, dst)
+		}
+
+		child, err := e.r.readInode(entry.ref)
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dst, entry.name)
+
+		if err := e.extract(child, path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// extract creates the file described by inode in at path.
+func (e *extractor) extract(in *inode, path string) error {
+	if e.layer && in.isWhiteout() {
+		if err := removeAll(path); err != nil {
+			return fmt.Errorf("failed to remove %s: %s", path, err)
+		}
+		return nil
+	}
+
+	replace := false
+	if e.layer && in.isDir() {
+		xattrs, err := e.r.readXattrs(in)
+		if err != nil {
+			return fmt.Errorf("failed to read extended attributes of %s: %s", path, err)
+		}
+		replace = string(xattrs[overlayOpaque]) == "y"
+	}
+
+	if fi, err := os.Lstat(path); err == nil {
+		if !in.isDir() || !fi.IsDir() || replace {
+			if err := removeAll(path); err != nil {
+				return fmt.Errorf("failed to remove %s: %s", path, err)
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if !in.isDir() && in.nlink > 1 {
+		if target, ok := e.links[in.Number]; ok {
+			if err := os.Link(target, path); err != nil {
+				return fmt.Errorf("failed to create hard link %s: %s", path, err)
+			}
+			return nil
+		}
+	}
+
+	switch {
+	case in.isDir():
+		if err := e.mkdir(path); err != nil {
+			return err
+		}
+		// directory attributes are set once its content is extracted
+		if err := e.extractDir(in, path); err != nil {
+			return err
+		}
+	case in.isRegular():
+		if err := e.extractFile(in, path); err != nil {
+			return err
+		}
+	case in.isSymlink():
+		if err := os.Symlink(in.target, path); err != nil {
+			return fmt.Errorf("failed to create symbolic link %s: %s", path, err)
+		}
+	default:
+		if err := unix.Mknod(path, in.fileType()|0600, int(in.rdev)); err != nil {
+			if err == syscall.EPERM && (!e.privileged || e.userns) {
+				sylog.Warningf("Skipping %s: can't create special file as an unprivileged user", path)
+				return nil
+			}
+			return fmt.Errorf("failed to create special file %s: %s", path, err)
+		}
+	}
+
+	if !in.isDir() && in.nlink > 1 {
+		e.links[in.Number] = path
+	}
+
+	return e.setAttributes(in, path)
+}
+
+// removeAll removes path and its content, including the content of
+// read-only directories.
+func removeAll(path string) error {
+	if err := os.RemoveAll(path); err == nil || !os.IsPermission(err) {
+		return err
+	}
+	filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+		if err == nil && fi.IsDir() {
+			os.Chmod(p, 0700)
+		}
+		return nil
+	})
+	return os.RemoveAll(path)
+}
+
+// mkdir creates the directory path if it doesn't exist and ensures
+// its content can be modified during the extraction.
+func (e *extractor) mkdir(path string) error {
+	if err := os.Mkdir(path, 0700); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("failed to create directory %s: %s", path, err)
+	}
+	if err := os.Chmod(path, 0700); err != nil {
+		return fmt.Errorf("failed to change permissions of %s: %s", path, err)
+	}
+	return nil
+}
+
+func (e *extractor) extractFile(in *inode, path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %s", path, err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, e.r.newFileReader(in)); err != nil {
+		return fmt.Errorf("failed to extract %s: %s", path, err)
+	}
+	return f.Close()
+}
+
+// setAttributes restores ownership, extended attributes, permissions and
+// modification time of the file at path.
+func (e *extractor) setAttributes(in *inode, path string) error {
+	if e.privileged {
+		// IDs not mapped in a fake root user namespace are replaced
+		if err := fakeroot.Lchown(path, int(in.uid), int(in.gid)); err != nil {
+			return fmt.Errorf("failed to change owner of %s: %s", path, err)
+		}
+	}
+
+	xattrs, err := e.r.readXattrs(in)
+	if err != nil {
+		return fmt.Errorf("failed to read extended attributes of %s: %s", path, err)
+	}
+	for name, value := range xattrs {
+		if e.layer && name == overlayOpaque {
+			continue
+		}
+		if err := unix.Lsetxattr(path, name, value, 0); err != nil {
+			sylog.Warningf("Skipping extended attribute %s of %s: %s", name, path, err)
+		}
+	}
+
+	// permissions are set after ownership as chown clears setuid/setgid bits
+	if !in.isSymlink() {
+		if err := unix.Chmod(path, uint32(in.Mode&07777)); err != nil {
+			return fmt.Errorf("failed to change permissions of %s: %s", path, err)
+		}
+	}
+
+	ts := []unix.Timespec{
+		{Sec: int64(in.Mtime)},
+		{Sec: int64(in.Mtime)},
+	}
+	if err := unix.UtimesNanoAt(unix.AT_FDCWD, path, ts, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return fmt.Errorf("failed to change modification time of %s: %s", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package squashfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sylabs/singularity/internal/pkg/test"
+)
+
+func checkExtracted(t *testing.T, dir string) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, "file"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(b) != "hard linked content\n" {
+		t.Errorf("unexpected content %q", b)
+	}
+
+	var file, link syscall.Stat_t
+	if err := syscall.Lstat(filepath.Join(dir, "file"), &file); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := syscall.Lstat(filepath.Join(dir, "dir", "link"), &link); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if file.Ino != link.Ino {
+		t.Errorf("file and dir/link are not hard links")
+	}
+	if file.Mode&07777 != 0640 {
+		t.Errorf("unexpected file permissions %o", file.Mode&07777)
+	}
+	if file.Mtim.Sec != time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC).Unix() {
+		t.Errorf("unexpected file modification time %d", file.Mtim.Sec)
+	}
+
+	fi, err := os.Lstat(filepath.Join(dir, "fifo"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if fi.Mode()&os.ModeNamedPipe == 0 {
+		t.Errorf("fifo is not a named pipe")
+	}
+
+	fi, err = os.Lstat(filepath.Join(dir, "dir"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if fi.Mode().Perm() != 0555 {
+		t.Errorf("unexpected directory permissions %s", fi.Mode())
+	}
+}
+
+func TestExtract(t *testing.T) {
+	r, close := openImage(t, "testdata/special.sqfs")
+	defer close()
+
+	t.Run("unprivileged", test.WithoutPrivilege(func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "squashfs-extract-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		// read-only directory content must be removable
+		defer os.Chmod(filepath.Join(dir, "dir"), 0755)
+
+		if err := r.Extract("/", dir); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		checkExtracted(t, dir)
+
+		if _, err := os.Lstat(filepath.Join(dir, "dev", "null")); err == nil {
+			t.Errorf("unexpected device node creation")
+		}
+
+		// extracting again overwrites existing files
+		if err := r.Extract("/", dir); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}))
+
+	t.Run("privileged", test.WithPrivilege(func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "squashfs-extract-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		if err := r.Extract("/", dir); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		checkExtracted(t, dir)
+
+		var st syscall.Stat_t
+		if err := syscall.Lstat(filepath.Join(dir, "dev", "null"), &st); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if st.Mode&syscall.S_IFMT != syscall.S_IFCHR || st.Rdev != 0x103 {
+			t.Errorf("dev/null is not the null character device")
+		}
+		if st.Uid != 0 || st.Gid != 0 {
+			t.Errorf("unexpected owner %d:%d", st.Uid, st.Gid)
+		}
+	}))
+
+	if err := r.Extract("/file", os.TempDir()); err == nil {
+		t.Errorf("unexpected success while extracting a regular file")
+	}
+}
+
+func TestExtractLayer(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	base, closeBase := openImage(t, "testdata/special.sqfs")
+	defer closeBase()
+	layer, closeLayer := openImage(t, "testdata/layer.sqfs")
+	defer closeLayer()
+
+	dir, err := ioutil.TempDir("", "squashfs-extract-layer-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Chmod(filepath.Join(dir, "dir"), 0755)
+
+	if err := base.Extract("/", dir); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := layer.ExtractLayer("/", dir); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// files of the layer replace the existing ones
+	b, err := ioutil.ReadFile(filepath.Join(dir, "file"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(b) != "layer content\n" {
+		t.Errorf("unexpected content %q", b)
+	}
+	// whiteouts remove files
+	if _, err := os.Lstat(filepath.Join(dir, "fifo")); !os.IsNotExist(err) {
+		t.Errorf("whited out fifo was not removed: %v", err)
+	}
+	// opaque directories replace the existing ones
+	if _, err := os.Lstat(filepath.Join(dir, "dir", "link")); !os.IsNotExist(err) {
+		t.Errorf("content of opaque directory was not removed: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "dir", "new")); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	// files missing from the layer are kept
+	if _, err := os.Lstat(filepath.Join(dir, "dev")); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	// whiteouts are character devices when not extracted as a layer
+	if err := layer.Extract("/", filepath.Join(dir, "plain")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "plain", "dir", "new")); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
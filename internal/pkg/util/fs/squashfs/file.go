@@ -0,0 +1,112 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package squashfs
+
+import (
+	"fmt"
+	"io"
+)
+
+const (
+	blockUncompressed = 1 << 24
+	blockSizeMask     = blockUncompressed - 1
+)
+
+// fileReader reads the content of a regular file block by block.
+type fileReader struct {
+	r         *Reader
+	in        *inode
+	block     int
+	offset    int64
+	remaining uint64
+	buf       []byte
+}
+
+func (r *Reader) newFileReader(in *inode) *fileReader {
+	return &fileReader{
+		r:         r,
+		in:        in,
+		offset:    int64(in.blocksStart),
+		remaining: in.size,
+	}
+}
+
+func (f *fileReader) Read(p []byte) (int, error) {
+	for len(f.buf) == 0 {
+		if f.remaining == 0 {
+			return 0, io.EOF
+		}
+		if err := f.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, f.buf)
+	f.buf = f.buf[n:]
+	return n, nil
+}
+
+// next loads the next data block or the fragment holding the tail end of the file.
+func (f *fileReader) next() error {
+	blockSize := uint64(f.r.sb.BlockSize)
+	n := f.remaining
+	if n > blockSize {
+		n = blockSize
+	}
+
+	if f.block < len(f.in.blockSizes) {
+		size := f.in.blockSizes[f.block]
+		f.block++
+
+		if size&blockSizeMask == 0 {
+			// sparse block
+			f.buf = make([]byte, n)
+		} else {
+			data, err := f.r.readBlock(f.offset, size)
+			if err != nil {
+				return err
+			}
+			f.offset += int64(size & blockSizeMask)
+			if uint64(len(data)) < n {
+				return fmt.Errorf("data block %d is truncated", f.block-1)
+			}
+			f.buf = data[:n]
+		}
+	} else {
+		if f.in.fragment == noFragment {
+			return fmt.Errorf("missing data for inode %d", f.in.Number)
+		}
+		frag := f.r.fragments[f.in.fragment]
+		data, err := f.r.readBlock(int64(frag.Start), frag.Size)
+		if err != nil {
+			return err
+		}
+		end := uint64(f.in.fragOffset) + n
+		if end > uint64(len(data)) {
+			return fmt.Errorf("fragment %d is truncated", f.in.fragment)
+		}
+		f.buf = data[f.in.fragOffset:end]
+	}
+
+	f.remaining -= n
+	return nil
+}
+
+// readBlock reads a data block located at offset off, size is the on disk
+// size of the block with the uncompressed flag.
+func (r *Reader) readBlock(off int64, size uint32) ([]byte, error) {
+	data := make([]byte, size&blockSizeMask)
+	if _, err := r.r.ReadAt(data, off); err != nil {
+		return nil, fmt.Errorf("failed to read data block at offset %d: %s", off, err)
+	}
+	if size&blockUncompressed != 0 {
+		return data, nil
+	}
+	data, err := r.decompress(data, int(r.sb.BlockSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress data block at offset %d: %s", off, err)
+	}
+	return data, nil
+}
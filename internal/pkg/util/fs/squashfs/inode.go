@@ -0,0 +1,304 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package squashfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+// inode types
+const (
+	typeDir = iota + 1
+	typeFile
+	typeSymlink
+	typeBlockDev
+	typeCharDev
+	typeFifo
+	typeSocket
+	typeExtDir
+	typeExtFile
+	typeExtSymlink
+	typeExtBlockDev
+	typeExtCharDev
+	typeExtFifo
+	typeExtSocket
+)
+
+const noXattr = 0xffffffff
+
+type inodeHeader struct {
+	Type   uint16
+	Mode   uint16
+	UID    uint16
+	GID    uint16
+	Mtime  uint32
+	Number uint32
+}
+
+// inode holds the information of a file, fields are set depending
+// on the type of the file.
+type inode struct {
+	inodeHeader
+	uid   uint32
+	gid   uint32
+	nlink uint32
+	size  uint64
+	xattr uint32
+	// directory listing location
+	dirBlock  uint32
+	dirOffset uint16
+	// regular file data location
+	blocksStart uint64
+	fragment    uint32
+	fragOffset  uint32
+	blockSizes  []uint32
+	// symbolic link target
+	target string
+	// device number, encoded like the kernel dev_t
+	rdev uint32
+}
+
+func (i *inode) isDir() bool {
+	return i.Type == typeDir || i.Type == typeExtDir
+}
+
+func (i *inode) isRegular() bool {
+	return i.Type == typeFile || i.Type == typeExtFile
+}
+
+func (i *inode) isSymlink() bool {
+	return i.Type == typeSymlink || i.Type == typeExtSymlink
+}
+
+// isWhiteout returns whether the inode is an overlay whiteout, a character
+// device with 0/0 device numbers.
+func (i *inode) isWhiteout() bool {
+	return (i.Type == typeCharDev || i.Type == typeExtCharDev) && i.rdev == 0
+}
+
+// fileType returns the file type bits of the inode as found in the
+// st_mode field of a stat structure.
+func (i *inode) fileType() uint32 {
+	switch i.Type {
+	case typeDir, typeExtDir:
+		return syscall.S_IFDIR
+	case typeSymlink, typeExtSymlink:
+		return syscall.S_IFLNK
+	case typeBlockDev, typeExtBlockDev:
+		return syscall.S_IFBLK
+	case typeCharDev, typeExtCharDev:
+		return syscall.S_IFCHR
+	case typeFifo, typeExtFifo:
+		return syscall.S_IFIFO
+	case typeSocket, typeExtSocket:
+		return syscall.S_IFSOCK
+	}
+	return syscall.S_IFREG
+}
+
+// readInode reads the inode referenced by ref, a reference is the location of
+// the metadata block relative to the inode table in its upper 48 bits and the
+// offset of the inode in the uncompressed block in its lower 16 bits.
+func (r *Reader) readInode(ref uint64) (*inode, error) {
+	m, err := r.newMetadataReader(int64(r.sb.InodeTableStart+ref>>16), int(ref&0xffff))
+	if err != nil {
+		return nil, err
+	}
+
+	in := &inode{xattr: noXattr}
+	if err := binary.Read(m, binary.LittleEndian, &in.inodeHeader); err != nil {
+		return nil, fmt.Errorf("failed to read inode header: %s", err)
+	}
+	if int(in.UID) >= len(r.ids) || int(in.GID) >= len(r.ids) {
+		return nil, fmt.Errorf("inode %d has an invalid owner index", in.Number)
+	}
+	in.uid = r.ids[in.UID]
+	in.gid = r.ids[in.GID]
+
+	switch in.Type {
+	case typeDir:
+		var d struct {
+			Block  uint32
+			Nlink  uint32
+			Size   uint16
+			Offset uint16
+			Parent uint32
+		}
+		err = binary.Read(m, binary.LittleEndian, &d)
+		in.dirBlock, in.nlink, in.size, in.dirOffset = d.Block, d.Nlink, uint64(d.Size), d.Offset
+	case typeExtDir:
+		var d struct {
+			Nlink      uint32
+			Size       uint32
+			Block      uint32
+			Parent     uint32
+			IndexCount uint16
+			Offset     uint16
+			Xattr      uint32
+		}
+		err = binary.Read(m, binary.LittleEndian, &d)
+		in.nlink, in.size, in.dirBlock, in.dirOffset, in.xattr = d.Nlink, uint64(d.Size), d.Block, d.Offset, d.Xattr
+	case typeFile:
+		var f struct {
+			BlocksStart uint32
+			Fragment    uint32
+			Offset      uint32
+			Size        uint32
+		}
+		err = binary.Read(m, binary.LittleEndian, &f)
+		in.nlink, in.blocksStart, in.fragment, in.fragOffset, in.size = 1, uint64(f.BlocksStart), f.Fragment, f.Offset, uint64(f.Size)
+		if err == nil {
+			err = r.readBlockSizes(m, in)
+		}
+	case typeExtFile:
+		var f struct {
+			BlocksStart uint64
+			Size        uint64
+			Sparse      uint64
+			Nlink       uint32
+			Fragment    uint32
+			Offset      uint32
+			Xattr       uint32
+		}
+		err = binary.Read(m, binary.LittleEndian, &f)
+		in.blocksStart, in.size, in.nlink, in.fragment, in.fragOffset, in.xattr = f.BlocksStart, f.Size, f.Nlink, f.Fragment, f.Offset, f.Xattr
+		if err == nil {
+			err = r.readBlockSizes(m, in)
+		}
+	case typeSymlink, typeExtSymlink:
+		var s struct {
+			Nlink uint32
+			Size  uint32
+		}
+		if err = binary.Read(m, binary.LittleEndian, &s); err != nil {
+			break
+		}
+		if s.Size > 4096 {
+			return nil, fmt.Errorf("inode %d has an invalid symbolic link size", in.Number)
+		}
+		target := make([]byte, s.Size)
+		if _, err = io.ReadFull(m, target); err != nil {
+			break
+		}
+		in.nlink, in.size, in.target = s.Nlink, uint64(s.Size), string(target)
+		if in.Type == typeExtSymlink {
+			err = binary.Read(m, binary.LittleEndian, &in.xattr)
+		}
+	case typeBlockDev, typeCharDev, typeExtBlockDev, typeExtCharDev:
+		var d struct {
+			Nlink uint32
+			Rdev  uint32
+		}
+		err = binary.Read(m, binary.LittleEndian, &d)
+		in.nlink, in.rdev = d.Nlink, d.Rdev
+		if err == nil && (in.Type == typeExtBlockDev || in.Type == typeExtCharDev) {
+			err = binary.Read(m, binary.LittleEndian, &in.xattr)
+		}
+	case typeFifo, typeSocket, typeExtFifo, typeExtSocket:
+		err = binary.Read(m, binary.LittleEndian, &in.nlink)
+		if err == nil && (in.Type == typeExtFifo || in.Type == typeExtSocket) {
+			err = binary.Read(m, binary.LittleEndian, &in.xattr)
+		}
+	default:
+		return nil, fmt.Errorf("inode %d has an unknown type %d", in.Number, in.Type)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inode %d: %s", in.Number, err)
+	}
+
+	return in, nil
+}
+
+// readBlockSizes reads the list of data blocks sizes following a regular
+// file inode, the tail end of the file is stored in a fragment if any.
+func (r *Reader) readBlockSizes(m io.Reader, in *inode) error {
+	count := in.size / uint64(r.sb.BlockSize)
+	if in.fragment == noFragment && in.size%uint64(r.sb.BlockSize) != 0 {
+		count++
+	}
+	if in.fragment != noFragment && int(in.fragment) >= len(r.fragments) {
+		return fmt.Errorf("invalid fragment index %d", in.fragment)
+	}
+	if count > r.sb.BytesUsed {
+		return fmt.Errorf("invalid file size %d", in.size)
+	}
+
+	in.blockSizes = make([]uint32, count)
+	return binary.Read(m, binary.LittleEndian, in.blockSizes)
+}
+
+// fileInfo implements os.FileInfo for files stored in a squashfs image.
+type fileInfo struct {
+	name string
+	in   *inode
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return int64(fi.in.size)
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	mode := os.FileMode(fi.in.Mode & 0777)
+
+	if fi.in.Mode&0x800 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if fi.in.Mode&0x400 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if fi.in.Mode&0x200 != 0 {
+		mode |= os.ModeSticky
+	}
+
+	switch fi.in.Type {
+	case typeDir, typeExtDir:
+		mode |= os.ModeDir
+	case typeSymlink, typeExtSymlink:
+		mode |= os.ModeSymlink
+	case typeBlockDev, typeExtBlockDev:
+		mode |= os.ModeDevice
+	case typeCharDev, typeExtCharDev:
+		mode |= os.ModeDevice | os.ModeCharDevice
+	case typeFifo, typeExtFifo:
+		mode |= os.ModeNamedPipe
+	case typeSocket, typeExtSocket:
+		mode |= os.ModeSocket
+	}
+
+	return mode
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return time.Unix(int64(fi.in.Mtime), 0)
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.in.isDir()
+}
+
+// Sys returns a *syscall.Stat_t filled with the inode information.
+func (fi *fileInfo) Sys() interface{} {
+	return &syscall.Stat_t{
+		Ino:   uint64(fi.in.Number),
+		Nlink: uint64(fi.in.nlink),
+		Mode:  fi.in.fileType() | uint32(fi.in.Mode&07777),
+		Uid:   fi.in.uid,
+		Gid:   fi.in.gid,
+		Rdev:  uint64(fi.in.rdev),
+		Size:  int64(fi.in.size),
+		Mtim:  syscall.Timespec{Sec: int64(fi.in.Mtime)},
+	}
+}
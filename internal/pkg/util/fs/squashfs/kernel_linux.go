@@ -0,0 +1,100 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package squashfs
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// kernelConfigOptions maps compression algorithms names to the kernel
+// configuration option enabling their support by the squashfs driver,
+// uncompressed filesystems are recorded as gzip compressed. lzo can't be used
+// to create filesystems anymore, it is only kept to check the images built
+// with it before.
+var kernelConfigOptions = map[string]string{
+	"gzip":          "CONFIG_SQUASHFS_ZLIB",
+	"lzo":           "CONFIG_SQUASHFS_LZO",
+	"lz4":           "CONFIG_SQUASHFS_LZ4",
+	"xz":            "CONFIG_SQUASHFS_XZ",
+	"zstd":          "CONFIG_SQUASHFS_ZSTD",
+	CompressionNone: "CONFIG_SQUASHFS_ZLIB",
+}
+
+// KernelSupportsCompression reports whether the running kernel can mount
+// squashfs filesystems compressed with the algorithm name. It returns an
+// error if the kernel configuration can't be read from /proc/config.gz or
+// /boot/config-<release>.
+func KernelSupportsCompression(name string) (bool, error) {
+	option, ok := kernelConfigOptions[name]
+	if !ok {
+		return false, nil
+	}
+
+	r, err := openKernelConfig()
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+
+	return hasKernelOption(r, option)
+}
+
+// hasKernelOption returns whether option is built in or built as a module in
+// the kernel configuration read from r.
+func hasKernelOption(r io.Reader, option string) (bool, error) {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == option+"=y" || line == option+"=m" {
+			return true, nil
+		}
+	}
+	return false, s.Err()
+}
+
+type kernelConfig struct {
+	io.Reader
+	f *os.File
+}
+
+func (k *kernelConfig) Close() error {
+	return k.f.Close()
+}
+
+func openKernelConfig() (io.ReadCloser, error) {
+	if f, err := os.Open("/proc/config.gz"); err == nil {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("while reading /proc/config.gz: %s", err)
+		}
+		return &kernelConfig{zr, f}, nil
+	}
+
+	var u syscall.Utsname
+	if err := syscall.Uname(&u); err != nil {
+		return nil, err
+	}
+	var release []byte
+	for _, c := range u.Release {
+		if c == 0 {
+			break
+		}
+		release = append(release, byte(c))
+	}
+
+	f, err := os.Open("/boot/config-" + string(release))
+	if err != nil {
+		return nil, fmt.Errorf("kernel configuration not found: %s", err)
+	}
+	return &kernelConfig{f, f}, nil
+}
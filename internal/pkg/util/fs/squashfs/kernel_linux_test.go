@@ -0,0 +1,34 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package squashfs
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHasKernelOption(t *testing.T) {
+	config := "# CONFIG_SQUASHFS_LZO is not set\nCONFIG_SQUASHFS_XZ=y\nCONFIG_SQUASHFS_ZSTD=m\n"
+
+	tests := []struct {
+		option   string
+		expected bool
+	}{
+		{"CONFIG_SQUASHFS_LZO", false},
+		{"CONFIG_SQUASHFS_XZ", true},
+		{"CONFIG_SQUASHFS_ZSTD", true},
+		{"CONFIG_SQUASHFS_LZ4", false},
+	}
+	for _, tt := range tests {
+		ok, err := hasKernelOption(strings.NewReader(config), tt.option)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if ok != tt.expected {
+			t.Errorf("unexpected result %v for %s", ok, tt.option)
+		}
+	}
+}
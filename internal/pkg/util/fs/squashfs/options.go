@@ -0,0 +1,61 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package squashfs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CompressionNone is the name used for filesystems created without compressing
+// data, metadata, fragments and extended attributes. mksquashfs still records
+// gzip as their compressor, so the kernel needs zlib support to mount them.
+const CompressionNone = "none"
+
+const minBlockSize = 4096
+
+// Compressions lists the names of the compression algorithms filesystems can be
+// created with, mounted by the kernel and read by Reader. lzo isn't listed as
+// Reader can't decompress it, images couldn't be inspected or updated.
+var Compressions = []string{"gzip", "lz4", "xz", "zstd", CompressionNone}
+
+// CheckCompression returns an error if filesystems can't be created with the
+// compression algorithm name.
+func CheckCompression(name string) error {
+	for _, c := range Compressions {
+		if name == c {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported squashfs compression %q, expected one of %s", name, strings.Join(Compressions, ", "))
+}
+
+// ParseBlockSize returns the size in bytes of the block size s, given in bytes
+// or with a K or M suffix. The block size must be a power of two between 4K
+// and 1M.
+func ParseBlockSize(s string) (int, error) {
+	unit := 1
+	n := strings.ToUpper(strings.TrimSpace(s))
+	switch {
+	case strings.HasSuffix(n, "K"):
+		unit = 1024
+		n = strings.TrimSuffix(n, "K")
+	case strings.HasSuffix(n, "M"):
+		unit = 1024 * 1024
+		n = strings.TrimSuffix(n, "M")
+	}
+
+	size, err := strconv.Atoi(n)
+	if err != nil {
+		return 0, fmt.Errorf("invalid squashfs block size %q", s)
+	}
+	size *= unit
+	if size < minBlockSize || size > maxBlockSize || size&(size-1) != 0 {
+		return 0, fmt.Errorf("invalid squashfs block size %q, expected a power of two between 4K and 1M", s)
+	}
+	return size, nil
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package squashfs
+
+import (
+	"testing"
+)
+
+func TestParseBlockSize(t *testing.T) {
+	tests := []struct {
+		size     string
+		expected int
+		valid    bool
+	}{
+		{"131072", 131072, true},
+		{"128K", 131072, true},
+		{"4k", 4096, true},
+		{"1M", 1048576, true},
+		{"2M", 0, false},
+		{"2K", 0, false},
+		{"100K", 0, false},
+		{"", 0, false},
+		{"big", 0, false},
+	}
+
+	for _, tt := range tests {
+		size, err := ParseBlockSize(tt.size)
+		if tt.valid && err != nil {
+			t.Errorf("unexpected error for %q: %s", tt.size, err)
+		} else if !tt.valid && err == nil {
+			t.Errorf("unexpected success for %q", tt.size)
+		} else if size != tt.expected {
+			t.Errorf("unexpected size %d for %q", size, tt.size)
+		}
+	}
+}
+
+func TestCheckCompression(t *testing.T) {
+	for _, c := range Compressions {
+		if err := CheckCompression(c); err != nil {
+			t.Errorf("unexpected error for %s: %s", c, err)
+		}
+	}
+	if err := CheckCompression("lzma"); err == nil {
+		t.Errorf("unexpected success for lzma")
+	}
+}
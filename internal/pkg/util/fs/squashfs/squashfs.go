@@ -0,0 +1,412 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package squashfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const (
+	magic          = 0x73717368
+	superblockSize = 96
+	metadataSize   = 8192
+	maxBlockSize   = 1024 * 1024
+	maxSymlinks    = 40
+	noFragment     = 0xffffffff
+)
+
+// Compression algorithms identifiers as stored in the superblock
+const (
+	CompGzip = 1
+	CompLzma = 2
+	CompLzo  = 3
+	CompXz   = 4
+	CompLz4  = 5
+	CompZstd = 6
+)
+
+type superblock struct {
+	Magic               uint32
+	Inodes              uint32
+	MkfsTime            uint32
+	BlockSize           uint32
+	Fragments           uint32
+	Compression         uint16
+	BlockLog            uint16
+	Flags               uint16
+	NoIds               uint16
+	Major               uint16
+	Minor               uint16
+	RootInode           uint64
+	BytesUsed           uint64
+	IDTableStart        uint64
+	XattrIDTableStart   uint64
+	InodeTableStart     uint64
+	DirectoryTableStart uint64
+	FragmentTableStart  uint64
+	LookupTableStart    uint64
+}
+
+type fragmentEntry struct {
+	Start  uint64
+	Size   uint32
+	Unused uint32
+}
+
+// Reader provides read-only access to the files stored in a squashfs
+// filesystem image without mounting it.
+type Reader struct {
+	r          io.ReaderAt
+	sb         superblock
+	decompress decompressor
+	ids        []uint32
+	fragments  []fragmentEntry
+	xattrStart uint64
+	xattrIDs   []xattrID
+	root       *inode
+}
+
+// NewReader returns a Reader for the squashfs filesystem read from r, the
+// superblock is expected at offset 0 of r.
+func NewReader(r io.ReaderAt) (*Reader, error) {
+	sr := &Reader{r: r}
+
+	if err := binary.Read(io.NewSectionReader(r, 0, superblockSize), binary.LittleEndian, &sr.sb); err != nil {
+		return nil, fmt.Errorf("failed to read superblock: %s", err)
+	}
+	if sr.sb.Magic != magic {
+		return nil, fmt.Errorf("not a valid squashfs image")
+	}
+	if sr.sb.Major != 4 {
+		return nil, fmt.Errorf("squashfs version %d.%d is not supported", sr.sb.Major, sr.sb.Minor)
+	}
+	if sr.sb.BlockSize > maxBlockSize || sr.sb.BlockSize != 1<<sr.sb.BlockLog {
+		return nil, fmt.Errorf("invalid squashfs block size %d", sr.sb.BlockSize)
+	}
+
+	d, err := getDecompressor(sr.sb.Compression)
+	if err != nil {
+		return nil, err
+	}
+	sr.decompress = d
+
+	data, err := sr.readTable(int64(sr.sb.IDTableStart), int(sr.sb.NoIds), 4)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read id table: %s", err)
+	}
+	sr.ids = make([]uint32, sr.sb.NoIds)
+	for i := range sr.ids {
+		sr.ids[i] = binary.LittleEndian.Uint32(data[i*4:])
+	}
+
+	if sr.sb.FragmentTableStart != ^uint64(0) {
+		data, err = sr.readTable(int64(sr.sb.FragmentTableStart), int(sr.sb.Fragments), 16)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read fragment table: %s", err)
+		}
+		sr.fragments = make([]fragmentEntry, sr.sb.Fragments)
+		for i := range sr.fragments {
+			sr.fragments[i] = fragmentEntry{
+				Start: binary.LittleEndian.Uint64(data[i*16:]),
+				Size:  binary.LittleEndian.Uint32(data[i*16+8:]),
+			}
+		}
+	}
+
+	if err := sr.readXattrTable(); err != nil {
+		return nil, fmt.Errorf("failed to read xattr table: %s", err)
+	}
+
+	sr.root, err = sr.readInode(sr.sb.RootInode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read root inode: %s", err)
+	}
+	if !sr.root.isDir() {
+		return nil, fmt.Errorf("root inode is not a directory")
+	}
+
+	return sr, nil
+}
+
+// Compression returns the identifier of the compression algorithm used by
+// the filesystem.
+func (r *Reader) Compression() int {
+	return int(r.sb.Compression)
+}
+
+// readMetadataBlock reads the metadata block located at offset off and returns
+// its uncompressed content along with the offset of the following block.
+func (r *Reader) readMetadataBlock(off int64) ([]byte, int64, error) {
+	hdr := make([]byte, 2)
+	if _, err := r.r.ReadAt(hdr, off); err != nil {
+		return nil, 0, fmt.Errorf("failed to read metadata header at offset %d: %s", off, err)
+	}
+	h := binary.LittleEndian.Uint16(hdr)
+	size := int64(h & 0x7fff)
+
+	data := make([]byte, size)
+	if _, err := r.r.ReadAt(data, off+2); err != nil {
+		return nil, 0, fmt.Errorf("failed to read metadata block at offset %d: %s", off, err)
+	}
+	if h&0x8000 == 0 {
+		var err error
+		if data, err = r.decompress(data, metadataSize); err != nil {
+			return nil, 0, fmt.Errorf("failed to decompress metadata block at offset %d: %s", off, err)
+		}
+	}
+
+	return data, off + 2 + size, nil
+}
+
+// readTable reads count entries of entrySize bytes from a table indexed by a
+// list of metadata blocks locations stored at start.
+func (r *Reader) readTable(start int64, count int, entrySize int) ([]byte, error) {
+	if count == 0 {
+		return nil, nil
+	}
+
+	size := count * entrySize
+	locations := make([]uint64, (size+metadataSize-1)/metadataSize)
+	if err := binary.Read(io.NewSectionReader(r.r, start, int64(len(locations)*8)), binary.LittleEndian, locations); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, 0, size)
+	for _, loc := range locations {
+		b, _, err := r.readMetadataBlock(int64(loc))
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, b...)
+	}
+	if len(data) < size {
+		return nil, fmt.Errorf("table is truncated")
+	}
+
+	return data[:size], nil
+}
+
+// metadataReader reads consecutive metadata blocks as a single stream.
+type metadataReader struct {
+	r    *Reader
+	next int64
+	buf  []byte
+}
+
+func (r *Reader) newMetadataReader(block int64, offset int) (*metadataReader, error) {
+	m := &metadataReader{r: r, next: block}
+	if err := m.fill(); err != nil {
+		return nil, err
+	}
+	if offset > len(m.buf) {
+		return nil, fmt.Errorf("metadata offset %d out of block bounds", offset)
+	}
+	m.buf = m.buf[offset:]
+	return m, nil
+}
+
+func (m *metadataReader) fill() (err error) {
+	if m.next >= int64(m.r.sb.BytesUsed) {
+		return io.EOF
+	}
+	m.buf, m.next, err = m.r.readMetadataBlock(m.next)
+	return err
+}
+
+func (m *metadataReader) Read(p []byte) (int, error) {
+	for len(m.buf) == 0 {
+		if err := m.fill(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, m.buf)
+	m.buf = m.buf[n:]
+	return n, nil
+}
+
+// lookup returns the inode of the file name, symbolic links are resolved
+// within the image for every path component but the last one which is
+// resolved only if follow is true.
+func (r *Reader) lookup(name string, follow bool) (*inode, error) {
+	p := path.Clean("/" + name)
+
+	for links := 0; links <= maxSymlinks; links++ {
+		in := r.root
+		resolved := "/"
+		restart := false
+
+		components := strings.Split(p, "/")[1:]
+		if p == "/" {
+			components = nil
+		}
+
+		for i, c := range components {
+			if !in.isDir() {
+				return nil, syscall.ENOTDIR
+			}
+			child, err := r.child(in, c)
+			if err != nil {
+				return nil, err
+			}
+			if child.isSymlink() && (i < len(components)-1 || follow) {
+				target := child.target
+				if !path.IsAbs(target) {
+					target = path.Join(resolved, target)
+				}
+				p = path.Clean(path.Join(append([]string{target}, components[i+1:]...)...))
+				restart = true
+				break
+			}
+			resolved = path.Join(resolved, c)
+			in = child
+		}
+
+		if !restart {
+			return in, nil
+		}
+	}
+
+	return nil, syscall.ELOOP
+}
+
+// child returns the inode of the directory entry name found in directory in.
+func (r *Reader) child(in *inode, name string) (*inode, error) {
+	entries, err := r.readDir(in)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.name == name {
+			return r.readInode(e.ref)
+		}
+	}
+	return nil, syscall.ENOENT
+}
+
+// Stat returns a FileInfo describing the named file, symbolic links are followed.
+func (r *Reader) Stat(name string) (os.FileInfo, error) {
+	in, err := r.lookup(name, true)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return &fileInfo{name: path.Base(path.Clean("/" + name)), in: in}, nil
+}
+
+// Lstat returns a FileInfo describing the named file, if the file is a
+// symbolic link, the returned FileInfo describes the symbolic link.
+func (r *Reader) Lstat(name string) (os.FileInfo, error) {
+	in, err := r.lookup(name, false)
+	if err != nil {
+		return nil, &os.PathError{Op: "lstat", Path: name, Err: err}
+	}
+	return &fileInfo{name: path.Base(path.Clean("/" + name)), in: in}, nil
+}
+
+// Readlink returns the target of the named symbolic link.
+func (r *Reader) Readlink(name string) (string, error) {
+	in, err := r.lookup(name, false)
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	if !in.isSymlink() {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: syscall.EINVAL}
+	}
+	return in.target, nil
+}
+
+// ReadDir returns the entries of the named directory sorted by name.
+func (r *Reader) ReadDir(name string) ([]os.FileInfo, error) {
+	in, err := r.lookup(name, true)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	if !in.isDir() {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}
+	}
+
+	entries, err := r.readDir(in)
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: err}
+	}
+
+	list := make([]os.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		child, err := r.readInode(e.ref)
+		if err != nil {
+			return nil, &os.PathError{Op: "readdir", Path: name, Err: err}
+		}
+		list = append(list, &fileInfo{name: e.name, in: child})
+	}
+	return list, nil
+}
+
+// Open opens the named file for reading, symbolic links are followed.
+func (r *Reader) Open(name string) (io.Reader, error) {
+	in, err := r.lookup(name, true)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	if in.isDir() {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	} else if !in.isRegular() {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EINVAL}
+	}
+	return r.newFileReader(in), nil
+}
+
+// ReadFile returns the content of the named file, symbolic links are followed.
+func (r *Reader) ReadFile(name string) ([]byte, error) {
+	f, err := r.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(f)
+}
+
+// Walk walks the file tree rooted at root in lexical order, calling fn for
+// each file or directory in the tree including root, symbolic links are
+// not followed.
+func (r *Reader) Walk(root string, fn filepath.WalkFunc) error {
+	root = path.Clean("/" + root)
+	fi, err := r.Lstat(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+	err = r.walk(root, fi, fn)
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+func (r *Reader) walk(name string, fi os.FileInfo, fn filepath.WalkFunc) error {
+	if !fi.IsDir() {
+		return fn(name, fi, nil)
+	}
+
+	list, err := r.ReadDir(name)
+	if err := fn(name, fi, err); err != nil || list == nil {
+		return err
+	}
+
+	for _, child := range list {
+		err := r.walk(path.Join(name, child.Name()), child, fn)
+		if err == filepath.SkipDir && child.IsDir() {
+			continue
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,294 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package squashfs
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/sylabs/singularity/internal/pkg/test"
+)
+
+const largeHash = "86d691da9b1c7c320b7db9450fad01570bfac7bacb743a8019e04da0f6b4db0c"
+
+func openImage(t *testing.T, path string) (*Reader, func()) {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open %s: %s", path, err)
+	}
+	r, err := NewReader(f)
+	if err != nil {
+		f.Close()
+		t.Fatalf("failed to read squashfs image %s: %s", path, err)
+	}
+	return r, func() { f.Close() }
+}
+
+func TestNewReader(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	f, err := os.Open("squashfs_test.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := NewReader(f); err == nil {
+		t.Errorf("unexpected success with a non squashfs file")
+	}
+
+	r, close := openImage(t, "testdata/simple.sqfs")
+	defer close()
+
+	if r.Compression() != CompGzip {
+		t.Errorf("unexpected compression %s", CompressionName(r.Compression()))
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	r, close := openImage(t, "testdata/simple.sqfs")
+	defer close()
+
+	tests := []struct {
+		name    string
+		path    string
+		content string
+		err     error
+	}{
+		{"regular", "/.singularity.d/env/90-environment.sh", "#!/bin/sh\nexport FOO=bar\n", nil},
+		{"relative", ".singularity.d/env/01-base.sh", "#!/bin/sh\n", nil},
+		{"symlink", "/singularity", "#!/bin/sh\n\necho \"hello world\"\n", nil},
+		{"relative symlink chain", "/data/sub/runscript", "#!/bin/sh\n\necho \"hello world\"\n", nil},
+		{"absolute symlink", "/data/abs/runscript", "#!/bin/sh\n\necho \"hello world\"\n", nil},
+		{"dot dot", "/data/../.singularity.d/labels.json", "{\n\t\"MAINTAINER\": \"sylabs\"\n}", nil},
+		{"not found", "/data/missing", "", syscall.ENOENT},
+		{"not directory", "/data/large/file", "", syscall.ENOTDIR},
+		{"directory", "/data", "", syscall.EISDIR},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := r.ReadFile(tt.path)
+			if tt.err != nil {
+				if perr, ok := err.(*os.PathError); !ok || perr.Err != tt.err {
+					t.Errorf("unexpected error for %s: got %v, expected %v", tt.path, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error for %s: %s", tt.path, err)
+			}
+			if string(b) != tt.content {
+				t.Errorf("unexpected content for %s: %q", tt.path, b)
+			}
+		})
+	}
+
+	b, err := r.ReadFile("/data/large")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(b) != 10000 {
+		t.Errorf("unexpected size %d for /data/large", len(b))
+	}
+	if h := fmt.Sprintf("%x", sha256.Sum256(b)); h != largeHash {
+		t.Errorf("unexpected hash %s for /data/large", h)
+	}
+}
+
+func TestReadDir(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	r, close := openImage(t, "testdata/simple.sqfs")
+	defer close()
+
+	list, err := r.ReadDir("/data")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := []struct {
+		name string
+		mode os.FileMode
+	}{
+		{"abs", os.ModeSymlink},
+		{"large", 0},
+		{"sub", os.ModeDir},
+	}
+	if len(list) != len(expected) {
+		t.Fatalf("unexpected number of entries %d", len(list))
+	}
+	for i, e := range expected {
+		if list[i].Name() != e.name {
+			t.Errorf("unexpected entry %s at index %d, expected %s", list[i].Name(), i, e.name)
+		}
+		if list[i].Mode()&os.ModeType != e.mode {
+			t.Errorf("unexpected type %s for %s", list[i].Mode(), list[i].Name())
+		}
+	}
+
+	if _, err := r.ReadDir("/data/large"); err == nil {
+		t.Errorf("unexpected success while reading a regular file as a directory")
+	}
+}
+
+func TestStat(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	r, close := openImage(t, "testdata/simple.sqfs")
+	defer close()
+
+	fi, err := r.Lstat("/singularity")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("/singularity is not reported as a symbolic link")
+	}
+
+	fi, err = r.Stat("/singularity")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !fi.Mode().IsRegular() || fi.Name() != "singularity" {
+		t.Errorf("unexpected file info for /singularity: %s %s", fi.Name(), fi.Mode())
+	}
+
+	target, err := r.Readlink("/data/sub/runscript")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if target != "../../singularity" {
+		t.Errorf("unexpected symbolic link target %s", target)
+	}
+
+	if _, err := r.Readlink("/data/large"); err == nil {
+		t.Errorf("unexpected success while reading link of a regular file")
+	}
+
+	fi, err = r.Stat("/")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !fi.IsDir() {
+		t.Errorf("root is not reported as a directory")
+	}
+}
+
+func TestCompression(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	tests := []struct {
+		path        string
+		compression int
+	}{
+		{"testdata/simple.sqfs", CompGzip},
+		{"testdata/simple-xz.sqfs", CompXz},
+		{"testdata/simple-lz4.sqfs", CompLz4},
+		{"testdata/simple-zstd.sqfs", CompZstd},
+	}
+
+	for _, tt := range tests {
+		t.Run(CompressionName(tt.compression), func(t *testing.T) {
+			r, close := openImage(t, tt.path)
+			defer close()
+
+			if r.Compression() != tt.compression {
+				t.Errorf("unexpected compression %s", CompressionName(r.Compression()))
+			}
+			b, err := r.ReadFile("/data/large")
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if h := fmt.Sprintf("%x", sha256.Sum256(b)); h != largeHash {
+				t.Errorf("unexpected hash %s for /data/large", h)
+			}
+			if _, err := r.ReadFile("/singularity"); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestXattrs(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	r, close := openImage(t, "testdata/special.sqfs")
+	defer close()
+
+	xattrs, err := r.Xattrs("/attr")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(xattrs, map[string][]byte{"user.foo": []byte("bar")}) {
+		t.Errorf("unexpected extended attributes %v", xattrs)
+	}
+
+	xattrs, err = r.Xattrs("/file")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(xattrs) != 0 {
+		t.Errorf("unexpected extended attributes %v", xattrs)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	r, close := openImage(t, "testdata/special.sqfs")
+	defer close()
+
+	var paths []string
+	err := r.Walk("/", func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == "/dev" {
+			return filepath.SkipDir
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := []string{"/", "/attr", "/dir", "/dir/link", "/fifo", "/file"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("unexpected walk order %v", paths)
+	}
+
+	stat := func(name string) *syscall.Stat_t {
+		fi, err := r.Lstat(name)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return fi.Sys().(*syscall.Stat_t)
+	}
+
+	fifo, file, link := stat("/fifo"), stat("/file"), stat("/dir/link")
+	if fifo.Mode&syscall.S_IFMT != syscall.S_IFIFO {
+		t.Errorf("/fifo is not reported as a named pipe")
+	}
+	if file.Ino != link.Ino || file.Nlink != 2 {
+		t.Errorf("/file and /dir/link are not reported as hard links")
+	}
+}
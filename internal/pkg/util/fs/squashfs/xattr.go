@@ -0,0 +1,147 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package squashfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	xattrValueOOL   = 0x100
+	xattrPrefixMask = 0xff
+	maxXattrSize    = 64 * 1024
+)
+
+var xattrPrefixes = []string{"user.", "trusted.", "security."}
+
+type xattrID struct {
+	Ref   uint64
+	Count uint32
+	Size  uint32
+}
+
+// readXattrTable reads the xattr id table, the table is absent when the
+// superblock location is set to all ones.
+func (r *Reader) readXattrTable() error {
+	if r.sb.XattrIDTableStart == ^uint64(0) {
+		return nil
+	}
+
+	var hdr struct {
+		TableStart uint64
+		IDs        uint32
+		Unused     uint32
+	}
+	if err := binary.Read(io.NewSectionReader(r.r, int64(r.sb.XattrIDTableStart), 16), binary.LittleEndian, &hdr); err != nil {
+		return err
+	}
+
+	data, err := r.readTable(int64(r.sb.XattrIDTableStart)+16, int(hdr.IDs), 16)
+	if err != nil {
+		return err
+	}
+
+	r.xattrStart = hdr.TableStart
+	r.xattrIDs = make([]xattrID, hdr.IDs)
+	for i := range r.xattrIDs {
+		r.xattrIDs[i] = xattrID{
+			Ref:   binary.LittleEndian.Uint64(data[i*16:]),
+			Count: binary.LittleEndian.Uint32(data[i*16+8:]),
+			Size:  binary.LittleEndian.Uint32(data[i*16+12:]),
+		}
+	}
+	return nil
+}
+
+// readXattrs returns the extended attributes of inode in.
+func (r *Reader) readXattrs(in *inode) (map[string][]byte, error) {
+	if in.xattr == noXattr {
+		return nil, nil
+	}
+	if int(in.xattr) >= len(r.xattrIDs) {
+		return nil, fmt.Errorf("inode %d has an invalid xattr index %d", in.Number, in.xattr)
+	}
+	id := r.xattrIDs[in.xattr]
+
+	m, err := r.newMetadataReader(int64(r.xattrStart+id.Ref>>16), int(id.Ref&0xffff))
+	if err != nil {
+		return nil, err
+	}
+
+	xattrs := make(map[string][]byte, id.Count)
+	for i := uint32(0); i < id.Count; i++ {
+		var key struct {
+			Type     uint16
+			NameSize uint16
+		}
+		if err := binary.Read(m, binary.LittleEndian, &key); err != nil {
+			return nil, fmt.Errorf("failed to read xattr key: %s", err)
+		}
+		prefix := int(key.Type & xattrPrefixMask)
+		if prefix >= len(xattrPrefixes) {
+			return nil, fmt.Errorf("unknown xattr prefix %d", prefix)
+		}
+		name := make([]byte, key.NameSize)
+		if _, err := io.ReadFull(m, name); err != nil {
+			return nil, fmt.Errorf("failed to read xattr name: %s", err)
+		}
+
+		value, err := readXattrValue(m)
+		if err != nil {
+			return nil, err
+		}
+		if key.Type&xattrValueOOL != 0 {
+			// the value is stored out of line, we got its reference
+			if len(value) != 8 {
+				return nil, fmt.Errorf("invalid out of line xattr value reference")
+			}
+			ref := binary.LittleEndian.Uint64(value)
+			vm, err := r.newMetadataReader(int64(r.xattrStart+ref>>16), int(ref&0xffff))
+			if err != nil {
+				return nil, err
+			}
+			if value, err = readXattrValue(vm); err != nil {
+				return nil, err
+			}
+		}
+
+		xattrs[xattrPrefixes[prefix]+string(name)] = value
+	}
+
+	return xattrs, nil
+}
+
+func readXattrValue(m io.Reader) ([]byte, error) {
+	var size uint32
+	if err := binary.Read(m, binary.LittleEndian, &size); err != nil {
+		return nil, fmt.Errorf("failed to read xattr value size: %s", err)
+	}
+	if size > maxXattrSize {
+		return nil, fmt.Errorf("invalid xattr value size %d", size)
+	}
+	value := make([]byte, size)
+	if _, err := io.ReadFull(m, value); err != nil {
+		return nil, fmt.Errorf("failed to read xattr value: %s", err)
+	}
+	return value, nil
+}
+
+// Xattrs returns the extended attributes of the named file, if the file is
+// a symbolic link, the attributes of the symbolic link are returned.
+func (r *Reader) Xattrs(name string) (map[string][]byte, error) {
+	in, err := r.lookup(name, false)
+	if err != nil {
+		return nil, &os.PathError{Op: "getxattr", Path: name, Err: err}
+	}
+	xattrs, err := r.readXattrs(in)
+	if err != nil {
+		return nil, &os.PathError{Op: "getxattr", Path: name, Err: err}
+	}
+	return xattrs, nil
+}
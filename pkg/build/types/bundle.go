@@ -9,6 +9,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"time"
 
 	ocitypes "github.com/containers/image/types"
 	"github.com/sylabs/singularity/internal/pkg/sylog"
@@ -58,6 +59,32 @@ type Options struct {
 	// NoCleanUp allows a user to prevent a bundle from being cleaned up after a failed build
 	// useful for debugging
 	NoCleanUp bool `json:"noCleanUp"`
+	// BuildArgs holds the values of build arguments substituted in the definition
+	BuildArgs map[string]string `json:"buildArgs"`
+	// Reproducible builds bit identical images from identical inputs
+	Reproducible bool `json:"reproducible"`
+	// SourceDateEpoch is the build time, in seconds since the epoch, recorded
+	// in reproducible images
+	SourceDateEpoch int64 `json:"sourceDateEpoch"`
+	// Compression is the compression algorithm of the squashfs filesystem of
+	// SIF images, singularity.conf sets the default
+	Compression string `json:"compression"`
+	// BlockSize is the block size of the squashfs filesystem of SIF images
+	BlockSize string `json:"blockSize"`
+	// Processors is the number of processors used to create the squashfs
+	// filesystem of SIF images, 0 for the singularity.conf default
+	Processors uint `json:"processors"`
+	// Excludes are wildcard patterns of files left out of SIF images
+	Excludes []string `json:"excludes"`
+}
+
+// BuildTime returns the time recorded as build time in images, it is the
+// source date epoch for reproducible builds.
+func (o Options) BuildTime() time.Time {
+	if o.Reproducible {
+		return time.Unix(o.SourceDateEpoch, 0).UTC()
+	}
+	return time.Now()
 }
 
 // NewBundle creates a Bundle environment
@@ -10,6 +10,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
 )
 
@@ -50,6 +51,9 @@ type Data struct {
 type FileTransport struct {
 	Src string `json:"source"`
 	Dst string `json:"destination"`
+	// Stage is the name of the build stage to copy Src from, files
+	// are copied from the host when empty
+	Stage string `json:"stage,omitempty"`
 }
 
 // Scripts defines scripts that are used at build time.
@@ -79,7 +83,9 @@ func NewDefinitionFromURI(uri string) (d Definition, err error) {
 	}
 
 	var buf bytes.Buffer
-	populateRaw(&d, &buf)
+	if err := WriteDefinitionFile(&d, &buf); err != nil {
+		return d, err
+	}
 	d.Raw = buf.Bytes()
 
 	return d, nil
@@ -100,81 +106,207 @@ func NewDefinitionFromJSON(r io.Reader) (d Definition, err error) {
 	// if JSON definition doesn't have a raw data section, add it
 	if len(d.Raw) == 0 {
 		var buf bytes.Buffer
-		populateRaw(&d, &buf)
+		if err := WriteDefinitionFile(&d, &buf); err != nil {
+			return d, err
+		}
 		d.Raw = buf.Bytes()
 	}
 
 	return d, nil
 }
 
-func writeSectionIfExists(w io.Writer, ident string, s string) {
+// headerOrder lists the known header keywords in the order they are written
+// to a definition file, with their usual spelling.
+var headerOrder = []struct {
+	key  string
+	name string
+}{
+	{"bootstrap", "Bootstrap"},
+	{"from", "From"},
+	{"stage", "Stage"},
+	{"library", "Library"},
+	{"registry", "Registry"},
+	{"namespace", "Namespace"},
+	{"osversion", "OSVersion"},
+	{"mirrorurl", "MirrorURL"},
+	{"updateurl", "UpdateURL"},
+	{"include", "Include"},
+	{"includecmd", "IncludeCmd"},
+}
+
+// appSectionOrder lists the app sections in the order they are written for
+// each app.
+var appSectionOrder = []string{
+	"apphelp",
+	"appenv",
+	"applabels",
+	"appfiles",
+	"appinstall",
+	"apprun",
+	"apptest",
+}
+
+func writeSectionIfExists(w *bytes.Buffer, ident string, s string) {
 	if len(s) > 0 {
-		w.Write([]byte("%"))
-		w.Write([]byte(ident))
-		w.Write([]byte("\n"))
-		w.Write([]byte(s))
-		w.Write([]byte("\n\n"))
+		w.WriteString("%" + ident + "\n")
+		w.WriteString(s)
+		// sections parsed from a definition file always end with a
+		// newline, followed by an empty line written by the parser
+		if !strings.HasSuffix(s, "\n") {
+			w.WriteString("\n")
+		}
 	}
 }
 
-func writeFilesIfExists(w io.Writer, f []FileTransport) {
+func writeFilesIfExists(w *bytes.Buffer, f []FileTransport) {
+	// group file transfers by stage in order of appearance
+	var stages []string
+	byStage := make(map[string][]FileTransport)
+	for _, ft := range f {
+		if _, ok := byStage[ft.Stage]; !ok {
+			stages = append(stages, ft.Stage)
+		}
+		byStage[ft.Stage] = append(byStage[ft.Stage], ft)
+	}
+
+	for _, stage := range stages {
+		w.WriteString("%files")
+		if stage != "" {
+			w.WriteString(" from " + stage)
+		}
+		w.WriteString("\n")
 
-	if len(f) > 0 {
+		for _, ft := range byStage[stage] {
+			w.WriteString("    " + ft.Src)
+			if ft.Dst != "" {
+				w.WriteString(" " + ft.Dst)
+			}
+			w.WriteString("\n")
+		}
+		w.WriteString("\n")
+	}
+}
 
-		w.Write([]byte("%"))
-		w.Write([]byte("files"))
-		w.Write([]byte("\n"))
+func writeLabelsIfExists(w *bytes.Buffer, l map[string]string) {
+	if len(l) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
 
-		for _, ft := range f {
-			w.Write([]byte("\t"))
-			w.Write([]byte(ft.Src))
-			w.Write([]byte("\t"))
-			w.Write([]byte(ft.Dst))
-			w.Write([]byte("\n"))
+	w.WriteString("%labels\n")
+	for _, k := range keys {
+		w.WriteString("    " + k)
+		if l[k] != "" {
+			w.WriteString(" " + l[k])
 		}
-		w.Write([]byte("\n"))
+		w.WriteString("\n")
 	}
+	w.WriteString("\n")
 }
 
-func writeLabelsIfExists(w io.Writer, l map[string]string) {
+func writeHeader(w *bytes.Buffer, h map[string]string) {
+	if len(h) == 0 {
+		return
+	}
 
-	if len(l) > 0 {
+	known := make(map[string]bool)
+	for _, k := range headerOrder {
+		known[k.key] = true
+		if v, ok := h[k.key]; ok {
+			w.WriteString(k.name + ": " + v + "\n")
+		}
+	}
 
-		w.Write([]byte("%"))
-		w.Write([]byte("labels"))
-		w.Write([]byte("\n"))
+	var keys []string
+	for k := range h {
+		if !known[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		w.WriteString(k + ": " + h[k] + "\n")
+	}
+	w.WriteString("\n")
+}
 
-		for k, v := range l {
-			w.Write([]byte("\t"))
-			w.Write([]byte(k))
-			w.Write([]byte(" "))
-			w.Write([]byte(v))
-			w.Write([]byte("\n"))
+// writeCustomSections writes the app sections grouped by app and sorted by
+// app name, followed by the other custom sections sorted by name.
+func writeCustomSections(w *bytes.Buffer, c map[string]string) {
+	apps := make(map[string]bool)
+	var others []string
+	for k := range c {
+		fields := strings.Fields(k)
+		if len(fields) == 2 && strings.HasPrefix(fields[0], "app") {
+			apps[fields[1]] = true
+		}
+		others = append(others, k)
+	}
+
+	written := make(map[string]bool)
+	var names []string
+	for app := range apps {
+		names = append(names, app)
+	}
+	sort.Strings(names)
+	for _, app := range names {
+		for _, sect := range appSectionOrder {
+			k := sect + " " + app
+			if v, ok := c[k]; ok {
+				writeSectionIfExists(w, k, v)
+				written[k] = true
+			}
+		}
+	}
+
+	sort.Strings(others)
+	for _, k := range others {
+		if !written[k] {
+			writeSectionIfExists(w, k, c[k])
 		}
-		w.Write([]byte("\n"))
 	}
 }
 
-// populateRaw is a helper func to output a Definition struct
-// into a definition file.
-func populateRaw(d *Definition, w io.Writer) {
-	for k, v := range d.Header {
-		w.Write([]byte(k))
-		w.Write([]byte(": "))
-		w.Write([]byte(v))
-		w.Write([]byte("\n"))
+// WriteDefinitionFile writes d to w as a definition file. The output is
+// canonical: headers, labels and sections are always written in the same
+// order, including the app sections and the other sections of CustomData,
+// and section content is kept as is with its comments, ending with a newline.
+// Parsing the output gives back d, except for the newline added to sections
+// without one, like the first section of a definition file without header
+// whose trailing whitespace is trimmed by the parser.
+func WriteDefinitionFile(d *Definition, w io.Writer) error {
+	var buf bytes.Buffer
+
+	if len(d.Header) == 0 {
+		// the parser trims the first section of a definition file
+		// without header, unless an empty header comes first
+		buf.WriteString("\n")
+	}
+	writeHeader(&buf, d.Header)
+
+	writeSectionIfExists(&buf, "pre", d.BuildData.Pre)
+	writeSectionIfExists(&buf, "setup", d.BuildData.Setup)
+	writeFilesIfExists(&buf, d.BuildData.Files)
+	writeSectionIfExists(&buf, "environment", d.ImageData.Environment)
+	writeSectionIfExists(&buf, "post", d.BuildData.Post)
+	writeSectionIfExists(&buf, "runscript", d.ImageData.Runscript)
+	writeSectionIfExists(&buf, "startscript", d.ImageData.Startscript)
+	// the %test section gives both the image and the build test
+	test := d.ImageData.Test
+	if test == "" {
+		test = d.BuildData.Test
 	}
-	w.Write([]byte("\n"))
+	writeSectionIfExists(&buf, "test", test)
+	writeLabelsIfExists(&buf, d.ImageData.Labels)
+	writeSectionIfExists(&buf, "help", d.ImageData.Help)
 
-	writeLabelsIfExists(w, d.ImageData.Labels)
-	writeFilesIfExists(w, d.BuildData.Files)
+	writeCustomSections(&buf, d.CustomData)
 
-	writeSectionIfExists(w, "help", d.ImageData.Help)
-	writeSectionIfExists(w, "environment", d.ImageData.Environment)
-	writeSectionIfExists(w, "runscript", d.ImageData.Runscript)
-	writeSectionIfExists(w, "test", d.ImageData.Test)
-	writeSectionIfExists(w, "startscript", d.ImageData.Startscript)
-	writeSectionIfExists(w, "pre", d.BuildData.Pre)
-	writeSectionIfExists(w, "setup", d.BuildData.Setup)
-	writeSectionIfExists(w, "post", d.BuildData.Post)
+	_, err := w.Write(buf.Bytes())
+	return err
 }
@@ -0,0 +1,161 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// argumentRef matches a reference to a build argument, {{ NAME }}
+var argumentRef = regexp.MustCompile(`{{\s*([A-Za-z_][A-Za-z0-9_]*)\s*}}`)
+
+// argumentName matches a valid build argument name
+var argumentName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// parseArguments parses NAME=value lines of build arguments, empty lines
+// and comments are ignored.
+func parseArguments(r io.Reader) (map[string]string, error) {
+	args := make(map[string]string)
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, err := ParseArgument(line)
+		if err != nil {
+			return nil, err
+		}
+		args[name] = value
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return args, nil
+}
+
+// ParseArgument parses a build argument of the form NAME=value.
+func ParseArgument(arg string) (name string, value string, err error) {
+	split := strings.SplitN(arg, "=", 2)
+	name = strings.TrimSpace(split[0])
+	if len(split) != 2 || !argumentName.MatchString(name) {
+		return "", "", fmt.Errorf("invalid build argument %q, expected NAME=value", arg)
+	}
+	return name, strings.TrimSpace(split[1]), nil
+}
+
+// ParseArgumentsFile parses a file of build arguments holding one NAME=value
+// argument per line.
+func ParseArgumentsFile(r io.Reader) (map[string]string, error) {
+	return parseArguments(r)
+}
+
+// isSectionLine returns the lower case name of the section started by line,
+// or an empty string if line doesn't start a section.
+func isSectionLine(line []byte) string {
+	fields := strings.Fields(string(line))
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "%") {
+		return ""
+	}
+	return strings.ToLower(strings.TrimLeft(fields[0], "%"))
+}
+
+// splitArguments separates the %arguments sections from the raw content of
+// a build stage, it returns the stage without them and their content.
+func splitArguments(raw []byte) (stage []byte, arguments []byte) {
+	inArguments := false
+	for _, line := range bytes.SplitAfter(raw, []byte("\n")) {
+		if name := isSectionLine(line); name != "" {
+			inArguments = name == "arguments"
+			if inArguments {
+				continue
+			}
+		}
+		if inArguments {
+			arguments = append(arguments, line...)
+		} else {
+			stage = append(stage, line...)
+		}
+	}
+	return stage, arguments
+}
+
+// substituteArguments replaces the {{ NAME }} build argument references of
+// the raw content of a build stage with their value. Default values are
+// declared with NAME=value lines in a %arguments section and are overridden
+// by args. The returned stage records the values of the arguments in its
+// %arguments section, used holds the names of the arguments of args used
+// by the stage. Unless lenient is true, referencing an undefined argument is
+// an error, otherwise the reference is kept.
+func substituteArguments(raw []byte, args map[string]string, lenient bool) (stage []byte, used map[string]bool, err error) {
+	stage, section := splitArguments(raw)
+
+	values, err := parseArguments(bytes.NewReader(section))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%%arguments: %v", err)
+	}
+	if len(values) == 0 && !argumentRef.Match(stage) {
+		return raw, nil, nil
+	}
+
+	used = make(map[string]bool)
+	resolved := make(map[string]bool)
+	for name := range values {
+		resolved[name] = true
+	}
+
+	undefined := make(map[string]bool)
+	stage = argumentRef.ReplaceAllFunc(stage, func(ref []byte) []byte {
+		name := string(argumentRef.FindSubmatch(ref)[1])
+		if v, ok := args[name]; ok {
+			used[name] = true
+			resolved[name] = true
+			return []byte(v)
+		}
+		if v, ok := values[name]; ok {
+			return []byte(v)
+		}
+		undefined[name] = true
+		return ref
+	})
+	if len(undefined) > 0 && !lenient {
+		var names []string
+		for name := range undefined {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, nil, fmt.Errorf("undefined build argument(s): %s", strings.Join(names, ", "))
+	}
+
+	// record the value of declared and used arguments for auditing
+	var names []string
+	for name := range resolved {
+		if v, ok := args[name]; ok {
+			values[name] = v
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if len(names) > 0 {
+		// the section is appended in a way that parsing the returned
+		// stage again gives the same stage
+		stage = append(bytes.TrimRight(stage, " \t\r\n"), "\n\n%arguments\n"...)
+		for _, name := range names {
+			stage = append(stage, fmt.Sprintf("    %s=%s\n", name, values[name])...)
+		}
+		stage = append(stage, '\n')
+	}
+
+	return stage, used, nil
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package parser
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sylabs/singularity/internal/pkg/test"
+	"github.com/sylabs/singularity/pkg/build/types"
+)
+
+func TestParseArgumentsFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		args    map[string]string
+		fail    bool
+	}{
+		{"Empty", "", map[string]string{}, false},
+		{"Comments", "# comment\n\n  A=1\n", map[string]string{"A": "1"}, false},
+		{"Values", "A = a b\nB=x=y\nC=\n", map[string]string{"A": "a b", "B": "x=y", "C": ""}, false},
+		{"Override", "A=1\nA=2\n", map[string]string{"A": "2"}, false},
+		{"NoValue", "A\n", nil, true},
+		{"BadName", "1A=1\n", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := ParseArgumentsFile(strings.NewReader(tt.content))
+			if tt.fail {
+				if err == nil {
+					t.Fatalf("unexpected success parsing %q", tt.content)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to parse %q: %v", tt.content, err)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Fatalf("unexpected arguments: got %v, expected %v", args, tt.args)
+			}
+		})
+	}
+}
+
+func TestParseDefinitionStagesArgs(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	parse := func(args map[string]string) ([]types.Definition, error) {
+		defFile, err := os.Open("testdata_good/arguments/arguments")
+		if err != nil {
+			t.Fatal("failed to open:", err)
+		}
+		defer defFile.Close()
+		return ParseDefinitionStagesArgs(defFile, args)
+	}
+
+	// PACKAGE is not declared by the final stage
+	if _, err := parse(nil); err == nil {
+		t.Fatal("unexpected success parsing definition with undefined argument")
+	}
+
+	defs, err := parse(map[string]string{"PACKAGE": "wget", "UNUSED": "1"})
+	if err != nil {
+		t.Fatal("failed to parse definition file:", err)
+	}
+	if len(defs) != 2 {
+		t.Fatalf("expected 2 stages, got %d", len(defs))
+	}
+
+	for _, d := range defs {
+		if d.Header["from"] != "alpine:3.9" {
+			t.Errorf("unexpected from header: %s", d.Header["from"])
+		}
+	}
+	if post := strings.TrimSpace(defs[0].BuildData.Post); post != "apk add --no-cache wget" {
+		t.Errorf("unexpected %%post section: %s", post)
+	}
+	files := []types.FileTransport{{Src: "/usr/bin/wget", Dst: "/usr/local/bin/wget", Stage: "build"}}
+	if !reflect.DeepEqual(defs[1].BuildData.Files, files) {
+		t.Errorf("unexpected files: got %v, expected %v", defs[1].BuildData.Files, files)
+	}
+	// only {{ NAME }} references are substituted
+	if run := strings.TrimSpace(defs[1].Runscript); run != `exec /usr/local/bin/wget --format '{{.Id}}' "$@"` {
+		t.Errorf("unexpected %%runscript section: %s", run)
+	}
+
+	// resolved values are recorded in the raw definition of each stage
+	for i, d := range defs {
+		raw := string(d.Raw)
+		if strings.Contains(raw, "{{ PACKAGE }}") || strings.Contains(raw, "UNUSED") {
+			t.Errorf("stage %d: unexpected raw definition:\n%s", i+1, raw)
+		}
+		if !strings.Contains(raw, "%arguments\n    PACKAGE=wget\n    VERSION=3.9\n") {
+			t.Errorf("stage %d: resolved arguments not recorded:\n%s", i+1, raw)
+		}
+	}
+
+	// the recorded definition parses again to the same definition
+	again, err := ParseDefinitionStages(strings.NewReader(string(defs[0].Raw)))
+	if err != nil {
+		t.Fatal("failed to parse recorded definition:", err)
+	}
+	if !reflect.DeepEqual(again[0].BuildData, defs[0].BuildData) {
+		t.Errorf("recorded definition differs: got %v, expected %v", again[0].BuildData, defs[0].BuildData)
+	}
+}
+
+func TestIsValidDefinitionArgs(t *testing.T) {
+	// undefined build arguments are only known at build time
+	valid, err := IsValidDefinition("testdata_good/arguments/arguments")
+	if err != nil || !valid {
+		t.Fatalf("definition with undefined build argument is not valid: %v", err)
+	}
+}
@@ -14,9 +14,12 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
 	"strings"
 
+	"github.com/sylabs/singularity/internal/pkg/sylog"
 	"github.com/sylabs/singularity/pkg/build/types"
 )
 
@@ -155,7 +158,16 @@ func parseTokenSection(tok string, sections map[string]string) error {
 	}
 
 	key := getSectionName(split[0])
-	if appSections[key] {
+	if key == "files" {
+		// %files may copy from a previous build stage with "%files from <stage>"
+		ident := strings.SplitN(strings.TrimLeft(split[0], "%"), "#", 2)[0]
+		args := strings.Fields(ident)[1:]
+		if len(args) == 2 && strings.ToLower(args[0]) == "from" {
+			key = "files from " + args[1]
+		} else if len(args) != 0 {
+			return fmt.Errorf("Section %v: Invalid arguments, expected \"from <stage>\"", split[0])
+		}
+	} else if appSections[key] {
 		sectionSplit := strings.SplitN(strings.TrimLeft(split[0], "%"), " ", 3)
 		if len(sectionSplit) < 2 {
 			return fmt.Errorf("App Section %v: Could not be split into section name and app name", sectionSplit[0])
@@ -210,11 +222,10 @@ func doSections(s *bufio.Scanner, d *types.Definition) error {
 	return populateDefinition(sectionsMap, d)
 }
 
-func populateDefinition(sections map[string]string, d *types.Definition) (err error) {
-	// Files are parsed as a map[string]string
-	filesSections := strings.TrimSpace(sections["files"])
-	subs := strings.Split(filesSections, "\n")
-	var files []types.FileTransport
+// parseFiles parses the content of a %files section, stage is the name of the
+// build stage the files are copied from or empty for files copied from the host
+func parseFiles(content, stage string) (files []types.FileTransport) {
+	subs := strings.Split(strings.TrimSpace(content), "\n")
 
 	for _, line := range subs {
 
@@ -231,12 +242,33 @@ func populateDefinition(sections map[string]string, d *types.Definition) (err er
 			dst = strings.TrimSpace(lineSubs[1])
 		}
 
-		files = append(files, types.FileTransport{Src: src, Dst: dst})
+		files = append(files, types.FileTransport{Src: src, Dst: dst, Stage: stage})
+	}
+
+	return files
+}
+
+func populateDefinition(sections map[string]string, d *types.Definition) (err error) {
+	// Files are parsed as a map[string]string
+	files := parseFiles(sections["files"], "")
+
+	// files copied from previous build stages, sorted by stage name
+	// since sections are stored in a map
+	var stages []string
+	for k := range sections {
+		if strings.HasPrefix(k, "files from ") {
+			stages = append(stages, k)
+		}
+	}
+	sort.Strings(stages)
+	for _, k := range stages {
+		files = append(files, parseFiles(sections[k], strings.TrimPrefix(k, "files from "))...)
+		delete(sections, k)
 	}
 
 	// labels are parsed as a map[string]string
 	labelsSections := strings.TrimSpace(sections["labels"])
-	subs = strings.Split(labelsSections, "\n")
+	subs := strings.Split(labelsSections, "\n")
 	labels := make(map[string]string)
 
 	for _, line := range subs {
@@ -336,13 +368,18 @@ func doHeader(h string, d *types.Definition) (err error) {
 
 // ParseDefinitionFile receives a reader from a definition file
 // and parse it into a Definition struct or return error if
-// the definition file has a bad section.
+// the definition file has a bad section. %import directives are
+// rejected, they are only resolved by ParseDefinitionStagesPath.
 func ParseDefinitionFile(r io.Reader) (d types.Definition, err error) {
 	d.Raw, err = ioutil.ReadAll(r)
 	if err != nil {
 		return d, fmt.Errorf("While attempting to read in definition: %v", err)
 	}
 
+	if err = rejectImports(d.Raw); err != nil {
+		return d, err
+	}
+
 	s := bufio.NewScanner(bytes.NewReader(d.Raw))
 	s.Split(scanDefinitionFile)
 
@@ -364,6 +401,118 @@ func ParseDefinitionFile(r io.Reader) (d types.Definition, err error) {
 	return
 }
 
+// ParseDefinitionStages receives a reader from a definition file and parses
+// each build stage it declares into a Definition struct. Every stage starts
+// with its own Bootstrap header keyword, and may be named with the Stage
+// header keyword so that later stages can copy files out of it with a
+// "%files from <stage>" section. A definition file without multiple stages
+// returns a single Definition.
+func ParseDefinitionStages(r io.Reader) ([]types.Definition, error) {
+	return ParseDefinitionStagesArgs(r, nil)
+}
+
+// ParseDefinitionStagesArgs parses the build stages of a definition file like
+// ParseDefinitionStages after substituting the {{ NAME }} build argument
+// references of every stage. Values from args override the defaults declared
+// in the %arguments section of a stage, and the values used are recorded in
+// that section of the raw definition of the stage. %import directives are
+// rejected like by ParseDefinitionFile.
+func ParseDefinitionStagesArgs(r io.Reader, args map[string]string) ([]types.Definition, error) {
+	return parseDefinitionStages(r, "", args, false)
+}
+
+// ParseDefinitionStagesPath parses the build stages of the definition file
+// read from r like ParseDefinitionStagesArgs, path is the location of the
+// definition file and the %import directives of the definition are resolved
+// relative to its directory. The raw definition of every stage holds the
+// imported sections.
+func ParseDefinitionStagesPath(path string, r io.Reader, args map[string]string) ([]types.Definition, error) {
+	return parseDefinitionStages(r, path, args, false)
+}
+
+func parseDefinitionStages(r io.Reader, path string, args map[string]string, lenient bool) ([]types.Definition, error) {
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("While attempting to read in definition: %v", err)
+	}
+
+	if path == "" {
+		err = rejectImports(raw)
+	} else {
+		var abs string
+		if abs, err = filepath.Abs(path); err == nil {
+			raw, err = expandImports(raw, path, []string{abs})
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var defs []types.Definition
+	names := make(map[string]bool)
+	used := make(map[string]bool)
+
+	for i, stage := range splitStages(raw) {
+		stage, stageUsed, err := substituteArguments(stage, args, lenient)
+		if err != nil {
+			return nil, fmt.Errorf("stage %d: %v", i+1, err)
+		}
+		for name := range stageUsed {
+			used[name] = true
+		}
+
+		d, err := ParseDefinitionFile(bytes.NewReader(stage))
+		if err != nil {
+			return nil, fmt.Errorf("stage %d: %v", i+1, err)
+		}
+
+		for _, f := range d.BuildData.Files {
+			if f.Stage != "" && !names[f.Stage] {
+				return nil, fmt.Errorf("stage %d: %%files from %s: no previous stage named %s", i+1, f.Stage, f.Stage)
+			}
+		}
+
+		if name := d.Header["stage"]; name != "" {
+			if names[name] {
+				return nil, fmt.Errorf("stage %d: stage name %s is already used by a previous stage", i+1, name)
+			}
+			names[name] = true
+		}
+
+		defs = append(defs, d)
+	}
+
+	for name := range args {
+		if !used[name] {
+			sylog.Warningf("Build argument %s is not used by the definition", name)
+		}
+	}
+
+	return defs, nil
+}
+
+// splitStages splits the raw content of a definition file into the raw
+// content of each build stage, a new stage starts on each line holding
+// a Bootstrap header keyword after the first one.
+func splitStages(raw []byte) (stages [][]byte) {
+	start, offset := 0, 0
+	found := false
+
+	for _, line := range bytes.SplitAfter(raw, []byte("\n")) {
+		linetoks := strings.SplitN(string(line), ":", 2)
+		if len(linetoks) == 2 && strings.ToLower(strings.TrimSpace(linetoks[0])) == "bootstrap" {
+			if found {
+				stages = append(stages, raw[start:offset])
+				start = offset
+			}
+			found = true
+		}
+		offset += len(line)
+	}
+
+	return append(stages, raw[start:])
+}
+
 // IsValidDefinition returns whether or not the given file is a valid definition
 func IsValidDefinition(source string) (valid bool, err error) {
 	defFile, err := os.Open(source)
@@ -379,7 +528,9 @@ func IsValidDefinition(source string) (valid bool, err error) {
 
 	defer defFile.Close()
 
-	_, err = ParseDefinitionFile(defFile)
+	// build arguments are only known at build time, undefined
+	// ones don't make a definition invalid
+	_, err = parseDefinitionStages(defFile, source, nil, true)
 	if err != nil {
 		return false, err
 	}
@@ -400,6 +551,7 @@ var validSections = map[string]bool{
 	"runscript":   true,
 	"test":        true,
 	"startscript": true,
+	"arguments":   true,
 }
 
 var appSections = map[string]bool{
@@ -425,4 +577,5 @@ var validHeaders = map[string]bool{
 	"library":    true,
 	"registry":   true,
 	"namespace":  true,
+	"stage":      true,
 }
@@ -148,3 +148,73 @@ func TestParseDefinitionFileFailure(t *testing.T) {
 		}))
 	}
 }
+
+func TestParseDefinitionStages(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	defFile, err := os.Open("testdata_good/multistage/multistage")
+	if err != nil {
+		t.Fatal("failed to open:", err)
+	}
+	defer defFile.Close()
+
+	defs, err := ParseDefinitionStages(defFile)
+	if err != nil {
+		t.Fatal("failed to parse definition file:", err)
+	}
+
+	if len(defs) != 2 {
+		t.Fatalf("expected 2 stages, got %d", len(defs))
+	}
+
+	if defs[0].Header["stage"] != "devel" || defs[0].Header["bootstrap"] != "docker" {
+		t.Fatalf("unexpected header for first stage: %v", defs[0].Header)
+	}
+	if defs[1].Header["stage"] != "" || defs[1].Header["bootstrap"] != "library" {
+		t.Fatalf("unexpected header for final stage: %v", defs[1].Header)
+	}
+
+	files := []types.FileTransport{
+		{Src: "hello.txt", Dst: "/hello.txt"},
+		{Src: "/go/bin/hello", Dst: "/usr/local/bin/hello", Stage: "devel"},
+		{Src: "/go/src/hello", Dst: "", Stage: "devel"},
+	}
+	if !reflect.DeepEqual(defs[1].BuildData.Files, files) {
+		t.Fatalf("unexpected files for final stage: %v", defs[1].BuildData.Files)
+	}
+
+	b, err := ioutil.ReadFile("testdata_good/multistage/multistage")
+	if err != nil {
+		t.Fatal("failed to read definition file:", err)
+	}
+	if raw := string(defs[0].Raw) + string(defs[1].Raw); raw != string(b) {
+		t.Fatal("stages raw data do not match definition file")
+	}
+}
+
+func TestParseDefinitionStagesFailure(t *testing.T) {
+	tests := []struct {
+		name    string
+		defPath string
+	}{
+		{"UnknownStage", "testdata_bad/multistage_unknown"},
+		{"DuplicateStage", "testdata_bad/multistage_duplicate"},
+		{"ForwardStage", "testdata_bad/multistage_forward"},
+		{"BadSection", "testdata_bad/bad_section"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, test.WithoutPrivilege(func(t *testing.T) {
+			defFile, err := os.Open(tt.defPath)
+			if err != nil {
+				t.Fatal("failed to open:", err)
+			}
+			defer defFile.Close()
+
+			if _, err = ParseDefinitionStages(defFile); err == nil {
+				t.Fatal("unexpected success parsing definition file")
+			}
+		}))
+	}
+}
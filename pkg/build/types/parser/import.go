@@ -0,0 +1,121 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// importDirective is the section like directive importing the sections of
+// another definition file
+const importDirective = "import"
+
+// expandImports replaces the %import <path> directives of the definition
+// raw read from path with the sections of the imported definition files.
+// Relative paths are resolved from the directory of the importing file.
+// stack holds the files being imported to detect import cycles.
+func expandImports(raw []byte, path string, stack []string) ([]byte, error) {
+	name := path
+	dir := filepath.Dir(path)
+
+	var expanded []byte
+	// set after an %import line until the next section
+	afterImport := false
+
+	for i, line := range bytes.SplitAfter(raw, []byte("\n")) {
+		section := isSectionLine(line)
+		if section == "" {
+			content := strings.TrimSpace(string(line))
+			if afterImport && content != "" && !strings.HasPrefix(content, "#") {
+				return nil, fmt.Errorf("%s:%d: a section must follow %%import", name, i+1)
+			}
+			expanded = append(expanded, line...)
+			continue
+		}
+		afterImport = false
+		if section != importDirective {
+			expanded = append(expanded, line...)
+			continue
+		}
+
+		target := importPath(line)
+		if target == "" {
+			return nil, fmt.Errorf("%s:%d: %%import requires a definition file path", name, i+1)
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(dir, target)
+		}
+		target, err := filepath.Abs(target)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", name, i+1, err)
+		}
+		for j, p := range stack {
+			if p == target {
+				cycle := append(append([]string{}, stack[j:]...), target)
+				return nil, fmt.Errorf("%s:%d: import cycle: %s", name, i+1, strings.Join(cycle, " -> "))
+			}
+		}
+
+		content, err := ioutil.ReadFile(target)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: while importing: %v", name, i+1, err)
+		}
+		if err := checkImport(content, target); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", name, i+1, err)
+		}
+		content, err = expandImports(content, target, append(stack, target))
+		if err != nil {
+			return nil, err
+		}
+
+		expanded = append(expanded, content...)
+		if len(content) > 0 && content[len(content)-1] != '\n' {
+			expanded = append(expanded, '\n')
+		}
+		afterImport = true
+	}
+
+	return expanded, nil
+}
+
+// rejectImports returns an error if the definition raw holds an %import
+// directive, imports can't be resolved without the definition file path.
+func rejectImports(raw []byte) error {
+	for i, line := range bytes.SplitAfter(raw, []byte("\n")) {
+		if isSectionLine(line) == importDirective {
+			return fmt.Errorf("definition:%d: %%import requires the path of the definition file", i+1)
+		}
+	}
+	return nil
+}
+
+// importPath returns the path argument of an %import line.
+func importPath(line []byte) string {
+	ident := strings.SplitN(string(line), "#", 2)[0]
+	fields := strings.Fields(ident)
+	if len(fields) != 2 {
+		return ""
+	}
+	return fields[1]
+}
+
+// checkImport makes sure that an imported definition file from path only
+// holds sections, since a header would start a new build stage.
+func checkImport(content []byte, path string) error {
+	for i, line := range bytes.SplitAfter(content, []byte("\n")) {
+		if isSectionLine(line) != "" {
+			return nil
+		}
+		if l := strings.TrimSpace(string(line)); l != "" && !strings.HasPrefix(l, "#") {
+			return fmt.Errorf("%s:%d: imported definition files can only hold sections", path, i+1)
+		}
+	}
+	return nil
+}
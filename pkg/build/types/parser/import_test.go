@@ -0,0 +1,117 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package parser
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sylabs/singularity/internal/pkg/test"
+)
+
+func TestParseDefinitionStagesPathImport(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	path := "testdata_good/import/import"
+	defFile, err := os.Open(path)
+	if err != nil {
+		t.Fatal("failed to open:", err)
+	}
+	defer defFile.Close()
+
+	defs, err := ParseDefinitionStagesPath(path, defFile, nil)
+	if err != nil {
+		t.Fatal("failed to parse definition file:", err)
+	}
+	if len(defs) != 1 {
+		t.Fatalf("expected 1 stage, got %d", len(defs))
+	}
+	d := defs[0]
+
+	// Include is a header, not an import
+	if d.Header["include"] != "bash" {
+		t.Errorf("unexpected include header: %s", d.Header["include"])
+	}
+	labels := map[string]string{"Maintainer": "security-team"}
+	if !reflect.DeepEqual(d.Labels, labels) {
+		t.Errorf("unexpected labels: got %v, expected %v", d.Labels, labels)
+	}
+	if env := strings.TrimSpace(d.Environment); env != "export LC_ALL=C" {
+		t.Errorf("unexpected %%environment section: %s", env)
+	}
+	// imported sections come first in import order
+	post := strings.Fields(d.BuildData.Post)
+	if strings.Join(post, " ") != "chmod 0700 /root apk add --no-cache curl" {
+		t.Errorf("unexpected %%post section: %s", d.BuildData.Post)
+	}
+
+	// the raw definition is the expanded definition
+	raw := string(d.Raw)
+	if strings.Contains(raw, "%import") || !strings.Contains(raw, "Maintainer security-team") {
+		t.Errorf("unexpected raw definition:\n%s", raw)
+	}
+	again, err := ParseDefinitionStages(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("failed to parse expanded definition:", err)
+	}
+	if !reflect.DeepEqual(again[0].ImageData, d.ImageData) || !reflect.DeepEqual(again[0].BuildData, d.BuildData) {
+		t.Errorf("expanded definition differs")
+	}
+}
+
+func TestParseDefinitionStagesPathImportFailure(t *testing.T) {
+	tests := []struct {
+		name    string
+		defPath string
+		errMsg  string
+	}{
+		{"Cycle", "testdata_bad/import_cycle/a.def", "import cycle"},
+		{"CycleLocation", "testdata_bad/import_cycle/a.def", "c.def:1:"},
+		{"Header", "testdata_bad/import_cycle/header.def", "can only hold sections"},
+		{"Missing", "testdata_bad/import_cycle/missing.def", "missing.def:7:"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, test.WithoutPrivilege(func(t *testing.T) {
+			defFile, err := os.Open(tt.defPath)
+			if err != nil {
+				t.Fatal("failed to open:", err)
+			}
+			defer defFile.Close()
+
+			_, err = ParseDefinitionStagesPath(tt.defPath, defFile, nil)
+			if err == nil {
+				t.Fatal("unexpected success parsing definition file")
+			}
+			if !strings.Contains(err.Error(), tt.errMsg) {
+				t.Fatalf("unexpected error %q, expected %q", err, tt.errMsg)
+			}
+		}))
+	}
+}
+
+func TestExpandImportsAfterImport(t *testing.T) {
+	// content following an %import must start a new section
+	raw := "Bootstrap: docker\n%import fragments/labels.def\n    echo lost\n"
+	if _, err := expandImports([]byte(raw), "testdata_good/import/import", nil); err == nil {
+		t.Fatal("unexpected success expanding definition")
+	}
+}
+
+func TestParseDefinitionImportWithoutPath(t *testing.T) {
+	// imports are only resolved relative to the definition file path
+	raw := "Bootstrap: docker\nFrom: alpine\n\n%import testdata_good/import/fragments/labels.def\n"
+
+	if _, err := ParseDefinitionFile(strings.NewReader(raw)); err == nil || !strings.Contains(err.Error(), "definition:4:") {
+		t.Errorf("unexpected error parsing definition file: %v", err)
+	}
+	if _, err := ParseDefinitionStages(strings.NewReader(raw)); err == nil || !strings.Contains(err.Error(), "definition:4:") {
+		t.Errorf("unexpected error parsing definition stages: %v", err)
+	}
+}
@@ -0,0 +1,307 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package parser
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Severity is the severity of a problem found in a definition file.
+type Severity string
+
+const (
+	// SeverityError is a problem failing the build
+	SeverityError Severity = "error"
+	// SeverityWarning is a problem which doesn't prevent to build
+	SeverityWarning Severity = "warning"
+)
+
+// Diagnostic is a problem found in a definition file, Suggestion may hold
+// a hint to fix it.
+type Diagnostic struct {
+	Position
+	Severity   Severity `json:"severity"`
+	Message    string   `json:"message"`
+	Suggestion string   `json:"suggestion,omitempty"`
+}
+
+func (d Diagnostic) String() string {
+	s := fmt.Sprintf("%s: %s: %s", d.Position, d.Severity, d.Message)
+	if d.Suggestion != "" {
+		s += " (" + d.Suggestion + ")"
+	}
+	return s
+}
+
+// Lint parses the definition file read from r and returns all the problems
+// found, sorted by position. path is the location of the definition file,
+// %import directives are resolved relative to its directory while %files
+// sources are looked up from the current directory like during a build.
+func Lint(path string, r io.Reader) ([]Diagnostic, error) {
+	tree, diagnostics, err := ParseSyntaxTree(path, r)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &linter{syntaxParser: syntaxParser{diagnostics: diagnostics}, path: path}
+	l.lint(tree)
+
+	sort.SliceStable(l.diagnostics, func(i, j int) bool {
+		a, b := l.diagnostics[i].Position, l.diagnostics[j].Position
+		if a.File != b.File {
+			// diagnostics of the definition file come first
+			return a.File == path
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+
+	return l.diagnostics, nil
+}
+
+type linter struct {
+	syntaxParser
+	path string
+}
+
+func (l *linter) lint(tree *SyntaxTree) {
+	if len(tree.Stages) == 0 {
+		l.report(Position{File: l.path, Line: 1, Column: 1}, SeverityError, "", "%s", errEmptyDefinition)
+		return
+	}
+
+	names := make(map[string]Position)
+	for _, s := range tree.Stages {
+		l.lintHeaders(s)
+		l.lintSections(s, names)
+
+		if name := headerValue(s, "stage"); name != "" {
+			if pos, ok := names[name]; ok {
+				l.report(s.Pos, SeverityError, "", "stage name %s is already used by the stage at %s", name, pos)
+			} else {
+				names[name] = s.Pos
+			}
+		}
+	}
+}
+
+// headerValue returns the value of the last header key of stage s.
+func headerValue(s Stage, key string) string {
+	value := ""
+	for _, h := range s.Headers {
+		if h.Key == key {
+			value = h.Value
+		}
+	}
+	return value
+}
+
+func (l *linter) lintHeaders(s Stage) {
+	seen := make(map[string]Position)
+	for _, h := range s.Headers {
+		if !validHeaders[h.Key] {
+			l.report(h.Pos, SeverityError, didYouMean(h.Key, keys(validHeaders)), "unknown header keyword %s", h.Key)
+			continue
+		}
+		if pos, ok := seen[h.Key]; ok {
+			l.report(h.Pos, SeverityWarning, "remove one of them", "header %s is already set at %s, this value is used", h.Key, pos)
+		}
+		seen[h.Key] = h.Pos
+
+		if h.Value == "" {
+			severity := SeverityWarning
+			if h.Key == "from" || h.Key == "bootstrap" {
+				severity = SeverityError
+			}
+			l.report(h.ValuePos, severity, "", "empty %s header", h.Key)
+		}
+		if h.Key == "registry" || h.Key == "namespace" {
+			l.report(h.Pos, SeverityWarning, "set it in the From header", "header %s is no longer supported", h.Key)
+		}
+	}
+
+	if len(s.Headers) > 0 {
+		if _, ok := seen["bootstrap"]; !ok {
+			l.report(s.Pos, SeverityError, "add a Bootstrap header", "missing Bootstrap header")
+		}
+	}
+}
+
+func (l *linter) lintSections(s Stage, stages map[string]Position) {
+	seen := make(map[string]Position)
+	declared := make(map[string]bool)
+
+	for _, sec := range s.Sections {
+		key := sec.Name
+		switch {
+		case appSections[sec.Name]:
+			if len(sec.Args) == 0 {
+				l.report(sec.Pos, SeverityError, "use %"+sec.Name+" <app>", "%%%s section without app name", sec.Name)
+				continue
+			}
+			key += " " + sec.Args[0]
+			if pos, ok := seen[key]; ok {
+				l.report(sec.Pos, SeverityError, "merge it with the first one", "duplicate %%%s section for app %s, already declared at %s", sec.Name, sec.Args[0], pos)
+			}
+		case sec.Name == "files":
+			stage := ""
+			if len(sec.Args) == 2 && strings.ToLower(sec.Args[0]) == "from" {
+				stage = sec.Args[1]
+				key += " from " + stage
+				if _, ok := stages[stage]; !ok {
+					l.report(sec.Pos, SeverityError, "", "%%files from %s: no previous stage named %s", stage, stage)
+				}
+			} else if len(sec.Args) != 0 {
+				l.report(sec.Pos, SeverityError, "use %files or %files from <stage>", "invalid %%files arguments %s", strings.Join(sec.Args, " "))
+				continue
+			}
+			l.lintFiles(sec, stage)
+			fallthrough
+		case validSections[sec.Name]:
+			// sections of the definition file itself are not expected to
+			// be repeated, only imports add to existing sections
+			if pos, ok := seen[key]; ok && pos.File == sec.Pos.File {
+				l.report(sec.Pos, SeverityWarning, "merge it with the first one", "%%%s section already declared at %s, their content is concatenated", key, pos)
+			}
+		default:
+			l.report(sec.Pos, SeverityError, didYouMean(sec.Name, append(keys(validSections), keys(appSections)...)), "unknown section %%%s", sec.Name)
+			continue
+		}
+		if _, ok := seen[key]; !ok {
+			seen[key] = sec.Pos
+		}
+
+		if sec.Name == "arguments" {
+			for _, line := range sec.Body {
+				text := strings.TrimSpace(line.Text)
+				if text == "" || strings.HasPrefix(text, "#") {
+					continue
+				}
+				name, _, err := ParseArgument(text)
+				if err != nil {
+					l.report(line.Pos, SeverityError, "", "%v", err)
+					continue
+				}
+				declared[name] = true
+			}
+		}
+	}
+
+	l.lintArguments(s, declared)
+}
+
+// lintFiles checks that the sources of a %files section copying from the
+// host exist.
+func (l *linter) lintFiles(sec Section, stage string) {
+	for _, line := range sec.Body {
+		text := strings.TrimSpace(line.Text)
+		if text == "" || strings.HasPrefix(text, "#") || stage != "" {
+			continue
+		}
+		src := strings.Fields(text)[0]
+		// the actual path is only known at build time
+		if argumentRef.MatchString(text) {
+			continue
+		}
+		if _, err := os.Stat(src); err != nil {
+			l.report(line.Pos, SeverityError, "", "%%files source %s doesn't exist", src)
+		}
+	}
+}
+
+// lintArguments reports the build arguments referenced by stage s without
+// default value.
+func (l *linter) lintArguments(s Stage, declared map[string]bool) {
+	reported := make(map[string]bool)
+	// pos is the position of the start of text
+	check := func(pos Position, text string) {
+		for _, m := range argumentRef.FindAllStringSubmatchIndex(text, -1) {
+			name := text[m[2]:m[3]]
+			if declared[name] || reported[name] {
+				continue
+			}
+			reported[name] = true
+			p := pos
+			p.Column += m[0]
+			l.report(p, SeverityWarning, "set it with --build-arg "+name+"=<value>", "build argument %s has no default value", name)
+		}
+	}
+
+	for _, h := range s.Headers {
+		check(h.ValuePos, h.Value)
+	}
+	for _, sec := range s.Sections {
+		if sec.Name == "arguments" {
+			continue
+		}
+		for _, line := range sec.Body {
+			p := line.Pos
+			p.Column = 1
+			check(p, line.Text)
+		}
+	}
+}
+
+func keys(m map[string]bool) []string {
+	var k []string
+	for s := range m {
+		k = append(k, s)
+	}
+	sort.Strings(k)
+	return k
+}
+
+// didYouMean returns a suggestion naming the candidate closest to word, or
+// an empty string if none is close enough.
+func didYouMean(word string, candidates []string) string {
+	best, bestDistance := "", 3
+	for _, c := range candidates {
+		if d := distance(strings.ToLower(word), c); d < bestDistance {
+			best, bestDistance = c, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return "did you mean " + best + "?"
+}
+
+// distance returns the Levenshtein distance between a and b.
+func distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package parser
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	path := "testdata_bad/lint/lint"
+	defFile, err := os.Open(path)
+	if err != nil {
+		t.Fatal("failed to open:", err)
+	}
+	defer defFile.Close()
+
+	diagnostics, err := Lint(path, defFile)
+	if err != nil {
+		t.Fatal("failed to lint definition file:", err)
+	}
+
+	expected := []Diagnostic{
+		{Position{path, 2, 1}, SeverityError, "unknown header keyword form", "did you mean from?"},
+		{Position{path, 3, 6}, SeverityError, "empty from header", ""},
+		{Position{path, 7, 10}, SeverityWarning, "build argument VERSION has no default value", "set it with --build-arg VERSION=<value>"},
+		{Position{path, 9, 1}, SeverityError, "unknown section %pots", "did you mean post?"},
+		{Position{path, 14, 5}, SeverityError, "%files source testdata_bad/lint/missing doesn't exist", ""},
+		{Position{path, 19, 1}, SeverityError, "duplicate %appinstall section for app foo, already declared at " + path + ":16:1", "merge it with the first one"},
+		{Position{path, 25, 1}, SeverityError, "%files from devel: no previous stage named devel", ""},
+	}
+	if !reflect.DeepEqual(diagnostics, expected) {
+		var got []string
+		for _, d := range diagnostics {
+			got = append(got, d.String())
+		}
+		t.Fatalf("unexpected diagnostics:\n%s", strings.Join(got, "\n"))
+	}
+}
+
+func TestLintGood(t *testing.T) {
+	for _, path := range []string{
+		"testdata_good/arguments/arguments",
+		"testdata_good/import/import",
+		"testdata_good/multistage/multistage",
+	} {
+		defFile, err := os.Open(path)
+		if err != nil {
+			t.Fatal("failed to open:", err)
+		}
+		defer defFile.Close()
+
+		diagnostics, err := Lint(path, defFile)
+		if err != nil {
+			t.Fatalf("failed to lint %s: %v", path, err)
+		}
+		for _, d := range diagnostics {
+			// sources of %files are relative to the build directory
+			if d.Severity == SeverityError && !strings.HasPrefix(d.Message, "%files source") {
+				t.Errorf("unexpected diagnostic: %s", d)
+			}
+		}
+	}
+}
+
+func TestLintImport(t *testing.T) {
+	path := "testdata_bad/import_cycle/a.def"
+	defFile, err := os.Open(path)
+	if err != nil {
+		t.Fatal("failed to open:", err)
+	}
+	defer defFile.Close()
+
+	diagnostics, err := Lint(path, defFile)
+	if err != nil {
+		t.Fatal("failed to lint definition file:", err)
+	}
+	if len(diagnostics) != 1 || !strings.Contains(diagnostics[0].Message, "import cycle") {
+		t.Fatalf("unexpected diagnostics: %v", diagnostics)
+	}
+	if pos := diagnostics[0].Position; !strings.HasSuffix(pos.File, "c.def") || pos.Line != 1 {
+		t.Fatalf("unexpected position of import cycle: %s", pos)
+	}
+}
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		d    int
+	}{
+		{"", "", 0},
+		{"post", "post", 0},
+		{"pots", "post", 2},
+		{"boostrap", "bootstrap", 1},
+		{"", "abc", 3},
+	}
+	for _, tt := range tests {
+		if d := distance(tt.a, tt.b); d != tt.d {
+			t.Errorf("distance(%q, %q) = %d, expected %d", tt.a, tt.b, d, tt.d)
+		}
+	}
+}
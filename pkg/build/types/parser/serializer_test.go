@@ -0,0 +1,161 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sylabs/singularity/pkg/build/types"
+)
+
+// parseWritten writes d as a definition file and parses it again.
+func parseWritten(t *testing.T, d types.Definition) (types.Definition, []byte) {
+	var buf bytes.Buffer
+	if err := types.WriteDefinitionFile(&d, &buf); err != nil {
+		t.Fatal("failed to write definition file:", err)
+	}
+	out, err := ParseDefinitionFile(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("failed to parse written definition file: %v\n%s", err, buf.Bytes())
+	}
+	return out, buf.Bytes()
+}
+
+// endSections returns a copy of d whose non empty sections end with a
+// newline, as written by WriteDefinitionFile.
+func endSections(d types.Definition) types.Definition {
+	end := func(s *string) {
+		if *s != "" && !strings.HasSuffix(*s, "\n") {
+			*s += "\n"
+		}
+	}
+	for _, s := range []*string{
+		&d.ImageData.Help, &d.ImageData.Environment, &d.ImageData.Runscript, &d.ImageData.Test, &d.ImageData.Startscript,
+		&d.BuildData.Pre, &d.BuildData.Setup, &d.BuildData.Post, &d.BuildData.Test,
+	} {
+		end(s)
+	}
+	if d.CustomData != nil {
+		custom := make(map[string]string)
+		for k, v := range d.CustomData {
+			end(&v)
+			custom[k] = v
+		}
+		d.CustomData = custom
+	}
+	return d
+}
+
+func TestWriteDefinitionFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		defPath string
+	}{
+		{"Apps", "testdata_good/apps/apps"},
+		{"Arch", "testdata_good/arch/arch"},
+		{"BusyBox", "testdata_good/busybox/busybox"},
+		{"Debootstrap", "testdata_good/debootstrap/debootstrap"},
+		{"Docker", "testdata_good/docker/docker"},
+		{"LocalImage", "testdata_good/localimage/localimage"},
+		{"Scratch", "testdata_good/scratch/scratch"},
+		{"Shub", "testdata_good/shub/shub"},
+		{"Yum", "testdata_good/yum/yum"},
+		{"Zypper", "testdata_good/zypper/zypper"},
+		{"NoHeader", "testdata_good/noheader/noheader"},
+		{"NoHeaderComments", "testdata_good/noheadercomments/noheadercomments"},
+		{"NoHeaderWhiteSpace", "testdata_good/noheaderwhitespace/noheaderwhitespace"},
+		{"MultipleScripts", "testdata_good/multiplescripts/multiplescripts"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defFile, err := os.Open(tt.defPath)
+			if err != nil {
+				t.Fatal("failed to open:", err)
+			}
+			defer defFile.Close()
+
+			d, err := ParseDefinitionFile(defFile)
+			if err != nil {
+				t.Fatal("failed to parse definition file:", err)
+			}
+			// a header holding only comments can't be told apart from
+			// a missing one
+			if len(d.Header) == 0 {
+				d.Header = nil
+			}
+
+			// the first section of a definition file without header
+			// is trimmed by the parser, sections are written with a
+			// trailing newline
+			d = endSections(d)
+
+			out, raw := parseWritten(t, d)
+			out.Raw = d.Raw
+			if !reflect.DeepEqual(out, d) {
+				t.Fatalf("definition changed by a round trip:\n%s", raw)
+			}
+
+			// the written definition is stable
+			again, rawAgain := parseWritten(t, out)
+			if !bytes.Equal(raw, rawAgain) {
+				t.Fatalf("written definition is not deterministic:\n%s\n---\n%s", raw, rawAgain)
+			}
+			again.Raw = d.Raw
+			if !reflect.DeepEqual(again, d) {
+				t.Fatalf("definition changed by a second round trip:\n%s", rawAgain)
+			}
+		})
+	}
+}
+
+func TestNewDefinitionFromJSONApps(t *testing.T) {
+	defFile, err := os.Open("testdata_good/apps/apps")
+	if err != nil {
+		t.Fatal("failed to open:", err)
+	}
+	defer defFile.Close()
+
+	d, err := ParseDefinitionFile(defFile)
+	if err != nil {
+		t.Fatal("failed to parse definition file:", err)
+	}
+
+	// JSON definitions without raw definition get one generated
+	d.Raw = nil
+	b, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal("failed to marshal definition:", err)
+	}
+	fromJSON, err := types.NewDefinitionFromJSON(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal("failed to read JSON definition:", err)
+	}
+
+	raw := string(fromJSON.Raw)
+	for _, s := range []string{"%appinstall foo", "%apprun foo", "%appenv foo", "%apprun bar", "%applabels bar"} {
+		if !strings.Contains(raw, s+"\n") {
+			t.Errorf("section %s missing from raw definition:\n%s", s, raw)
+		}
+	}
+	// apps are grouped and sorted by name
+	if strings.Index(raw, "%apprun bar") > strings.Index(raw, "%appenv foo") {
+		t.Errorf("unexpected app section order:\n%s", raw)
+	}
+
+	out, err := ParseDefinitionFile(bytes.NewReader(fromJSON.Raw))
+	if err != nil {
+		t.Fatal("failed to parse raw definition:", err)
+	}
+	if !reflect.DeepEqual(out.CustomData, d.CustomData) || !reflect.DeepEqual(out.Labels, d.Labels) {
+		t.Fatalf("raw definition differs from JSON definition:\n%s", raw)
+	}
+}
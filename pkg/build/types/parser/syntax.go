@@ -0,0 +1,234 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package parser
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Position is a location in a definition file, lines and columns start at 1.
+type Position struct {
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// Header is a Key: value header line of a build stage.
+type Header struct {
+	Pos      Position
+	Key      string
+	Value    string
+	ValuePos Position
+}
+
+// Line is a line of the body of a section.
+type Line struct {
+	Pos  Position
+	Text string
+}
+
+// Section is a section of a build stage, Name is the lower case section
+// name and Args holds the words following it, like the app name of app
+// sections.
+type Section struct {
+	Pos  Position
+	Name string
+	Args []string
+	Body []Line
+}
+
+// Stage is a build stage of a definition file, sections imported with
+// %import directives are part of the stage.
+type Stage struct {
+	Pos      Position
+	Headers  []Header
+	Sections []Section
+}
+
+// SyntaxTree is a definition file parsed with the location of its elements.
+type SyntaxTree struct {
+	Stages []Stage
+}
+
+// syntaxParser builds the syntax tree of a definition file and its imports.
+type syntaxParser struct {
+	tree        SyntaxTree
+	diagnostics []Diagnostic
+}
+
+func (p *syntaxParser) report(pos Position, severity Severity, suggestion string, format string, a ...interface{}) {
+	p.diagnostics = append(p.diagnostics, Diagnostic{
+		Position:   pos,
+		Severity:   severity,
+		Message:    fmt.Sprintf(format, a...),
+		Suggestion: suggestion,
+	})
+}
+
+// stage returns the current build stage, starting one at pos if there is
+// none yet.
+func (p *syntaxParser) stage(pos Position) *Stage {
+	if len(p.tree.Stages) == 0 {
+		p.tree.Stages = append(p.tree.Stages, Stage{Pos: pos})
+	}
+	return &p.tree.Stages[len(p.tree.Stages)-1]
+}
+
+// isBootstrapLine reports whether line starts a new build stage, like
+// splitStages does.
+func isBootstrapLine(line string) bool {
+	linetoks := strings.SplitN(line, ":", 2)
+	return len(linetoks) == 2 && strings.ToLower(strings.TrimSpace(linetoks[0])) == "bootstrap"
+}
+
+// column returns the column of the first non blank character of line.
+func column(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " \t")) + 1
+}
+
+// parse adds the content of the definition file read from path to the tree,
+// imported is true for the sections of an imported file and stack holds the
+// files being imported.
+func (p *syntaxParser) parse(raw []byte, path string, imported bool, stack []string) {
+	var section *Section
+	afterImport := false
+
+	lines := strings.Split(string(raw), "\n")
+	for i, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		trimmed := strings.TrimSpace(line)
+		pos := Position{File: path, Line: i + 1, Column: column(line)}
+
+		if !imported && isBootstrapLine(line) && len(p.tree.Stages) > 0 {
+			if s := p.stage(pos); len(s.Headers) > 0 || len(s.Sections) > 0 {
+				p.tree.Stages = append(p.tree.Stages, Stage{Pos: pos})
+			}
+			section = nil
+			afterImport = false
+		}
+
+		if strings.HasPrefix(trimmed, "%") {
+			fields := strings.Fields(strings.SplitN(trimmed, "#", 2)[0])
+			name := strings.ToLower(strings.TrimLeft(fields[0], "%"))
+			afterImport = false
+
+			if name == importDirective {
+				section = nil
+				afterImport = true
+				p.parseImport(line, pos, stack)
+				continue
+			}
+
+			s := p.stage(pos)
+			s.Sections = append(s.Sections, Section{Pos: pos, Name: name, Args: fields[1:]})
+			section = &s.Sections[len(s.Sections)-1]
+			continue
+		}
+
+		if section != nil {
+			// the last empty string is the end of the file
+			if i < len(lines)-1 || line != "" {
+				section.Body = append(section.Body, Line{Pos: pos, Text: line})
+			}
+			continue
+		}
+
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if afterImport {
+			p.report(pos, SeverityError, "start a new section", "content following %%import is not part of any section")
+			continue
+		}
+		if imported {
+			p.report(pos, SeverityError, "", "imported definition files can only hold sections")
+			continue
+		}
+		p.parseHeader(line, pos)
+	}
+}
+
+// parseHeader adds the header line at pos to the current stage.
+func (p *syntaxParser) parseHeader(line string, pos Position) {
+	content := strings.SplitN(line, "#", 2)[0]
+	linetoks := strings.SplitN(content, ":", 2)
+	if len(linetoks) == 1 {
+		p.report(pos, SeverityError, "use the Key: value form", "header %s has no value", strings.TrimSpace(content))
+		return
+	}
+
+	h := Header{
+		Pos:   pos,
+		Key:   strings.ToLower(strings.TrimSpace(linetoks[0])),
+		Value: strings.TrimSpace(linetoks[1]),
+	}
+	h.ValuePos = pos
+	h.ValuePos.Column = len(linetoks[0]) + 1 + column(linetoks[1])
+
+	s := p.stage(pos)
+	s.Headers = append(s.Headers, h)
+}
+
+// parseImport adds the sections of the definition file imported by the
+// %import line at pos.
+func (p *syntaxParser) parseImport(line string, pos Position, stack []string) {
+	target := importPath([]byte(line))
+	if target == "" {
+		p.report(pos, SeverityError, "use %import <path>", "%%import requires a definition file path")
+		return
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(pos.File), target)
+	}
+	abs, err := filepath.Abs(target)
+	if err != nil {
+		p.report(pos, SeverityError, "", "%v", err)
+		return
+	}
+	for j, s := range stack {
+		if s == abs {
+			cycle := append(append([]string{}, stack[j:]...), abs)
+			p.report(pos, SeverityError, "", "import cycle: %s", strings.Join(cycle, " -> "))
+			return
+		}
+	}
+
+	content, err := ioutil.ReadFile(target)
+	if err != nil {
+		p.report(pos, SeverityError, "", "while importing: %v", err)
+		return
+	}
+	p.parse(content, target, true, append(stack, abs))
+}
+
+// ParseSyntaxTree parses the definition file read from r into a syntax tree
+// locating its elements, path is the location of the definition file used
+// in positions and to resolve %import directives. Problems preventing to
+// build the tree, like unreadable imports, are returned as diagnostics.
+func ParseSyntaxTree(path string, r io.Reader) (*SyntaxTree, []Diagnostic, error) {
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("While attempting to read in definition: %v", err)
+	}
+
+	var stack []string
+	if abs, err := filepath.Abs(path); err == nil {
+		stack = append(stack, abs)
+	}
+
+	p := &syntaxParser{}
+	p.parse(raw, path, false, stack)
+
+	return &p.tree, p.diagnostics, nil
+}
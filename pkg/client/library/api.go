@@ -1,4 +1,4 @@
-// Copyright (c) 2018, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2019, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -6,156 +6,173 @@
 package client
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"net/url"
-	"time"
 
 	"github.com/globalsign/mgo/bson"
 	"github.com/sylabs/singularity/internal/pkg/sylog"
-	"github.com/sylabs/singularity/pkg/util/user-agent"
 )
 
 // HTTP timeout in seconds
 const httpTimeout = 10
 
-func getEntity(baseURL string, authToken string, entityRef string) (entity Entity, found bool, err error) {
-	url := (baseURL + "/v1/entities/" + entityRef)
-	entJSON, found, err := apiGet(url, authToken)
-	if err != nil {
-		return entity, false, err
-	}
-	if !found {
-		return entity, false, nil
-	}
+// GetEntity returns the entity entityRef, found is false if it doesn't exist
+func (c *Client) GetEntity(ctx context.Context, entityRef string) (entity Entity, found bool, err error) {
 	var res EntityResponse
-	if err := json.Unmarshal(entJSON, &res); err != nil {
-		return entity, false, fmt.Errorf("error decoding entity: %v", err)
-	}
-	return res.Data, found, nil
+	found, err = c.get(ctx, "/v1/entities/"+entityRef, &res)
+	return res.Data, found, err
 }
 
-func getCollection(baseURL string, authToken string, collectionRef string) (collection Collection, found bool, err error) {
-	url := baseURL + "/v1/collections/" + collectionRef
-	colJSON, found, err := apiGet(url, authToken)
-	if err != nil {
-		return collection, false, err
-	}
-	if !found {
-		return collection, false, nil
-	}
+// GetCollection returns the collection collectionRef (entity/collection),
+// found is false if it doesn't exist
+func (c *Client) GetCollection(ctx context.Context, collectionRef string) (collection Collection, found bool, err error) {
 	var res CollectionResponse
-	if err := json.Unmarshal(colJSON, &res); err != nil {
-		return collection, false, fmt.Errorf("error decoding collection: %v", err)
-	}
-	return res.Data, found, nil
+	found, err = c.get(ctx, "/v1/collections/"+collectionRef, &res)
+	return res.Data, found, err
 }
 
-func getContainer(baseURL string, authToken string, containerRef string) (container Container, found bool, err error) {
-	url := baseURL + "/v1/containers/" + containerRef
-	conJSON, found, err := apiGet(url, authToken)
-	if err != nil {
-		return container, false, err
-	}
-	if !found {
-		return container, false, nil
-	}
+// GetContainer returns the container containerRef
+// (entity/collection/container), found is false if it doesn't exist
+func (c *Client) GetContainer(ctx context.Context, containerRef string) (container Container, found bool, err error) {
 	var res ContainerResponse
-	if err := json.Unmarshal(conJSON, &res); err != nil {
-		return container, false, fmt.Errorf("error decoding container: %v", err)
-	}
-	return res.Data, found, nil
+	found, err = c.get(ctx, "/v1/containers/"+containerRef, &res)
+	return res.Data, found, err
 }
 
-func getImage(baseURL string, authToken string, imageRef string) (image Image, found bool, err error) {
-	url := baseURL + "/v1/images/" + imageRef
-	imgJSON, found, err := apiGet(url, authToken)
-	if err != nil {
-		return image, false, err
-	}
-	if !found {
-		return image, false, nil
-	}
+// GetImage returns the image imageRef (entity/collection/container:tag or
+// entity/collection/container:hash), found is false if it doesn't exist
+func (c *Client) GetImage(ctx context.Context, imageRef string) (image Image, found bool, err error) {
 	var res ImageResponse
-	if err := json.Unmarshal(imgJSON, &res); err != nil {
-		return image, false, fmt.Errorf("error decoding image: %v", err)
-	}
-	return res.Data, found, nil
+	found, err = c.get(ctx, "/v1/images/"+imageRef, &res)
+	return res.Data, found, err
 }
 
-func createEntity(baseURL string, authToken string, name string) (entity Entity, err error) {
+// CreateEntity creates the entity name
+func (c *Client) CreateEntity(ctx context.Context, name string) (entity Entity, err error) {
 	e := Entity{
 		Name:        name,
 		Description: "No description",
 	}
-	entJSON, err := apiCreate(e, baseURL+"/v1/entities", authToken)
-	if err != nil {
-		return entity, err
-	}
 	var res EntityResponse
-	if err := json.Unmarshal(entJSON, &res); err != nil {
-		return entity, fmt.Errorf("error decoding entity: %v", err)
-	}
-	return res.Data, nil
+	err = c.create(ctx, "/v1/entities", e, &res)
+	return res.Data, err
 }
 
-func createCollection(baseURL string, authToken string, name string, entityID string) (collection Collection, err error) {
-	c := Collection{
+// CreateCollection creates the collection name in the entity entityID
+func (c *Client) CreateCollection(ctx context.Context, name string, entityID string) (collection Collection, err error) {
+	col := Collection{
 		Name:        name,
 		Description: "No description",
 		Entity:      bson.ObjectIdHex(entityID),
 	}
-	colJSON, err := apiCreate(c, baseURL+"/v1/collections", authToken)
-	if err != nil {
-		return collection, err
-	}
 	var res CollectionResponse
-	if err := json.Unmarshal(colJSON, &res); err != nil {
-		return collection, fmt.Errorf("error decoding collection: %v", err)
-	}
-	return res.Data, nil
+	err = c.create(ctx, "/v1/collections", col, &res)
+	return res.Data, err
 }
 
-func createContainer(baseURL string, authToken string, name string, collectionID string) (container Container, err error) {
-	c := Container{
+// CreateContainer creates the container name in the collection collectionID
+func (c *Client) CreateContainer(ctx context.Context, name string, collectionID string) (container Container, err error) {
+	con := Container{
 		Name:        name,
 		Description: "No description",
 		Collection:  bson.ObjectIdHex(collectionID),
 	}
-	conJSON, err := apiCreate(c, baseURL+"/v1/containers", authToken)
-	if err != nil {
-		return container, err
-	}
 	var res ContainerResponse
-	if err := json.Unmarshal(conJSON, &res); err != nil {
-		return container, fmt.Errorf("error decoding container: %v", err)
-	}
-	return res.Data, nil
+	err = c.create(ctx, "/v1/containers", con, &res)
+	return res.Data, err
 }
 
-func createImage(baseURL string, authToken string, hash string, containerID string, description string) (image Image, err error) {
+// CreateImage creates the image with the given hash in the container
+// containerID
+func (c *Client) CreateImage(ctx context.Context, hash string, containerID string, description string) (image Image, err error) {
 	i := Image{
 		Hash:        hash,
 		Description: description,
 		Container:   bson.ObjectIdHex(containerID),
 	}
-	imgJSON, err := apiCreate(i, baseURL+"/v1/images", authToken)
-	if err != nil {
-		return image, err
-	}
 	var res ImageResponse
-	if err := json.Unmarshal(imgJSON, &res); err != nil {
-		return image, fmt.Errorf("error decoding image: %v", err)
-	}
-	return res.Data, nil
+	err = c.create(ctx, "/v1/images", i, &res)
+	return res.Data, err
 }
 
-func setTags(baseURL string, authToken string, containerID string, imageID string, tags []string) error {
+func (c *Client) create(ctx context.Context, path string, o interface{}, out interface{}) error {
+	sylog.Debugf("create calling %s\n", c.BaseURL+path)
+	return c.doJSON(ctx, "creation", http.MethodPost, path, o, out)
+}
+
+// ListEntities returns all the entities of the library
+func (c *Client) ListEntities(ctx context.Context) (entities []Entity, err error) {
+	err = c.list(ctx, "/v1/entities", nil, func(data json.RawMessage) (int, error) {
+		var page []Entity
+		err := json.Unmarshal(data, &page)
+		entities = append(entities, page...)
+		return len(page), err
+	})
+	return entities, err
+}
+
+// ListCollections returns the collections of the entity entityRef
+func (c *Client) ListCollections(ctx context.Context, entityRef string) (collections []Collection, err error) {
+	q := url.Values{"entity": {entityRef}}
+	err = c.list(ctx, "/v1/collections", q, func(data json.RawMessage) (int, error) {
+		var page []Collection
+		err := json.Unmarshal(data, &page)
+		collections = append(collections, page...)
+		return len(page), err
+	})
+	return collections, err
+}
+
+// ListContainers returns the containers of the collection collectionRef
+// (entity/collection)
+func (c *Client) ListContainers(ctx context.Context, collectionRef string) (containers []Container, err error) {
+	q := url.Values{"collection": {collectionRef}}
+	err = c.list(ctx, "/v1/containers", q, func(data json.RawMessage) (int, error) {
+		var page []Container
+		err := json.Unmarshal(data, &page)
+		containers = append(containers, page...)
+		return len(page), err
+	})
+	return containers, err
+}
+
+// ListTags returns the tags of the container containerID
+func (c *Client) ListTags(ctx context.Context, containerID string) (tags TagMap, err error) {
+	return c.listTags(ctx, "/v1/tags/"+containerID)
+}
+
+func (c *Client) listTags(ctx context.Context, path string) (tags TagMap, err error) {
+	sylog.Debugf("listTags calling %s\n", c.BaseURL+path)
+	tags = TagMap{}
+	err = c.list(ctx, path, nil, func(data json.RawMessage) (int, error) {
+		var page TagMap
+		err := json.Unmarshal(data, &page)
+		for tag, id := range page {
+			tags[tag] = id
+		}
+		return len(page), err
+	})
+	return tags, err
+}
+
+// SetTag points the tag t of the container containerID to its image
+func (c *Client) SetTag(ctx context.Context, containerID string, t ImageTag) error {
+	return c.setTag(ctx, "/v1/tags/"+containerID, t)
+}
+
+func (c *Client) setTag(ctx context.Context, path string, t ImageTag) error {
+	sylog.Debugf("setTag calling %s\n", c.BaseURL+path)
+	return c.doJSON(ctx, "setting tag", http.MethodPost, path, t, nil)
+}
+
+// SetTags points the tags of the container containerID to the image imageID,
+// replacing existing tags
+func (c *Client) SetTags(ctx context.Context, containerID string, imageID string, tags []string) error {
 	// Get existing tags, so we know which will be replaced
-	existingTags, err := apiGetTags(baseURL+"/v1/tags/"+containerID, authToken)
+	existingTags, err := c.ListTags(ctx, containerID)
 	if err != nil {
 		return err
 	}
@@ -171,177 +188,144 @@ func setTags(baseURL string, authToken string, containerID string, imageID strin
 			tag,
 			bson.ObjectIdHex(imageID),
 		}
-		err := apiSetTag(baseURL+"/v1/tags/"+containerID, authToken, imgTag)
-		if err != nil {
+		if err := c.SetTag(ctx, containerID, imgTag); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func search(baseURL string, authToken string, value string) (results SearchResults, err error) {
-	u, err := url.Parse(baseURL + "/v1/search")
-	if err != nil {
-		return
-	}
-	q := u.Query()
-	q.Set("value", value)
-	u.RawQuery = q.Encode()
+// ListImages returns the images of the container containerID
+func (c *Client) ListImages(ctx context.Context, containerID string) (images []Image, err error) {
+	q := url.Values{"container": {containerID}}
+	err = c.list(ctx, "/v1/images", q, func(data json.RawMessage) (int, error) {
+		var page []Image
+		err := json.Unmarshal(data, &page)
+		images = append(images, page...)
+		return len(page), err
+	})
+	return images, err
+}
 
-	resJSON, _, err := apiGet(u.String(), authToken)
-	if err != nil {
-		return results, err
-	}
+// DeleteTag removes the tag from the container containerID, the image it
+// points to is kept
+func (c *Client) DeleteTag(ctx context.Context, containerID string, tag string) error {
+	return c.delete(ctx, "/v1/tags/"+containerID+"/"+url.PathEscape(tag))
+}
 
+// DeleteImage deletes the image imageID along with the tags pointing to it
+func (c *Client) DeleteImage(ctx context.Context, imageID string) error {
+	return c.delete(ctx, "/v1/images/"+imageID)
+}
+
+// DeleteCollection deletes the collection collectionID, which must not hold
+// any container
+func (c *Client) DeleteCollection(ctx context.Context, collectionID string) error {
+	return c.delete(ctx, "/v1/collections/"+collectionID)
+}
+
+func (c *Client) delete(ctx context.Context, path string) error {
+	sylog.Debugf("delete calling %s\n", c.BaseURL+path)
+	return c.doJSON(ctx, "deletion", http.MethodDelete, path, nil, nil)
+}
+
+// SetCollectionPrivate makes the collection collectionID and its containers
+// private, or public if private is false
+func (c *Client) SetCollectionPrivate(ctx context.Context, collectionID string, private bool) (collection Collection, err error) {
+	path := "/v1/collections/" + collectionID
+	sylog.Debugf("update calling %s\n", c.BaseURL+path)
+	in := struct {
+		Private bool `json:"private"`
+	}{private}
+	var res CollectionResponse
+	err = c.doJSON(ctx, "update", http.MethodPut, path, in, &res)
+	return res.Data, err
+}
+
+// Search returns the entities, collections and containers matching value
+func (c *Client) Search(ctx context.Context, value string) (results SearchResults, err error) {
+	q := url.Values{"value": {value}}
+	path := "/v1/search?" + q.Encode()
+	sylog.Debugf("search calling %s\n", c.BaseURL+path)
 	var res SearchResponse
-	if err := json.Unmarshal(resJSON, &res); err != nil {
-		return results, fmt.Errorf("error decoding reesults: %v", err)
+	if err := c.doJSON(ctx, "search", http.MethodGet, path, nil, &res); err != nil {
+		return results, err
 	}
-
 	return res.Data, nil
 }
 
-func apiCreate(o interface{}, url string, authToken string) (objJSON []byte, err error) {
-	sylog.Debugf("apiCreate calling %s\n", url)
-	s, err := json.Marshal(o)
-	if err != nil {
-		return []byte{}, fmt.Errorf("error encoding object to JSON:\n\t%v", err)
-	}
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(s))
-	req.Header.Set("Content-Type", "application/json")
-	if authToken != "" {
-		req.Header.Set("Authorization", "Bearer "+authToken)
-	}
-	req.Header.Set("User-Agent", useragent.Value())
+func getEntity(baseURL string, authToken string, entityRef string) (entity Entity, found bool, err error) {
+	return NewClient(baseURL, authToken).GetEntity(context.Background(), entityRef)
+}
 
-	client := &http.Client{
-		Timeout: (httpTimeout * time.Second),
-	}
-	res, err := client.Do(req)
-	if err != nil {
-		return []byte{}, fmt.Errorf("error making request to server:\n\t%v", err)
-	}
-	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusCreated {
-		jRes, err := ParseErrorBody(res.Body)
-		if err != nil {
-			jRes = ParseErrorResponse(res)
-		}
-		return []byte{}, fmt.Errorf("creation did not succeed: %d %s\n\t%v",
-			jRes.Error.Code, jRes.Error.Status, jRes.Error.Message)
-	}
-	objJSON, err = ioutil.ReadAll(res.Body)
-	if err != nil {
-		return []byte{}, fmt.Errorf("error reading response from server:\n\t%v", err)
-	}
-	return objJSON, nil
+func getCollection(baseURL string, authToken string, collectionRef string) (collection Collection, found bool, err error) {
+	return NewClient(baseURL, authToken).GetCollection(context.Background(), collectionRef)
+}
+
+func getContainer(baseURL string, authToken string, containerRef string) (container Container, found bool, err error) {
+	return NewClient(baseURL, authToken).GetContainer(context.Background(), containerRef)
+}
+
+func getImage(baseURL string, authToken string, imageRef string) (image Image, found bool, err error) {
+	return NewClient(baseURL, authToken).GetImage(context.Background(), imageRef)
+}
+
+func createEntity(baseURL string, authToken string, name string) (entity Entity, err error) {
+	return NewClient(baseURL, authToken).CreateEntity(context.Background(), name)
+}
+
+func createCollection(baseURL string, authToken string, name string, entityID string) (collection Collection, err error) {
+	return NewClient(baseURL, authToken).CreateCollection(context.Background(), name, entityID)
+}
+
+func createContainer(baseURL string, authToken string, name string, collectionID string) (container Container, err error) {
+	return NewClient(baseURL, authToken).CreateContainer(context.Background(), name, collectionID)
+}
+
+func createImage(baseURL string, authToken string, hash string, containerID string, description string) (image Image, err error) {
+	return NewClient(baseURL, authToken).CreateImage(context.Background(), hash, containerID, description)
+}
+
+func setTags(baseURL string, authToken string, containerID string, imageID string, tags []string) error {
+	return NewClient(baseURL, authToken).SetTags(context.Background(), containerID, imageID, tags)
+}
+
+func search(baseURL string, authToken string, value string) (results SearchResults, err error) {
+	return NewClient(baseURL, authToken).Search(context.Background(), value)
+}
+
+func apiCreate(o interface{}, url string, authToken string) (objJSON []byte, err error) {
+	var raw json.RawMessage
+	err = NewClient("", authToken).create(context.Background(), url, o, &raw)
+	return raw, err
 }
 
 func apiGet(url string, authToken string) (objJSON []byte, found bool, err error) {
-	sylog.Debugf("apiGet calling %s\n", url)
-	client := &http.Client{
-		Timeout: (httpTimeout * time.Second),
-	}
-	req, err := http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
-		return []byte{}, false, fmt.Errorf("error creating request to server:\n\t%v", err)
-	}
-	if authToken != "" {
-		req.Header.Set("Authorization", "Bearer "+authToken)
-	}
-	req.Header.Set("User-Agent", useragent.Value())
-	res, err := client.Do(req)
-	if err != nil {
-		return []byte{}, false, fmt.Errorf("error making request to server:\n\t%v", err)
-	}
-	defer res.Body.Close()
-	if res.StatusCode == http.StatusNotFound {
-		return []byte{}, false, nil
-	}
-	if res.StatusCode == http.StatusOK {
-		objJSON, err := ioutil.ReadAll(res.Body)
-		if err != nil {
-			return []byte{}, false, fmt.Errorf("error reading response from server:\n\t%v", err)
-		}
-		return objJSON, true, nil
-	}
-	// Not OK, not 404.... error
-	jRes, err := ParseErrorBody(res.Body)
-	if err != nil {
-		jRes = ParseErrorResponse(res)
-	}
-	return []byte{}, false, fmt.Errorf("get did not succeed: %d %s\n\t%v",
-		jRes.Error.Code, jRes.Error.Status, jRes.Error.Message)
+	var raw json.RawMessage
+	found, err = NewClient("", authToken).get(context.Background(), url, &raw)
+	return raw, found, err
 }
 
 func apiGetTags(url string, authToken string) (tags TagMap, err error) {
-	sylog.Debugf("apiGetTags calling %s\n", url)
-	client := &http.Client{
-		Timeout: (httpTimeout * time.Second),
-	}
-	req, err := http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("error creating request to server:\n\t%v", err)
-	}
-	if authToken != "" {
-		req.Header.Set("Authorization", "Bearer "+authToken)
-	}
-	req.Header.Set("User-Agent", useragent.Value())
-	res, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("error making request to server:\n\t%v", err)
-	}
-	if res.StatusCode != http.StatusOK {
-		jRes, err := ParseErrorBody(res.Body)
-		if err != nil {
-			jRes = ParseErrorResponse(res)
-		}
-		return nil, fmt.Errorf("creation did not succeed: %d %s\n\t%v",
-			jRes.Error.Code, jRes.Error.Status, jRes.Error.Message)
-	}
-	var tagRes TagsResponse
-	err = json.NewDecoder(res.Body).Decode(&tagRes)
-	if err != nil {
-		return tags, fmt.Errorf("error decoding tags: %v", err)
-	}
-	return tagRes.Data, nil
-
+	return NewClient("", authToken).listTags(context.Background(), url)
 }
 
 func apiSetTag(url string, authToken string, t ImageTag) (err error) {
-	sylog.Debugf("apiSetTag calling %s\n", url)
-	s, err := json.Marshal(t)
-	if err != nil {
-		return fmt.Errorf("error encoding object to JSON:\n\t%v", err)
-	}
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(s))
-	req.Header.Set("Content-Type", "application/json")
-	if authToken != "" {
-		req.Header.Set("Authorization", "Bearer "+authToken)
-	}
-	req.Header.Set("User-Agent", useragent.Value())
-	client := &http.Client{
-		Timeout: (httpTimeout * time.Second),
-	}
-	res, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("error making request to server:\n\t%v", err)
-	}
-	if res.StatusCode != http.StatusOK {
-		jRes, err := ParseErrorBody(res.Body)
-		if err != nil {
-			jRes = ParseErrorResponse(res)
-		}
-		return fmt.Errorf("creation did not succeed: %d %s\n\t%v",
-			jRes.Error.Code, jRes.Error.Status, jRes.Error.Message)
-	}
-	return nil
+	return NewClient("", authToken).setTag(context.Background(), url, t)
 }
 
 // GetImage returns the Image object if exists, otherwise returns error
 func GetImage(baseURL string, authToken string, imageRef string) (image Image, err error) {
+	return NewClient(baseURL, authToken).GetLibraryImage(context.Background(), imageRef)
+}
+
+// GetLibraryImage returns the image of the library reference imageRef
+// ([library://][entity/[collection/]]container[:tag]), or an error if it
+// doesn't exist
+func (c *Client) GetLibraryImage(ctx context.Context, imageRef string) (image Image, err error) {
 	entityName, collectionName, containerName, tags := parseLibraryRef(imageRef)
 
-	i, f, err := getImage(baseURL, authToken, entityName+"/"+collectionName+"/"+containerName+":"+tags[0])
+	i, f, err := c.GetImage(ctx, entityName+"/"+collectionName+"/"+containerName+":"+tags[0])
 	if err != nil {
 		return Image{}, err
 	} else if !f {
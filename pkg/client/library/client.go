@@ -0,0 +1,245 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	useragent "github.com/sylabs/singularity/pkg/util/user-agent"
+)
+
+// Number of times a request is retried after a server error or rate limiting
+const defaultRetries = 3
+
+// Number of items requested per page when listing
+const defaultPageSize = 100
+
+// defaultRetryDelay is the delay before retrying a request, doubled after
+// each attempt
+var defaultRetryDelay = time.Second
+
+// Client is a client of the library API. Requests are cancelled with their
+// context, and retried with an increasing delay after rate limiting, or
+// after server errors for idempotent requests.
+type Client struct {
+	// BaseURL is the URL of the library API, e.g. https://library.sylabs.io
+	BaseURL string
+	// AuthToken is sent as a bearer token when set
+	AuthToken string
+	// HTTPClient sends the requests. Image transfers use a copy of it with
+	// their own timeout.
+	HTTPClient *http.Client
+	// Retries is the number of times a request is retried
+	Retries int
+	// RetryDelay is the delay before the first retry, unless the library
+	// sets a Retry-After header
+	RetryDelay time.Duration
+	// PageSize is the number of items requested per page when listing
+	PageSize int
+}
+
+// NewClient returns a client of the library API at baseURL, authenticated
+// with authToken if set.
+func NewClient(baseURL string, authToken string) *Client {
+	return &Client{
+		BaseURL:   baseURL,
+		AuthToken: authToken,
+		HTTPClient: &http.Client{
+			Timeout: (httpTimeout * time.Second),
+		},
+		Retries:    defaultRetries,
+		RetryDelay: defaultRetryDelay,
+		PageSize:   defaultPageSize,
+	}
+}
+
+// newRequest returns a request to the library path, which may also be an
+// absolute URL when BaseURL is empty.
+func (c *Client) newRequest(ctx context.Context, method string, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request to server:\n\t%v", err)
+	}
+	req = req.WithContext(ctx)
+	if c.AuthToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.AuthToken)
+	}
+	req.Header.Set("User-Agent", useragent.Value())
+	return req, nil
+}
+
+// transferClient returns the HTTP client used for image transfers, which
+// last longer than API calls.
+func (c *Client) transferClient(timeout time.Duration) *http.Client {
+	hc := *c.HTTPClient
+	hc.Timeout = timeout
+	return &hc
+}
+
+// do sends a request with body to the library path and returns the response,
+// after retries if the library is rate limiting or failing.
+func (c *Client) do(ctx context.Context, method string, path string, body []byte) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		var r io.Reader
+		if body != nil {
+			r = bytes.NewReader(body)
+		}
+		req, err := c.newRequest(ctx, method, path, r)
+		if err != nil {
+			return nil, err
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		res, err := c.HTTPClient.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("error making request to server:\n\t%v", err)
+		}
+		if attempt >= c.Retries || !retryable(method, res.StatusCode) {
+			return res, nil
+		}
+		res.Body.Close()
+
+		delay := c.RetryDelay << uint(attempt)
+		if s, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && s >= 0 {
+			delay = time.Duration(s) * time.Second
+		}
+		sylog.Debugf("%s %s returned %d, retrying in %s\n", method, req.URL, res.StatusCode, delay)
+		if err := sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// retryable returns whether a request may be sent again after a response
+// with the status code, requests which may have been processed by a failing
+// server are only sent again if they are idempotent.
+func retryable(method string, code int) bool {
+	if code == http.StatusTooManyRequests {
+		return true
+	}
+	if code < http.StatusInternalServerError {
+		return false
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// sleep waits for d, unless ctx is cancelled first
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// doJSON sends a request with in encoded as JSON to the library path and
+// decodes the response into out, unless the response has no content.
+// Unsuccessful responses are returned as an *Error for the operation op.
+func (c *Client) doJSON(ctx context.Context, op string, method string, path string, in interface{}, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return fmt.Errorf("error encoding object to JSON:\n\t%v", err)
+		}
+	}
+
+	res, err := c.do(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+	case http.StatusNoContent:
+		return nil
+	default:
+		return newError(op, res)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(res.Body).Decode(out); err == io.EOF {
+		// empty body
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error decoding response from server:\n\t%v", err)
+	}
+	return nil
+}
+
+// get decodes the object at the library path into out, found is false if it
+// doesn't exist.
+func (c *Client) get(ctx context.Context, path string, out interface{}) (found bool, err error) {
+	sylog.Debugf("get calling %s\n", c.BaseURL+path)
+	err = c.doJSON(ctx, "get", http.MethodGet, path, nil, out)
+	if IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// listResponse - Response from the API for a page of a list request
+type listResponse struct {
+	Data  json.RawMessage `json:"data"`
+	Page  *Page           `json:"page,omitempty"`
+	Error JSONError       `json:"error,omitempty"`
+}
+
+// list requests all the pages of the list at the library path, and calls add
+// with the data of each page, which returns the number of items it holds.
+// Responses without pagination hold the whole list.
+func (c *Client) list(ctx context.Context, path string, query url.Values, add func(data json.RawMessage) (int, error)) error {
+	if query == nil {
+		query = url.Values{}
+	}
+	offset := 0
+	for {
+		query.Set("offset", strconv.Itoa(offset))
+		query.Set("limit", strconv.Itoa(c.PageSize))
+
+		var res listResponse
+		if err := c.doJSON(ctx, "list", http.MethodGet, path+"?"+query.Encode(), nil, &res); err != nil {
+			return err
+		}
+		if len(res.Data) == 0 {
+			return nil
+		}
+		n, err := add(res.Data)
+		if err != nil {
+			return fmt.Errorf("error decoding list: %v", err)
+		}
+		if res.Page == nil || n == 0 {
+			return nil
+		}
+		offset = res.Page.Offset + n
+		if offset >= res.Page.Total {
+			return nil
+		}
+	}
+}
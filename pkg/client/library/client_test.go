@@ -0,0 +1,218 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/sylabs/singularity/internal/pkg/test"
+)
+
+func TestClientRetries(t *testing.T) {
+	tests := []struct {
+		description string
+		method      string
+		codes       []int
+		retryAfter  string
+		requests    int
+		expectCode  int
+	}{
+		{"GetServerError", http.MethodGet, []int{500, 503, 200}, "", 3, 0},
+		{"GetTooManyServerErrors", http.MethodGet, []int{500, 500, 500, 500, 200}, "", 4, 500},
+		{"PostServerError", http.MethodPost, []int{500, 200}, "", 1, 500},
+		{"PostRateLimited", http.MethodPost, []int{429, 200}, "", 2, 0},
+		{"RetryAfter", http.MethodGet, []int{429, 200}, "0", 2, 0},
+		{"ClientError", http.MethodGet, []int{400, 200}, "", 1, 400},
+		{"NoContent", http.MethodDelete, []int{204}, "", 1, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, test.WithoutPrivilege(func(t *testing.T) {
+			requests := 0
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != tt.method {
+					t.Errorf("Unexpected method %s", r.Method)
+				}
+				if r.Header.Get("Authorization") != "Bearer "+testToken {
+					t.Errorf("Unexpected authorization %q", r.Header.Get("Authorization"))
+				}
+				code := tt.codes[requests]
+				requests++
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(code)
+				if code != http.StatusOK {
+					json.NewEncoder(w).Encode(JSONResponse{Error: JSONError{Code: code, Status: http.StatusText(code), Message: "failed"}})
+					return
+				}
+				json.NewEncoder(w).Encode(EntityResponse{Data: testEntity})
+			}))
+			defer s.Close()
+
+			c := NewClient(s.URL, testToken)
+			c.RetryDelay = time.Millisecond
+
+			var res EntityResponse
+			err := c.doJSON(context.Background(), "test", tt.method, "/v1/entities/test", nil, &res)
+
+			if requests != tt.requests {
+				t.Errorf("Unexpected number of requests %d, expected %d", requests, tt.requests)
+			}
+			if tt.expectCode == 0 {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			e, ok := err.(*Error)
+			if !ok {
+				t.Fatalf("Unexpected error type %T: %v", err, err)
+			}
+			if e.Code != tt.expectCode || e.Message != "failed" || e.Op != "test" {
+				t.Errorf("Unexpected error %#v", e)
+			}
+			if e.Temporary() != (tt.expectCode >= 500) {
+				t.Errorf("Unexpected temporary %v for %d", e.Temporary(), e.Code)
+			}
+		}))
+	}
+}
+
+func TestClientCancel(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer s.Close()
+
+	c := NewClient(s.URL, "")
+	c.RetryDelay = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, _, err := c.GetEntity(ctx, "test")
+	if err != context.DeadlineExceeded {
+		t.Errorf("Unexpected error %v, expected %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/entities/missing", "/v1/search":
+			w.WriteHeader(http.StatusNotFound)
+		case "/v1/entities/private":
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(JSONResponse{Error: JSONError{Code: http.StatusUnauthorized, Status: "Unauthorized", Message: "token expired"}})
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("bad request"))
+		}
+	}))
+	defer s.Close()
+
+	c := NewClient(s.URL, "")
+	ctx := context.Background()
+
+	if _, found, err := c.GetEntity(ctx, "missing"); found || err != nil {
+		t.Errorf("Unexpected result for missing entity: %v %v", found, err)
+	}
+	if _, err := c.Search(ctx, "missing"); !IsNotFound(err) {
+		t.Errorf("Unexpected error %v, expected not found", err)
+	}
+
+	_, _, err := c.GetEntity(ctx, "private")
+	if !IsUnauthorized(err) {
+		t.Errorf("Unexpected error %v, expected unauthorized", err)
+	} else if e := err.(*Error); e.Message != "token expired" {
+		t.Errorf("Unexpected message %q", e.Message)
+	}
+
+	_, err = c.CreateEntity(ctx, "bad")
+	if e, ok := err.(*Error); !ok || e.Code != http.StatusBadRequest || e.Message != "bad request" || e.Op != "creation" {
+		t.Errorf("Unexpected error %#v", err)
+	}
+}
+
+func TestClientList(t *testing.T) {
+	var entities []Entity
+	for i := 0; i < 7; i++ {
+		entities = append(entities, Entity{ID: bson.NewObjectId(), Name: "entity" + strconv.Itoa(i)})
+	}
+	tags := TagMap{"latest": bson.NewObjectId(), "v1": bson.NewObjectId()}
+
+	requests := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+		switch r.URL.Path {
+		case "/v1/entities":
+			end := offset + limit
+			if end > len(entities) {
+				end = len(entities)
+			}
+			json.NewEncoder(w).Encode(struct {
+				Data []Entity `json:"data"`
+				Page Page     `json:"page"`
+			}{entities[offset:end], Page{Offset: offset, Limit: limit, Total: len(entities)}})
+		case "/v1/collections":
+			if r.URL.Query().Get("entity") != "test-user" {
+				t.Errorf("Unexpected entity %q", r.URL.Query().Get("entity"))
+			}
+			json.NewEncoder(w).Encode(struct {
+				Data []Collection `json:"data"`
+				Page Page         `json:"page"`
+			}{nil, Page{Offset: offset, Limit: limit}})
+		case "/v1/tags/container":
+			// no pagination support
+			json.NewEncoder(w).Encode(TagsResponse{Data: tags})
+		default:
+			t.Errorf("Unexpected request %s", r.URL)
+		}
+	}))
+	defer s.Close()
+
+	c := NewClient(s.URL, "")
+	c.PageSize = 3
+	ctx := context.Background()
+
+	e, err := c.ListEntities(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(e, entities) {
+		t.Errorf("Unexpected entities %v", e)
+	}
+	if requests != 3 {
+		t.Errorf("Unexpected number of requests %d for entities", requests)
+	}
+
+	requests = 0
+	col, err := c.ListCollections(ctx, "test-user")
+	if err != nil || len(col) != 0 || requests != 1 {
+		t.Errorf("Unexpected collections %v in %d requests: %v", col, requests, err)
+	}
+
+	requests = 0
+	tm, err := c.ListTags(ctx, "container")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(tm, tags) || requests != 1 {
+		t.Errorf("Unexpected tags %v in %d requests", tm, requests)
+	}
+}
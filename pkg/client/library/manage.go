@@ -0,0 +1,201 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+)
+
+// parseCollectionRef returns the entity and collection names of the library
+// reference collectionRef ([library://]entity/collection)
+func parseCollectionRef(collectionRef string) (entity string, collection string, err error) {
+	refParts := strings.Split(strings.TrimPrefix(collectionRef, "library://"), "/")
+	if len(refParts) != 2 || refParts[0] == "" || refParts[1] == "" {
+		return "", "", fmt.Errorf("invalid collection reference %s, must be library://entity/collection", collectionRef)
+	}
+	return refParts[0], refParts[1], nil
+}
+
+// parseContainerRef returns the entity/collection/container path and the
+// tags of the library reference containerRef
+// ([library://]entity/collection/container[:tag])
+func parseContainerRef(containerRef string) (path string, tags []string, err error) {
+	entity, collection, container, tags := parseLibraryRef(containerRef)
+	if entity == "" || collection == "" || container == "" {
+		return "", nil, fmt.Errorf("invalid container reference %s, must be library://entity/collection/container", containerRef)
+	}
+	return entity + "/" + collection + "/" + container, tags, nil
+}
+
+// GetLibraryCollection returns the collection of the library reference
+// collectionRef ([library://]entity/collection), or an error if it doesn't
+// exist
+func (c *Client) GetLibraryCollection(ctx context.Context, collectionRef string) (collection Collection, err error) {
+	entity, name, err := parseCollectionRef(collectionRef)
+	if err != nil {
+		return Collection{}, err
+	}
+	col, found, err := c.GetCollection(ctx, entity+"/"+name)
+	if err != nil {
+		return Collection{}, err
+	} else if !found {
+		return Collection{}, fmt.Errorf("the collection %s was not found in the library", collectionRef)
+	}
+	return col, nil
+}
+
+// GetLibraryContainer returns the container of the library reference
+// containerRef ([library://]entity/collection/container), or an error if it
+// doesn't exist. A tag in the reference is ignored.
+func (c *Client) GetLibraryContainer(ctx context.Context, containerRef string) (container Container, err error) {
+	path, _, err := parseContainerRef(containerRef)
+	if err != nil {
+		return Container{}, err
+	}
+	con, found, err := c.GetContainer(ctx, path)
+	if err != nil {
+		return Container{}, err
+	} else if !found {
+		return Container{}, fmt.Errorf("the container %s was not found in the library", containerRef)
+	}
+	return con, nil
+}
+
+// AddLibraryTags points the tags of the container of the library image
+// imageRef to this image. Tags must not exist yet, or must already exist
+// when they are moved from another image.
+func (c *Client) AddLibraryTags(ctx context.Context, imageRef string, tags []string, move bool) error {
+	image, err := c.GetLibraryImage(ctx, imageRef)
+	if err != nil {
+		return err
+	}
+	containerID := image.Container.Hex()
+	existingTags, err := c.ListTags(ctx, containerID)
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		id, ok := existingTags[tag]
+		if ok && !move {
+			return fmt.Errorf("the tag %s already exists, it must be moved instead", tag)
+		} else if !ok && move {
+			return fmt.Errorf("the tag %s doesn't exist", tag)
+		} else if id == image.ID {
+			sylog.Infof("%s already points to the image\n", tag)
+		}
+	}
+	for _, tag := range tags {
+		if existingTags[tag] == image.ID {
+			continue
+		}
+		sylog.Infof("Setting tag %s\n", tag)
+		if err := c.SetTag(ctx, containerID, ImageTag{tag, image.ID}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveLibraryTags removes the tags of the library reference tagRef
+// ([library://]entity/collection/container:tag[,tag...]), the images they
+// point to are kept.
+func (c *Client) RemoveLibraryTags(ctx context.Context, tagRef string) error {
+	path, tags, err := parseContainerRef(tagRef)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(tagRef[strings.LastIndex(tagRef, "/")+1:], ":") {
+		return fmt.Errorf("no tag to remove in %s", tagRef)
+	}
+	con, err := c.GetLibraryContainer(ctx, path)
+	if err != nil {
+		return err
+	}
+	existingTags, err := c.ListTags(ctx, con.ID.Hex())
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		if _, ok := existingTags[tag]; !ok {
+			return fmt.Errorf("the tag %s doesn't exist", tag)
+		}
+	}
+	for _, tag := range tags {
+		if err := c.DeleteTag(ctx, con.ID.Hex(), tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteLibraryImage deletes the image of the library reference imageRef
+// along with the tags pointing to it
+func (c *Client) DeleteLibraryImage(ctx context.Context, imageRef string) error {
+	image, err := c.GetLibraryImage(ctx, imageRef)
+	if err != nil {
+		return err
+	}
+	return c.DeleteImage(ctx, image.ID.Hex())
+}
+
+// CreateLibraryCollection creates the collection of the library reference
+// collectionRef ([library://]entity/collection) in its existing entity
+func (c *Client) CreateLibraryCollection(ctx context.Context, collectionRef string, private bool) (collection Collection, err error) {
+	entityName, name, err := parseCollectionRef(collectionRef)
+	if err != nil {
+		return Collection{}, err
+	}
+	entity, found, err := c.GetEntity(ctx, entityName)
+	if err != nil {
+		return Collection{}, err
+	} else if !found {
+		return Collection{}, fmt.Errorf("the entity %s was not found in the library", entityName)
+	}
+	if _, found, err := c.GetCollection(ctx, entityName+"/"+name); err != nil {
+		return Collection{}, err
+	} else if found {
+		return Collection{}, fmt.Errorf("the collection %s already exists", collectionRef)
+	}
+
+	col, err := c.CreateCollection(ctx, name, entity.ID.Hex())
+	if err != nil || col.Private == private {
+		return col, err
+	}
+	return c.SetCollectionPrivate(ctx, col.ID.Hex(), private)
+}
+
+// DeleteLibraryCollection deletes the collection of the library reference
+// collectionRef, which must not hold any container
+func (c *Client) DeleteLibraryCollection(ctx context.Context, collectionRef string) error {
+	col, err := c.GetLibraryCollection(ctx, collectionRef)
+	if err != nil {
+		return err
+	}
+	if len(col.Containers) > 0 {
+		return fmt.Errorf("the collection %s holds %d containers, their images must be deleted first", collectionRef, len(col.Containers))
+	}
+	return c.DeleteCollection(ctx, col.ID.Hex())
+}
+
+// SetLibraryVisibility makes the collection of the library reference
+// collectionRef private, or public if private is false
+func (c *Client) SetLibraryVisibility(ctx context.Context, collectionRef string, private bool) error {
+	col, err := c.GetLibraryCollection(ctx, collectionRef)
+	if err != nil {
+		return err
+	}
+	if col.Private == private {
+		return nil
+	}
+	_, err = c.SetCollectionPrivate(ctx, col.ID.Hex(), private)
+	return err
+}
@@ -0,0 +1,250 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// mockLibrary serves a library holding the entity test-user, with an empty
+// collection empty and the collection test-collection holding the container
+// test-container, whose images are tagged latest and v1. The modifying
+// requests are recorded, deletions are answered without content if
+// noContent is set.
+type mockLibrary struct {
+	t         *testing.T
+	images    []Image
+	tags      TagMap
+	requests  []string
+	noContent bool
+}
+
+func newMockLibrary(t *testing.T) *mockLibrary {
+	m := &mockLibrary{t: t}
+	for _, h := range []string{"sha256.1111", "sha256.2222"} {
+		m.images = append(m.images, Image{ID: bson.NewObjectId(), Hash: h, Container: testContainer.ID})
+	}
+	m.tags = TagMap{"latest": m.images[1].ID, "v1": m.images[0].ID}
+	return m
+}
+
+func (m *mockLibrary) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var data interface{}
+	path := r.URL.Path
+
+	if r.Method != http.MethodGet {
+		m.requests = append(m.requests, r.Method+" "+path)
+		if r.Method == http.MethodDelete && m.noContent {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		data = testCollection
+		if r.Method == http.MethodPut {
+			var in map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&in)
+			col := testCollection
+			col.Private = in["private"].(bool)
+			data = col
+		}
+	} else {
+		switch {
+		case path == "/v1/entities/test-user":
+			data = testEntity
+		case path == "/v1/collections/test-user/test-collection":
+			col := testCollection
+			col.Containers = []bson.ObjectId{testContainer.ID}
+			data = col
+		case path == "/v1/collections/test-user/empty":
+			col := testCollection
+			col.Private = true
+			data = col
+		case path == "/v1/containers/test-user/test-collection/test-container":
+			data = testContainer
+		case strings.HasPrefix(path, "/v1/images/test-user/test-collection/test-container:"):
+			ref := strings.TrimPrefix(path, "/v1/images/test-user/test-collection/test-container:")
+			for _, img := range m.images {
+				if img.Hash == ref || m.tags[ref] == img.ID {
+					data = img
+				}
+			}
+		case path == "/v1/images":
+			if r.URL.Query().Get("container") != testContainer.ID.Hex() {
+				m.t.Errorf("Unexpected container %s", r.URL.Query().Get("container"))
+			}
+			data = m.images
+		case path == "/v1/tags/"+testContainer.ID.Hex():
+			data = m.tags
+		}
+	}
+
+	if data == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(JSONResponse{Data: data})
+}
+
+func TestLibraryTags(t *testing.T) {
+	m := newMockLibrary(t)
+	s := httptest.NewServer(m)
+	defer s.Close()
+
+	c := NewClient(s.URL, testToken)
+	ctx := context.Background()
+	tagsPath := "/v1/tags/" + testContainer.ID.Hex()
+
+	images, err := c.ListImages(ctx, testContainer.ID.Hex())
+	if err != nil || !reflect.DeepEqual(images, m.images) {
+		t.Errorf("Unexpected images %v: %v", images, err)
+	}
+
+	tests := []struct {
+		description string
+		fn          func() error
+		expectError bool
+		requests    []string
+	}{
+		{"AddTag", func() error {
+			return c.AddLibraryTags(ctx, "library://test-user/test-collection/test-container:v1", []string{"stable", "v1"}, false)
+		}, true, nil},
+		{"AddNewTags", func() error {
+			return c.AddLibraryTags(ctx, "library://test-user/test-collection/test-container:sha256.1111", []string{"stable", "1.0"}, false)
+		}, false, []string{"POST " + tagsPath, "POST " + tagsPath}},
+		{"MoveMissingTag", func() error {
+			return c.AddLibraryTags(ctx, "test-user/test-collection/test-container:v1", []string{"stable"}, true)
+		}, true, nil},
+		{"MoveTag", func() error {
+			return c.AddLibraryTags(ctx, "test-user/test-collection/test-container:v1", []string{"latest", "v1"}, true)
+		}, false, []string{"POST " + tagsPath}},
+		{"RemoveWithoutTag", func() error {
+			return c.RemoveLibraryTags(ctx, "library://test-user/test-collection/test-container")
+		}, true, nil},
+		{"RemoveMissingTag", func() error {
+			return c.RemoveLibraryTags(ctx, "library://test-user/test-collection/test-container:latest,stable")
+		}, true, nil},
+		{"RemoveTags", func() error {
+			return c.RemoveLibraryTags(ctx, "library://test-user/test-collection/test-container:latest,v1")
+		}, false, []string{"DELETE " + tagsPath + "/latest", "DELETE " + tagsPath + "/v1"}},
+		{"RemoveShortRef", func() error {
+			return c.RemoveLibraryTags(ctx, "test-collection/test-container:latest")
+		}, true, nil},
+		{"DeleteImage", func() error {
+			return c.DeleteLibraryImage(ctx, "library://test-user/test-collection/test-container:latest")
+		}, false, []string{"DELETE /v1/images/" + m.images[1].ID.Hex()}},
+		{"DeleteImageNoContent", func() error {
+			m.noContent = true
+			defer func() { m.noContent = false }()
+			return c.DeleteLibraryImage(ctx, "library://test-user/test-collection/test-container:v1")
+		}, false, []string{"DELETE /v1/images/" + m.images[0].ID.Hex()}},
+		{"RemoveTagsNoContent", func() error {
+			m.noContent = true
+			defer func() { m.noContent = false }()
+			return c.RemoveLibraryTags(ctx, "library://test-user/test-collection/test-container:v1")
+		}, false, []string{"DELETE " + tagsPath + "/v1"}},
+		{"DeleteMissingImage", func() error {
+			return c.DeleteLibraryImage(ctx, "library://test-user/test-collection/test-container:sha256.3333")
+		}, true, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			m.requests = nil
+			err := tt.fn()
+			if tt.expectError && err == nil {
+				t.Errorf("Unexpected success")
+			} else if !tt.expectError && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(m.requests, tt.requests) {
+				t.Errorf("Unexpected requests %v, expected %v", m.requests, tt.requests)
+			}
+		})
+	}
+}
+
+func TestLibraryCollections(t *testing.T) {
+	m := newMockLibrary(t)
+	s := httptest.NewServer(m)
+	defer s.Close()
+
+	c := NewClient(s.URL, testToken)
+	ctx := context.Background()
+	colPath := "/v1/collections/" + testCollection.ID.Hex()
+
+	tests := []struct {
+		description string
+		fn          func() error
+		expectError bool
+		requests    []string
+	}{
+		{"CreateInvalid", func() error {
+			_, err := c.CreateLibraryCollection(ctx, "library://test-user", false)
+			return err
+		}, true, nil},
+		{"CreateExisting", func() error {
+			_, err := c.CreateLibraryCollection(ctx, "library://test-user/empty", false)
+			return err
+		}, true, nil},
+		{"CreateMissingEntity", func() error {
+			_, err := c.CreateLibraryCollection(ctx, "library://other/new", false)
+			return err
+		}, true, nil},
+		{"Create", func() error {
+			_, err := c.CreateLibraryCollection(ctx, "library://test-user/new", false)
+			return err
+		}, false, []string{"POST /v1/collections"}},
+		{"CreatePrivate", func() error {
+			col, err := c.CreateLibraryCollection(ctx, "test-user/new", true)
+			if err == nil && !col.Private {
+				t.Errorf("Collection isn't private")
+			}
+			return err
+		}, false, []string{"POST /v1/collections", "PUT " + colPath}},
+		{"DeleteNotEmpty", func() error {
+			return c.DeleteLibraryCollection(ctx, "library://test-user/test-collection")
+		}, true, nil},
+		{"Delete", func() error {
+			return c.DeleteLibraryCollection(ctx, "library://test-user/empty")
+		}, false, []string{"DELETE " + colPath}},
+		{"DeleteNoContent", func() error {
+			m.noContent = true
+			defer func() { m.noContent = false }()
+			return c.DeleteLibraryCollection(ctx, "library://test-user/empty")
+		}, false, []string{"DELETE " + colPath}},
+		{"SetPrivate", func() error {
+			return c.SetLibraryVisibility(ctx, "library://test-user/test-collection", true)
+		}, false, []string{"PUT " + colPath}},
+		{"SetUnchanged", func() error {
+			return c.SetLibraryVisibility(ctx, "library://test-user/empty", true)
+		}, false, nil},
+		{"SetMissing", func() error {
+			return c.SetLibraryVisibility(ctx, "library://test-user/missing", false)
+		}, true, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			m.requests = nil
+			err := tt.fn()
+			if tt.expectError && err == nil {
+				t.Errorf("Unexpected success")
+			} else if !tt.expectError && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(m.requests, tt.requests) {
+				t.Errorf("Unexpected requests %v, expected %v", m.requests, tt.requests)
+			}
+		})
+	}
+}
@@ -1,4 +1,4 @@
-// Copyright (c) 2018, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2019, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -200,6 +200,23 @@ func (b Blob) GetID() bson.ObjectId {
 	return b.ID
 }
 
+// MultipartUpload - Represents the upload of an image file in parts of
+// PartSize bytes, numbered from 1. The library acknowledges parts in order,
+// UploadedParts is the number of parts acknowledged so far.
+type MultipartUpload struct {
+	UploadID      string `json:"uploadID"`
+	PartSize      int64  `json:"partSize"`
+	UploadedParts int    `json:"uploadedParts"`
+}
+
+// MultipartUploadStart - Request starting the upload of an image file in
+// parts, or resuming the unfinished upload of the same file
+type MultipartUploadStart struct {
+	Size     int64  `json:"size"`
+	Hash     string `json:"hash"`
+	PartSize int64  `json:"partSize"`
+}
+
 // ImageTag - A single mapping from a string to bson ID. Not stored in the DB
 // but used by API calls setting tags
 type ImageTag struct {
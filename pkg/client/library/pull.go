@@ -1,4 +1,4 @@
-// Copyright (c) 2018, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2019, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -6,24 +6,65 @@
 package client
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/sylabs/singularity/internal/pkg/sylog"
-	useragent "github.com/sylabs/singularity/pkg/util/user-agent"
 	pb "gopkg.in/cheggaaa/pb.v1"
 )
 
 // Timeout for an image pull in seconds - could be a large download...
 const pullTimeout = 1800
 
+// Number of times an interrupted download is resumed before giving up
+const downloadRetries = 3
+
+var (
+	// downloadChunkSize is the size of the ranges fetched by each connection
+	// of a parallel download
+	downloadChunkSize int64 = 64 * 1024 * 1024
+	// downloadRetryDelay is the delay before resuming an interrupted download,
+	// multiplied by the number of attempts
+	downloadRetryDelay = time.Second
+)
+
+// errRangeUnsupported is returned when the server ignores a range request
+var errRangeUnsupported = errors.New("server does not support range requests")
+
+// retryableError is a network error after which the download is resumed
+type retryableError struct {
+	err error
+}
+
+func (e retryableError) Error() string {
+	return e.err.Error()
+}
+
 // DownloadImage will retrieve an image from the Container Library,
 // saving it into the specified file
 func DownloadImage(filePath string, libraryRef string, libraryURL string, Force bool, authToken string) error {
+	return DownloadImageParallel(filePath, libraryRef, libraryURL, Force, authToken, 1)
+}
+
+// DownloadImageParallel will retrieve an image from the Container Library
+// with up to parallel connections, saving it into the specified file
+func DownloadImageParallel(filePath string, libraryRef string, libraryURL string, Force bool, authToken string, parallel int) error {
+	return NewClient(libraryURL, authToken).DownloadImage(context.Background(), filePath, libraryRef, Force, parallel)
+}
+
+// DownloadImage will retrieve an image from the library with up to parallel
+// connections, saving it into the specified file. The image is downloaded
+// into a temporary file next to filePath which is resumed after an
+// interruption, and moved into place once its hash matches the hash of the
+// library image.
+func (c *Client) DownloadImage(ctx context.Context, filePath string, libraryRef string, force bool, parallel int) error {
 
 	if !IsLibraryPullRef(libraryRef) {
 		return fmt.Errorf("Not a valid library reference: %s", libraryRef)
@@ -35,89 +76,278 @@ func DownloadImage(filePath string, libraryRef string, libraryURL string, Force
 		sylog.Infof("Download filename not provided. Downloading to: %s\n", filePath)
 	}
 
+	if !force {
+		if _, err := os.Stat(filePath); err == nil {
+			return fmt.Errorf("image file already exists - will not overwrite")
+		}
+	}
+
+	image, err := c.GetLibraryImage(ctx, libraryRef)
+	if err != nil {
+		return err
+	}
+	if !IsImageHash(image.Hash) {
+		return fmt.Errorf("the library returned an invalid image hash %q", image.Hash)
+	}
+
 	libraryRef = strings.TrimPrefix(libraryRef, "library://")
 
 	if strings.Index(libraryRef, ":") == -1 {
 		libraryRef += ":latest"
 	}
 
-	url := libraryURL + "/v1/imagefile/" + libraryRef
+	d := &imageDownload{
+		ctx:  ctx,
+		c:    c,
+		hc:   c.transferClient(pullTimeout * time.Second),
+		path: "/v1/imagefile/" + libraryRef,
+		bar:  pb.New64(image.Size).SetUnits(pb.U_BYTES),
+	}
 
-	sylog.Debugf("Pulling from URL: %s\n", url)
+	sylog.Debugf("Pulling from URL: %s\n", c.BaseURL+d.path)
 
-	if !Force {
-		if _, err := os.Stat(filePath); err == nil {
-			return fmt.Errorf("image file already exists - will not overwrite")
-		}
-	}
+	// the temporary file is named after the image hash so a download is only
+	// resumed from a partial download of the same image
+	tmpPath := fmt.Sprintf("%s.%s.part", filePath, image.Hash)
 
-	client := &http.Client{
-		Timeout: pullTimeout * time.Second,
+	if sylog.GetLevel() < 0 {
+		d.bar.NotPrint = true
 	}
+	d.bar.ShowTimeLeft = true
+	d.bar.ShowSpeed = true
+	d.bar.Start()
 
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+	err = errRangeUnsupported
+	if parallel > 1 && image.Size > downloadChunkSize {
+		err = d.chunks(tmpPath, image.Size, parallel)
+		if err == errRangeUnsupported {
+			sylog.Debugf("Server does not support range requests, downloading with a single connection")
+		}
+	}
+	if err == errRangeUnsupported {
+		err = d.download(tmpPath, 0, -1)
+	}
+	d.bar.Finish()
 	if err != nil {
 		return err
 	}
 
-	if authToken != "" {
-		req.Header.Set("Authorization", "Bearer "+authToken)
-	}
-	req.Header.Set("User-Agent", useragent.Value())
+	sylog.Debugf("Download complete, verifying image hash\n")
 
-	res, err := client.Do(req)
+	hash, err := ImageHash(tmpPath)
 	if err != nil {
+		return fmt.Errorf("while computing the hash of the downloaded image: %v", err)
+	}
+	if hash != image.Hash {
+		os.Remove(tmpPath)
+		return fmt.Errorf("downloaded image hash %s does not match the library image hash %s", hash, image.Hash)
+	}
+
+	return os.Rename(tmpPath, filePath)
+}
+
+// imageDownload holds the state shared by the connections downloading an
+// image file
+type imageDownload struct {
+	ctx context.Context
+	c   *Client
+	// hc is the HTTP client with the transfer timeout
+	hc *http.Client
+	// path is the path of the image file in the library
+	path string
+	bar  *pb.ProgressBar
+}
+
+// chunks writes the content of the image file of the given size into the
+// file path, fetching ranges of downloadChunkSize with parallel connections.
+// Each range is stored in its own file until all are complete, so the ranges
+// downloaded before an interruption are kept.
+func (d *imageDownload) chunks(path string, size int64, parallel int) error {
+	n := int((size + downloadChunkSize - 1) / downloadChunkSize)
+	chunkPath := func(i int) string {
+		return fmt.Sprintf("%s.%d", path, i)
+	}
+
+	chunks := make(chan int, n)
+	for i := 0; i < n; i++ {
+		chunks <- i
+	}
+	close(chunks)
+
+	errs := make(chan error, parallel)
+	var wg sync.WaitGroup
+	for w := 0; w < parallel; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range chunks {
+				start := int64(i) * downloadChunkSize
+				end := start + downloadChunkSize - 1
+				if end >= size {
+					end = size - 1
+				}
+				if err := d.download(chunkPath(i), start, end); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	if err := <-errs; err != nil {
+		if err == errRangeUnsupported {
+			for i := 0; i < n; i++ {
+				os.Remove(chunkPath(i))
+			}
+		}
 		return err
 	}
-	defer res.Body.Close()
 
-	if res.StatusCode == http.StatusNotFound {
-		return fmt.Errorf("The requested image was not found in the library")
+	// Perms are 777 *prior* to umask
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0777)
+	if err != nil {
+		return err
 	}
+	defer out.Close()
 
-	if res.StatusCode != http.StatusOK {
-		jRes, err := ParseErrorBody(res.Body)
+	for i := 0; i < n; i++ {
+		in, err := os.Open(chunkPath(i))
 		if err != nil {
-			jRes = ParseErrorResponse(res)
+			return err
 		}
-		return fmt.Errorf("Download did not succeed: %d %s\n\t%v",
-			jRes.Error.Code, jRes.Error.Status, jRes.Error.Message)
+		_, err = io.Copy(out, in)
+		in.Close()
+		if err != nil {
+			return fmt.Errorf("while assembling downloaded ranges: %v", err)
+		}
+	}
+	if err := out.Close(); err != nil {
+		return err
 	}
 
-	sylog.Debugf("OK response received, beginning body download\n")
+	for i := 0; i < n; i++ {
+		os.Remove(chunkPath(i))
+	}
+	return nil
+}
 
+// download writes the content of the image file from the offset start up to
+// end, or up to the end of the content if end is negative, into the file
+// path. It resumes from the data already present in the file, including
+// after network errors.
+func (d *imageDownload) download(path string, start, end int64) error {
 	// Perms are 777 *prior* to umask
-	out, err := os.OpenFile(filePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0777)
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0777)
 	if err != nil {
 		return err
 	}
 	defer out.Close()
 
-	sylog.Debugf("Created output file: %s\n", filePath)
+	sylog.Debugf("Writing to file: %s\n", path)
 
-	bodySize := res.ContentLength
-	bar := pb.New(int(bodySize)).SetUnits(pb.U_BYTES)
-	if sylog.GetLevel() < 0 {
-		bar.NotPrint = true
+	offset, err := out.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		sylog.Debugf("Resuming download of %s at %d bytes\n", path, offset)
+		d.bar.Add64(offset)
 	}
-	bar.ShowTimeLeft = true
-	bar.ShowSpeed = true
 
-	// create proxy reader
-	bodyProgress := bar.NewProxyReader(res.Body)
+	for attempt := 1; ; attempt++ {
+		if end >= 0 && start+offset > end {
+			return nil
+		}
+
+		n, err := d.fetch(out, start+offset, end)
+		offset += n
 
-	bar.Start()
+		switch e := err.(type) {
+		case nil:
+			return nil
+		case retryableError:
+			if attempt > downloadRetries {
+				return fmt.Errorf("download failed after %d attempts: %v", attempt, e.err)
+			}
+			sylog.Warningf("Download interrupted: %v, resuming", e.err)
+			if err := sleep(d.ctx, time.Duration(attempt)*downloadRetryDelay); err != nil {
+				return err
+			}
+		default:
+			if err != errRangeUnsupported || start != 0 || end >= 0 {
+				return err
+			}
+			// the partial download can't be resumed, start over
+			sylog.Debugf("Server does not support range requests, restarting download\n")
+			if err := out.Truncate(0); err != nil {
+				return err
+			}
+			if _, err := out.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			d.bar.Add64(-offset)
+			offset = 0
+		}
+	}
+}
 
-	// Write the body to file
-	_, err = io.Copy(out, bodyProgress)
+// fetch writes the content of the image file from the offset start up to
+// end, or up to the end of the content if end is negative, to w. It returns
+// the number of bytes written, and a retryableError for errors worth
+// resuming after.
+func (d *imageDownload) fetch(w io.Writer, start, end int64) (int64, error) {
+	req, err := d.c.newRequest(d.ctx, http.MethodGet, d.path, nil)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	bar.Finish()
+	ranged := start > 0 || end >= 0
+	if ranged {
+		r := fmt.Sprintf("bytes=%d-", start)
+		if end >= 0 {
+			r += fmt.Sprintf("%d", end)
+		}
+		req.Header.Set("Range", r)
+	}
 
-	sylog.Debugf("Download complete\n")
+	res, err := d.hc.Do(req)
+	if err != nil {
+		if d.ctx.Err() != nil {
+			return 0, d.ctx.Err()
+		}
+		return 0, retryableError{err}
+	}
+	defer res.Body.Close()
 
-	return nil
+	switch res.StatusCode {
+	case http.StatusOK:
+		if ranged {
+			return 0, errRangeUnsupported
+		}
+	case http.StatusPartialContent:
+		if cr := res.Header.Get("Content-Range"); !strings.HasPrefix(cr, fmt.Sprintf("bytes %d-", start)) {
+			return 0, fmt.Errorf("unexpected content range %q for offset %d", cr, start)
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// the whole content was already downloaded
+		if end < 0 {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("requested range %d-%d is not satisfiable", start, end)
+	case http.StatusNotFound:
+		return 0, fmt.Errorf("The requested image was not found in the library")
+	default:
+		return 0, newError("Download", res)
+	}
 
+	n, err := io.Copy(w, d.bar.NewProxyReader(res.Body))
+	if err != nil {
+		if d.ctx.Err() != nil {
+			return n, d.ctx.Err()
+		}
+		return n, retryableError{err}
+	}
+	return n, nil
 }
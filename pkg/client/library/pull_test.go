@@ -1,4 +1,4 @@
-// Copyright (c) 2018, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2019, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -8,12 +8,17 @@ package client
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/sylabs/singularity/internal/pkg/sylog"
 	"github.com/sylabs/singularity/internal/pkg/test"
@@ -33,6 +38,7 @@ type mockRawService struct {
 func (m *mockRawService) Run() {
 	mux := http.NewServeMux()
 	mux.HandleFunc(m.httpPath, m.ServeHTTP)
+	mux.HandleFunc("/v1/images/", m.serveImage)
 	m.httpServer = httptest.NewServer(mux)
 	m.httpAddr = m.httpServer.Listener.Addr().String()
 	m.baseURI = "http://" + m.httpAddr
@@ -61,6 +67,15 @@ func (m *mockRawService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// serveImage serves the library image metadata matching the test file
+func (m *mockRawService) serveImage(w http.ResponseWriter, r *http.Request) {
+	hash, err := ImageHash(m.testFile)
+	if err != nil {
+		m.t.Errorf("error computing hash of %s: %v", m.testFile, err)
+	}
+	json.NewEncoder(w).Encode(ImageResponse{Data: Image{Hash: hash}})
+}
+
 func Test_DownloadImage(t *testing.T) {
 
 	f, err := ioutil.TempFile("", "test")
@@ -127,3 +142,146 @@ func Test_DownloadImage(t *testing.T) {
 		}))
 	}
 }
+
+// mockRangeService serves content with range requests support, dropping the
+// connection after limit bytes were sent by a request when limit is set
+type mockRangeService struct {
+	content     []byte
+	hash        string
+	limit       int
+	ignoreRange bool
+	requests    int
+	mutex       sync.Mutex
+}
+
+func (m *mockRangeService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/v1/images/") {
+		json.NewEncoder(w).Encode(ImageResponse{Data: Image{Hash: m.hash, Size: int64(len(m.content))}})
+		return
+	}
+
+	m.mutex.Lock()
+	m.requests++
+	m.mutex.Unlock()
+
+	if m.ignoreRange {
+		r.Header.Del("Range")
+	}
+	if m.limit == 0 {
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(m.content))
+		return
+	}
+
+	// announce the full range length and close the connection early
+	rec := httptest.NewRecorder()
+	http.ServeContent(rec, r, "", time.Time{}, bytes.NewReader(m.content))
+	for k, v := range rec.Header() {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(rec.Code)
+	body := rec.Body.Bytes()
+	if len(body) > m.limit {
+		body = body[:m.limit]
+	}
+	w.Write(body)
+	w.(http.Flusher).Flush()
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err == nil {
+		conn.Close()
+	}
+}
+
+func Test_DownloadImageResume(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10)
+	f, err := ioutil.TempFile("", "download-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(content)
+	f.Close()
+	defer os.Remove(f.Name())
+	hash, err := ImageHash(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(size int64, delay time.Duration) {
+		downloadChunkSize = size
+		downloadRetryDelay = delay
+	}(downloadChunkSize, downloadRetryDelay)
+	downloadChunkSize = 16
+	downloadRetryDelay = time.Millisecond
+
+	tests := []struct {
+		name        string
+		hash        string
+		limit       int
+		ignoreRange bool
+		parallel    int
+		partial     []byte
+		requests    int
+		expectError bool
+	}{
+		{name: "Single", hash: hash, parallel: 1, requests: 1},
+		{name: "Interrupted", hash: hash, limit: 40, parallel: 1, requests: 3},
+		{name: "TooManyInterruptions", hash: hash, limit: 20, parallel: 1, requests: 4, expectError: true},
+		{name: "Partial", hash: hash, parallel: 1, partial: content[:50], requests: 1},
+		{name: "Complete", hash: hash, parallel: 1, partial: content, requests: 1},
+		{name: "PartialIgnoreRange", hash: hash, ignoreRange: true, parallel: 1, partial: content[:50], requests: 2},
+		{name: "Parallel", hash: hash, parallel: 3, requests: 7},
+		{name: "ParallelInterrupted", hash: hash, limit: 10, parallel: 3, requests: 13},
+		{name: "ParallelIgnoreRange", hash: hash, ignoreRange: true, parallel: 3, requests: 4},
+		{name: "BadHash", hash: "sha256.0000000000000000000000000000000000000000000000000000000000000000", parallel: 1, requests: 1, expectError: true},
+		{name: "InvalidHash", hash: "../hash", parallel: 1, requests: 0, expectError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, test.WithoutPrivilege(func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "download-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			m := &mockRangeService{content: content, hash: tt.hash, limit: tt.limit, ignoreRange: tt.ignoreRange}
+			s := httptest.NewServer(m)
+			defer s.Close()
+
+			path := filepath.Join(dir, "image.sif")
+			tmpPath := path + "." + tt.hash + ".part"
+			if tt.partial != nil {
+				if err := ioutil.WriteFile(tmpPath, tt.partial, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err = DownloadImageParallel(path, "entity/collection/image:tag", s.URL, false, "", tt.parallel)
+			if err != nil && !tt.expectError {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if err == nil && tt.expectError {
+				t.Errorf("Unexpected success. Expected error.")
+			}
+			if m.requests != tt.requests {
+				t.Errorf("Unexpected number of requests %d, expected %d", m.requests, tt.requests)
+			}
+
+			if tt.expectError {
+				if _, err := os.Stat(path); err == nil {
+					t.Errorf("Image file created by a failed download")
+				}
+				return
+			}
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatalf("Error reading downloaded file: %v", err)
+			}
+			if !bytes.Equal(b, content) {
+				t.Errorf("Unexpected downloaded content %q", b)
+			}
+			files, _ := ioutil.ReadDir(dir)
+			if len(files) != 1 {
+				t.Errorf("Temporary files left after download")
+			}
+		}))
+	}
+}
@@ -1,4 +1,4 @@
-// Copyright (c) 2018, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2019, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -7,21 +7,47 @@ package client
 
 import (
 	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"time"
 
 	"github.com/sylabs/singularity/internal/pkg/sylog"
-	"github.com/sylabs/singularity/pkg/util/user-agent"
 	"gopkg.in/cheggaaa/pb.v1"
 )
 
 // Timeout in seconds for the main upload (not api calls)
 const pushTimeout = 1800
 
+// Number of times the upload of a part is retried before giving up
+const uploadRetries = 5
+
+var (
+	// uploadPartSize is the size of the parts of a multipart upload
+	uploadPartSize int64 = 64 * 1024 * 1024
+	// uploadRetryDelay is the delay before retrying the upload of a part,
+	// doubled after each attempt
+	uploadRetryDelay = time.Second
+)
+
+// errMultipartUnsupported is returned when the library doesn't support
+// multipart uploads
+var errMultipartUnsupported = errors.New("multipart uploads are not supported")
+
 // UploadImage will push a specified image up to the Container Library,
 func UploadImage(filePath string, libraryRef string, libraryURL string, authToken string, description string) error {
+	return NewClient(libraryURL, authToken).UploadImage(context.Background(), filePath, libraryRef, description)
+}
+
+// UploadImage will push a specified image up to the library, creating the
+// entity, collection and container of libraryRef if needed and setting its
+// tags to the image
+func (c *Client) UploadImage(ctx context.Context, filePath string, libraryRef string, description string) error {
 
 	if !IsLibraryPushRef(libraryRef) {
 		return fmt.Errorf("Not a valid library reference: %s", libraryRef)
@@ -36,52 +62,52 @@ func UploadImage(filePath string, libraryRef string, libraryURL string, authToke
 	entityName, collectionName, containerName, tags := parseLibraryRef(libraryRef)
 
 	// Find or create entity
-	entity, found, err := getEntity(libraryURL, authToken, entityName)
+	entity, found, err := c.GetEntity(ctx, entityName)
 	if err != nil {
 		return err
 	}
 	if !found {
 		sylog.Verbosef("Entity %s does not exist in library - creating it.\n", entityName)
-		entity, err = createEntity(libraryURL, authToken, entityName)
+		entity, err = c.CreateEntity(ctx, entityName)
 		if err != nil {
 			return err
 		}
 	}
 
 	// Find or create collection
-	collection, found, err := getCollection(libraryURL, authToken, entityName+"/"+collectionName)
+	collection, found, err := c.GetCollection(ctx, entityName+"/"+collectionName)
 	if err != nil {
 		return err
 	}
 	if !found {
 		sylog.Verbosef("Collection %s does not exist in library - creating it.\n", collectionName)
-		collection, err = createCollection(libraryURL, authToken, collectionName, entity.GetID().Hex())
+		collection, err = c.CreateCollection(ctx, collectionName, entity.GetID().Hex())
 		if err != nil {
 			return err
 		}
 	}
 
 	// Find or create container
-	container, found, err := getContainer(libraryURL, authToken, entityName+"/"+collectionName+"/"+containerName)
+	container, found, err := c.GetContainer(ctx, entityName+"/"+collectionName+"/"+containerName)
 	if err != nil {
 		return err
 	}
 	if !found {
 		sylog.Verbosef("Container %s does not exist in library - creating it.\n", containerName)
-		container, err = createContainer(libraryURL, authToken, containerName, collection.GetID().Hex())
+		container, err = c.CreateContainer(ctx, containerName, collection.GetID().Hex())
 		if err != nil {
 			return err
 		}
 	}
 
 	// Find or create image
-	image, found, err := getImage(libraryURL, authToken, entityName+"/"+collectionName+"/"+containerName+":"+imageHash)
+	image, found, err := c.GetImage(ctx, entityName+"/"+collectionName+"/"+containerName+":"+imageHash)
 	if err != nil {
 		return err
 	}
 	if !found {
 		sylog.Verbosef("Image %s does not exist in library - creating it.\n", imageHash)
-		image, err = createImage(libraryURL, authToken, imageHash, container.GetID().Hex(), description)
+		image, err = c.CreateImage(ctx, imageHash, container.GetID().Hex(), description)
 		if err != nil {
 			return err
 		}
@@ -89,7 +115,7 @@ func UploadImage(filePath string, libraryRef string, libraryURL string, authToke
 
 	if !image.Uploaded {
 		sylog.Infof("Now uploading %s to the library\n", filePath)
-		err = postFile(libraryURL, authToken, filePath, image.GetID().Hex())
+		err = c.uploadFile(ctx, filePath, image.GetID().Hex(), imageHash)
 		if err != nil {
 			return err
 		}
@@ -99,7 +125,7 @@ func UploadImage(filePath string, libraryRef string, libraryURL string, authToke
 	}
 
 	sylog.Debugf("Setting tags against uploaded image\n")
-	err = setTags(libraryURL, authToken, container.GetID().Hex(), image.GetID().Hex(), tags)
+	err = c.SetTags(ctx, container.GetID().Hex(), image.GetID().Hex(), tags)
 	if err != nil {
 		return err
 	}
@@ -107,7 +133,191 @@ func UploadImage(filePath string, libraryRef string, libraryURL string, authToke
 	return nil
 }
 
+func uploadFile(baseURL string, authToken string, filePath string, imageID string, imageHash string) error {
+	return NewClient(baseURL, authToken).uploadFile(context.Background(), filePath, imageID, imageHash)
+}
+
+// uploadFile uploads the image file in parts, checking the hash of the
+// uploaded content against imageHash, or with a single request to a library
+// without multipart uploads support.
+func (c *Client) uploadFile(ctx context.Context, filePath string, imageID string, imageHash string) error {
+	err := c.postFileParts(ctx, filePath, imageID, imageHash)
+	if err == errMultipartUnsupported {
+		sylog.Debugf("Library does not support multipart uploads, sending file in a single request\n")
+		return c.postFile(ctx, filePath, imageID)
+	}
+	return err
+}
+
+// postFileParts uploads the image file in parts, each part is retried after
+// a network or server error. The upload resumes after the parts acknowledged
+// by the library for an earlier interrupted upload of the same file.
+func (c *Client) postFileParts(ctx context.Context, filePath string, imageID string, imageHash string) error {
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("Could not open the image file to upload: %v", err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("Could not find size of the image file to upload: %v", err)
+	}
+	fileSize := fi.Size()
+
+	uploadPath := "/v1/imagefile/" + imageID + "/_multipart"
+
+	upload, err := c.startMultipartUpload(ctx, uploadPath, MultipartUploadStart{
+		Size:     fileSize,
+		Hash:     imageHash,
+		PartSize: uploadPartSize,
+	})
+	if err != nil {
+		return err
+	}
+	if upload.PartSize <= 0 {
+		return fmt.Errorf("the library returned an invalid part size %d", upload.PartSize)
+	}
+	parts := int((fileSize + upload.PartSize - 1) / upload.PartSize)
+	if upload.UploadedParts > parts {
+		return fmt.Errorf("the library acknowledged %d parts of %d", upload.UploadedParts, parts)
+	}
+
+	uploadPath += "/" + upload.UploadID
+	sylog.Debugf("Uploading %d parts to %s\n", parts, c.BaseURL+uploadPath)
+
+	uploaded := int64(upload.UploadedParts) * upload.PartSize
+	if uploaded > fileSize {
+		uploaded = fileSize
+	}
+	if upload.UploadedParts > 0 {
+		sylog.Infof("Resuming upload after %d of %d parts\n", upload.UploadedParts, parts)
+	}
+
+	bar := pb.New64(fileSize).SetUnits(pb.U_BYTES)
+	if sylog.GetLevel() < 0 {
+		bar.NotPrint = true
+	}
+	bar.ShowTimeLeft = true
+	bar.ShowSpeed = true
+	bar.Set64(uploaded)
+	bar.Start()
+
+	hc := c.transferClient(pushTimeout * time.Second)
+	for part := upload.UploadedParts + 1; part <= parts; part++ {
+		offset := int64(part-1) * upload.PartSize
+		size := upload.PartSize
+		if offset+size > fileSize {
+			size = fileSize - offset
+		}
+		if err := c.putPart(ctx, hc, uploadPath, part, io.NewSectionReader(f, offset, size), bar); err != nil {
+			bar.Finish()
+			return fmt.Errorf("upload of part %d of %d failed, push the image again to resume: %v", part, parts, err)
+		}
+	}
+
+	bar.Finish()
+
+	sylog.Debugf("completeMultipartUpload calling %s\n", c.BaseURL+uploadPath+"/_complete")
+	var res BlobResponse
+	if err := c.doJSON(ctx, "completing upload", http.MethodPost, uploadPath+"/_complete", nil, &res); err != nil {
+		return err
+	}
+	if res.Data.ContentHash != imageHash {
+		return fmt.Errorf("the library computed hash %s for the uploaded file instead of %s", res.Data.ContentHash, imageHash)
+	}
+
+	return nil
+}
+
+// startMultipartUpload starts or resumes the multipart upload described by
+// start, and returns the parts already acknowledged by the library
+func (c *Client) startMultipartUpload(ctx context.Context, path string, start MultipartUploadStart) (upload MultipartUpload, err error) {
+	sylog.Debugf("startMultipartUpload calling %s\n", c.BaseURL+path)
+	var res MultipartUploadResponse
+	err = c.doJSON(ctx, "starting upload", http.MethodPost, path, start, &res)
+	if e, ok := err.(*Error); ok {
+		switch e.Code {
+		case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+			return upload, errMultipartUnsupported
+		}
+	}
+	return res.Data, err
+}
+
+// putPart uploads the content of r as the part number part, retrying with
+// an increasing delay after network errors, server errors and rate limiting
+func (c *Client) putPart(ctx context.Context, hc *http.Client, path string, part int, r *io.SectionReader, bar *pb.ProgressBar) error {
+	// the digest lets the library check the integrity of each part
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return err
+	}
+	digest := "SHA-256=" + base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+	path = fmt.Sprintf("%s/%d", path, part)
+	sylog.Debugf("putPart calling %s\n", c.BaseURL+path)
+
+	current := bar.Get()
+	for attempt := 0; ; attempt++ {
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		err := c.sendPart(ctx, hc, path, digest, r, bar)
+		re, ok := err.(retryableError)
+		if !ok {
+			return err
+		}
+		if attempt >= uploadRetries {
+			return fmt.Errorf("giving up after %d attempts: %v", attempt+1, re.err)
+		}
+
+		bar.Set64(current)
+		delay := uploadRetryDelay << uint(attempt)
+		sylog.Warningf("Upload of part %d failed: %v, retrying in %s", part, re.err, delay)
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// sendPart sends a part to the library, errors after which the part can be
+// sent again are returned as retryableError
+func (c *Client) sendPart(ctx context.Context, hc *http.Client, path string, digest string, r *io.SectionReader, bar *pb.ProgressBar) error {
+	req, err := c.newRequest(ctx, http.MethodPut, path, bar.NewProxyReader(r))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Digest", digest)
+	// Content length is required by the API
+	req.ContentLength = r.Size()
+
+	res, err := hc.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return retryableError{err}
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusOK || res.StatusCode == http.StatusCreated {
+		return nil
+	}
+	e := newError("sending part", res)
+	if e.Temporary() {
+		return retryableError{e}
+	}
+	return e
+}
+
 func postFile(baseURL string, authToken string, filePath string, imageID string) error {
+	return NewClient(baseURL, authToken).postFile(context.Background(), filePath, imageID)
+}
+
+func (c *Client) postFile(ctx context.Context, filePath string, imageID string) error {
 
 	f, err := os.Open(filePath)
 	if err != nil {
@@ -120,8 +330,8 @@ func postFile(baseURL string, authToken string, filePath string, imageID string)
 	}
 	fileSize := fi.Size()
 
-	postURL := baseURL + "/v1/imagefile/" + imageID
-	sylog.Debugf("postFile calling %s\n", postURL)
+	postPath := "/v1/imagefile/" + imageID
+	sylog.Debugf("postFile calling %s\n", c.BaseURL+postPath)
 
 	b := bufio.NewReader(f)
 
@@ -136,31 +346,23 @@ func postFile(baseURL string, authToken string, filePath string, imageID string)
 	// create proxy reader
 	bodyProgress := bar.NewProxyReader(b)
 	// Make an upload request
-	req, _ := http.NewRequest("POST", postURL, bodyProgress)
-	req.Header.Set("Content-Type", "application/octet-stream")
-	if authToken != "" {
-		req.Header.Set("Authorization", "Bearer "+authToken)
+	req, err := c.newRequest(ctx, "POST", postPath, bodyProgress)
+	if err != nil {
+		return err
 	}
-	req.Header.Set("User-Agent", useragent.Value())
+	req.Header.Set("Content-Type", "application/octet-stream")
 	// Content length is required by the API
 	req.ContentLength = fileSize
-	client := &http.Client{
-		Timeout: pushTimeout * time.Second,
-	}
-	res, err := client.Do(req)
+	res, err := c.transferClient(pushTimeout * time.Second).Do(req)
 
 	bar.Finish()
 
 	if err != nil {
 		return fmt.Errorf("Error uploading file to server: %s", err.Error())
 	}
+	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
-		jRes, err := ParseErrorBody(res.Body)
-		if err != nil {
-			jRes = ParseErrorResponse(res)
-		}
-		return fmt.Errorf("Sending file did not succeed: %d %s\n\t%v",
-			jRes.Error.Code, jRes.Error.Status, jRes.Error.Message)
+		return newError("Sending file", res)
 	}
 
 	return nil
@@ -1,4 +1,4 @@
-// Copyright (c) 2018, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2019, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -6,8 +6,18 @@
 package client
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/globalsign/mgo/bson"
 	"github.com/sylabs/singularity/internal/pkg/test"
@@ -77,3 +87,177 @@ func Test_postFile(t *testing.T) {
 
 	}
 }
+
+// mockMultipartService implements the multipart upload API of the library,
+// holding the parts received for an image
+type mockMultipartService struct {
+	t           *testing.T
+	unsupported bool
+	parts       [][]byte
+	partSize    int64
+	failures    map[int]int
+	code        int
+	badHash     bool
+	requests    int
+	single      []byte
+}
+
+func (m *mockMultipartService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.requests++
+	path := strings.TrimPrefix(r.URL.Path, "/v1/imagefile/image")
+
+	switch {
+	case path == "" && r.Method == http.MethodPost:
+		m.single, _ = ioutil.ReadAll(r.Body)
+	case m.unsupported:
+		w.WriteHeader(http.StatusNotFound)
+	case path == "/_multipart":
+		var start MultipartUploadStart
+		if err := json.NewDecoder(r.Body).Decode(&start); err != nil {
+			m.t.Errorf("Error decoding upload request: %v", err)
+		}
+		if m.partSize == 0 {
+			m.partSize = start.PartSize
+		}
+		json.NewEncoder(w).Encode(MultipartUploadResponse{
+			Data: MultipartUpload{UploadID: "upload", PartSize: m.partSize, UploadedParts: len(m.parts)},
+		})
+	case path == "/_multipart/upload/_complete":
+		content := bytes.Join(m.parts, nil)
+		if m.badHash {
+			content = append(content, '\n')
+		}
+		json.NewEncoder(w).Encode(BlobResponse{
+			Data: Blob{ContentHash: fmt.Sprintf("sha256.%x", sha256.Sum256(content)), Size: int64(len(content))},
+		})
+	case strings.HasPrefix(path, "/_multipart/upload/") && r.Method == http.MethodPut:
+		var part int
+		fmt.Sscanf(strings.TrimPrefix(path, "/_multipart/upload/"), "%d", &part)
+		if part != len(m.parts)+1 {
+			m.t.Errorf("Unexpected part %d after %d parts", part, len(m.parts))
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		if m.failures[part] > 0 {
+			m.failures[part]--
+			w.WriteHeader(m.code)
+			return
+		}
+		sum := sha256.Sum256(b)
+		if digest := "SHA-256=" + base64.StdEncoding.EncodeToString(sum[:]); r.Header.Get("Digest") != digest {
+			m.t.Errorf("Unexpected digest %s of part %d", r.Header.Get("Digest"), part)
+		}
+		m.parts = append(m.parts, b)
+	default:
+		m.t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func Test_uploadFile(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10)
+	f, err := ioutil.TempFile("", "upload-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(content)
+	f.Chmod(0644)
+	f.Close()
+	defer os.Remove(f.Name())
+	hash, err := ImageHash(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(size int64, delay time.Duration) {
+		uploadPartSize = size
+		uploadRetryDelay = delay
+	}(uploadPartSize, uploadRetryDelay)
+	uploadPartSize = 16
+	uploadRetryDelay = time.Millisecond
+
+	tests := []struct {
+		description string
+		m           mockMultipartService
+		requests    int
+		expectError bool
+	}{
+		{
+			description: "Parts",
+			requests:    9,
+		},
+		{
+			description: "Library part size",
+			m:           mockMultipartService{partSize: 40},
+			requests:    5,
+		},
+		{
+			description: "Retried parts",
+			m:           mockMultipartService{failures: map[int]int{2: 2, 7: 1}, code: http.StatusServiceUnavailable},
+			requests:    12,
+		},
+		{
+			description: "Rate limited",
+			m:           mockMultipartService{failures: map[int]int{1: 1}, code: http.StatusTooManyRequests},
+			requests:    10,
+		},
+		{
+			description: "Too many failures",
+			m:           mockMultipartService{failures: map[int]int{3: uploadRetries + 1}, code: http.StatusBadGateway},
+			requests:    3 + uploadRetries + 1,
+			expectError: true,
+		},
+		{
+			description: "Client error",
+			m:           mockMultipartService{failures: map[int]int{1: 1}, code: http.StatusUnauthorized},
+			requests:    2,
+			expectError: true,
+		},
+		{
+			description: "Resumed",
+			m:           mockMultipartService{parts: [][]byte{content[:16], content[16:32], content[32:48]}},
+			requests:    6,
+		},
+		{
+			description: "Hash mismatch",
+			m:           mockMultipartService{badHash: true},
+			requests:    9,
+			expectError: true,
+		},
+		{
+			description: "Multipart unsupported",
+			m:           mockMultipartService{unsupported: true},
+			requests:    2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, test.WithoutPrivilege(func(t *testing.T) {
+			m := tt.m
+			m.t = t
+			s := httptest.NewServer(&m)
+			defer s.Close()
+
+			err := uploadFile(s.URL, testToken, f.Name(), "image", hash)
+
+			if err != nil && !tt.expectError {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if err == nil && tt.expectError {
+				t.Errorf("Unexpected success. Expected error.")
+			}
+			if m.requests != tt.requests {
+				t.Errorf("Unexpected number of requests %d, expected %d", m.requests, tt.requests)
+			}
+			if tt.expectError {
+				return
+			}
+			uploaded := bytes.Join(m.parts, nil)
+			if m.unsupported {
+				uploaded = m.single
+			}
+			if !bytes.Equal(uploaded, content) {
+				t.Errorf("Unexpected uploaded content %q", uploaded)
+			}
+		}))
+	}
+}
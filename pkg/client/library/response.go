@@ -1,10 +1,17 @@
-// Copyright (c) 2018, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2019, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
 
 package client
 
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
 // JSONError - Struct for standard error returns over REST API
 type JSONError struct {
 	Code    int    `json:"code,omitempty"`
@@ -42,6 +49,19 @@ type ImageResponse struct {
 	Error JSONError `json:"error,omitempty"`
 }
 
+// MultipartUploadResponse - Response from the API for a multipart upload
+// request
+type MultipartUploadResponse struct {
+	Data  MultipartUpload `json:"data"`
+	Error JSONError       `json:"error,omitempty"`
+}
+
+// BlobResponse - Response from the API for a completed upload
+type BlobResponse struct {
+	Data  Blob      `json:"data"`
+	Error JSONError `json:"error,omitempty"`
+}
+
 // TagsResponse - Response from the API for a tags request
 type TagsResponse struct {
 	Data  TagMap    `json:"data"`
@@ -60,3 +80,63 @@ type SearchResponse struct {
 	Data  SearchResults `json:"data"`
 	Error JSONError     `json:"error,omitempty"`
 }
+
+// Page - Pagination of a list response, absent from the responses of
+// libraries without pagination support
+type Page struct {
+	Offset int `json:"offset"`
+	Limit  int `json:"limit"`
+	Total  int `json:"total"`
+}
+
+// Error is an unsuccessful response of the library API, built from the
+// JSONError of the response
+type Error struct {
+	JSONError
+	// Op is the operation which did not succeed
+	Op string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s did not succeed: %d %s\n\t%v", e.Op, e.Code, e.Status, e.Message)
+}
+
+// Temporary returns whether the operation may succeed later, after a server
+// error or rate limiting
+func (e *Error) Temporary() bool {
+	return e.Code >= http.StatusInternalServerError || e.Code == http.StatusTooManyRequests
+}
+
+// IsNotFound returns whether err is an Error for an object which doesn't
+// exist in the library
+func IsNotFound(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.Code == http.StatusNotFound
+}
+
+// IsUnauthorized returns whether err is an Error for a missing or invalid
+// authentication token
+func IsUnauthorized(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.Code == http.StatusUnauthorized
+}
+
+// newError returns the Error of an unsuccessful response for the operation
+// op, the response status is used when the body holds no API error.
+func newError(op string, res *http.Response) *Error {
+	e := &Error{Op: op}
+	b, _ := ioutil.ReadAll(res.Body)
+	var jRes JSONResponse
+	if err := json.Unmarshal(b, &jRes); err == nil {
+		e.JSONError = jRes.Error
+	} else {
+		e.Message = string(b)
+	}
+	if e.Code == 0 {
+		e.Code = res.StatusCode
+	}
+	if e.Status == "" {
+		e.Status = http.StatusText(res.StatusCode)
+	}
+	return e
+}
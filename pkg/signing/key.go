@@ -0,0 +1,238 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package signing
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/pkg/sypgp"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// revocation reasons, see RFC 4880 section 5.2.3.23
+const (
+	reasonNone        = 0
+	reasonSuperseded  = 1
+	reasonCompromised = 2
+	reasonRetired     = 3
+)
+
+var revocationReasons = map[uint8]string{
+	reasonNone:        "no reason specified",
+	reasonSuperseded:  "key is superseded",
+	reasonCompromised: "key material has been compromised",
+	reasonRetired:     "key is retired and no longer used",
+}
+
+// KeyError reports why the key of a signing entity was not valid when a
+// signature was made.
+type KeyError struct {
+	// Fingerprint of the signing entity
+	Fingerprint [20]byte
+	// Reason describes why the key is not valid
+	Reason string
+}
+
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("key %X %s", e.Fingerprint, e.Reason)
+}
+
+// signingKeyRing returns the keys matching an id regardless of their
+// revocation status and flags, allowing to verify the signature first and
+// to report afterwards why the key is not valid.
+type signingKeyRing struct {
+	openpgp.EntityList
+}
+
+// KeysByIdUsage returns the set of keys that have the given key id, the
+// usage is checked later by checkKey.
+func (kr signingKeyRing) KeysByIdUsage(id uint64, requiredUsage byte) []openpgp.Key {
+	return kr.KeysById(id)
+}
+
+// verifySignature verifies the clear signed data with the keys of el and
+// returns the signing entity along with the signature.
+func verifySignature(el openpgp.EntityList, data []byte) (*openpgp.Entity, *packet.Signature, error) {
+	block, _ := clearsign.Decode(data)
+	if block == nil {
+		return nil, nil, fmt.Errorf("failed to parse signature block")
+	}
+	body, err := ioutil.ReadAll(block.ArmoredSignature.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read signature: %s", err)
+	}
+
+	p, err := packet.Read(bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse signature: %s", err)
+	}
+	sig, ok := p.(*packet.Signature)
+	if !ok || sig.IssuerKeyId == nil {
+		return nil, nil, fmt.Errorf("unsupported signature packet")
+	}
+
+	signer, err := openpgp.CheckDetachedSignature(signingKeyRing{el}, bytes.NewReader(block.Bytes), bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	return signer, sig, nil
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// revocationError returns a description of the first revocation of revs
+// invalidating a signature made at time t, or an empty string. Superseded
+// or retired keys remain valid for signatures made before their revocation,
+// any other revocation invalidates all signatures as the key may have been
+// compromised.
+func revocationError(revs []*packet.Signature, t time.Time) string {
+	for _, r := range revs {
+		reason := uint8(reasonNone)
+		if r.RevocationReason != nil {
+			reason = *r.RevocationReason
+		}
+		if (reason == reasonSuperseded || reason == reasonRetired) && t.Before(r.CreationTime) {
+			continue
+		}
+
+		s := fmt.Sprintf("was revoked on %s", formatTime(r.CreationTime))
+		if desc, ok := revocationReasons[reason]; ok {
+			s += fmt.Sprintf(" (%s)", desc)
+		}
+		if r.RevocationReasonText != "" {
+			s += fmt.Sprintf(": %s", r.RevocationReasonText)
+		}
+		return s
+	}
+	return ""
+}
+
+// selfSignature returns the self signature of the primary identity of e.
+func selfSignature(e *openpgp.Entity) *packet.Signature {
+	var selfSig *packet.Signature
+	for _, ident := range e.Identities {
+		if selfSig == nil {
+			selfSig = ident.SelfSignature
+		} else if ident.SelfSignature.IsPrimaryId != nil && *ident.SelfSignature.IsPrimaryId {
+			return ident.SelfSignature
+		}
+	}
+	return selfSig
+}
+
+// subkeyRevocations returns the revocations of the subkey pub found in
+// entities, subkey revocations following a subkey binding signature are
+// stored along the identity signatures by the openpgp package.
+func subkeyRevocations(entities []*openpgp.Entity, pub *packet.PublicKey) (revs []*packet.Signature) {
+	for _, e := range entities {
+		for _, sub := range e.Subkeys {
+			if sub.PublicKey.KeyId == pub.KeyId && sub.Sig.SigType == packet.SigTypeSubkeyRevocation {
+				revs = append(revs, sub.Sig)
+			}
+		}
+		for _, ident := range e.Identities {
+			for _, sig := range ident.Signatures {
+				if sig.SigType != packet.SigTypeSubkeyRevocation {
+					continue
+				}
+				if e.PrimaryKey.VerifyKeySignature(pub, sig) == nil {
+					revs = append(revs, sig)
+				}
+			}
+		}
+	}
+	return revs
+}
+
+// checkKey returns a *KeyError if the key of entity e which made the
+// signature sig was not valid when the signature was made. Revocations
+// found in the other copies of the key are also honoured.
+func checkKey(e *openpgp.Entity, sig *packet.Signature, copies ...*openpgp.Entity) error {
+	keyErr := func(format string, a ...interface{}) error {
+		return &KeyError{Fingerprint: e.PrimaryKey.Fingerprint, Reason: fmt.Sprintf(format, a...)}
+	}
+	entities := append([]*openpgp.Entity{e}, copies...)
+	t := sig.CreationTime
+
+	// the primary key must be valid, whichever key made the signature
+	var revs []*packet.Signature
+	for _, k := range entities {
+		revs = append(revs, k.Revocations...)
+	}
+	if s := revocationError(revs, t); s != "" {
+		return keyErr("%s", s)
+	}
+	if t.Before(e.PrimaryKey.CreationTime) {
+		return keyErr("was created on %s after the signature was made on %s", formatTime(e.PrimaryKey.CreationTime), formatTime(t))
+	}
+	selfSig := selfSignature(e)
+	if selfSig.KeyLifetimeSecs != nil && *selfSig.KeyLifetimeSecs != 0 {
+		expiry := e.PrimaryKey.CreationTime.Add(time.Duration(*selfSig.KeyLifetimeSecs) * time.Second)
+		if t.After(expiry) {
+			return keyErr("expired on %s before the signature was made on %s", formatTime(expiry), formatTime(t))
+		}
+		if time.Now().After(expiry) {
+			sylog.Warningf("key %X expired on %s, the signature was made before on %s", e.PrimaryKey.Fingerprint, formatTime(expiry), formatTime(t))
+		}
+	}
+
+	if *sig.IssuerKeyId == e.PrimaryKey.KeyId {
+		if selfSig.FlagsValid && !selfSig.FlagSign {
+			return keyErr("is not allowed to make signatures")
+		}
+		return nil
+	}
+
+	for _, sub := range e.Subkeys {
+		if sub.PublicKey.KeyId != *sig.IssuerKeyId {
+			continue
+		}
+		if s := revocationError(subkeyRevocations(entities, sub.PublicKey), t); s != "" {
+			return keyErr("subkey %X %s", sub.PublicKey.Fingerprint, s)
+		}
+		if t.Before(sub.PublicKey.CreationTime) {
+			return keyErr("subkey %X was created on %s after the signature was made on %s", sub.PublicKey.Fingerprint, formatTime(sub.PublicKey.CreationTime), formatTime(t))
+		}
+		if sub.Sig.KeyLifetimeSecs != nil && *sub.Sig.KeyLifetimeSecs != 0 {
+			expiry := sub.PublicKey.CreationTime.Add(time.Duration(*sub.Sig.KeyLifetimeSecs) * time.Second)
+			if t.After(expiry) {
+				return keyErr("subkey %X expired on %s before the signature was made on %s", sub.PublicKey.Fingerprint, formatTime(expiry), formatTime(t))
+			}
+		}
+		if sub.Sig.FlagsValid && !sub.Sig.FlagSign {
+			return keyErr("subkey %X is not allowed to make signatures", sub.PublicKey.Fingerprint)
+		}
+		return nil
+	}
+
+	return keyErr("has no key with ID %X", *sig.IssuerKeyId)
+}
+
+// fetchRevocations fetches the key of entity e from the key server to get
+// the revocations published since the key was stored locally. Nil is
+// returned if the key server can't be queried.
+func fetchRevocations(e *openpgp.Entity, url, authToken string) *openpgp.Entity {
+	fingerprint := fmt.Sprintf("%X", e.PrimaryKey.Fingerprint)
+
+	el, err := sypgp.FetchPubkey(fingerprint, url, authToken, true)
+	if err != nil {
+		sylog.Warningf("could not check revocation status of key %s on key server: %s", fingerprint, err)
+		return nil
+	}
+	if el[0].PrimaryKey.Fingerprint != e.PrimaryKey.Fingerprint {
+		sylog.Warningf("could not check revocation status of key %s: key server returned key %X", fingerprint, el[0].PrimaryKey.Fingerprint)
+		return nil
+	}
+	return el[0]
+}
@@ -0,0 +1,253 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package signing
+
+import (
+	"bytes"
+	"crypto"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+	pgperrors "golang.org/x/crypto/openpgp/errors"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+var (
+	keyCreation = time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	signTime    = time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC)
+)
+
+func config(t time.Time) *packet.Config {
+	return &packet.Config{Time: func() time.Time { return t }}
+}
+
+func newEntity(t *testing.T) *openpgp.Entity {
+	e, err := openpgp.NewEntity("Test", "", "test@example.com", config(keyCreation))
+	if err != nil {
+		t.Fatalf("failed to create entity: %s", err)
+	}
+	return e
+}
+
+// setLifetime sets the key lifetime of e and signs its identities again
+func setLifetime(t *testing.T, e *openpgp.Entity, lifetime time.Duration) {
+	secs := uint32(lifetime.Seconds())
+	for _, ident := range e.Identities {
+		ident.SelfSignature.KeyLifetimeSecs = &secs
+		if err := ident.SelfSignature.SignUserId(ident.UserId.Id, e.PrimaryKey, e.PrivateKey, config(keyCreation)); err != nil {
+			t.Fatalf("failed to sign identity: %s", err)
+		}
+	}
+}
+
+// revoke adds a key revocation signature made at time rt to e
+func revoke(t *testing.T, e *openpgp.Entity, rt time.Time) {
+	var buf bytes.Buffer
+	if err := e.PrimaryKey.Serialize(&buf); err != nil {
+		t.Fatal(err)
+	}
+	// skip the new format packet header to hash the key material only
+	b := buf.Bytes()
+	switch l := b[1]; {
+	case l < 192:
+		b = b[2:]
+	case l < 224:
+		b = b[3:]
+	default:
+		b = b[6:]
+	}
+	h := crypto.SHA256.New()
+	e.PrimaryKey.SerializeSignaturePrefix(h)
+	h.Write(b)
+
+	sig := &packet.Signature{
+		SigType:      packet.SigTypeKeyRevocation,
+		PubKeyAlgo:   e.PrimaryKey.PubKeyAlgo,
+		Hash:         crypto.SHA256,
+		CreationTime: rt,
+		IssuerKeyId:  &e.PrimaryKey.KeyId,
+	}
+	if err := sig.Sign(h, e.PrivateKey, nil); err != nil {
+		t.Fatalf("failed to sign revocation: %s", err)
+	}
+	if err := e.PrimaryKey.VerifyRevocationSignature(sig); err != nil {
+		t.Fatalf("invalid revocation signature: %s", err)
+	}
+	e.Revocations = append(e.Revocations, sig)
+}
+
+// clearSign returns the data clear signed by e at time st
+func clearSign(t *testing.T, e *openpgp.Entity, st time.Time) []byte {
+	var buf bytes.Buffer
+	w, err := clearsign.Encode(&buf, e.PrivateKey, config(st))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("SIFHASH:\n0123456789abcdef")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestVerifySignature(t *testing.T) {
+	e := newEntity(t)
+	other := newEntity(t)
+	revoke(t, e, signTime.Add(time.Hour))
+	data := clearSign(t, e, signTime)
+
+	// revoked keys are still returned so the reason can be reported
+	signer, sig, err := verifySignature(openpgp.EntityList{other, e}, data)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if signer != e {
+		t.Errorf("unexpected signer %X", signer.PrimaryKey.Fingerprint)
+	}
+	if !sig.CreationTime.Equal(signTime) {
+		t.Errorf("unexpected signature creation time %s", sig.CreationTime)
+	}
+
+	if _, _, err := verifySignature(openpgp.EntityList{other}, data); err != pgperrors.ErrUnknownIssuer {
+		t.Errorf("unexpected error with missing key: %v", err)
+	}
+
+	tampered := bytes.Replace(data, []byte("0123456789abcdef"), []byte("fedcba9876543210"), 1)
+	if _, _, err := verifySignature(openpgp.EntityList{e}, tampered); err == nil {
+		t.Errorf("unexpected success with tampered data")
+	}
+}
+
+func TestCheckKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		setup    func(t *testing.T, e *openpgp.Entity)
+		signTime time.Time
+		reason   string
+	}{
+		{
+			name:     "valid",
+			signTime: signTime,
+		},
+		{
+			name:     "signed before creation",
+			signTime: keyCreation.Add(-time.Hour),
+			reason:   "was created on 2019-01-01T00:00:00Z",
+		},
+		{
+			name: "expired before signing",
+			setup: func(t *testing.T, e *openpgp.Entity) {
+				setLifetime(t, e, 24*time.Hour)
+			},
+			signTime: signTime,
+			reason:   "expired on 2019-01-02T00:00:00Z",
+		},
+		{
+			name: "expired after signing",
+			setup: func(t *testing.T, e *openpgp.Entity) {
+				setLifetime(t, e, 90*24*time.Hour)
+			},
+			signTime: signTime,
+		},
+		{
+			name: "revoked after signing",
+			setup: func(t *testing.T, e *openpgp.Entity) {
+				revoke(t, e, signTime.Add(time.Hour))
+			},
+			signTime: signTime,
+			reason:   "was revoked on 2019-03-01T01:00:00Z (no reason specified)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newEntity(t)
+			if tt.setup != nil {
+				tt.setup(t, e)
+			}
+			signer, sig, err := verifySignature(openpgp.EntityList{e}, clearSign(t, e, tt.signTime))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			err = checkKey(signer, sig)
+			if tt.reason == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+				return
+			}
+			if _, ok := err.(*KeyError); !ok {
+				t.Fatalf("unexpected error type %T: %v", err, err)
+			}
+			if !strings.Contains(err.Error(), tt.reason) {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestCheckKeyCopies(t *testing.T) {
+	e := newEntity(t)
+	signer, sig, err := verifySignature(openpgp.EntityList{e}, clearSign(t, e, signTime))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// the revocation is only known by a copy of the key, like a key
+	// revoked on the key server after being stored locally
+	local := *signer
+	revoke(t, e, signTime.Add(time.Hour))
+
+	if err := checkKey(&local, sig); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err := checkKey(&local, sig, e); err == nil {
+		t.Errorf("unexpected success with a revoked key copy")
+	}
+}
+
+func TestRevocationError(t *testing.T) {
+	reason := func(r uint8) *uint8 { return &r }
+	revTime := signTime.Add(time.Hour)
+
+	tests := []struct {
+		name     string
+		reason   *uint8
+		text     string
+		signTime time.Time
+		expected string
+	}{
+		{"no reason", nil, "", signTime, "was revoked on 2019-03-01T01:00:00Z"},
+		{"compromised", reason(reasonCompromised), "leaked", signTime, "was revoked on 2019-03-01T01:00:00Z (key material has been compromised): leaked"},
+		{"superseded before", reason(reasonSuperseded), "", signTime, ""},
+		{"superseded after", reason(reasonSuperseded), "", revTime.Add(time.Hour), "(key is superseded)"},
+		{"retired before", reason(reasonRetired), "", signTime, ""},
+		{"retired after", reason(reasonRetired), "", revTime.Add(time.Hour), "(key is retired and no longer used)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rev := &packet.Signature{
+				SigType:              packet.SigTypeKeyRevocation,
+				CreationTime:         revTime,
+				RevocationReason:     tt.reason,
+				RevocationReasonText: tt.text,
+			}
+			s := revocationError([]*packet.Signature{rev}, tt.signTime)
+			if tt.expected == "" && s != "" {
+				t.Errorf("unexpected revocation error: %s", s)
+			} else if !strings.Contains(s, tt.expected) || (tt.expected != "" && s == "") {
+				t.Errorf("unexpected revocation error %q, expected %q", s, tt.expected)
+			}
+		})
+	}
+}
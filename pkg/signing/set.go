@@ -0,0 +1,247 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package signing
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sylabs/sif/pkg/sif"
+)
+
+// setHashHeader starts the plaintext of a signature block covering the set
+// of all data objects of a SIF file, it is followed by one line per object
+// holding its ID and its hash.
+const setHashHeader = "SIFHASH-SET:"
+
+// objectStatus is the verification status of a data object against the
+// hashes of a set signature.
+type objectStatus int
+
+const (
+	// objectVerified means the object is covered and unchanged
+	objectVerified objectStatus = iota
+	// objectTampered means the object is covered but was modified
+	objectTampered
+	// objectMissing means the object is covered but was removed
+	objectMissing
+	// objectNotCovered means the object was added after the signature or
+	// is an overlay partition, which is never signed
+	objectNotCovered
+)
+
+func (s objectStatus) String() string {
+	switch s {
+	case objectVerified:
+		return "verified"
+	case objectTampered:
+		return "TAMPERED"
+	case objectMissing:
+		return "MISSING"
+	case objectNotCovered:
+		return "not covered by signature"
+	}
+	return "unknown"
+}
+
+var datatypeNames = map[sif.Datatype]string{
+	sif.DataDeffile:     "Def.FILE",
+	sif.DataEnvVar:      "Env.Vars",
+	sif.DataLabels:      "JSON.Labels",
+	sif.DataPartition:   "FS",
+	sif.DataSignature:   "Signature",
+	sif.DataGenericJSON: "JSON.Generic",
+}
+
+// objectReport holds the verification status of a data object.
+type objectReport struct {
+	id       uint32
+	datatype sif.Datatype
+	name     string
+	overlay  bool
+	status   objectStatus
+}
+
+func (r objectReport) String() string {
+	datatype, ok := datatypeNames[r.datatype]
+	if !ok {
+		datatype = "Unknown"
+	}
+	return fmt.Sprintf("%-4d %-13s %-20s %s", r.id, datatype, r.name, r.status)
+}
+
+// isSetSignature returns true if the signature descriptor d covers the set
+// of all data objects, such signatures are not linked to any object.
+func isSetSignature(d *sif.Descriptor) bool {
+	return d.Datatype == sif.DataSignature && d.Link == sif.DescrUnusedLink
+}
+
+// getSigsSet returns all signatures covering the set of all data objects.
+func getSigsSet(fimg *sif.FileImage) (sigs []*sif.Descriptor) {
+	for i, d := range fimg.DescrArr {
+		if d.Used && isSetSignature(&d) {
+			sigs = append(sigs, &fimg.DescrArr[i])
+		}
+	}
+	return sigs
+}
+
+// isOverlay returns true if d is an overlay partition, its content is
+// modified by the runtime when the image is used with --writable.
+func isOverlay(d *sif.Descriptor) bool {
+	if d.Datatype != sif.DataPartition {
+		return false
+	}
+	ptype, err := d.GetPartType()
+	return err == nil && ptype == sif.PartOverlay
+}
+
+// objectsToSign returns all the data objects of the SIF file except
+// signatures and overlay partitions.
+func objectsToSign(fimg *sif.FileImage) (descr []*sif.Descriptor) {
+	for _, d := range objectsToCheck(fimg) {
+		if !isOverlay(d) {
+			descr = append(descr, d)
+		}
+	}
+	return descr
+}
+
+// objectsToCheck returns all the data objects of the SIF file except
+// signatures, overlay partitions are included so that they are reported as
+// not covered.
+func objectsToCheck(fimg *sif.FileImage) (descr []*sif.Descriptor) {
+	for i, d := range fimg.DescrArr {
+		if d.Used && d.Datatype != sif.DataSignature {
+			descr = append(descr, &fimg.DescrArr[i])
+		}
+	}
+	return descr
+}
+
+// objectHash returns the hash of the data object d covering its data along
+// with the descriptor fields describing the object, so an object can't be
+// turned into another type of object or moved to another group.
+func objectHash(fimg *sif.FileImage, d *sif.Descriptor) string {
+	hash := sha512.New384()
+	binary.Write(hash, binary.LittleEndian, d.Datatype)
+	binary.Write(hash, binary.LittleEndian, d.Groupid)
+	binary.Write(hash, binary.LittleEndian, d.Link)
+	hash.Write(d.Name[:])
+	hash.Write(d.Extra[:])
+	hash.Write(d.GetData(fimg))
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
+
+// computeSetHashStr generates the string stored in a signature block
+// covering the data objects descr.
+func computeSetHashStr(fimg *sif.FileImage, descr []*sif.Descriptor) string {
+	s := setHashHeader
+	for _, d := range descr {
+		s += fmt.Sprintf("\n%d %s", d.ID, objectHash(fimg, d))
+	}
+	return s
+}
+
+// parseSetHashStr returns the object hashes by ID stored in the plaintext of
+// a set signature block.
+func parseSetHashStr(plaintext []byte) (map[uint32]string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(plaintext))
+	if !scanner.Scan() || scanner.Text() != setHashHeader {
+		return nil, fmt.Errorf("signature block doesn't cover a set of data objects")
+	}
+
+	hashes := make(map[uint32]string)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid object hash line %q", line)
+		}
+		id, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid object ID in line %q", line)
+		}
+		hashes[uint32(id)] = fields[1]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(hashes) == 0 {
+		return nil, fmt.Errorf("signature block doesn't cover any data object")
+	}
+	return hashes, nil
+}
+
+// checkObjects compares the data objects of the SIF file with the hashes of
+// a set signature and returns the status of each object, ordered by ID.
+// Objects covered by the signature but no longer found are reported last.
+func checkObjects(fimg *sif.FileImage, hashes map[uint32]string) (reports []objectReport) {
+	seen := make(map[uint32]bool)
+	for _, d := range objectsToCheck(fimg) {
+		r := objectReport{id: d.ID, datatype: d.Datatype, name: d.GetName(), overlay: isOverlay(d)}
+		if h, ok := hashes[d.ID]; !ok {
+			r.status = objectNotCovered
+		} else if h != objectHash(fimg, d) {
+			r.status = objectTampered
+		} else {
+			r.status = objectVerified
+		}
+		seen[d.ID] = true
+		reports = append(reports, r)
+	}
+
+	var missing []objectReport
+	for id := range hashes {
+		if !seen[id] {
+			missing = append(missing, objectReport{id: id, status: objectMissing})
+		}
+	}
+	sort.Slice(missing, func(i, j int) bool { return missing[i].id < missing[j].id })
+	return append(reports, missing...)
+}
+
+// checkSetBlock verifies the data objects against the hashes of the clear
+// signed set signature block, it returns the status of each object and an
+// error if a covered object was tampered with or removed.
+func checkSetBlock(fimg *sif.FileImage, plaintext []byte) ([]objectReport, error) {
+	hashes, err := parseSetHashStr(plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	reports := checkObjects(fimg, hashes)
+	var bad []string
+	for _, r := range reports {
+		if r.status == objectTampered || r.status == objectMissing {
+			bad = append(bad, fmt.Sprintf("%d (%s)", r.id, strings.ToLower(r.status.String())))
+		}
+	}
+	if len(bad) > 0 {
+		return reports, fmt.Errorf("data objects %s differ from the signed set, data may be corrupted", strings.Join(bad, ", "))
+	}
+	return reports, nil
+}
+
+// objectCovered returns true if the object id is part of the set verified
+// by reports and unchanged.
+func objectCovered(reports []objectReport, id uint32) bool {
+	for _, r := range reports {
+		if r.id == id {
+			return r.status == objectVerified
+		}
+	}
+	return false
+}
@@ -0,0 +1,471 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package signing
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/internal/pkg/image"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+)
+
+const (
+	testDeffileID = 1
+	testLabelsID  = 2
+	testPrimID    = 3
+)
+
+func dataInput(datatype sif.Datatype, data string) sif.DescriptorInput {
+	input := sif.DescriptorInput{
+		Datatype: datatype,
+		Groupid:  sif.DescrDefaultGroup,
+		Link:     sif.DescrUnusedLink,
+		Data:     []byte(data),
+	}
+	input.Size = int64(binary.Size(input.Data))
+	return input
+}
+
+// createTestSIF creates a SIF file at path holding a definition file, labels
+// and a primary partition.
+func createTestSIF(t *testing.T, path string) {
+	part := dataInput(sif.DataPartition, "squashfs")
+	if err := part.SetPartExtra(sif.FsSquash, sif.PartPrimSys, sif.GetSIFArch(runtime.GOARCH)); err != nil {
+		t.Fatal(err)
+	}
+
+	cinfo := sif.CreateInfo{
+		Pathname:   path,
+		Launchstr:  sif.HdrLaunch,
+		Sifversion: sif.HdrVersion,
+		ID:         uuid.NewV4(),
+		InputDescr: []sif.DescriptorInput{
+			dataInput(sif.DataDeffile, "bootstrap: scratch\n"),
+			dataInput(sif.DataLabels, "{}"),
+			part,
+		},
+	}
+	if _, err := sif.CreateContainer(cinfo); err != nil {
+		t.Fatalf("failed to create SIF file: %s", err)
+	}
+}
+
+// signTestSIF adds a signature made by e covering all the data objects of
+// the SIF file at path.
+func signTestSIF(t *testing.T, path string, e *openpgp.Entity) {
+	fimg, err := sif.LoadContainer(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fimg.UnloadContainer()
+
+	signed, err := clearSignHash(e, computeSetHashStr(&fimg, objectsToSign(&fimg)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sifAddSignature(&fimg, sif.DescrUnusedGroup, sif.DescrUnusedLink, e.PrimaryKey.Fingerprint, signed); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// addTestLayer stacks a layer partition over the primary partition of the
+// SIF file at path and returns its ID.
+func addTestLayer(t *testing.T, path string) uint32 {
+	fimg, err := sif.LoadContainer(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fimg.UnloadContainer()
+
+	layer := dataInput(sif.DataPartition, "layer")
+	layer.Link = testPrimID
+	if err := layer.SetPartExtra(sif.FsSquash, sif.PartSystem, sif.GetSIFArch(runtime.GOARCH)); err != nil {
+		t.Fatal(err)
+	}
+	if err := fimg.AddObject(layer); err != nil {
+		t.Fatalf("failed to add layer partition: %s", err)
+	}
+	layers, err := image.SIFLayers(&fimg)
+	if err != nil || len(layers) != 1 {
+		t.Fatalf("unexpected layers %v: %v", layers, err)
+	}
+	return layers[0].ID
+}
+
+// signTestObject adds a signature made by e linked to the data object id of
+// the SIF file at path.
+func signTestObject(t *testing.T, path string, e *openpgp.Entity, id uint32) {
+	fimg, err := sif.LoadContainer(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fimg.UnloadContainer()
+
+	d, _, err := fimg.GetFromDescrID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := clearSignHash(e, computeHashStr(&fimg, []*sif.Descriptor{d}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sifAddSignature(&fimg, d.Groupid, d.ID, e.PrimaryKey.Fingerprint, signed); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// overwriteObject replaces the beginning of the data object id of the SIF
+// file at path with data.
+func overwriteObject(t *testing.T, path string, id uint32, data string) {
+	fimg, err := sif.LoadContainer(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, _, err := fimg.GetFromDescrID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	off := d.Fileoff
+	fimg.UnloadContainer()
+
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteAt([]byte(data), off); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkTestSIF returns the result of checkSetBlock for the set signature of
+// the SIF file at path.
+func checkTestSIF(t *testing.T, path string) ([]objectReport, error) {
+	fimg, err := sif.LoadContainer(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fimg.UnloadContainer()
+
+	sigs := getSigsSet(&fimg)
+	if len(sigs) != 1 {
+		t.Fatalf("unexpected number of set signatures %d", len(sigs))
+	}
+	block, _ := clearsign.Decode(sigs[0].GetData(&fimg))
+	if block == nil {
+		t.Fatalf("failed to parse signature block")
+	}
+	return checkSetBlock(&fimg, block.Plaintext)
+}
+
+func statusByID(reports []objectReport) map[uint32]objectStatus {
+	status := make(map[uint32]objectStatus)
+	for _, r := range reports {
+		status[r.id] = r.status
+	}
+	return status
+}
+
+func TestSetSignature(t *testing.T) {
+	e := newEntity(t)
+
+	tests := []struct {
+		name     string
+		modify   func(t *testing.T, path string)
+		status   map[uint32]objectStatus
+		failed   bool
+		entityOK bool
+	}{
+		{
+			name: "unmodified",
+			status: map[uint32]objectStatus{
+				testDeffileID: objectVerified,
+				testLabelsID:  objectVerified,
+				testPrimID:    objectVerified,
+			},
+			entityOK: true,
+		},
+		{
+			name: "definition file swapped",
+			modify: func(t *testing.T, path string) {
+				overwriteObject(t, path, testDeffileID, "Bootstrap")
+			},
+			status: map[uint32]objectStatus{
+				testDeffileID: objectTampered,
+				testLabelsID:  objectVerified,
+				testPrimID:    objectVerified,
+			},
+			failed: true,
+		},
+		{
+			name: "primary partition modified",
+			modify: func(t *testing.T, path string) {
+				overwriteObject(t, path, testPrimID, "SQUASHFS")
+			},
+			status: map[uint32]objectStatus{
+				testDeffileID: objectVerified,
+				testLabelsID:  objectVerified,
+				testPrimID:    objectTampered,
+			},
+			failed: true,
+		},
+		{
+			name: "object added",
+			modify: func(t *testing.T, path string) {
+				fimg, err := sif.LoadContainer(path, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer fimg.UnloadContainer()
+				if err := fimg.AddObject(dataInput(sif.DataEnvVar, "FOO=bar")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			status: map[uint32]objectStatus{
+				testDeffileID: objectVerified,
+				testLabelsID:  objectVerified,
+				testPrimID:    objectVerified,
+				5:             objectNotCovered,
+			},
+			entityOK: true,
+		},
+		{
+			name: "overlay added",
+			modify: func(t *testing.T, path string) {
+				fimg, err := sif.LoadContainer(path, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer fimg.UnloadContainer()
+				overlay := dataInput(sif.DataPartition, "ext3")
+				if err := overlay.SetPartExtra(sif.FsExt3, sif.PartOverlay, sif.GetSIFArch(runtime.GOARCH)); err != nil {
+					t.Fatal(err)
+				}
+				if err := fimg.AddObject(overlay); err != nil {
+					t.Fatal(err)
+				}
+			},
+			status: map[uint32]objectStatus{
+				testDeffileID: objectVerified,
+				testLabelsID:  objectVerified,
+				testPrimID:    objectVerified,
+				5:             objectNotCovered,
+			},
+			entityOK: true,
+		},
+		{
+			name: "object removed",
+			modify: func(t *testing.T, path string) {
+				fimg, err := sif.LoadContainer(path, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer fimg.UnloadContainer()
+				if err := fimg.DeleteObject(testLabelsID, sif.DelZero); err != nil {
+					t.Fatal(err)
+				}
+			},
+			status: map[uint32]objectStatus{
+				testDeffileID: objectVerified,
+				testLabelsID:  objectMissing,
+				testPrimID:    objectVerified,
+			},
+			failed: true,
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "signing-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, fmt.Sprintf("test%d.sif", i))
+			createTestSIF(t, path)
+			signTestSIF(t, path, e)
+			if tt.modify != nil {
+				tt.modify(t, path)
+			}
+
+			reports, err := checkTestSIF(t, path)
+			if tt.failed && err == nil {
+				t.Errorf("unexpected success")
+			} else if !tt.failed && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			status := statusByID(reports)
+			if len(status) != len(tt.status) {
+				t.Errorf("unexpected reports %v", reports)
+			}
+			for id, s := range tt.status {
+				if status[id] != s {
+					t.Errorf("unexpected status %s for object %d, expected %s", status[id], id, s)
+				}
+			}
+
+			// the ECL accepts set signatures covering the primary partition
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			entities, err := VerifySignEntitiesFp(f, openpgp.EntityList{e}, "")
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			fingerprint := fmt.Sprintf("%X", e.PrimaryKey.Fingerprint)
+			if err, ok := entities[fingerprint]; !ok {
+				t.Errorf("signing entity %s not found", fingerprint)
+			} else if tt.entityOK && err != nil {
+				t.Errorf("unexpected error for signing entity: %s", err)
+			} else if !tt.entityOK && err == nil {
+				t.Errorf("unexpected valid signature for signing entity")
+			}
+		})
+	}
+}
+
+func TestLayerSignatures(t *testing.T) {
+	e := newEntity(t)
+
+	// the default verification requires a signature linked to the primary
+	// partition, layers may be covered by a set signature
+	tests := []struct {
+		name     string
+		sign     func(t *testing.T, path string)
+		valid    bool
+		verified bool
+	}{
+		{
+			name: "set signature",
+			sign: func(t *testing.T, path string) {
+				addTestLayer(t, path)
+				signTestSIF(t, path, e)
+			},
+			valid: true,
+		},
+		{
+			name: "primary partition and set signatures",
+			sign: func(t *testing.T, path string) {
+				addTestLayer(t, path)
+				signTestObject(t, path, e, testPrimID)
+				signTestSIF(t, path, e)
+			},
+			valid:    true,
+			verified: true,
+		},
+		{
+			name: "layer added after set signature",
+			sign: func(t *testing.T, path string) {
+				signTestSIF(t, path, e)
+				addTestLayer(t, path)
+			},
+		},
+		{
+			name: "primary partition signature",
+			sign: func(t *testing.T, path string) {
+				addTestLayer(t, path)
+				signTestObject(t, path, e, testPrimID)
+			},
+		},
+		{
+			name: "primary partition and layer signatures",
+			sign: func(t *testing.T, path string) {
+				id := addTestLayer(t, path)
+				signTestObject(t, path, e, testPrimID)
+				signTestObject(t, path, e, id)
+			},
+			valid:    true,
+			verified: true,
+		},
+		{
+			name: "layer modified",
+			sign: func(t *testing.T, path string) {
+				id := addTestLayer(t, path)
+				signTestObject(t, path, e, testPrimID)
+				signTestObject(t, path, e, id)
+				overwriteObject(t, path, id, "LAYER")
+			},
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "signing-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fingerprint := fmt.Sprintf("%X", e.PrimaryKey.Fingerprint)
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, fmt.Sprintf("layer%d.sif", i))
+			createTestSIF(t, path)
+			tt.sign(t, path)
+
+			// the ECL requires the primary partition and each layer to be signed
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			entities, err := VerifySignEntitiesFp(f, openpgp.EntityList{e}, "")
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if err, ok := entities[fingerprint]; !ok {
+				t.Errorf("signing entity %s not found", fingerprint)
+			} else if tt.valid && err != nil {
+				t.Errorf("unexpected error for signing entity: %s", err)
+			} else if !tt.valid && err == nil {
+				t.Errorf("unexpected valid signature for signing entity")
+			}
+
+			v := &verifier{elist: openpgp.EntityList{e}, keyrings: []string{"test"}}
+			err = v.verify(path, 0, false)
+			if tt.verified && err != nil {
+				t.Errorf("unexpected verification error: %s", err)
+			} else if !tt.verified && err == nil {
+				t.Errorf("unexpected verification success")
+			}
+		})
+	}
+}
+
+func TestParseSetHashStr(t *testing.T) {
+	tests := []struct {
+		name       string
+		plaintext  string
+		shouldPass bool
+	}{
+		{"valid", setHashHeader + "\n1 abcd\n3 ef01\n", true},
+		{"primary partition hash", "SIFHASH:\nabcd", false},
+		{"empty set", setHashHeader + "\n", false},
+		{"missing hash", setHashHeader + "\n1\n", false},
+		{"invalid ID", setHashHeader + "\nfoo abcd\n", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseSetHashStr([]byte(tt.plaintext))
+			if tt.shouldPass && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			} else if !tt.shouldPass && err == nil {
+				t.Errorf("unexpected success")
+			}
+		})
+	}
+}
@@ -1,4 +1,4 @@
-// Copyright (c) 2018, Sylabs Inc. All rights reserved.
+// Copyright (c) 2018-2019, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -12,12 +12,15 @@ import (
 	"encoding/hex"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/internal/pkg/image"
 	"github.com/sylabs/singularity/internal/pkg/sylog"
 	"github.com/sylabs/singularity/pkg/sypgp"
 	"golang.org/x/crypto/openpgp"
 	"golang.org/x/crypto/openpgp/clearsign"
+	pgperrors "golang.org/x/crypto/openpgp/errors"
 )
 
 // computeHashStr generates a hash from data object(s) and generates a string
@@ -87,15 +90,13 @@ func descrToSign(fimg *sif.FileImage, id uint32, isGroup bool) (descr []*sif.Des
 	return
 }
 
-// Sign takes the path of a container and generates an OpenPGP signature block for
-// its system partition. Sign uses the private keys found in the default
-// location if available or helps the user by prompting with key generation
-// configuration options. In its current form, Sign also pushes, when desired,
-// public material to a key server.
-func Sign(cpath, url string, id uint32, isGroup bool, keyIdx int, authToken string) error {
+// signingEntity returns the private key used to sign, selected by its index
+// keyIdx in the private keyring or interactively. A new key pair is generated
+// if the keyring is empty, its public key may be pushed to the key server url.
+func signingEntity(url string, keyIdx int, authToken string) (*openpgp.Entity, error) {
 	elist, err := sypgp.LoadPrivKeyring()
 	if err != nil {
-		return fmt.Errorf("could not load private keyring: %s", err)
+		return nil, fmt.Errorf("could not load private keyring: %s", err)
 	}
 
 	// Generate a private key usable for signing
@@ -103,23 +104,23 @@ func Sign(cpath, url string, id uint32, isGroup bool, keyIdx int, authToken stri
 	if elist == nil {
 		resp, err := sypgp.AskQuestion("No OpenPGP signing keys found, autogenerate? [Y/n] ")
 		if err != nil {
-			return fmt.Errorf("could not read response: %s", err)
+			return nil, fmt.Errorf("could not read response: %s", err)
 		}
 		if resp == "" || resp == "y" || resp == "Y" {
 			entity, err = sypgp.GenKeyPair()
 			if err != nil {
-				return fmt.Errorf("generating openpgp key pair failed: %s", err)
+				return nil, fmt.Errorf("generating openpgp key pair failed: %s", err)
 			}
 		} else {
-			return fmt.Errorf("cannot sign without installed keys")
+			return nil, fmt.Errorf("cannot sign without installed keys")
 		}
 		resp, err = sypgp.AskQuestion("Upload public key %X to %s? [Y/n] ", entity.PrimaryKey.Fingerprint, url)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if resp == "" || resp == "y" || resp == "Y" {
 			if err = sypgp.PushPubkey(entity, url, authToken); err != nil {
-				return fmt.Errorf("failed while pushing public key to server: %s", err)
+				return nil, fmt.Errorf("failed while pushing public key to server: %s", err)
 			}
 			fmt.Printf("Uploaded key successfully!\n")
 		}
@@ -128,12 +129,12 @@ func Sign(cpath, url string, id uint32, isGroup bool, keyIdx int, authToken stri
 			if keyIdx >= 0 && keyIdx < len(elist) {
 				entity = elist[keyIdx]
 			} else {
-				return fmt.Errorf("specified (-k, --keyidx) key index out of range")
+				return nil, fmt.Errorf("specified (-k, --keyidx) key index out of range")
 			}
 		} else if len(elist) > 1 {
 			entity, err = sypgp.SelectPrivKey(elist)
 			if err != nil {
-				return fmt.Errorf("failed while reading selection: %s", err)
+				return nil, fmt.Errorf("failed while reading selection: %s", err)
 			}
 		} else {
 			entity = elist[0]
@@ -142,7 +143,38 @@ func Sign(cpath, url string, id uint32, isGroup bool, keyIdx int, authToken stri
 
 	// Decrypt key if needed
 	if err = sypgp.DecryptKey(entity); err != nil {
-		return fmt.Errorf("could not decrypt private key, wrong password?")
+		return nil, fmt.Errorf("could not decrypt private key, wrong password?")
+	}
+	return entity, nil
+}
+
+// clearSignHash returns an ascii armored signature block of sifhash made
+// with the private key of entity.
+func clearSignHash(entity *openpgp.Entity, sifhash string) ([]byte, error) {
+	var signedmsg bytes.Buffer
+	plaintext, err := clearsign.Encode(&signedmsg, entity.PrivateKey, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not build a signature block: %s", err)
+	}
+	_, err = plaintext.Write([]byte(sifhash))
+	if err != nil {
+		return nil, fmt.Errorf("failed writing hash value to signature block: %s", err)
+	}
+	if err = plaintext.Close(); err != nil {
+		return nil, fmt.Errorf("I/O error while wrapping up signature block: %s", err)
+	}
+	return signedmsg.Bytes(), nil
+}
+
+// Sign takes the path of a container and generates an OpenPGP signature block for
+// its system partition. Sign uses the private keys found in the default
+// location if available or helps the user by prompting with key generation
+// configuration options. In its current form, Sign also pushes, when desired,
+// public material to a key server.
+func Sign(cpath, url string, id uint32, isGroup bool, keyIdx int, authToken string) error {
+	entity, err := signingEntity(url, keyIdx, authToken)
+	if err != nil {
+		return err
 	}
 
 	// load the container
@@ -162,17 +194,9 @@ func Sign(cpath, url string, id uint32, isGroup bool, keyIdx int, authToken stri
 	sifhash := computeHashStr(&fimg, descr)
 
 	// create an ascii armored signature block
-	var signedmsg bytes.Buffer
-	plaintext, err := clearsign.Encode(&signedmsg, entity.PrivateKey, nil)
+	signedmsg, err := clearSignHash(entity, sifhash)
 	if err != nil {
-		return fmt.Errorf("could not build a signature block: %s", err)
-	}
-	_, err = plaintext.Write([]byte(sifhash))
-	if err != nil {
-		return fmt.Errorf("failed writing hash value to signature block: %s", err)
-	}
-	if err = plaintext.Close(); err != nil {
-		return fmt.Errorf("I/O error while wrapping up signature block: %s", err)
+		return err
 	}
 
 	// finally add the signature block (for descr) as a new SIF data object
@@ -184,7 +208,7 @@ func Sign(cpath, url string, id uint32, isGroup bool, keyIdx int, authToken stri
 		groupid = descr[0].Groupid
 		link = descr[0].ID
 	}
-	err = sifAddSignature(&fimg, groupid, link, entity.PrimaryKey.Fingerprint, signedmsg.Bytes())
+	err = sifAddSignature(&fimg, groupid, link, entity.PrimaryKey.Fingerprint, signedmsg)
 	if err != nil {
 		return fmt.Errorf("failed adding signature block to SIF container file: %s", err)
 	}
@@ -192,6 +216,57 @@ func Sign(cpath, url string, id uint32, isGroup bool, keyIdx int, authToken stri
 	return nil
 }
 
+// SignAll works like Sign but generates a single signature block covering all
+// the data objects of the container, such as the partitions, the definition
+// file, the labels and the environment, except the signatures and the overlay
+// partition. The signature block holds a hash for each object, so VerifyAll
+// can report which objects were tampered with.
+func SignAll(cpath, url string, keyIdx int, authToken string) error {
+	entity, err := signingEntity(url, keyIdx, authToken)
+	if err != nil {
+		return err
+	}
+
+	fimg, err := sif.LoadContainer(cpath, false)
+	if err != nil {
+		return fmt.Errorf("failed to load SIF container file: %s", err)
+	}
+	defer fimg.UnloadContainer()
+
+	descr := objectsToSign(&fimg)
+	if len(descr) == 0 {
+		return fmt.Errorf("no data object to sign")
+	}
+
+	signedmsg, err := clearSignHash(entity, computeSetHashStr(&fimg, descr))
+	if err != nil {
+		return err
+	}
+
+	// a set signature is not linked to any object
+	err = sifAddSignature(&fimg, sif.DescrUnusedGroup, sif.DescrUnusedLink, entity.PrimaryKey.Fingerprint, signedmsg)
+	if err != nil {
+		return fmt.Errorf("failed adding signature block to SIF container file: %s", err)
+	}
+
+	return nil
+}
+
+// getSigsLinked returns the signatures linked to the descriptor id, layer
+// partitions are linked to the partition they are stacked over too.
+func getSigsLinked(fimg *sif.FileImage, id uint32) (sigs []*sif.Descriptor) {
+	linked, _, err := fimg.GetFromLinkedDescr(id)
+	if err != nil {
+		return nil
+	}
+	for _, d := range linked {
+		if d.Datatype == sif.DataSignature {
+			sigs = append(sigs, d)
+		}
+	}
+	return sigs
+}
+
 // return all signatures for the primary partition
 func getSigsPrimPart(fimg *sif.FileImage) (sigs []*sif.Descriptor, descr []*sif.Descriptor, err error) {
 	descr = make([]*sif.Descriptor, 1)
@@ -201,8 +276,8 @@ func getSigsPrimPart(fimg *sif.FileImage) (sigs []*sif.Descriptor, descr []*sif.
 		return nil, nil, fmt.Errorf("no primary partition found")
 	}
 
-	sigs, _, err = fimg.GetFromLinkedDescr(descr[0].ID)
-	if err != nil {
+	sigs = getSigsLinked(fimg, descr[0].ID)
+	if len(sigs) == 0 {
 		return nil, nil, fmt.Errorf("no signatures found for system partition")
 	}
 
@@ -218,8 +293,8 @@ func getSigsDescr(fimg *sif.FileImage, id uint32) (sigs []*sif.Descriptor, descr
 		return nil, nil, fmt.Errorf("no descriptor found for id %v", id)
 	}
 
-	sigs, _, err = fimg.GetFromLinkedDescr(id)
-	if err != nil {
+	sigs = getSigsLinked(fimg, id)
+	if len(sigs) == 0 {
 		return nil, nil, fmt.Errorf("no signatures found for id %v", id)
 	}
 
@@ -260,12 +335,113 @@ func getSigsForSelection(fimg *sif.FileImage, id uint32, isGroup bool) (sigs []*
 	return getSigsDescr(fimg, id)
 }
 
+// keyServer holds the parameters used to look up keys on a key server.
+type keyServer struct {
+	url       string
+	authToken string
+	noPrompt  bool
+}
+
+// verifier verifies signature blocks with the keys of elist, keys missing
+// from elist are fetched from server unless it is nil.
+type verifier struct {
+	elist openpgp.EntityList
+	// keyrings lists the paths of the keyrings elist was loaded from
+	keyrings []string
+	server   *keyServer
+}
+
 // Verify takes a container path and look for a verification block for a
 // specified descriptor. If found, the signature block is used to verify the
 // partition hash against the signer's version. Verify takes care of looking
 // for OpenPGP keys in the default local store or looks it up from a key server
-// if access is enabled.
+// if access is enabled. Signatures made by a key which was expired or revoked
+// at signing time are rejected, revocations published on the key server are
+// honoured for keys found in the local store. When the primary partition is
+// selected, each layer partition stacked over it must be signed too, by a
+// signature linked to the layer or covering the set of all data objects.
 func Verify(cpath, url string, id uint32, isGroup bool, authToken string, noPrompt bool) error {
+	// load the public keys available locally from the cache
+	elist, err := sypgp.LoadPubKeyring()
+	if err != nil {
+		return fmt.Errorf("could not load public keyring: %s", err)
+	}
+
+	v := &verifier{
+		elist:    elist,
+		keyrings: []string{sypgp.PublicPath()},
+		server:   &keyServer{url: url, authToken: authToken, noPrompt: noPrompt},
+	}
+	return v.verify(cpath, id, isGroup)
+}
+
+// VerifyOffline works like Verify but never contacts a key server, the
+// OpenPGP keys are looked up in the trusted keyring, if not empty, and
+// in the user public keyring only. Verification fails if the key of a
+// signer is missing from both keyrings.
+func VerifyOffline(cpath string, id uint32, isGroup bool, trustedKeyring string) error {
+	v, err := newOfflineVerifier(trustedKeyring, sypgp.PublicPath())
+	if err != nil {
+		return err
+	}
+	return v.verify(cpath, id, isGroup)
+}
+
+// VerifyAll verifies the signature blocks covering the set of all data
+// objects of the container created by SignAll. The status of each data
+// object is reported: verified, tampered with, removed or not covered when
+// added after the signature was made. Verification fails if a covered object
+// was tampered with or removed. Keys are looked up like Verify does.
+func VerifyAll(cpath, url, authToken string, noPrompt bool) error {
+	elist, err := sypgp.LoadPubKeyring()
+	if err != nil {
+		return fmt.Errorf("could not load public keyring: %s", err)
+	}
+
+	v := &verifier{
+		elist:    elist,
+		keyrings: []string{sypgp.PublicPath()},
+		server:   &keyServer{url: url, authToken: authToken, noPrompt: noPrompt},
+	}
+	return v.verifyAll(cpath)
+}
+
+// VerifyAllOffline works like VerifyAll but never contacts a key server,
+// keys are looked up like VerifyOffline does.
+func VerifyAllOffline(cpath, trustedKeyring string) error {
+	v, err := newOfflineVerifier(trustedKeyring, sypgp.PublicPath())
+	if err != nil {
+		return err
+	}
+	return v.verifyAll(cpath)
+}
+
+// newOfflineVerifier returns a verifier using the keys of the keyrings
+// trusted and user only. A missing user keyring is treated as empty.
+func newOfflineVerifier(trusted, user string) (*verifier, error) {
+	v := &verifier{}
+	if trusted != "" {
+		el, err := sypgp.LoadKeyring(trusted)
+		if err != nil {
+			return nil, fmt.Errorf("could not load trusted keyring: %s", err)
+		}
+		v.elist = append(v.elist, el...)
+		v.keyrings = append(v.keyrings, trusted)
+	}
+
+	el, err := sypgp.LoadKeyring(user)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("could not load public keyring: %s", err)
+	}
+	v.elist = append(v.elist, el...)
+	v.keyrings = append(v.keyrings, user)
+
+	return v, nil
+}
+
+// verify checks the data integrity and the signatures of the descriptor id,
+// or of the group id if isGroup is true, of the container at cpath.
+func (v *verifier) verify(cpath string, id uint32, isGroup bool) error {
 	fimg, err := sif.LoadContainer(cpath, true)
 	if err != nil {
 		return fmt.Errorf("failed to load SIF container file: %s", err)
@@ -275,103 +451,283 @@ func Verify(cpath, url string, id uint32, isGroup bool, authToken string, noProm
 	// get all signature blocks (signatures) for ID/GroupID selected (descr) from SIF file
 	signatures, descr, err := getSigsForSelection(&fimg, id, isGroup)
 	if err != nil {
+		if id == 0 && len(getSigsSet(&fimg)) > 0 {
+			return fmt.Errorf("error while searching for signature blocks: %s, only the set of all data objects is signed", err)
+		}
 		return fmt.Errorf("error while searching for signature blocks: %s", err)
 	}
 
-	// the selected data object is hashed for comparison against signature block's
-	sifhash := computeHashStr(&fimg, descr)
-
-	// load the public keys available locally from the cache
-	elist, err := sypgp.LoadPubKeyring()
+	signers, err := v.verifySigs(&fimg, signatures, descr)
 	if err != nil {
-		return fmt.Errorf("could not load public keyring: %s", err)
+		return err
+	}
+	var authok string
+	for _, signer := range signers {
+		authok += fmt.Sprintf("\t%s, KeyID %X\n", entityName(signer), signer.PrimaryKey.KeyId)
 	}
 
+	// the layers stacked over the primary partition are part of the container
+	if id == 0 && !isGroup {
+		layers, err := image.SIFLayers(&fimg)
+		if err != nil {
+			return err
+		}
+		for _, l := range layers {
+			signers, err := v.verifyLayer(&fimg, l)
+			if err != nil {
+				return err
+			}
+			for _, signer := range signers {
+				authok += fmt.Sprintf("\t%s, KeyID %X, layer partition %d\n", entityName(signer), signer.PrimaryKey.KeyId, l.ID)
+			}
+		}
+	}
+
+	fmt.Printf("Data integrity checked, authentic and signed by:\n")
+	fmt.Print(authok)
+
+	return nil
+}
+
+// entityName returns the name of the first identity of e.
+func entityName(e *openpgp.Entity) string {
+	for _, i := range e.Identities {
+		return i.Name
+	}
+	return ""
+}
+
+// verifySigs checks the data integrity of the data objects descr and the
+// signature blocks signatures covering them, it returns the signers.
+func (v *verifier) verifySigs(fimg *sif.FileImage, signatures []*sif.Descriptor, descr []*sif.Descriptor) ([]*openpgp.Entity, error) {
+	// the selected data object is hashed for comparison against signature block's
+	sifhash := computeHashStr(fimg, descr)
+
 	// compare freshly computed hash with hashes stored in signatures block(s)
-	var authok string
-	for _, v := range signatures {
+	var signers []*openpgp.Entity
+	for _, s := range signatures {
 		// Extract hash string from signature block
-		data := v.GetData(&fimg)
+		data := s.GetData(fimg)
 		block, _ := clearsign.Decode(data)
 		if block == nil {
-			return fmt.Errorf("failed to parse signature block")
+			return nil, fmt.Errorf("failed to parse signature block")
 		}
 
 		if !bytes.Equal(bytes.TrimRight(block.Plaintext, "\n"), []byte(sifhash)) {
 			sylog.Infof("NOTE: group signatures will fail if new data is added to a group")
 			sylog.Infof("after the group signature is created.")
-			return fmt.Errorf("hashes differ, data may be corrupted")
+			return nil, fmt.Errorf("hashes differ, data may be corrupted")
 		}
 
 		// (1) Data integrity is verified, (2) now validate identify of signers
 
 		// get the entity fingerprint for the signature block
-		fingerprint, err := v.GetEntityString()
+		fingerprint, err := s.GetEntityString()
+		if err != nil {
+			return nil, fmt.Errorf("could not get the signing entity fingerprint: %s", err)
+		}
+
+		signer, err := v.verifyBlock(data, fingerprint)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, signer)
+	}
+	return signers, nil
+}
+
+// verifyLayer verifies the signatures of the layer partition l, linked to
+// the layer or else covering the set of all data objects, and returns the
+// signers. An unsigned layer is an error.
+func (v *verifier) verifyLayer(fimg *sif.FileImage, l *sif.Descriptor) ([]*openpgp.Entity, error) {
+	if sigs := getSigsLinked(fimg, l.ID); len(sigs) > 0 {
+		signers, err := v.verifySigs(fimg, sigs, []*sif.Descriptor{l})
+		if err != nil {
+			return nil, fmt.Errorf("layer partition %d: %s", l.ID, err)
+		}
+		return signers, nil
+	}
+
+	var signers []*openpgp.Entity
+	for _, s := range getSigsSet(fimg) {
+		data := s.GetData(fimg)
+		block, _ := clearsign.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("failed to parse signature block")
+		}
+		fingerprint, err := s.GetEntityString()
+		if err != nil {
+			return nil, fmt.Errorf("could not get the signing entity fingerprint: %s", err)
+		}
+		signer, err := v.verifyBlock(data, fingerprint)
+		if err != nil {
+			return nil, err
+		}
+		reports, err := checkSetBlock(fimg, block.Plaintext)
+		if err != nil {
+			return nil, err
+		}
+		if objectCovered(reports, l.ID) {
+			signers = append(signers, signer)
+		}
+	}
+	if len(signers) == 0 {
+		return nil, fmt.Errorf("layer partition %d is not signed, its content can't be verified", l.ID)
+	}
+	return signers, nil
+}
+
+// verifyAll verifies the set signatures of the container at cpath and
+// reports the status of each data object.
+func (v *verifier) verifyAll(cpath string) error {
+	fimg, err := sif.LoadContainer(cpath, true)
+	if err != nil {
+		return fmt.Errorf("failed to load SIF container file: %s", err)
+	}
+	defer fimg.UnloadContainer()
+
+	signatures := getSigsSet(&fimg)
+	if len(signatures) == 0 {
+		return fmt.Errorf("no signatures found for the set of all data objects")
+	}
+
+	var failed, uncovered bool
+	for _, s := range signatures {
+		data := s.GetData(&fimg)
+		block, _ := clearsign.Decode(data)
+		if block == nil {
+			return fmt.Errorf("failed to parse signature block")
+		}
+
+		fingerprint, err := s.GetEntityString()
 		if err != nil {
 			return fmt.Errorf("could not get the signing entity fingerprint: %s", err)
 		}
 
-		// try to verify with local OpenPGP store first
-		signer, err := openpgp.CheckDetachedSignature(elist, bytes.NewBuffer(block.Bytes), block.ArmoredSignature.Body)
+		// the signature is checked first as the object hashes come from the signed plaintext
+		signer, err := v.verifyBlock(data, fingerprint)
 		if err != nil {
-			// verification with local keyring failed, try to fetch from key server
-			sylog.Infof("key missing, searching key server for KeyID: %s...", fingerprint[24:])
-			netlist, err := sypgp.FetchPubkey(fingerprint, url, authToken, noPrompt)
-			if err != nil {
-				return fmt.Errorf("could not fetch public key from server: %s", err)
-			}
-			sylog.Infof("key retrieved successfully!")
+			return err
+		}
 
-			block, _ := clearsign.Decode(data)
-			if block == nil {
-				return fmt.Errorf("failed to parse signature block")
-			}
+		fmt.Printf("Data objects signed by %s, KeyID %X:\n", entityName(signer), signer.PrimaryKey.KeyId)
 
-			// try verification again with downloaded key
-			signer, err = openpgp.CheckDetachedSignature(netlist, bytes.NewBuffer(block.Bytes), block.ArmoredSignature.Body)
-			if err != nil {
-				return fmt.Errorf("signature verification failed: %s", err)
+		reports, err := checkSetBlock(&fimg, block.Plaintext)
+		for _, r := range reports {
+			fmt.Printf("\t%s\n", r)
+		}
+		if err != nil {
+			sylog.Errorf("%s", err)
+			failed = true
+		}
+		for _, r := range reports {
+			if r.status != objectNotCovered {
+				continue
 			}
-
-			if noPrompt {
-				// always store key when prompts disabled
-				if err = sypgp.StorePubKey(netlist[0]); err != nil {
-					return fmt.Errorf("could not store public key: %s", err)
-				}
+			uncovered = true
+			if r.overlay {
+				sylog.Warningf("overlay partition %d is not covered by signature, its content can't be verified", r.id)
 			} else {
-				// Ask to store new public key
-				resp, err := sypgp.AskQuestion("Store new public key %X? [Y/n] ", signer.PrimaryKey.Fingerprint)
-				if err != nil {
-					return err
-				}
-				if resp == "" || resp == "y" || resp == "Y" {
-					if err = sypgp.StorePubKey(netlist[0]); err != nil {
-						return fmt.Errorf("could not store public key: %s", err)
-					}
-				}
+				sylog.Warningf("data object %d was added after the signature was made and is not covered by signature", r.id)
 			}
 		}
+	}
+	if failed {
+		return fmt.Errorf("data integrity check failed")
+	}
+	if uncovered {
+		fmt.Printf("Data integrity checked, the data objects covered by signature are authentic and signed\n")
+		return nil
+	}
+	fmt.Printf("Data integrity checked, authentic and signed\n")
 
-		// Get first Identity data for convenience
-		var name string
-		for _, i := range signer.Identities {
-			name = i.Name
-			break
+	return nil
+}
+
+// verifyBlock verifies the signature block data made by the entity
+// fingerprint and returns the signing entity.
+func (v *verifier) verifyBlock(data []byte, fingerprint string) (*openpgp.Entity, error) {
+	// try to verify with local OpenPGP store first
+	signer, sig, err := verifySignature(v.elist, data)
+	if err == pgperrors.ErrUnknownIssuer {
+		if v.server == nil {
+			return nil, fmt.Errorf("public key %s not found in %s and key server lookup is disabled", fingerprint, strings.Join(v.keyrings, " or "))
 		}
-		authok += fmt.Sprintf("\t%s, KeyID %X\n", name, signer.PrimaryKey.KeyId)
+		return v.fetchAndVerify(data, fingerprint)
+	} else if err != nil {
+		return nil, fmt.Errorf("signature verification failed: %s", err)
 	}
-	fmt.Printf("Data integrity checked, authentic and signed by:\n")
-	fmt.Print(authok)
 
-	return nil
+	// honour revocations published on the key server since the key was stored locally
+	var copies []*openpgp.Entity
+	if v.server != nil {
+		if netkey := fetchRevocations(signer, v.server.url, v.server.authToken); netkey != nil {
+			copies = append(copies, netkey)
+		}
+	}
+	if err = checkKey(signer, sig, copies...); err != nil {
+		return nil, fmt.Errorf("signature verification failed: %s", err)
+	}
+	return signer, nil
+}
+
+// fetchAndVerify fetches the key of entity fingerprint from the key server
+// to verify the signature block data, the key is then stored locally.
+func (v *verifier) fetchAndVerify(data []byte, fingerprint string) (*openpgp.Entity, error) {
+	// verification with local keyring failed, try to fetch from key server
+	sylog.Infof("key missing, searching key server for KeyID: %s...", fingerprint[24:])
+	netlist, err := sypgp.FetchPubkey(fingerprint, v.server.url, v.server.authToken, v.server.noPrompt)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch public key from server: %s", err)
+	}
+	sylog.Infof("key retrieved successfully!")
+
+	// try verification again with downloaded key
+	signer, sig, err := verifySignature(netlist, data)
+	if err != nil {
+		return nil, fmt.Errorf("signature verification failed: %s", err)
+	}
+	if err = checkKey(signer, sig); err != nil {
+		return nil, fmt.Errorf("signature verification failed: %s", err)
+	}
+
+	if v.server.noPrompt {
+		// always store key when prompts disabled
+		if err = sypgp.StorePubKey(netlist[0]); err != nil {
+			return nil, fmt.Errorf("could not store public key: %s", err)
+		}
+	} else {
+		// Ask to store new public key
+		resp, err := sypgp.AskQuestion("Store new public key %X? [Y/n] ", signer.PrimaryKey.Fingerprint)
+		if err != nil {
+			return nil, err
+		}
+		if resp == "" || resp == "y" || resp == "Y" {
+			if err = sypgp.StorePubKey(netlist[0]); err != nil {
+				return nil, fmt.Errorf("could not store public key: %s", err)
+			}
+		}
+	}
+	return signer, nil
 }
 
 func getSignEntities(fimg *sif.FileImage) ([]string, error) {
 	// get all signature blocks (signatures) for ID/GroupID selected (descr) from SIF file
 	signatures, _, err := getSigsPrimPart(fimg)
+	// signatures covering the set of all data objects cover the primary partition too
+	sets := getSigsSet(fimg)
+	if err != nil && len(sets) == 0 {
+		return nil, err
+	}
+	signatures = append(signatures, sets...)
+	// so do the signatures of the layers stacked over it
+	layers, err := image.SIFLayers(fimg)
 	if err != nil {
 		return nil, err
 	}
+	for _, l := range layers {
+		signatures = append(signatures, getSigsLinked(fimg, l.ID)...)
+	}
 
 	var entities []string
 	for _, v := range signatures {
@@ -405,3 +761,188 @@ func GetSignEntitiesFp(fp *os.File) ([]string, error) {
 
 	return getSignEntities(&fimg)
 }
+
+// partCoverage records the partitions validly signed by an entity and the
+// reason the other partitions it signed are not.
+type partCoverage struct {
+	signed map[uint32]bool
+	errs   map[uint32]error
+}
+
+// add records the result err of the verification of a signature of the
+// partition id, a valid signature takes precedence.
+func (c *partCoverage) add(id uint32, err error) {
+	if err == nil {
+		c.signed[id] = true
+	} else if c.errs[id] == nil {
+		c.errs[id] = err
+	}
+}
+
+// status returns nil if all the partitions parts, the primary partition
+// first then its layers, are validly signed, or the reason the first one
+// not validly signed is not.
+func (c *partCoverage) status(parts []*sif.Descriptor) error {
+	for i, p := range parts {
+		if c.signed[p.ID] {
+			continue
+		}
+		err := c.errs[p.ID]
+		if i == 0 {
+			if err == nil {
+				err = fmt.Errorf("primary partition is not signed")
+			}
+			return err
+		}
+		if err == nil {
+			return fmt.Errorf("layer partition %d is not signed", p.ID)
+		}
+		return fmt.Errorf("layer partition %d: %s", p.ID, err)
+	}
+	return nil
+}
+
+// VerifySignEntitiesFp verifies the signatures of the primary partition of an
+// already opened container, and of the layer partitions stacked over it, with
+// the public keys of el, including signatures covering the set of all data
+// objects. If keyserverURL is set, keys missing from el are fetched from the
+// key server and the revocations published there are honoured. It returns the
+// fingerprints of all signing entities along with the reason their signatures
+// are not valid, or nil if the primary partition and each layer were signed
+// by a key valid at signing time.
+func VerifySignEntitiesFp(fp *os.File, el openpgp.EntityList, keyserverURL string) (map[string]error, error) {
+	fimg, err := sif.LoadContainerFp(fp, true)
+	if err != nil {
+		return nil, err
+	}
+
+	prim, _, err := fimg.GetPartPrimSys()
+	if err != nil {
+		return nil, fmt.Errorf("no primary partition found")
+	}
+	sets := getSigsSet(&fimg)
+	if len(getSigsLinked(&fimg, prim.ID)) == 0 && len(sets) == 0 {
+		return nil, fmt.Errorf("no signatures found for system partition")
+	}
+	layers, err := image.SIFLayers(&fimg)
+	if err != nil {
+		return nil, err
+	}
+	parts := append([]*sif.Descriptor{prim}, layers...)
+
+	coverage := make(map[string]*partCoverage)
+	entity := func(v *sif.Descriptor) (string, *partCoverage, error) {
+		fingerprint, err := v.GetEntityString()
+		if err != nil {
+			return "", nil, err
+		}
+		c, ok := coverage[fingerprint]
+		if !ok {
+			c = &partCoverage{signed: make(map[uint32]bool), errs: make(map[uint32]error)}
+			coverage[fingerprint] = c
+		}
+		return fingerprint, c, nil
+	}
+	for _, p := range parts {
+		sifhash := computeHashStr(&fimg, []*sif.Descriptor{p})
+		for _, v := range getSigsLinked(&fimg, p.ID) {
+			fingerprint, c, err := entity(v)
+			if err != nil {
+				return nil, err
+			}
+			if !c.signed[p.ID] {
+				c.add(p.ID, verifySignEntity(&fimg, v, el, keyserverURL, fingerprint, sifhash))
+			}
+		}
+	}
+	for _, v := range sets {
+		fingerprint, c, err := entity(v)
+		if err != nil {
+			return nil, err
+		}
+		reports, err := verifySetEntity(&fimg, v, el, keyserverURL, fingerprint)
+		for i, p := range parts {
+			if c.signed[p.ID] {
+				continue
+			}
+			if err == nil && !objectCovered(reports, p.ID) {
+				if i == 0 {
+					c.add(p.ID, fmt.Errorf("primary partition is not covered by the signature"))
+				} else {
+					c.add(p.ID, fmt.Errorf("not covered by the signature"))
+				}
+				continue
+			}
+			c.add(p.ID, err)
+		}
+	}
+
+	status := make(map[string]error)
+	for fingerprint, c := range coverage {
+		status[fingerprint] = c.status(parts)
+	}
+	return status, nil
+}
+
+// verifyEntityBlock checks that the clear signed data was signed by the
+// entity fingerprint. If keyserverURL is set, a key missing from el is
+// fetched from the key server and the revocations published there are
+// honoured.
+func verifyEntityBlock(el openpgp.EntityList, data []byte, keyserverURL, fingerprint string) error {
+	fetched := false
+	signer, sig, err := verifySignature(el, data)
+	if err == pgperrors.ErrUnknownIssuer && keyserverURL != "" {
+		netlist, ferr := sypgp.FetchPubkey(fingerprint, keyserverURL, "", true)
+		if ferr != nil {
+			return fmt.Errorf("public key missing from keyring and key server: %s", ferr)
+		}
+		signer, sig, err = verifySignature(netlist, data)
+		fetched = true
+	}
+	if err == pgperrors.ErrUnknownIssuer {
+		return fmt.Errorf("public key missing from keyring")
+	} else if err != nil {
+		return err
+	}
+	// the descriptor must not claim the signature of another entity
+	if signerFp := fmt.Sprintf("%X", signer.PrimaryKey.Fingerprint); signerFp != fingerprint {
+		return fmt.Errorf("signature was made by key %s", signerFp)
+	}
+
+	var copies []*openpgp.Entity
+	if keyserverURL != "" && !fetched {
+		if netkey := fetchRevocations(signer, keyserverURL, ""); netkey != nil {
+			copies = append(copies, netkey)
+		}
+	}
+	return checkKey(signer, sig, copies...)
+}
+
+// verifySignEntity checks the data integrity and the signature of the
+// signature block descriptor sigDescr.
+func verifySignEntity(fimg *sif.FileImage, sigDescr *sif.Descriptor, el openpgp.EntityList, keyserverURL, fingerprint, sifhash string) error {
+	data := sigDescr.GetData(fimg)
+	block, _ := clearsign.Decode(data)
+	if block == nil {
+		return fmt.Errorf("failed to parse signature block")
+	}
+	if !bytes.Equal(bytes.TrimRight(block.Plaintext, "\n"), []byte(sifhash)) {
+		return fmt.Errorf("hashes differ, data may be corrupted")
+	}
+	return verifyEntityBlock(el, data, keyserverURL, fingerprint)
+}
+
+// verifySetEntity checks the signature of the set signature block descriptor
+// sigDescr and the integrity of the data objects it covers, it returns the
+// status of each data object.
+func verifySetEntity(fimg *sif.FileImage, sigDescr *sif.Descriptor, el openpgp.EntityList, keyserverURL, fingerprint string) ([]objectReport, error) {
+	data := sigDescr.GetData(fimg)
+	block, _ := clearsign.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("failed to parse signature block")
+	}
+	if err := verifyEntityBlock(el, data, keyserverURL, fingerprint); err != nil {
+		return nil, err
+	}
+	return checkSetBlock(fimg, block.Plaintext)
+}
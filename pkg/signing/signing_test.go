@@ -0,0 +1,103 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package signing
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+// writeKeyring writes the public keys of el to path, ASCII armored if
+// armored is true.
+func writeKeyring(t *testing.T, path string, el openpgp.EntityList, armored bool) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var w io.Writer = f
+	if armored {
+		aw, err := armor.Encode(f, openpgp.PublicKeyType, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer aw.Close()
+		w = aw
+	}
+	for _, e := range el {
+		if err := e.Serialize(w); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestVerifyOffline(t *testing.T) {
+	trustedKey := newEntity(t)
+	userKey := newEntity(t)
+	unknownKey := newEntity(t)
+
+	dir, err := ioutil.TempDir("", "signing-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	trusted := filepath.Join(dir, "trusted")
+	user := filepath.Join(dir, "pgp-public")
+	writeKeyring(t, trusted, openpgp.EntityList{trustedKey}, true)
+	writeKeyring(t, user, openpgp.EntityList{userKey}, false)
+
+	if _, err := newOfflineVerifier(filepath.Join(dir, "missing"), user); err == nil {
+		t.Errorf("unexpected success with a missing trusted keyring")
+	}
+
+	// a missing user keyring holds no keys
+	v, err := newOfflineVerifier(trusted, filepath.Join(dir, "missing"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(v.elist) != 1 {
+		t.Errorf("unexpected number of keys %d", len(v.elist))
+	}
+
+	v, err = newOfflineVerifier(trusted, user)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if v.server != nil {
+		t.Fatalf("unexpected key server in offline mode")
+	}
+
+	for _, e := range []*openpgp.Entity{trustedKey, userKey} {
+		fingerprint := fmt.Sprintf("%X", e.PrimaryKey.Fingerprint)
+		signer, err := v.verifyBlock(clearSign(t, e, signTime), fingerprint)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		} else if signer.PrimaryKey.Fingerprint != e.PrimaryKey.Fingerprint {
+			t.Errorf("unexpected signer %X", signer.PrimaryKey.Fingerprint)
+		}
+	}
+
+	fingerprint := fmt.Sprintf("%X", unknownKey.PrimaryKey.Fingerprint)
+	_, err = v.verifyBlock(clearSign(t, unknownKey, signTime), fingerprint)
+	if err == nil {
+		t.Fatalf("unexpected success with an unknown key")
+	}
+	for _, s := range []string{fingerprint, trusted, user} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("error %q doesn't mention %s", err, s)
+		}
+	}
+}
@@ -0,0 +1,433 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sypgp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// ReadKeys reads the keys from r which may be ASCII armored or binary
+// encoded.
+func ReadKeys(r io.Reader) (openpgp.EntityList, error) {
+	br := bufio.NewReader(r)
+	b, err := br.Peek(1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keys: %s", err)
+	}
+	// the first byte of a binary OpenPGP packet always has its high bit set
+	if b[0]&0x80 != 0 {
+		return openpgp.ReadKeyRing(br)
+	}
+	return openpgp.ReadArmoredKeyRing(br)
+}
+
+// LoadKeyring reads the ASCII armored or binary encoded keys from the
+// keyring file at path, an empty keyring holds no keys.
+func LoadKeyring(path string) (openpgp.EntityList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() == 0 {
+		return nil, nil
+	}
+	return ReadKeys(f)
+}
+
+// ImportKey reads the ASCII armored or binary encoded keys from r and
+// stores them into the local key stores. Private keys go into the secret
+// store and their public part into the public store, keys already present
+// are updated with their new revocations, signatures and subkeys. The list
+// of imported or updated keys is returned.
+func ImportKey(r io.Reader) (openpgp.EntityList, error) {
+	if err := PathsCheck(); err != nil {
+		return nil, err
+	}
+	el, err := ReadKeys(r)
+	if err != nil {
+		return nil, err
+	}
+	return importKeys(el, PublicPath(), SecretPath())
+}
+
+// ExportPubKey writes the public key matching fingerprint from the local
+// public store to w, ASCII armored if armored is true.
+func ExportPubKey(w io.Writer, fingerprint string, armored bool) error {
+	el, err := LoadPubKeyring()
+	if err != nil {
+		return err
+	}
+	return exportKey(w, el, fingerprint, false, armored)
+}
+
+// ExportPrivKey writes the private key matching fingerprint from the local
+// secret store to w, ASCII armored if armored is true. The private key is
+// exported as stored, encrypted keys stay encrypted.
+func ExportPrivKey(w io.Writer, fingerprint string, armored bool) error {
+	el, err := LoadPrivKeyring()
+	if err != nil {
+		return err
+	}
+	return exportKey(w, el, fingerprint, true, armored)
+}
+
+// RemovePubKey removes the public key matching fingerprint from the local
+// public store.
+func RemovePubKey(fingerprint string) error {
+	if err := PathsCheck(); err != nil {
+		return err
+	}
+	return removeKey(PublicPath(), fingerprint, false)
+}
+
+// RemovePrivKey removes the private key matching fingerprint from the local
+// secret store.
+func RemovePrivKey(fingerprint string) error {
+	if err := PathsCheck(); err != nil {
+		return err
+	}
+	return removeKey(SecretPath(), fingerprint, true)
+}
+
+// findEntity returns the entity of el whose primary key matches the
+// 40 characters hexadecimal fingerprint.
+func findEntity(el openpgp.EntityList, fingerprint string) (*openpgp.Entity, error) {
+	fp, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(fingerprint), "0x"))
+	if err != nil || len(fp) != 20 {
+		return nil, fmt.Errorf("invalid fingerprint %s: a full fingerprint (40 chars) is required", fingerprint)
+	}
+	for _, e := range el {
+		if bytes.Equal(e.PrimaryKey.Fingerprint[:], fp) {
+			return e, nil
+		}
+	}
+	return nil, fmt.Errorf("no key matching fingerprint %s found", fingerprint)
+}
+
+// serializeEntity writes e to w including revocations and signatures from
+// other entities. If secret is true the private keys are written as stored,
+// contrary to Entity.SerializePrivate the signatures are not computed again
+// so encrypted private keys can be serialized.
+func serializeEntity(w io.Writer, e *openpgp.Entity, secret bool) error {
+	if secret {
+		if e.PrivateKey == nil {
+			return fmt.Errorf("key %X has no private key", e.PrimaryKey.Fingerprint)
+		}
+		if err := e.PrivateKey.Serialize(w); err != nil {
+			return err
+		}
+	} else if err := e.PrimaryKey.Serialize(w); err != nil {
+		return err
+	}
+	for _, sig := range e.Revocations {
+		if err := sig.Serialize(w); err != nil {
+			return err
+		}
+	}
+	for _, ident := range e.Identities {
+		if err := ident.UserId.Serialize(w); err != nil {
+			return err
+		}
+		if err := ident.SelfSignature.Serialize(w); err != nil {
+			return err
+		}
+		for _, sig := range ident.Signatures {
+			if err := sig.Serialize(w); err != nil {
+				return err
+			}
+		}
+	}
+	for _, subkey := range e.Subkeys {
+		if secret && subkey.PrivateKey != nil {
+			if err := subkey.PrivateKey.Serialize(w); err != nil {
+				return err
+			}
+		} else if err := subkey.PublicKey.Serialize(w); err != nil {
+			return err
+		}
+		if err := subkey.Sig.Serialize(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func exportKey(w io.Writer, el openpgp.EntityList, fingerprint string, secret, armored bool) error {
+	e, err := findEntity(el, fingerprint)
+	if err != nil {
+		return err
+	}
+	if !armored {
+		return serializeEntity(w, e, secret)
+	}
+
+	blockType := openpgp.PublicKeyType
+	if secret {
+		blockType = openpgp.PrivateKeyType
+	}
+	aw, err := armor.Encode(w, blockType, nil)
+	if err != nil {
+		return err
+	}
+	if err := serializeEntity(aw, e, secret); err != nil {
+		aw.Close()
+		return err
+	}
+	return aw.Close()
+}
+
+// readKeyring reads the binary keyring stored at path.
+func readKeyring(path string) (openpgp.EntityList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return openpgp.ReadKeyRing(f)
+}
+
+// writeKeyring replaces the keyring stored at path with el, the keyring
+// is written to a temporary file first which is then renamed so the
+// keyring is never left half written.
+func writeKeyring(path string, el openpgp.EntityList, secret bool) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	for _, e := range el {
+		if err := serializeEntity(w, e, secret); err != nil {
+			return fmt.Errorf("failed to write key %X: %s", e.PrimaryKey.Fingerprint, err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := f.Chmod(0600); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// removeKey removes the key matching fingerprint from the keyring stored
+// at path.
+func removeKey(path, fingerprint string, secret bool) error {
+	el, err := readKeyring(path)
+	if err != nil {
+		return err
+	}
+	e, err := findEntity(el, fingerprint)
+	if err != nil {
+		return err
+	}
+
+	kept := make(openpgp.EntityList, 0, len(el)-1)
+	for _, k := range el {
+		if k != e {
+			kept = append(kept, k)
+		}
+	}
+	return writeKeyring(path, kept, secret)
+}
+
+// appendKeys appends the keys of el to the keyring stored at path.
+func appendKeys(path string, el openpgp.EntityList, secret bool) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	for _, e := range el {
+		if err := serializeEntity(w, e, secret); err != nil {
+			return fmt.Errorf("failed to write key %X: %s", e.PrimaryKey.Fingerprint, err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// findFingerprint returns the entity of el with the same primary key
+// fingerprint as e, or nil if there is none.
+func findFingerprint(el openpgp.EntityList, e *openpgp.Entity) *openpgp.Entity {
+	for _, k := range el {
+		if k.PrimaryKey.Fingerprint == e.PrimaryKey.Fingerprint {
+			return k
+		}
+	}
+	return nil
+}
+
+// hasSignature returns true if sigs contains a signature identical to sig.
+func hasSignature(sigs []*packet.Signature, sig *packet.Signature) bool {
+	var b bytes.Buffer
+	if err := sig.Serialize(&b); err != nil {
+		return false
+	}
+	for _, s := range sigs {
+		var sb bytes.Buffer
+		if err := s.Serialize(&sb); err == nil && bytes.Equal(sb.Bytes(), b.Bytes()) {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeEntity adds to dst the revocations, identities, signatures and
+// subkeys of src it doesn't have yet, a newer self signature or subkey
+// binding replaces the stored one and a subkey revocation is never
+// replaced by a binding. Private subkeys are merged only if secret is
+// true. It returns true if dst was modified.
+func mergeEntity(dst, src *openpgp.Entity, secret bool) bool {
+	modified := false
+
+	for _, sig := range src.Revocations {
+		if !hasSignature(dst.Revocations, sig) {
+			dst.Revocations = append(dst.Revocations, sig)
+			modified = true
+		}
+	}
+
+	for name, ident := range src.Identities {
+		d, ok := dst.Identities[name]
+		if !ok {
+			dst.Identities[name] = ident
+			modified = true
+			continue
+		}
+		// signature creation times are stored with a one second precision
+		if ident.SelfSignature.CreationTime.Unix() > d.SelfSignature.CreationTime.Unix() {
+			d.SelfSignature = ident.SelfSignature
+			modified = true
+		}
+		for _, sig := range ident.Signatures {
+			if !hasSignature(d.Signatures, sig) {
+				d.Signatures = append(d.Signatures, sig)
+				modified = true
+			}
+		}
+	}
+
+	for _, subkey := range src.Subkeys {
+		var d *openpgp.Subkey
+		for i := range dst.Subkeys {
+			if dst.Subkeys[i].PublicKey.Fingerprint == subkey.PublicKey.Fingerprint {
+				d = &dst.Subkeys[i]
+				break
+			}
+		}
+		if d == nil {
+			dst.Subkeys = append(dst.Subkeys, subkey)
+			modified = true
+			continue
+		}
+
+		revoked := d.Sig.SigType == packet.SigTypeSubkeyRevocation
+		revokes := subkey.Sig.SigType == packet.SigTypeSubkeyRevocation
+		if (revokes && !revoked) || (revokes == revoked && subkey.Sig.CreationTime.Unix() > d.Sig.CreationTime.Unix()) {
+			d.Sig = subkey.Sig
+			modified = true
+		}
+		if secret && d.PrivateKey == nil && subkey.PrivateKey != nil {
+			d.PrivateKey = subkey.PrivateKey
+			modified = true
+		}
+	}
+
+	return modified
+}
+
+// addEntity adds e to the keyring el, e is merged into the entity with the
+// same fingerprint if there is one. It returns the keyring, whether e
+// brought anything new and whether an entity already in el was modified.
+func addEntity(el openpgp.EntityList, e *openpgp.Entity, secret bool) (openpgp.EntityList, bool, bool) {
+	if k := findFingerprint(el, e); k != nil {
+		modified := mergeEntity(k, e, secret)
+		return el, modified, modified
+	}
+	return append(el, e), true, false
+}
+
+// importKeys adds the keys of el to the public keyring pubPath and, for
+// keys with a private part, to the secret keyring secPath. Keys already
+// present are updated with their new revocations, signatures and subkeys
+// so that re-importing a key picks up its revocation.
+func importKeys(el openpgp.EntityList, pubPath, secPath string) (openpgp.EntityList, error) {
+	pubEl, err := readKeyring(pubPath)
+	if err != nil {
+		return nil, err
+	}
+	secEl, err := readKeyring(secPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var imported openpgp.EntityList
+	pubCount, secCount := len(pubEl), len(secEl)
+	pubModified, secModified := false, false
+	for _, e := range el {
+		var added, secAdded, modified bool
+
+		// only the public part is written to the public keyring
+		pubEl, added, modified = addEntity(pubEl, e, false)
+		pubModified = pubModified || modified
+
+		if e.PrivateKey != nil {
+			secEl, secAdded, modified = addEntity(secEl, e, true)
+			secModified = secModified || modified
+			added = added || secAdded
+		}
+		if added {
+			imported = append(imported, e)
+		}
+	}
+
+	// keyrings with modified keys are written again, otherwise the new
+	// keys are appended
+	if pubModified {
+		if err := writeKeyring(pubPath, pubEl, false); err != nil {
+			return nil, err
+		}
+	} else if err := appendKeys(pubPath, pubEl[pubCount:], false); err != nil {
+		return nil, err
+	}
+	if secModified {
+		if err := writeKeyring(secPath, secEl, true); err != nil {
+			return nil, err
+		}
+	} else if err := appendKeys(secPath, secEl[secCount:], true); err != nil {
+		return nil, err
+	}
+	return imported, nil
+}
@@ -0,0 +1,288 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sypgp
+
+import (
+	"bytes"
+	"crypto"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+func TestReadKeys(t *testing.T) {
+	fp := fmt.Sprintf("%X", testEntity.PrimaryKey.Fingerprint)
+	el := openpgp.EntityList{testEntity}
+
+	for _, armored := range []bool{false, true} {
+		var buf bytes.Buffer
+		if err := exportKey(&buf, el, fp, false, armored); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if armored != strings.HasPrefix(buf.String(), "-----BEGIN PGP PUBLIC KEY BLOCK-----") {
+			t.Errorf("unexpected encoding with armored=%v", armored)
+		}
+
+		keys, err := ReadKeys(&buf)
+		if err != nil {
+			t.Fatalf("unexpected error with armored=%v: %s", armored, err)
+		}
+		if len(keys) != 1 || keys[0].PrimaryKey.Fingerprint != testEntity.PrimaryKey.Fingerprint {
+			t.Errorf("unexpected keys read with armored=%v", armored)
+		}
+		if keys[0].PrivateKey != nil {
+			t.Errorf("unexpected private key in public export")
+		}
+	}
+
+	if _, err := ReadKeys(strings.NewReader("")); err == nil {
+		t.Errorf("unexpected success with empty input")
+	}
+}
+
+func TestExportKey(t *testing.T) {
+	fp := fmt.Sprintf("%x", testEntity.PrimaryKey.Fingerprint)
+	el := openpgp.EntityList{testEntity}
+
+	tests := []struct {
+		name        string
+		fingerprint string
+		shouldPass  bool
+	}{
+		{"lower case", fp, true},
+		{"upper case", strings.ToUpper(fp), true},
+		{"hex prefix", "0x" + fp, true},
+		{"key id", fp[24:], false},
+		{"not hexadecimal", strings.Repeat("z", 40), false},
+		{"unknown", strings.Repeat("0", 40), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := exportKey(ioutil.Discard, el, tt.fingerprint, false, false)
+			if tt.shouldPass && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			} else if !tt.shouldPass && err == nil {
+				t.Errorf("unexpected success")
+			}
+		})
+	}
+}
+
+func TestImportRemoveKey(t *testing.T) {
+	e, err := openpgp.NewEntity(testName, testComment, testEmail, nil)
+	if err != nil {
+		t.Fatalf("failed to create entity: %s", err)
+	}
+	if err := EncryptKey(e, "passphrase"); err != nil {
+		t.Fatalf("failed to encrypt key: %s", err)
+	}
+	fp := fmt.Sprintf("%X", e.PrimaryKey.Fingerprint)
+
+	dir, err := ioutil.TempDir("", "sypgp-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pubPath := filepath.Join(dir, "pgp-public")
+	secPath := filepath.Join(dir, "pgp-secret")
+	for _, p := range []string{pubPath, secPath} {
+		if err := ioutil.WriteFile(p, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// export the encrypted private key and import it back
+	var buf bytes.Buffer
+	if err := exportKey(&buf, openpgp.EntityList{e}, fp, true, true); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	el, err := ReadKeys(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	imported, err := importKeys(append(el, testEntity), pubPath, secPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(imported) != 2 {
+		t.Errorf("unexpected number of imported keys %d", len(imported))
+	}
+
+	// importing again is a no-op
+	imported, err = importKeys(el, pubPath, secPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(imported) != 0 {
+		t.Errorf("unexpected number of imported keys %d", len(imported))
+	}
+
+	pubEl, err := readKeyring(pubPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(pubEl) != 2 {
+		t.Fatalf("unexpected number of public keys %d", len(pubEl))
+	}
+	secEl, err := readKeyring(secPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(secEl) != 2 {
+		t.Fatalf("unexpected number of private keys %d", len(secEl))
+	}
+	k, err := findEntity(secEl, fp)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !k.PrivateKey.Encrypted {
+		t.Errorf("private key is not encrypted anymore")
+	}
+	if err := k.PrivateKey.Decrypt([]byte("passphrase")); err != nil {
+		t.Errorf("failed to decrypt imported private key: %s", err)
+	}
+
+	// remove the key from both keyrings, the other key must be preserved
+	if err := removeKey(pubPath, fp, false); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := removeKey(secPath, fp, true); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := removeKey(pubPath, fp, false); err == nil {
+		t.Errorf("unexpected success while removing a missing key")
+	}
+
+	for _, p := range []string{pubPath, secPath} {
+		el, err := readKeyring(p)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if len(el) != 1 || el[0].PrimaryKey.Fingerprint != testEntity.PrimaryKey.Fingerprint {
+			t.Errorf("unexpected keys left in %s", p)
+		}
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != 0600 {
+			t.Errorf("unexpected permissions %s for %s", fi.Mode(), p)
+		}
+	}
+}
+
+// revoke adds a key revocation signature to e
+func revoke(t *testing.T, e *openpgp.Entity) {
+	var buf bytes.Buffer
+	if err := e.PrimaryKey.Serialize(&buf); err != nil {
+		t.Fatal(err)
+	}
+	// skip the new format packet header to hash the key material only
+	b := buf.Bytes()
+	switch l := b[1]; {
+	case l < 192:
+		b = b[2:]
+	case l < 224:
+		b = b[3:]
+	default:
+		b = b[6:]
+	}
+	h := crypto.SHA256.New()
+	e.PrimaryKey.SerializeSignaturePrefix(h)
+	h.Write(b)
+
+	sig := &packet.Signature{
+		SigType:      packet.SigTypeKeyRevocation,
+		PubKeyAlgo:   e.PrimaryKey.PubKeyAlgo,
+		Hash:         crypto.SHA256,
+		CreationTime: time.Now(),
+		IssuerKeyId:  &e.PrimaryKey.KeyId,
+	}
+	if err := sig.Sign(h, e.PrivateKey, nil); err != nil {
+		t.Fatalf("failed to sign revocation: %s", err)
+	}
+	e.Revocations = append(e.Revocations, sig)
+}
+
+func TestImportRevokedKey(t *testing.T) {
+	e, err := openpgp.NewEntity(testName, testComment, testEmail, nil)
+	if err != nil {
+		t.Fatalf("failed to create entity: %s", err)
+	}
+	fp := fmt.Sprintf("%X", e.PrimaryKey.Fingerprint)
+
+	dir, err := ioutil.TempDir("", "sypgp-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pubPath := filepath.Join(dir, "pgp-public")
+	secPath := filepath.Join(dir, "pgp-secret")
+	for _, p := range []string{pubPath, secPath} {
+		if err := ioutil.WriteFile(p, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// importKey imports the public key of e and returns the number of
+	// imported keys
+	importKey := func() int {
+		var buf bytes.Buffer
+		if err := exportKey(&buf, openpgp.EntityList{e, testEntity}, fp, false, false); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		el, err := ReadKeys(&buf)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		imported, err := importKeys(append(el, testEntity), pubPath, secPath)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return len(imported)
+	}
+
+	if n := importKey(); n != 2 {
+		t.Errorf("unexpected number of imported keys %d", n)
+	}
+
+	// the revocation is merged into the key already present
+	revoke(t, e)
+	if n := importKey(); n != 1 {
+		t.Errorf("unexpected number of updated keys %d", n)
+	}
+	if n := importKey(); n != 0 {
+		t.Errorf("unexpected number of updated keys %d", n)
+	}
+
+	pubEl, err := readKeyring(pubPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(pubEl) != 2 {
+		t.Fatalf("unexpected number of public keys %d", len(pubEl))
+	}
+	k, err := findEntity(pubEl, fp)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(k.Revocations) != 1 {
+		t.Errorf("unexpected number of revocations %d", len(k.Revocations))
+	}
+	if k.PrivateKey != nil {
+		t.Errorf("unexpected private key in public keyring")
+	}
+}